@@ -18,7 +18,8 @@
 // limitations under the License and the Condition.
 
 locals {
-  service_name = "abacus"
+  service_name     = "abacus"
+  service_location = "us-central1"
 
   # Maximum length of revision name is 63 characters
   service_revision_name = substr("${local.service_name}-${var.image_git_sha}-${regex("@sha256:(.*)$", var.image_reference)[0]}", 0, 63)
@@ -30,7 +31,7 @@ data "google_service_account" "service" {
 
 resource "google_cloud_run_service" "service" {
   name     = local.service_name
-  location = "us-central1"
+  location = local.service_location
 
   template {
     spec {
@@ -44,6 +45,16 @@ resource "google_cloud_run_service" "service" {
           value = data.google_project.project.name
         }
 
+        env {
+          name  = "REGION"
+          value = local.service_location
+        }
+
+        env {
+          name  = "TRACES_EXPORTER"
+          value = "honeycomb"
+        }
+
         env {
           name = "HONEYCOMB_API_KEY"
           value_from {
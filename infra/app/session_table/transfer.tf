@@ -33,6 +33,7 @@ resource "google_bigquery_data_transfer_config" "sessions_transfer" {
   service_account_name   = data.google_service_account.bigquery_transfer_service.email
 
   params = {
+    # Encrypted sessions are stored as '*.json.enc' objects, which BigQuery can't read, so they are deliberately not matched here.
     data_path_template              = "gs://${data.google_project.project.name}-sessions/v1/${var.application_id}/*/*.json"
     destination_table_name_template = google_bigquery_table.sessions_table.table_id
     file_format                     = "JSON"
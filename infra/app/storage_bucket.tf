@@ -31,6 +31,17 @@ resource "google_storage_bucket" "session_storage" {
     enabled = true
   }
 
+  # Compaction deletes session objects once they've been bundled, so don't keep the old versions around forever.
+  lifecycle_rule {
+    condition {
+      days_since_noncurrent_time = 30
+    }
+
+    action {
+      type = "Delete"
+    }
+  }
+
   lifecycle {
     prevent_destroy = true
   }
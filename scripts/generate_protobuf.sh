@@ -0,0 +1,25 @@
+#! /usr/bin/env bash
+
+# Generates Go code from the protobuf schemas given as arguments, relative to the current directory.
+#
+# protoc's version is pinned in the build environment (.batect/build-env/Dockerfile) and protoc-gen-go's version is pinned
+# in go.mod, so that generated code only changes when the schema or one of those versions changes. Run this with
+# './batect generate', rather than directly, to use the pinned version of protoc.
+
+set -euo pipefail
+
+root_dir="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+expected_protoc_version=$(sed -n 's/^ARG PROTOC_VERSION=//p' "$root_dir/.batect/build-env/Dockerfile")
+actual_protoc_version=$(protoc --version)
+
+if [[ "$actual_protoc_version" != "libprotoc $expected_protoc_version" ]]; then
+  echo "Expected protoc $expected_protoc_version, but found '$actual_protoc_version'. Run './batect generate' to use the expected version." >&2
+  exit 1
+fi
+
+plugin_dir=$(mktemp -d)
+trap 'rm -rf "$plugin_dir"' EXIT
+
+go build -o "$plugin_dir/protoc-gen-go" google.golang.org/protobuf/cmd/protoc-gen-go
+
+protoc --plugin=protoc-gen-go="$plugin_dir/protoc-gen-go" --go_out=. --go_opt=paths=source_relative "$@"
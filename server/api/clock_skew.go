@@ -0,0 +1,95 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/services-common/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Clients can send this header, containing the time (in RFC 3339 format) that they sent the request according to their clock.
+// The difference between this and the time the request is received is used to correct the session's timestamps for
+// any error in the client's clock.
+const sentAtHeader = "Abacus-Sent-At"
+
+// Differences smaller than this are most likely due to the time taken to send the request rather than an incorrect clock, so are ignored.
+const minimumClockSkewCorrection = time.Minute
+
+// correctClockSkew adjusts all of the timestamps in session to account for any error in the client's clock,
+// writing an error response and returning false if the client sent an invalid sentAtHeader.
+func correctClockSkew(w http.ResponseWriter, req *http.Request, session *types.Session, receivedAt time.Time) bool {
+	header := req.Header.Get(sentAtHeader)
+
+	if header == "" {
+		return true
+	}
+
+	sentAt, err := time.Parse(time.RFC3339Nano, header)
+
+	if err != nil {
+		badRequest(req.Context(), w, errorCodeInvalidHeader, fmt.Sprintf("%v header must be a RFC 3339 timestamp", sentAtHeader))
+		return false
+	}
+
+	skew := receivedAt.Sub(sentAt)
+
+	if skew > -minimumClockSkewCorrection && skew < minimumClockSkewCorrection {
+		return true
+	}
+
+	shiftTimestamps(session, skew)
+
+	middleware.LoggerFromContext(req.Context()).WithField("clockSkewCorrection", skew.String()).Info("Corrected session timestamps for client clock skew.")
+	trace.SpanFromContext(req.Context()).SetAttributes(clockSkewCorrection.Int64(skew.Milliseconds()))
+
+	return true
+}
+
+func shiftTimestamps(session *types.Session, offset time.Duration) {
+	session.SessionStartTime = shiftTimestamp(session.SessionStartTime, offset)
+	session.SessionEndTime = shiftTimestamp(session.SessionEndTime, offset)
+
+	if session.Consent != nil {
+		session.Consent.Time = shiftTimestamp(session.Consent.Time, offset)
+	}
+
+	for i := range session.Events {
+		session.Events[i].Time = shiftTimestamp(session.Events[i].Time, offset)
+	}
+
+	for i := range session.Spans {
+		session.Spans[i].StartTime = shiftTimestamp(session.Spans[i].StartTime, offset)
+		session.Spans[i].EndTime = shiftTimestamp(session.Spans[i].EndTime, offset)
+	}
+}
+
+// Missing timestamps are left as-is so that validation reports them as missing.
+func shiftTimestamp(t time.Time, offset time.Duration) time.Time {
+	if t.IsZero() {
+		return t
+	}
+
+	return t.Add(offset)
+}
@@ -0,0 +1,210 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/versions"
+)
+
+// ConfigPathPrefix is the path the config endpoint is served under. The application ID follows the prefix.
+const ConfigPathPrefix = "/v1/config/"
+
+// Clients are expected to fetch their configuration regularly, so it can be cached only briefly before being revalidated with its ETag.
+const configCacheControl = "public, max-age=300"
+
+type configHandler struct {
+	registry  *applications.Registry
+	overrides map[string][]clientConfigOverride
+}
+
+type clientConfigOverride struct {
+	minimumVersion *versions.Version
+	maximumVersion *versions.Version
+	config         applications.ClientConfig
+}
+
+type clientConfigResponse struct {
+	Enabled               bool     `json:"enabled"`
+	SamplingRate          float64  `json:"samplingRate"`
+	UploadIntervalSeconds int64    `json:"uploadIntervalSeconds"`
+	DisabledEventTypes    []string `json:"disabledEventTypes"`
+}
+
+// NewConfigHandler returns a handler that returns the client configuration for the application named in the request's path,
+// from registry. If the request's 'version' query parameter is set, any overrides for that version are applied.
+//
+// Responses include an ETag, and requests with a matching If-None-Match header receive a HTTP 304 response.
+// It returns an error if any application's configuration overrides refer to invalid versions.
+func NewConfigHandler(registry *applications.Registry) (http.Handler, error) {
+	overrides := map[string][]clientConfigOverride{}
+
+	for _, app := range registry.All() {
+		for _, override := range app.ClientConfigOverrides {
+			parsed, err := parseClientConfigOverride(override)
+
+			if err != nil {
+				return nil, fmt.Errorf("application '%v' has an invalid client configuration override: %w", app.ID, err)
+			}
+
+			overrides[app.ID] = append(overrides[app.ID], parsed)
+		}
+	}
+
+	return &configHandler{registry: registry, overrides: overrides}, nil
+}
+
+func parseClientConfigOverride(override applications.ClientConfigOverride) (clientConfigOverride, error) {
+	parsed := clientConfigOverride{config: override.Config}
+
+	if override.MinimumVersion != "" {
+		v, err := versions.Parse(override.MinimumVersion)
+
+		if err != nil {
+			return clientConfigOverride{}, fmt.Errorf("invalid minimum version: %w", err)
+		}
+
+		parsed.minimumVersion = &v
+	}
+
+	if override.MaximumVersion != "" {
+		v, err := versions.Parse(override.MaximumVersion)
+
+		if err != nil {
+			return clientConfigOverride{}, fmt.Errorf("invalid maximum version: %w", err)
+		}
+
+		parsed.maximumVersion = &v
+	}
+
+	return parsed, nil
+}
+
+func (h *configHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !requireMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	applicationID := strings.TrimPrefix(req.URL.Path, ConfigPathPrefix)
+	app, ok := h.registry.Get(applicationID)
+
+	if !ok {
+		resp := errorResponse{Code: errorCodeUnknownApplication, Message: fmt.Sprintf("Application '%v' is not known", applicationID)}
+		resp.Write(req.Context(), w, http.StatusNotFound)
+
+		return
+	}
+
+	config := app.ClientConfig
+
+	if version := req.URL.Query().Get("version"); version != "" {
+		parsed, err := versions.Parse(version)
+
+		if err != nil {
+			badRequest(req.Context(), w, errorCodeInvalidParameter, "version parameter must be a valid version")
+
+			return
+		}
+
+		config = h.configForVersion(app, parsed)
+	}
+
+	body, err := json.Marshal(newClientConfigResponse(config))
+
+	if err != nil {
+		panic(err)
+	}
+
+	etag := configETag(body)
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", configCacheControl)
+
+	if matchesETag(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, jsonMimeType)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(body); err != nil {
+		panic(err)
+	}
+}
+
+func (h *configHandler) configForVersion(app applications.Application, version versions.Version) applications.ClientConfig {
+	for _, override := range h.overrides[app.ID] {
+		if override.minimumVersion != nil && version.Compare(*override.minimumVersion) < 0 {
+			continue
+		}
+
+		if override.maximumVersion != nil && version.Compare(*override.maximumVersion) >= 0 {
+			continue
+		}
+
+		return override.config
+	}
+
+	return app.ClientConfig
+}
+
+func newClientConfigResponse(config applications.ClientConfig) clientConfigResponse {
+	disabledEventTypes := config.DisabledEventTypes
+
+	if disabledEventTypes == nil {
+		disabledEventTypes = []string{}
+	}
+
+	return clientConfigResponse{
+		Enabled:               config.Enabled,
+		SamplingRate:          config.SamplingRate,
+		UploadIntervalSeconds: int64(config.UploadInterval.Seconds()),
+		DisabledEventTypes:    disabledEventTypes,
+	}
+}
+
+// The ETag is derived from the response body, so it changes whenever the configuration does, even across server restarts and instances.
+func configETag(body []byte) string {
+	hash := sha256.Sum256(body)
+
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// matchesETag returns true if the If-None-Match header value ifNoneMatch matches etag, using weak comparison as required by RFC 9110.
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
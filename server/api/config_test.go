@@ -0,0 +1,257 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config endpoint", func() {
+	var handler http.Handler
+	var resp *httptest.ResponseRecorder
+
+	BeforeEach(func() {
+		var err error
+		handler, err = api.NewConfigHandler(applications.NewRegistry(
+			applications.Application{
+				ID: "test-app",
+				ClientConfig: applications.ClientConfig{
+					Enabled:            true,
+					SamplingRate:       0.5,
+					UploadInterval:     30 * time.Minute,
+					DisabledEventTypes: []string{"VerboseEvent"},
+				},
+				ClientConfigOverrides: []applications.ClientConfigOverride{
+					{MaximumVersion: "0.40", Config: applications.ClientConfig{Enabled: false}},
+					{MinimumVersion: "2.0.0-alpha", Config: applications.ClientConfig{Enabled: true, SamplingRate: 1, UploadInterval: time.Minute}},
+				},
+			},
+		))
+
+		Expect(err).ToNot(HaveOccurred())
+
+		resp = httptest.NewRecorder()
+	})
+
+	get := func(path string, headers map[string]string) {
+		req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("GET", path, nil))
+
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		handler.ServeHTTP(resp, req)
+	}
+
+	const defaultConfig = `{"enabled":true,"samplingRate":0.5,"uploadIntervalSeconds":1800,"disabledEventTypes":["VerboseEvent"]}`
+
+	Context("when invoked with a HTTP method other than GET", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/config/test-app", nil))
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 405 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("sets the response Allow header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Allow", []string{"GET"}))
+		})
+	})
+
+	Context("when invoked for an unknown application", func() {
+		BeforeEach(func() {
+			get("/v1/config/unknown-app", nil)
+		})
+
+		It("returns a HTTP 404 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unknown-application",
+				"title":"Unknown application",
+				"status":404,
+				"code":"unknown-application",
+				"detail":"Application 'unknown-app' is not known",
+				"message":"Application 'unknown-app' is not known"
+			}`))
+		})
+	})
+
+	Context("when invoked without a version", func() {
+		BeforeEach(func() {
+			get("/v1/config/test-app", nil)
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns the application's configuration", func() {
+			Expect(resp.Body).To(MatchJSON(defaultConfig))
+		})
+
+		It("sets the response Content-Type header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+		})
+
+		It("includes an ETag", func() {
+			Expect(resp.Result().Header.Get("ETag")).To(MatchRegexp(`^"[0-9a-f]{32}"$`))
+		})
+
+		It("allows the response to be cached briefly", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Cache-Control", []string{"public, max-age=300"}))
+		})
+	})
+
+	Context("when invoked with a version that has no overrides", func() {
+		BeforeEach(func() {
+			get("/v1/config/test-app?version=1.2.3", nil)
+		})
+
+		It("returns the application's configuration", func() {
+			Expect(resp.Body).To(MatchJSON(defaultConfig))
+		})
+	})
+
+	Context("when invoked with a version before the maximum version of an override", func() {
+		BeforeEach(func() {
+			get("/v1/config/test-app?version=0.39.2", nil)
+		})
+
+		It("returns the configuration from the override", func() {
+			Expect(resp.Body).To(MatchJSON(`{"enabled":false,"samplingRate":0,"uploadIntervalSeconds":0,"disabledEventTypes":[]}`))
+		})
+	})
+
+	Context("when invoked with the maximum version of an override", func() {
+		BeforeEach(func() {
+			get("/v1/config/test-app?version=0.40.0", nil)
+		})
+
+		It("returns the application's configuration", func() {
+			Expect(resp.Body).To(MatchJSON(defaultConfig))
+		})
+	})
+
+	Context("when invoked with the minimum version of an override", func() {
+		BeforeEach(func() {
+			get("/v1/config/test-app?version=2.0.0-alpha", nil)
+		})
+
+		It("returns the configuration from the override", func() {
+			Expect(resp.Body).To(MatchJSON(`{"enabled":true,"samplingRate":1,"uploadIntervalSeconds":60,"disabledEventTypes":[]}`))
+		})
+	})
+
+	Context("when invoked with an invalid version", func() {
+		BeforeEach(func() {
+			get("/v1/config/test-app?version=latest", nil)
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#invalid-parameter",
+				"title":"Invalid query parameter",
+				"status":400,
+				"code":"invalid-parameter",
+				"detail":"version parameter must be a valid version",
+				"message":"version parameter must be a valid version"
+			}`))
+		})
+	})
+
+	Describe("caching", func() {
+		var etag string
+
+		BeforeEach(func() {
+			get("/v1/config/test-app", nil)
+			etag = resp.Result().Header.Get("ETag")
+			resp = httptest.NewRecorder()
+		})
+
+		Context("when invoked with an If-None-Match header that matches the current configuration", func() {
+			BeforeEach(func() {
+				get("/v1/config/test-app", map[string]string{"If-None-Match": `"something-else", ` + etag})
+			})
+
+			It("returns a HTTP 304 response", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotModified))
+			})
+
+			It("does not return a body", func() {
+				Expect(resp.Body.Len()).To(BeZero())
+			})
+
+			It("includes the ETag", func() {
+				Expect(resp.Result().Header.Get("ETag")).To(Equal(etag))
+			})
+		})
+
+		Context("when invoked with an If-None-Match header with a weak validator that matches the current configuration", func() {
+			BeforeEach(func() {
+				get("/v1/config/test-app", map[string]string{"If-None-Match": "W/" + etag})
+			})
+
+			It("returns a HTTP 304 response", func() {
+				Expect(resp.Code).To(Equal(http.StatusNotModified))
+			})
+		})
+
+		Context("when invoked with an If-None-Match header for different configuration", func() {
+			BeforeEach(func() {
+				get("/v1/config/test-app?version=0.39.2", map[string]string{"If-None-Match": etag})
+			})
+
+			It("returns a HTTP 200 response with the configuration", func() {
+				Expect(resp.Code).To(Equal(http.StatusOK))
+				Expect(resp.Result().Header.Get("ETag")).ToNot(Equal(etag))
+			})
+		})
+	})
+
+	Context("given an application with an override with an invalid version", func() {
+		It("returns an error", func() {
+			_, err := api.NewConfigHandler(applications.NewRegistry(
+				applications.Application{
+					ID:                    "my-app",
+					ClientConfigOverrides: []applications.ClientConfigOverride{{MinimumVersion: "v2"}},
+				},
+			))
+
+			Expect(err).To(MatchError("application 'my-app' has an invalid client configuration override: invalid minimum version: 'v2' is not a valid version"))
+		})
+	})
+})
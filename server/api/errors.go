@@ -22,40 +22,117 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/batect/abacus/server/storage"
 	"github.com/batect/abacus/server/validation"
 	"github.com/batect/services-common/middleware"
 )
 
+const problemMimeType = "application/problem+json"
+
+// Each error code is documented in docs/errors.md, and the problem type for each code links to that documentation.
+const problemTypeBaseURL = "https://github.com/batect/abacus/blob/main/docs/errors.md#"
+
+type errorCode string
+
+// These codes are part of the API: clients can rely on them, so existing codes must not be changed or reused for other errors.
+const (
+	errorCodeMethodNotAllowed       errorCode = "method-not-allowed"
+	errorCodeUnsupportedContentType errorCode = "unsupported-content-type"
+	errorCodeMalformedBody          errorCode = "malformed-body"
+	errorCodeInvalidHeader          errorCode = "invalid-header"
+	errorCodeInvalidParameter       errorCode = "invalid-parameter"
+	errorCodeRequestTooLarge        errorCode = "request-too-large"
+	errorCodeValidationFailed       errorCode = "validation-failed"
+	errorCodeUnsupportedVersion     errorCode = "unsupported-version"
+	errorCodeUnknownApplication     errorCode = "unknown-application"
+	errorCodeStorageUnavailable     errorCode = "storage-unavailable"
+	errorCodeTooManyRequests        errorCode = "too-many-requests"
+)
+
+var errorTitles = map[errorCode]string{
+	errorCodeMethodNotAllowed:       "Method not allowed",
+	errorCodeUnsupportedContentType: "Unsupported content type",
+	errorCodeMalformedBody:          "Malformed request body",
+	errorCodeInvalidHeader:          "Invalid request header",
+	errorCodeInvalidParameter:       "Invalid query parameter",
+	errorCodeRequestTooLarge:        "Request body too large",
+	errorCodeValidationFailed:       "Validation failed",
+	errorCodeUnsupportedVersion:     "Unsupported application version",
+	errorCodeUnknownApplication:     "Unknown application",
+	errorCodeStorageUnavailable:     "Storage unavailable",
+	errorCodeTooManyRequests:        "Too many requests",
+}
+
+// errorResponse is an RFC 7807 problem details object.
+//
+// Message duplicates Detail, and is kept for clients written before responses followed RFC 7807.
 type errorResponse struct {
+	Type             string             `json:"type"`
+	Title            string             `json:"title"`
+	Status           int                `json:"status"`
+	Code             errorCode          `json:"code"`
+	Detail           string             `json:"detail"`
 	Message          string             `json:"message"`
 	ValidationErrors []validation.Error `json:"validationErrors,omitempty"`
 }
 
-func badRequest(ctx context.Context, w http.ResponseWriter, message string) {
-	resp := errorResponse{Message: message}
+func badRequest(ctx context.Context, w http.ResponseWriter, code errorCode, message string) {
+	resp := errorResponse{Code: code, Message: message}
 	resp.Write(ctx, w, http.StatusBadRequest)
 }
 
 func invalidBody(ctx context.Context, w http.ResponseWriter, errors []validation.Error) {
-	resp := errorResponse{Message: "Request body has validation errors", ValidationErrors: errors}
+	resp := errorResponse{Code: errorCodeValidationFailed, Message: "Request body has validation errors", ValidationErrors: errors}
 	resp.Write(ctx, w, http.StatusBadRequest)
 }
 
 func methodNotAllowed(ctx context.Context, w http.ResponseWriter, allowedMethod string) {
 	w.Header().Set("Allow", allowedMethod)
 
-	resp := errorResponse{Message: fmt.Sprintf("This endpoint only supports %v requests", allowedMethod)}
+	resp := errorResponse{Code: errorCodeMethodNotAllowed, Message: fmt.Sprintf("This endpoint only supports %v requests", allowedMethod)}
 	resp.Write(ctx, w, http.StatusMethodNotAllowed)
 }
 
+func requestTooLarge(ctx context.Context, w http.ResponseWriter, limit int64) {
+	resp := errorResponse{Code: errorCodeRequestTooLarge, Message: fmt.Sprintf("Request body must be no more than %v bytes", limit)}
+	resp.Write(ctx, w, http.StatusRequestEntityTooLarge)
+}
+
+func storageUnavailable(ctx context.Context, w http.ResponseWriter, err error) {
+	var circuitOpenError *storage.CircuitOpenError
+
+	if errors.As(err, &circuitOpenError) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitOpenError.RetryAfter.Seconds()))))
+	}
+
+	resp := errorResponse{Code: errorCodeStorageUnavailable, Message: "Could not process request"}
+	resp.Write(ctx, w, http.StatusServiceUnavailable)
+}
+
+func tooManyRequests(ctx context.Context, w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	resp := errorResponse{Code: errorCodeTooManyRequests, Message: "Too many requests, try again later"}
+	resp.Write(ctx, w, http.StatusTooManyRequests)
+}
+
 func (e *errorResponse) Write(ctx context.Context, w http.ResponseWriter, status int) {
+	e.Type = problemTypeBaseURL + string(e.Code)
+	e.Title = errorTitles[e.Code]
+	e.Status = status
+	e.Detail = e.Message
+
 	log := middleware.LoggerFromContext(ctx)
 	log.WithField("errorResponse", e).WithField("statusCode", status).Warn("Returning error to client.")
 
-	w.Header().Set(contentTypeHeader, jsonMimeType)
+	w.Header().Set(contentTypeHeader, problemMimeType)
 	w.WriteHeader(status)
 
 	bytes, err := json.Marshal(e)
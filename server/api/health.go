@@ -0,0 +1,195 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/services-common/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+const (
+	dependencyStatusOK          = "ok"
+	dependencyStatusUnavailable = "unavailable"
+	readinessStatusReady        = "ready"
+	readinessStatusNotReady     = "notReady"
+)
+
+type livenessResponse struct {
+	Status string `json:"status"`
+}
+
+type readinessResponse struct {
+	Status       string                        `json:"status"`
+	CheckedAt    time.Time                     `json:"checkedAt"`
+	Dependencies map[string]dependencyResponse `json:"dependencies"`
+}
+
+type dependencyResponse struct {
+	Status string `json:"status"`
+}
+
+// Live reports that the process is running and able to handle requests. It does not check any dependencies, so that
+// an outage of a dependency doesn't cause the service to be restarted.
+func Live(w http.ResponseWriter, req *http.Request) {
+	if !requireMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	writeHealthResponse(w, http.StatusOK, livenessResponse{Status: dependencyStatusOK})
+}
+
+type readinessHandler struct {
+	dependencies  map[string]storage.HealthChecker
+	cacheDuration time.Duration
+	timeSource    timeSource
+
+	mutex  sync.Mutex
+	cached *readinessResponse
+}
+
+// NewReadinessHandler returns a handler that reports whether the service is ready to handle requests, by checking the
+// health of each of dependencies. The result is cached for cacheDuration, and concurrent requests share a single check,
+// so that frequent probes don't overload the dependencies.
+//
+// Responses list the status of each dependency by name. The reasons for failures are logged rather than returned, as the
+// endpoint is public.
+func NewReadinessHandler(dependencies map[string]storage.HealthChecker, cacheDuration time.Duration) http.Handler {
+	return NewReadinessHandlerWithTimeSource(dependencies, cacheDuration, time.Now)
+}
+
+func NewReadinessHandlerWithTimeSource(dependencies map[string]storage.HealthChecker, cacheDuration time.Duration, timeSource timeSource) http.Handler {
+	return &readinessHandler{
+		dependencies:  dependencies,
+		cacheDuration: cacheDuration,
+		timeSource:    timeSource,
+	}
+}
+
+func (h *readinessHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !requireMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	resp := h.check(req.Context())
+	status := http.StatusOK
+
+	if resp.Status != readinessStatusReady {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealthResponse(w, status, resp)
+}
+
+func (h *readinessHandler) check(ctx context.Context) readinessResponse {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := h.timeSource()
+
+	if h.cached != nil && now.Sub(h.cached.CheckedAt) < h.cacheDuration {
+		return *h.cached
+	}
+
+	// The result is shared with other probes, so it mustn't depend on whether this probe gives up waiting for it.
+	checkCtx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), healthCheckTimeout)
+	defer cancel()
+
+	errs := h.checkDependencies(checkCtx)
+	resp := readinessResponse{Status: readinessStatusReady, CheckedAt: now, Dependencies: make(map[string]dependencyResponse, len(h.dependencies))}
+	log := middleware.LoggerFromContext(ctx)
+
+	for _, name := range h.dependencyNames() {
+		if err := errs[name]; err != nil {
+			log.WithError(err).WithField("dependency", name).Warn("Dependency is unavailable.")
+			resp.Dependencies[name] = dependencyResponse{Status: dependencyStatusUnavailable}
+			resp.Status = readinessStatusNotReady
+		} else {
+			resp.Dependencies[name] = dependencyResponse{Status: dependencyStatusOK}
+		}
+	}
+
+	h.cached = &resp
+
+	return resp
+}
+
+// checkDependencies checks all dependencies concurrently, returning the error from each dependency that failed.
+func (h *readinessHandler) checkDependencies(ctx context.Context) map[string]error {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	errs := map[string]error{}
+
+	for name, checker := range h.dependencies {
+		wg.Add(1)
+
+		go func(name string, checker storage.HealthChecker) {
+			defer wg.Done()
+
+			if err := checker.CheckHealth(ctx); err != nil {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				errs[name] = err
+			}
+		}(name, checker)
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+func (h *readinessHandler) dependencyNames() []string {
+	names := make([]string, 0, len(h.dependencies))
+
+	for name := range h.dependencies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func writeHealthResponse(w http.ResponseWriter, status int, resp interface{}) {
+	body, err := json.Marshal(resp)
+
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set(contentTypeHeader, jsonMimeType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Liveness endpoint", func() {
+	var resp *httptest.ResponseRecorder
+
+	BeforeEach(func() {
+		resp = httptest.NewRecorder()
+	})
+
+	Context("when invoked with a HTTP method other than GET", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/health/live", nil))
+			api.Live(resp, req)
+		})
+
+		It("returns a HTTP 405 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("when invoked with a HTTP GET", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("GET", "/health/live", nil))
+			api.Live(resp, req)
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns a JSON status payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{"status":"ok"}`))
+		})
+
+		It("sets the response Content-Type header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+		})
+	})
+})
+
+var _ = Describe("Readiness endpoint", func() {
+	var sessionStore, optOutStore *fakeHealthChecker
+	var handler http.Handler
+	var currentTime time.Time
+
+	BeforeEach(func() {
+		sessionStore = &fakeHealthChecker{}
+		optOutStore = &fakeHealthChecker{}
+		currentTime = time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+
+		handler = api.NewReadinessHandlerWithTimeSource(map[string]storage.HealthChecker{
+			"sessionStore": sessionStore,
+			"optOutStore":  optOutStore,
+		}, 10*time.Second, func() time.Time { return currentTime })
+	})
+
+	check := func(method string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, _ := testutils.RequestWithTestLogger(httptest.NewRequest(method, "/health/ready", nil))
+		handler.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	Context("when invoked with a HTTP method other than GET", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			resp = check("POST")
+		})
+
+		It("returns a HTTP 405 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("does not check any dependencies", func() {
+			Expect(sessionStore.checks).To(BeZero())
+			Expect(optOutStore.checks).To(BeZero())
+		})
+	})
+
+	Context("when all dependencies are healthy", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			resp = check("GET")
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns the status of each dependency", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"status": "ready",
+				"checkedAt": "2020-05-24T10:12:14Z",
+				"dependencies": {
+					"sessionStore": {"status": "ok"},
+					"optOutStore": {"status": "ok"}
+				}
+			}`))
+		})
+
+		It("sets the response Content-Type header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+		})
+
+		It("prevents the response from being cached", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Cache-Control", []string{"no-store"}))
+		})
+
+		It("passes a context with a deadline to each dependency", func() {
+			Expect(sessionStore.hadDeadline).To(BeTrue())
+			Expect(optOutStore.hadDeadline).To(BeTrue())
+		})
+	})
+
+	Context("when a dependency is unhealthy", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			optOutStore.err = errors.New("bucket does not exist")
+			resp = check("GET")
+		})
+
+		It("returns a HTTP 503 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("returns the status of each dependency without the details of the failure", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"status": "notReady",
+				"checkedAt": "2020-05-24T10:12:14Z",
+				"dependencies": {
+					"sessionStore": {"status": "ok"},
+					"optOutStore": {"status": "unavailable"}
+				}
+			}`))
+		})
+	})
+
+	Context("when the request is cancelled before the dependencies are checked", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("GET", "/health/ready", nil).WithContext(ctx))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			resp = check("GET")
+		})
+
+		It("checks the dependencies without the request's cancellation", func() {
+			Expect(sessionStore.checks).To(Equal(1))
+			Expect(optOutStore.checks).To(Equal(1))
+		})
+
+		It("caches the result of the checks", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Body).To(MatchJSON(`{
+				"status": "ready",
+				"checkedAt": "2020-05-24T10:12:14Z",
+				"dependencies": {
+					"sessionStore": {"status": "ok"},
+					"optOutStore": {"status": "ok"}
+				}
+			}`))
+		})
+	})
+
+	Context("when the endpoint is invoked again before the cache duration has elapsed", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			check("GET")
+			sessionStore.err = errors.New("something went wrong")
+			currentTime = currentTime.Add(9 * time.Second)
+			resp = check("GET")
+		})
+
+		It("does not check the dependencies again", func() {
+			Expect(sessionStore.checks).To(Equal(1))
+			Expect(optOutStore.checks).To(Equal(1))
+		})
+
+		It("returns the cached result", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+			Expect(resp.Body).To(MatchJSON(`{
+				"status": "ready",
+				"checkedAt": "2020-05-24T10:12:14Z",
+				"dependencies": {
+					"sessionStore": {"status": "ok"},
+					"optOutStore": {"status": "ok"}
+				}
+			}`))
+		})
+	})
+
+	Context("when the endpoint is invoked again after the cache duration has elapsed", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			check("GET")
+			sessionStore.err = errors.New("something went wrong")
+			currentTime = currentTime.Add(10 * time.Second)
+			resp = check("GET")
+		})
+
+		It("checks the dependencies again", func() {
+			Expect(sessionStore.checks).To(Equal(2))
+			Expect(optOutStore.checks).To(Equal(2))
+		})
+
+		It("returns the new result", func() {
+			Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(resp.Body).To(MatchJSON(`{
+				"status": "notReady",
+				"checkedAt": "2020-05-24T10:12:24Z",
+				"dependencies": {
+					"sessionStore": {"status": "unavailable"},
+					"optOutStore": {"status": "ok"}
+				}
+			}`))
+		})
+	})
+})
+
+type fakeHealthChecker struct {
+	err         error
+	checks      int
+	hadDeadline bool
+}
+
+func (f *fakeHealthChecker) CheckHealth(ctx context.Context) error {
+	f.checks++
+	_, f.hadDeadline = ctx.Deadline()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	return ctx.Err()
+}
@@ -47,11 +47,11 @@ var _ = Describe("Home endpoint", func() {
 		})
 
 		It("returns a JSON error payload", func() {
-			Expect(resp.Body).To(MatchJSON(`{"message":"This endpoint only supports GET requests"}`))
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#method-not-allowed","title":"Method not allowed","status":405,"code":"method-not-allowed","detail":"This endpoint only supports GET requests","message":"This endpoint only supports GET requests"}`))
 		})
 
 		It("sets the response Content-Type header", func() {
-			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/problem+json"}))
 		})
 
 		It("sets the response Allow header", func() {
@@ -20,45 +20,87 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/metrics"
 	"github.com/batect/abacus/server/storage"
 	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	"github.com/batect/abacus/server/versions"
 	"github.com/batect/services-common/middleware"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
 type ingestHandler struct {
-	loader       *jsonLoader
+	loader       *requestLoader
 	sessionStore storage.SessionStore
+	optOuts      storage.OptOutStore
+	enrichment   *enrichment.Pipeline
+	versions     *versions.Policies
 	timeSource   timeSource
 }
 
 type timeSource func() time.Time
 
 const sessionID = attribute.Key("session.sessionId")
-const userID = attribute.Key("session.userId")
 const applicationID = attribute.Key("session.applicationId")
 const applicationVersion = attribute.Key("session.applicationVersion")
+const clockSkewCorrection = attribute.Key("session.clockSkewCorrectionMs")
 
-func NewIngestHandler(sessionStore storage.SessionStore) (http.Handler, error) {
-	return NewIngestHandlerWithTimeSource(sessionStore, time.Now)
+// NewIngestHandler returns a handler that validates, enriches and stores sessions.
+//
+// Sessions rejected by their application's version policy receive an error response. Sessions dropped by the policy,
+// and sessions from users who have opted out, are acknowledged in the same way as stored sessions, so that clients
+// don't retry them, but are not stored.
+func NewIngestHandler(
+	sessionStore storage.SessionStore,
+	optOuts storage.OptOutStore,
+	registry *applications.Registry,
+	enrichment *enrichment.Pipeline,
+	versionPolicies *versions.Policies,
+) (http.Handler, error) {
+	return NewIngestHandlerWithTimeSource(sessionStore, optOuts, registry, enrichment, versionPolicies, time.Now)
+}
+
+func NewIngestHandlerWithTimeSource(
+	sessionStore storage.SessionStore,
+	optOuts storage.OptOutStore,
+	registry *applications.Registry,
+	enrichment *enrichment.Pipeline,
+	versionPolicies *versions.Policies,
+	timeSource timeSource,
+) (http.Handler, error) {
+	return newIngestHandler(sessionStore, optOuts, registry, enrichment, versionPolicies, timeSource, jsonDecoder, protobufDecoder)
 }
 
-func NewIngestHandlerWithTimeSource(sessionStore storage.SessionStore, timeSource timeSource) (http.Handler, error) {
-	loader, err := newJSONLoader()
+func newIngestHandler(
+	sessionStore storage.SessionStore,
+	optOuts storage.OptOutStore,
+	registry *applications.Registry,
+	enrichment *enrichment.Pipeline,
+	versionPolicies *versions.Policies,
+	timeSource timeSource,
+	decoders ...decoderRegistration,
+) (*ingestHandler, error) {
+	loader, err := newRequestLoader(registry, decoders...)
 
 	if err != nil {
-		return nil, fmt.Errorf("could not create JSON loader: %w", err)
+		return nil, fmt.Errorf("could not create request loader: %w", err)
 	}
 
 	return &ingestHandler{
 		loader:       loader,
 		sessionStore: sessionStore,
+		optOuts:      optOuts,
+		enrichment:   enrichment,
+		versions:     versionPolicies,
 		timeSource:   timeSource,
 	}, nil
 }
@@ -68,52 +110,156 @@ func (h *ingestHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	session := types.Session{}
+	session, decision, validationErrors, ok := h.loadSession(w, req)
+
+	if !ok {
+		metrics.RecordValidationFailures(validationErrors)
+		h.recordOutcome(session, metrics.Invalid)
+
+		return
+	}
+
+	ctx := h.contextForSession(req.Context(), session)
+
+	if decision == versions.Drop {
+		h.recordOutcome(session, metrics.Dropped)
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusCreated)
+
+		return
+	}
+
+	if err := h.storeSession(ctx, session); errors.Is(err, storage.ErrAlreadyExists) {
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusNotModified)
 
-	if ok := h.loader.LoadJSON(w, req, &session); !ok {
 		return
+	} else if err != nil {
+		storageUnavailable(ctx, w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// loadSession decodes, cleans, validates and enriches the session in req, and applies its application's version policy,
+// writing an error response and returning false if any of these fail. The returned decision is never versions.Reject.
+// If the session is not valid, its validation errors are returned so that callers can record them.
+func (h *ingestHandler) loadSession(w http.ResponseWriter, req *http.Request) (types.Session, versions.Decision, []validation.Error, bool) {
+	session := types.Session{}
+
+	if ok := h.loader.Decode(w, req, &session); !ok {
+		return session, versions.Reject, nil, false
+	}
+
+	receivedAt := h.timeSource()
+
+	if ok := correctClockSkew(w, req, &session, receivedAt); !ok {
+		return session, versions.Reject, nil, false
+	}
+
+	session = h.cleanSession(session, receivedAt)
+
+	if validationErrors, ok := h.loader.Check(w, req, &session); !ok {
+		return session, versions.Reject, validationErrors, false
+	}
+
+	decision, reason := h.applyVersionPolicy(req.Context(), session)
+
+	if decision == versions.Reject {
+		badRequest(req.Context(), w, errorCodeUnsupportedVersion, "Application "+reason)
+
+		return session, versions.Reject, nil, false
 	}
 
-	log := middleware.LoggerFromContext(req.Context()).
+	h.enrichment.Enrich(req, &session)
+
+	return session, decision, nil, true
+}
+
+// applyVersionPolicy returns the decision made by the version policy for session's application and the reason for it,
+// logging the reason if the session is not accepted. session must have been validated.
+func (h *ingestHandler) applyVersionPolicy(ctx context.Context, session types.Session) (versions.Decision, string) {
+	decision, reason := h.versions.Check(session.ApplicationID, *session.ParsedApplicationVersion)
+
+	if decision == versions.Accept {
+		return decision, reason
+	}
+
+	log := middleware.LoggerFromContext(ctx).
+		WithField("applicationId", session.ApplicationID).
+		WithField("applicationVersion", session.ApplicationVersion).
+		WithField("reason", reason)
+
+	if decision == versions.Drop {
+		log.Info("Discarding session excluded by application's version policy.")
+	} else {
+		log.Warn("Rejecting session excluded by application's version policy.")
+	}
+
+	return decision, reason
+}
+
+func (h *ingestHandler) contextForSession(ctx context.Context, session types.Session) context.Context {
+	log := middleware.LoggerFromContext(ctx).
 		WithField("sessionId", session.SessionID).
 		WithField("applicationId", session.ApplicationID)
 
-	ctx := middleware.ContextWithLogger(req.Context(), log)
-
 	span := trace.SpanFromContext(ctx)
 	span.SetAttributes(
 		sessionID.String(session.SessionID),
-		userID.String(session.UserID),
 		applicationID.String(session.ApplicationID),
 		applicationVersion.String(session.ApplicationVersion),
 	)
 
-	session = h.cleanSession(session)
+	return middleware.ContextWithLogger(ctx, log)
+}
+
+// storeSession stores a session that has already been cleaned and validated, unless its user has opted out, in which case
+// the session is discarded and no error is returned. It returns storage.ErrAlreadyExists if the session has been stored previously.
+// The outcome is recorded in the session metrics.
+func (h *ingestHandler) storeSession(ctx context.Context, session types.Session) error {
+	log := middleware.LoggerFromContext(ctx)
+
+	if optedOut, err := h.optOuts.IsOptedOut(ctx, session.UserID); err != nil {
+		log.WithError(err).Error("Checking for opt-out failed.")
+		h.recordOutcome(session, metrics.Failed)
+
+		return err
+	} else if optedOut {
+		log.Info("User has opted out, discarding session.")
+		h.recordOutcome(session, metrics.Dropped)
+
+		return nil
+	}
 
 	if err := h.sessionStore.Store(ctx, &session); errors.Is(err, storage.ErrAlreadyExists) {
 		log.Warn("Session already exists, not storing.")
+		h.recordOutcome(session, metrics.Duplicate)
 
-		w.Header().Set("Content-Length", "0")
-		w.WriteHeader(http.StatusNotModified)
-
-		return
+		return err
 	} else if err != nil {
 		log.WithError(err).Error("Storing session failed.")
+		h.recordOutcome(session, metrics.Failed)
 
-		resp := errorResponse{Message: "Could not process request"}
-		resp.Write(ctx, w, http.StatusServiceUnavailable)
-
-		return
+		return err
 	}
 
 	log.Info("Stored session successfully.")
+	h.recordOutcome(session, metrics.Created)
 
-	w.Header().Set("Content-Length", "0")
-	w.WriteHeader(http.StatusCreated)
+	return nil
 }
 
-func (h *ingestHandler) cleanSession(session types.Session) types.Session {
-	session.IngestionTime = h.timeSource()
+func (h *ingestHandler) cleanSession(session types.Session, ingestionTime time.Time) types.Session {
+	session.IngestionTime = ingestionTime
+	session.ParsedApplicationVersion = nil
+
+	if version, err := versions.Parse(session.ApplicationVersion); err == nil {
+		session.ParsedApplicationVersion = &version
+	}
 
 	if session.Attributes == nil {
 		session.Attributes = map[string]interface{}{}
@@ -20,6 +20,7 @@
 package api_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -29,28 +30,36 @@ import (
 	"time"
 
 	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/sessionpb"
 	"github.com/batect/abacus/server/storage"
 	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/versions"
 	"github.com/batect/services-common/middleware/testutils"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	gomega_types "github.com/onsi/gomega/types"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ = Describe("Ingest endpoint", func() {
 	var handler http.Handler
 	var resp *httptest.ResponseRecorder
 	var store *mockStore
-	currentTime := time.Date(2020, 5, 24, 10, 12, 14, 123, time.UTC)
+	var optOuts *mockOptOutStore
+	currentTime := time.Date(2019, 1, 2, 10, 12, 14, 123, time.UTC)
 
 	BeforeEach(func() {
 		store = &mockStore{}
+		optOuts = &mockOptOutStore{}
 		timeSource := func() time.Time { return currentTime }
 
 		var err error
-		handler, err = api.NewIngestHandlerWithTimeSource(store, timeSource)
+		handler, err = api.NewIngestHandlerWithTimeSource(store, optOuts, testRegistry(), testEnrichment(), testVersionPolicies(), timeSource)
 		Expect(err).ToNot(HaveOccurred())
 
 		resp = httptest.NewRecorder()
@@ -66,7 +75,7 @@ var _ = Describe("Ingest endpoint", func() {
 		})
 
 		It("sets the response Content-Type header", func() {
-			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/problem+json"}))
 		})
 
 		It("does not store any sessions", func() {
@@ -99,11 +108,11 @@ var _ = Describe("Ingest endpoint", func() {
 		})
 
 		It("returns a JSON error payload", func() {
-			Expect(resp.Body).To(MatchJSON(`{"message":"This endpoint only supports PUT requests"}`))
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#method-not-allowed","title":"Method not allowed","status":405,"code":"method-not-allowed","detail":"This endpoint only supports PUT requests","message":"This endpoint only supports PUT requests"}`))
 		})
 
 		It("sets the response Content-Type header", func() {
-			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/problem+json"}))
 		})
 
 		It("sets the response Allow header", func() {
@@ -122,7 +131,7 @@ var _ = Describe("Ingest endpoint", func() {
 				handler.ServeHTTP(resp, req)
 			})
 
-			ItReturnsABadRequestResponseWithBody(`{"message":"Content-Type must be 'application/json'"}`)
+			ItReturnsABadRequestResponseWithBody(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-content-type","title":"Unsupported content type","status":400,"code":"unsupported-content-type","detail":"Content-Type must be 'application/json' or 'application/x-protobuf'","message":"Content-Type must be 'application/json' or 'application/x-protobuf'"}`)
 		})
 
 		Context("when invoked with an invalid Content-Type header", func() {
@@ -132,7 +141,7 @@ var _ = Describe("Ingest endpoint", func() {
 				handler.ServeHTTP(resp, req)
 			})
 
-			ItReturnsABadRequestResponseWithBody(`{"message":"Content-Type must be 'application/json'"}`)
+			ItReturnsABadRequestResponseWithBody(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-content-type","title":"Unsupported content type","status":400,"code":"unsupported-content-type","detail":"Content-Type must be 'application/json' or 'application/x-protobuf'","message":"Content-Type must be 'application/json' or 'application/x-protobuf'"}`)
 		})
 
 		Context("when invoked with the required Content-Type header", func() {
@@ -149,7 +158,7 @@ var _ = Describe("Ingest endpoint", func() {
 					handler.ServeHTTP(resp, req)
 				})
 
-				ItReturnsABadRequestResponseWithBody(`{"message":"Request body is not valid: EOF"}`)
+				ItReturnsABadRequestResponseWithBody(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#malformed-body","title":"Malformed request body","status":400,"code":"malformed-body","detail":"Request body is not valid: EOF","message":"Request body is not valid: EOF"}`)
 			})
 
 			Context("when the request body is not valid JSON", func() {
@@ -158,7 +167,7 @@ var _ = Describe("Ingest endpoint", func() {
 					handler.ServeHTTP(resp, req)
 				})
 
-				ItReturnsABadRequestResponseWithBody(`{"message":"Request body is not valid: unexpected EOF"}`)
+				ItReturnsABadRequestResponseWithBody(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#malformed-body","title":"Malformed request body","status":400,"code":"malformed-body","detail":"Request body is not valid: unexpected EOF","message":"Request body is not valid: unexpected EOF"}`)
 			})
 
 			Context("when the request body is valid JSON but is empty", func() {
@@ -168,6 +177,11 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 
 				ItReturnsABadRequestResponseWithBody(`{
+					"type": "https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title": "Validation failed",
+					"status": 400,
+					"code": "validation-failed",
+					"detail": "Request body has validation errors",
 					"message": "Request body has validation errors",
 					"validationErrors": [
 						{ "key": "sessionId", "type": "required", "message": "sessionId is a required field" },
@@ -195,6 +209,11 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 
 				ItReturnsABadRequestResponseWithBody(`{
+					"type": "https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title": "Validation failed",
+					"status": 400,
+					"code": "validation-failed",
+					"detail": "Request body has validation errors",
 					"message": "Request body has validation errors",
 					"validationErrors": [
 						{ "key": "sessionId", "type": "uuid4", "invalidValue": "abc123", "message": "sessionId must be a valid version 4 UUID" },
@@ -209,7 +228,7 @@ var _ = Describe("Ingest endpoint", func() {
 					handler.ServeHTTP(resp, req)
 				})
 
-				ItReturnsABadRequestResponseWithBody(`{"message":"Request body is not valid: unknown field \"blah\""}`)
+				ItReturnsABadRequestResponseWithBody(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#malformed-body","title":"Malformed request body","status":400,"code":"malformed-body","detail":"Request body is not valid: unknown field \"blah\"","message":"Request body is not valid: unknown field \"blah\""}`)
 			})
 
 			Context("when the request body is valid JSON but contains a value for the ingestion time", func() {
@@ -230,13 +249,14 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 
 				ItReturnsACreatedResponseAndStoresTheSession("with the current ingestion time, not the ingestion time from the request", types.Session{
-					SessionID:          "11112222-3333-4444-a555-666677778888",
-					UserID:             "99990000-3333-4444-a555-666677778888",
-					SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
-					SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
-					IngestionTime:      currentTime,
-					ApplicationID:      "test-app",
-					ApplicationVersion: "1.0.0",
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "test-app",
+					ApplicationVersion:       "1.0.0",
 					Attributes: map[string]interface{}{
 						"operatingSystem": "Mac",
 					},
@@ -278,13 +298,14 @@ var _ = Describe("Ingest endpoint", func() {
 						})
 
 						ItReturnsACreatedResponseAndStoresTheSession("without modification", types.Session{
-							SessionID:          "11112222-3333-4444-a555-666677778888",
-							UserID:             "99990000-3333-4444-a555-666677778888",
-							SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
-							SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
-							IngestionTime:      currentTime,
-							ApplicationID:      "test-app",
-							ApplicationVersion: "1.0.0",
+							SessionID:                "11112222-3333-4444-a555-666677778888",
+							UserID:                   "99990000-3333-4444-a555-666677778888",
+							SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+							SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+							IngestionTime:            currentTime,
+							ParsedApplicationVersion: &versions.Version{Major: 1},
+							ApplicationID:            "test-app",
+							ApplicationVersion:       "1.0.0",
 							Attributes: map[string]interface{}{
 								"operatingSystem": "Mac",
 							},
@@ -310,6 +331,40 @@ var _ = Describe("Ingest endpoint", func() {
 						})
 					})
 
+					Context("when the session's user has opted out", func() {
+						BeforeEach(func() {
+							optOuts.OptedOutUserIDs = []string{"99990000-3333-4444-a555-666677778888"}
+							handler.ServeHTTP(resp, req)
+						})
+
+						It("returns a HTTP 201 response, so that the client does not retry", func() {
+							Expect(resp.Code).To(Equal(http.StatusCreated))
+						})
+
+						It("does not store the session", func() {
+							Expect(store.StoredSessions).To(BeEmpty())
+						})
+					})
+
+					Context("when checking if the session's user has opted out fails", func() {
+						BeforeEach(func() {
+							optOuts.ErrorToReturn = errors.New("could not check for opt-out")
+							handler.ServeHTTP(resp, req)
+						})
+
+						It("returns a HTTP 503 response", func() {
+							Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+						})
+
+						It("does not store the session", func() {
+							Expect(store.StoredSessions).To(BeEmpty())
+						})
+
+						It("logs the error", func() {
+							Expect(loggingHook.Entries).To(ContainElement(LogEntryWithError("Checking for opt-out failed.", optOuts.ErrorToReturn)))
+						})
+					})
+
 					Context("when storing the session fails", func() {
 						BeforeEach(func() {
 							store.ErrorToReturnFromStore = errors.New("could not store session")
@@ -321,17 +376,36 @@ var _ = Describe("Ingest endpoint", func() {
 						})
 
 						It("returns a JSON error payload", func() {
-							Expect(resp.Body).To(MatchJSON(`{"message": "Could not process request"}`))
+							Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#storage-unavailable","title":"Storage unavailable","status":503,"code":"storage-unavailable","detail":"Could not process request","message":"Could not process request"}`))
 						})
 
 						It("sets the response Content-Type header", func() {
-							Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+							Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/problem+json"}))
 						})
 
 						It("logs the error", func() {
 							Expect(loggingHook.Entries).To(ContainElement(LogEntryWithError("Storing session failed.", store.ErrorToReturnFromStore)))
 						})
 					})
+
+					Context("when storing the session fails because the session store is unavailable", func() {
+						BeforeEach(func() {
+							store.ErrorToReturnFromStore = &storage.CircuitOpenError{RetryAfter: 12300 * time.Millisecond}
+							handler.ServeHTTP(resp, req)
+						})
+
+						It("returns a HTTP 503 response", func() {
+							Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+						})
+
+						It("returns a JSON error payload", func() {
+							Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#storage-unavailable","title":"Storage unavailable","status":503,"code":"storage-unavailable","detail":"Could not process request","message":"Could not process request"}`))
+						})
+
+						It("tells the client when to retry", func() {
+							Expect(resp.Result().Header).To(HaveKeyWithValue("Retry-After", []string{"13"}))
+						})
+					})
 				})
 
 				Context("when the session already exists", func() {
@@ -373,17 +447,348 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 
 				ItReturnsACreatedResponseAndStoresTheSession("with an empty set of attributes, an empty set of spans, and an empty set of events", types.Session{
-					SessionID:          "11112222-3333-4444-a555-666677778888",
-					UserID:             "99990000-3333-4444-a555-666677778888",
-					SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
-					SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
-					IngestionTime:      currentTime,
-					ApplicationID:      "test-app",
-					ApplicationVersion: "1.0.0",
-					Attributes:         map[string]interface{}{},
-					Events:             []types.Event{},
-					Spans:              []types.Span{},
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "test-app",
+					ApplicationVersion:       "1.0.0",
+					Attributes:               map[string]interface{}{},
+					Events:                   []types.Event{},
+					Spans:                    []types.Span{},
+				})
+			})
+
+			Context("when the session started too long before the current time", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2018-10-01T03:04:05.678Z", 
+						"sessionEndTime": "2018-10-01T09:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "1.0.0"
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsABadRequestResponseWithBody(`{
+					"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title":"Validation failed",
+					"status":400,
+					"code":"validation-failed",
+					"detail":"Request body has validation errors",
+					"message":"Request body has validation errors",
+					"validationErrors":[
+						{"key":"sessionStartTime","type":"notTooOld","invalidValue":"2018-10-01T03:04:05.678Z","message":"sessionStartTime must be no more than 90 days before the session was uploaded"}
+					]
+				}`)
+			})
+
+			Context("when the session ends too far after the current time", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T09:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T12:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "1.0.0"
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsABadRequestResponseWithBody(`{
+					"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title":"Validation failed",
+					"status":400,
+					"code":"validation-failed",
+					"detail":"Request body has validation errors",
+					"message":"Request body has validation errors",
+					"validationErrors":[
+						{"key":"sessionEndTime","type":"notInFuture","invalidValue":"2019-01-02T12:04:05.678Z","message":"sessionEndTime must not be in the future"}
+					]
+				}`)
+			})
+
+			Context("when the request includes a sent at time", func() {
+				createRequestSentAt := func(sentAt string) *http.Request {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "1.0.0",
+						"events": [
+							{ "type": "ThingHappened", "time": "2019-01-02T03:04:06.678Z" }
+						],
+						"spans": [
+							{ "type": "LoadingThings", "startTime": "2019-01-02T03:04:07.678Z", "endTime": "2019-01-02T03:04:08.678Z" }
+						]
+					}`)
+
+					req.Header.Set("Abacus-Sent-At", sentAt)
+
+					return req
+				}
+
+				Context("when the sent at time is close to the current time", func() {
+					BeforeEach(func() {
+						handler.ServeHTTP(resp, createRequestSentAt("2019-01-02T10:12:00Z"))
+					})
+
+					ItReturnsACreatedResponseAndStoresTheSession("without adjusting its timestamps", types.Session{
+						SessionID:                "11112222-3333-4444-a555-666677778888",
+						UserID:                   "99990000-3333-4444-a555-666677778888",
+						SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+						SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+						IngestionTime:            currentTime,
+						ParsedApplicationVersion: &versions.Version{Major: 1},
+						ApplicationID:            "test-app",
+						ApplicationVersion:       "1.0.0",
+						Attributes:               map[string]interface{}{},
+						Events: []types.Event{
+							{Type: "ThingHappened", Time: time.Date(2019, 1, 2, 3, 4, 6, 678000000, time.UTC), Attributes: map[string]interface{}{}},
+						},
+						Spans: []types.Span{
+							{Type: "LoadingThings", StartTime: time.Date(2019, 1, 2, 3, 4, 7, 678000000, time.UTC), EndTime: time.Date(2019, 1, 2, 3, 4, 8, 678000000, time.UTC), Attributes: map[string]interface{}{}},
+						},
+					})
+				})
+
+				Context("when the client's clock is behind the current time", func() {
+					BeforeEach(func() {
+						handler.ServeHTTP(resp, createRequestSentAt("2019-01-02T08:12:14.000000123Z"))
+					})
+
+					ItReturnsACreatedResponseAndStoresTheSession("with its timestamps adjusted for the difference", types.Session{
+						SessionID:                "11112222-3333-4444-a555-666677778888",
+						UserID:                   "99990000-3333-4444-a555-666677778888",
+						SessionStartTime:         time.Date(2019, 1, 2, 5, 4, 5, 678000000, time.UTC),
+						SessionEndTime:           time.Date(2019, 1, 2, 11, 4, 5, 678000000, time.UTC),
+						IngestionTime:            currentTime,
+						ParsedApplicationVersion: &versions.Version{Major: 1},
+						ApplicationID:            "test-app",
+						ApplicationVersion:       "1.0.0",
+						Attributes:               map[string]interface{}{},
+						Events: []types.Event{
+							{Type: "ThingHappened", Time: time.Date(2019, 1, 2, 5, 4, 6, 678000000, time.UTC), Attributes: map[string]interface{}{}},
+						},
+						Spans: []types.Span{
+							{Type: "LoadingThings", StartTime: time.Date(2019, 1, 2, 5, 4, 7, 678000000, time.UTC), EndTime: time.Date(2019, 1, 2, 5, 4, 8, 678000000, time.UTC), Attributes: map[string]interface{}{}},
+						},
+					})
+				})
+
+				Context("when the sent at time is not a valid timestamp", func() {
+					BeforeEach(func() {
+						handler.ServeHTTP(resp, createRequestSentAt("yesterday"))
+					})
+
+					ItReturnsABadRequestResponseWithBody(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#invalid-header","title":"Invalid request header","status":400,"code":"invalid-header","detail":"Abacus-Sent-At header must be a RFC 3339 timestamp","message":"Abacus-Sent-At header must be a RFC 3339 timestamp"}`)
+				})
+			})
+
+			Context("when the request body is valid and the application has enrichers configured", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "smoke-test-app", 
+						"applicationVersion": "1.0.0",
+						"attributes": { "operatingSystem": "Mac" }
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsACreatedResponseAndStoresTheSession("with the attributes from the enrichers added", types.Session{
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "smoke-test-app",
+					ApplicationVersion:       "1.0.0",
+					Attributes: map[string]interface{}{
+						"operatingSystem":  "Mac",
+						"abacusReceivedBy": "test",
+					},
+					Events: []types.Event{},
+					Spans:  []types.Span{},
+				})
+			})
+
+			Context("when the request body contains a version with prerelease and build components", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "smoke-test-app", 
+						"applicationVersion": "2.3.4-beta.5+abc123"
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsACreatedResponseAndStoresTheSession("with the components of the version", types.Session{
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 2, Minor: 3, Patch: 4, Prerelease: "beta.5", Build: "abc123"},
+					ApplicationID:            "smoke-test-app",
+					ApplicationVersion:       "2.3.4-beta.5+abc123",
+					Attributes:               map[string]interface{}{"abacusReceivedBy": "test"},
+					Events:                   []types.Event{},
+					Spans:                    []types.Span{},
+				})
+			})
+
+			Context("when the request body is for a version older than the application's minimum version", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "0.39.2"
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsABadRequestResponseWithBody(`{
+					"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-version",
+					"title":"Unsupported application version",
+					"status":400,
+					"code":"unsupported-version",
+					"detail":"Application version 0.39.2 is older than the minimum supported version 0.40.0",
+					"message":"Application version 0.39.2 is older than the minimum supported version 0.40.0"
+				}`)
+			})
+
+			Context("when the request body is for a version the application's version policy drops", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "1.0.0-rc.1"
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				It("returns a HTTP 201 response, so that the client does not retry", func() {
+					Expect(resp.Code).To(Equal(http.StatusCreated))
+				})
+
+				It("does not store the session", func() {
+					Expect(store.StoredSessions).To(BeEmpty())
+				})
+			})
+
+			Context("when the request body contains an attribute in the namespace reserved for enrichers", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "smoke-test-app", 
+						"applicationVersion": "1.0.0",
+						"attributes": { "abacusReceivedBy": "client" }
+					}`)
+
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsABadRequestResponseWithBody(`{
+					"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title":"Validation failed",
+					"status":400,
+					"code":"validation-failed",
+					"detail":"Request body has validation errors",
+					"message":"Request body has validation errors",
+					"validationErrors":[
+						{"key":"attributes[abacusReceivedBy]","type":"reservedAttribute","invalidValue":"client","message":"attributes[abacusReceivedBy] must not start with 'abacus', as this is reserved for attributes added by the server"}
+					]
+				}`)
+			})
+
+			Context("when the request body contains consent and the client's clock is behind the current time", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "1.0.0",
+						"consent": { "policyVersion": "2023-06", "time": "2019-01-01T03:04:05.678Z" }
+					}`)
+
+					req.Header.Set("Abacus-Sent-At", "2019-01-02T08:12:14.000000123Z")
+					handler.ServeHTTP(resp, req)
+				})
+
+				ItReturnsACreatedResponseAndStoresTheSession("with its consent time adjusted for the difference", types.Session{
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 5, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 11, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "test-app",
+					ApplicationVersion:       "1.0.0",
+					Consent:                  &types.Consent{PolicyVersion: "2023-06", Time: time.Date(2019, 1, 1, 5, 4, 5, 678000000, time.UTC)},
+					Attributes:               map[string]interface{}{},
+					Events:                   []types.Event{},
+					Spans:                    []types.Span{},
+				})
+			})
+
+			Context("when the request body contains consent to a privacy policy version that has been withdrawn", func() {
+				BeforeEach(func() {
+					req, _ := createRequest(`{
+						"sessionId": "11112222-3333-4444-a555-666677778888", 
+						"userId": "99990000-3333-4444-a555-666677778888", 
+						"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+						"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+						"applicationId": "test-app", 
+						"applicationVersion": "1.0.0",
+						"consent": { "policyVersion": "2022-01", "time": "2019-01-01T03:04:05.678Z" }
+					}`)
+
+					handler.ServeHTTP(resp, req)
 				})
+
+				ItReturnsABadRequestResponseWithBody(`{
+					"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title":"Validation failed",
+					"status":400,
+					"code":"validation-failed",
+					"detail":"Request body has validation errors",
+					"message":"Request body has validation errors",
+					"validationErrors":[
+						{"key":"consent.policyVersion","type":"acceptedPolicyVersion","invalidValue":"2022-01","message":"consent.policyVersion must be a privacy policy version currently accepted for this application"}
+					]
+				}`)
 			})
 
 			Context("when the request body is valid but contains no attributes for a span", func() {
@@ -405,15 +810,16 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 
 				ItReturnsACreatedResponseAndStoresTheSession("with an empty set of attributes for the span", types.Session{
-					SessionID:          "11112222-3333-4444-a555-666677778888",
-					UserID:             "99990000-3333-4444-a555-666677778888",
-					SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
-					SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
-					IngestionTime:      currentTime,
-					ApplicationID:      "test-app",
-					ApplicationVersion: "1.0.0",
-					Attributes:         map[string]interface{}{},
-					Events:             []types.Event{},
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "test-app",
+					ApplicationVersion:       "1.0.0",
+					Attributes:               map[string]interface{}{},
+					Events:                   []types.Event{},
 					Spans: []types.Span{
 						{
 							Type:      "LoadingThings",
@@ -452,14 +858,15 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 
 				ItReturnsACreatedResponseAndStoresTheSession("with an empty set of attributes for the span", types.Session{
-					SessionID:          "11112222-3333-4444-a555-666677778888",
-					UserID:             "99990000-3333-4444-a555-666677778888",
-					SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
-					SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
-					IngestionTime:      currentTime,
-					ApplicationID:      "test-app",
-					ApplicationVersion: "1.0.0",
-					Attributes:         map[string]interface{}{},
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "test-app",
+					ApplicationVersion:       "1.0.0",
+					Attributes:               map[string]interface{}{},
 					Events: []types.Event{
 						{
 							Type: "DidThing",
@@ -478,9 +885,166 @@ var _ = Describe("Ingest endpoint", func() {
 				})
 			})
 		})
+
+		Context("when invoked with a protobuf body", func() {
+			createProtobufRequest := func(msg *sessionpb.Session) *http.Request {
+				body, err := proto.Marshal(msg)
+				Expect(err).ToNot(HaveOccurred())
+
+				req := httptest.NewRequest("PUT", "/ingest", bytes.NewReader(body))
+				req.Header.Set("Content-Type", "application/x-protobuf")
+				req, _ = testutils.RequestWithTestLogger(req)
+
+				return req
+			}
+
+			Context("when the request body is not a valid protobuf message", func() {
+				BeforeEach(func() {
+					req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("PUT", "/ingest", strings.NewReader("\xff\xff\xff")))
+					req.Header.Set("Content-Type", "application/x-protobuf")
+					handler.ServeHTTP(resp, req)
+				})
+
+				It("returns a HTTP 400 response", func() {
+					Expect(resp.Code).To(Equal(http.StatusBadRequest))
+				})
+
+				It("does not store any sessions", func() {
+					Expect(store.StoredSessions).To(BeEmpty())
+				})
+			})
+
+			Context("when the request body has an invalid value for one or more fields", func() {
+				BeforeEach(func() {
+					handler.ServeHTTP(resp, createProtobufRequest(&sessionpb.Session{
+						SessionId:          "abc123",
+						UserId:             "99990000-3333-4444-a555-666677778888",
+						SessionStartTime:   timestamppb.New(time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)),
+						ApplicationId:      "test-app",
+						ApplicationVersion: "1.0.0",
+					}))
+				})
+
+				ItReturnsABadRequestResponseWithBody(`{
+					"type": "https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+					"title": "Validation failed",
+					"status": 400,
+					"code": "validation-failed",
+					"detail": "Request body has validation errors",
+					"message": "Request body has validation errors",
+					"validationErrors": [
+						{ "key": "sessionId", "type": "uuid4", "invalidValue": "abc123", "message": "sessionId must be a valid version 4 UUID" },
+						{ "key": "sessionEndTime", "type": "required", "message": "sessionEndTime is a required field" }
+					]
+				}`)
+			})
+
+			Context("when the request body is valid", func() {
+				BeforeEach(func() {
+					handler.ServeHTTP(resp, createProtobufRequest(&sessionpb.Session{
+						SessionId:          "11112222-3333-4444-a555-666677778888",
+						UserId:             "99990000-3333-4444-a555-666677778888",
+						SessionStartTime:   timestamppb.New(time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)),
+						SessionEndTime:     timestamppb.New(time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC)),
+						ApplicationId:      "test-app",
+						ApplicationVersion: "1.0.0",
+						Attributes: map[string]*sessionpb.AttributeValue{
+							"operatingSystem": {Value: &sessionpb.AttributeValue_StringValue{StringValue: "Mac"}},
+						},
+						Events: []*sessionpb.Event{
+							{
+								Type:       "ThingHappened",
+								Time:       timestamppb.New(time.Date(2019, 1, 2, 3, 4, 6, 678000000, time.UTC)),
+								Attributes: map[string]*sessionpb.AttributeValue{"thingEnabled": {Value: &sessionpb.AttributeValue_BoolValue{BoolValue: true}}},
+							},
+						},
+						Spans: []*sessionpb.Span{
+							{
+								Type:      "LoadingThings",
+								StartTime: timestamppb.New(time.Date(2019, 1, 2, 3, 4, 7, 678000000, time.UTC)),
+								EndTime:   timestamppb.New(time.Date(2019, 1, 2, 3, 4, 8, 678000000, time.UTC)),
+							},
+						},
+					}))
+				})
+
+				ItReturnsACreatedResponseAndStoresTheSession("in the same form as a JSON session", types.Session{
+					SessionID:                "11112222-3333-4444-a555-666677778888",
+					UserID:                   "99990000-3333-4444-a555-666677778888",
+					SessionStartTime:         time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+					SessionEndTime:           time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+					IngestionTime:            currentTime,
+					ParsedApplicationVersion: &versions.Version{Major: 1},
+					ApplicationID:            "test-app",
+					ApplicationVersion:       "1.0.0",
+					Attributes: map[string]interface{}{
+						"operatingSystem": "Mac",
+					},
+					Events: []types.Event{
+						{
+							Type:       "ThingHappened",
+							Time:       time.Date(2019, 1, 2, 3, 4, 6, 678000000, time.UTC),
+							Attributes: map[string]interface{}{"thingEnabled": true},
+						},
+					},
+					Spans: []types.Span{
+						{
+							Type:       "LoadingThings",
+							StartTime:  time.Date(2019, 1, 2, 3, 4, 7, 678000000, time.UTC),
+							EndTime:    time.Date(2019, 1, 2, 3, 4, 8, 678000000, time.UTC),
+							Attributes: map[string]interface{}{},
+						},
+					},
+				})
+			})
+		})
 	})
 })
 
+type staticEnricher map[string]interface{}
+
+func (e staticEnricher) Enrich(_ *http.Request, _ *types.Session) map[string]interface{} {
+	return e
+}
+
+// testRegistry returns a registry with the same applications as the default registry, where test-app only accepts consent
+// to the 2023-01 and 2023-06 privacy policies.
+func testRegistry() *applications.Registry {
+	return applications.NewRegistry(
+		applications.Application{ID: "batect"},
+		applications.Application{ID: "test-app", ConsentPolicy: applications.ConsentPolicy{AcceptedPolicyVersions: []string{"2023-01", "2023-06"}}},
+		applications.Application{ID: "smoke-test-app"},
+	)
+}
+
+// testEnrichment returns a pipeline that enriches sessions from smoke-test-app, but not test-app.
+func testEnrichment() *enrichment.Pipeline {
+	pipeline, err := enrichment.NewPipeline(
+		applications.NewRegistry(
+			applications.Application{ID: "test-app"},
+			applications.Application{ID: "smoke-test-app", Enrichers: []string{"static"}},
+		),
+		map[string]enrichment.Enricher{"static": staticEnricher{"receivedBy": "test"}},
+	)
+
+	Expect(err).ToNot(HaveOccurred())
+
+	return pipeline
+}
+
+// testVersionPolicies returns policies that reject sessions from versions of test-app before 0.40 and drop sessions from
+// its prerelease versions, and accept all versions of smoke-test-app.
+func testVersionPolicies() *versions.Policies {
+	policies, err := versions.NewPolicies(applications.NewRegistry(
+		applications.Application{ID: "test-app", VersionPolicy: applications.VersionPolicy{MinimumVersion: "0.40", DropPrereleases: true}},
+		applications.Application{ID: "smoke-test-app"},
+	))
+
+	Expect(err).ToNot(HaveOccurred())
+
+	return policies
+}
+
 type mockStore struct {
 	ErrorToReturnFromStore error
 	StoredSessions         []types.Session
@@ -501,6 +1065,36 @@ func (m *mockStore) Store(_ context.Context, session *types.Session) error {
 	return nil
 }
 
+type mockOptOutStore struct {
+	ErrorToReturn   error
+	OptedOutUserIDs []string
+	RecordedOptOuts []types.OptOut
+}
+
+func (m *mockOptOutStore) RecordOptOut(_ context.Context, optOut *types.OptOut) error {
+	if m.ErrorToReturn != nil {
+		return m.ErrorToReturn
+	}
+
+	m.RecordedOptOuts = append(m.RecordedOptOuts, *optOut)
+
+	return nil
+}
+
+func (m *mockOptOutStore) IsOptedOut(_ context.Context, userID string) (bool, error) {
+	if m.ErrorToReturn != nil {
+		return false, m.ErrorToReturn
+	}
+
+	for _, id := range m.OptedOutUserIDs {
+		if id == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func GetMessage(e logrus.Entry) string     { return e.Message }
 func GetData(e logrus.Entry) logrus.Fields { return e.Data }
 func GetLevel(e logrus.Entry) logrus.Level { return e.Level }
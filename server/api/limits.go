@@ -0,0 +1,42 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import "net/http"
+
+// LimitRequestBodySize wraps next, rejecting requests with bodies larger than the number of bytes returned by limit with
+// a HTTP 413 response. limit is called for each request, so the limit can be changed while the service is running.
+//
+// Requests that declare their length are rejected before next is called. Otherwise, the body is cut off once it exceeds
+// the limit, and next responds as it would for any other request body that is too large.
+func LimitRequestBodySize(limit func() int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		maxSize := limit()
+
+		if req.ContentLength > maxSize {
+			requestTooLarge(req.Context(), w, maxSize)
+			return
+		}
+
+		req.Body = http.MaxBytesReader(w, req.Body, maxSize)
+
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,146 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiting request body sizes", func() {
+	var limit int64
+	var store *mockStore
+	var handler http.Handler
+	var resp *httptest.ResponseRecorder
+
+	smallBody := `{}`
+	largeBody := `{"sessionId":"` + strings.Repeat("a", 200) + `"}`
+
+	BeforeEach(func() {
+		limit = 100
+		store = &mockStore{}
+		timeSource := func() time.Time { return time.Date(2019, 1, 2, 10, 12, 14, 123, time.UTC) }
+
+		ingestHandler, err := api.NewIngestHandlerWithTimeSource(store, &mockOptOutStore{}, applications.DefaultRegistry(), testEnrichment(), testVersionPolicies(), timeSource)
+		Expect(err).ToNot(HaveOccurred())
+
+		handler = api.LimitRequestBodySize(func() int64 { return limit }, ingestHandler)
+		resp = httptest.NewRecorder()
+	})
+
+	sendRequest := func(body string, contentType string, declareLength bool) {
+		req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("PUT", "/v1/sessions", strings.NewReader(body)))
+		req.Header.Set("Content-Type", contentType)
+
+		if !declareLength {
+			req.ContentLength = -1
+		}
+
+		handler.ServeHTTP(resp, req)
+	}
+
+	ItReturnsARequestTooLargeResponse := func(expectedLimit int64) {
+		It("returns a HTTP 413 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+
+		It("returns a JSON error payload that includes the limit", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#request-too-large","title":"Request body too large","status":413,"code":"request-too-large","detail":"Request body must be no more than ` + strconv.FormatInt(expectedLimit, 10) + ` bytes","message":"Request body must be no more than ` + strconv.FormatInt(expectedLimit, 10) + ` bytes"}`))
+		})
+
+		It("does not store any sessions", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	}
+
+	Context("when the request body is within the limit", func() {
+		BeforeEach(func() {
+			sendRequest(smallBody, "application/json", true)
+		})
+
+		It("passes the request to the wrapped handler", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+			Expect(resp.Body.String()).To(ContainSubstring("validation-failed"))
+		})
+	})
+
+	Context("when the request declares a body larger than the limit", func() {
+		BeforeEach(func() {
+			sendRequest(largeBody, "application/json", true)
+		})
+
+		ItReturnsARequestTooLargeResponse(100)
+	})
+
+	Context("when the request does not declare its length and its body is larger than the limit", func() {
+		Context("when the body is JSON", func() {
+			BeforeEach(func() {
+				sendRequest(largeBody, "application/json", false)
+			})
+
+			ItReturnsARequestTooLargeResponse(100)
+		})
+
+		Context("when the body is protobuf", func() {
+			BeforeEach(func() {
+				sendRequest(largeBody, "application/x-protobuf", false)
+			})
+
+			ItReturnsARequestTooLargeResponse(100)
+		})
+	})
+
+	Context("when the limit changes", func() {
+		BeforeEach(func() {
+			limit = 1000
+			sendRequest(largeBody, "application/json", true)
+		})
+
+		It("applies the new limit", func() {
+			Expect(resp.Code).ToNot(Equal(http.StatusRequestEntityTooLarge))
+		})
+	})
+
+	Context("when the wrapped handler reads the body", func() {
+		var body []byte
+
+		BeforeEach(func() {
+			handler = api.LimitRequestBodySize(func() int64 { return limit }, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ = io.ReadAll(req.Body)
+			}))
+
+			sendRequest(smallBody, "application/json", false)
+		})
+
+		It("passes the complete body to the wrapped handler", func() {
+			Expect(string(body)).To(Equal(smallBody))
+		})
+	})
+})
@@ -0,0 +1,177 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/decoding"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+const jsonMimeType = "application/json"
+const protobufMimeType = "application/x-protobuf"
+const contentTypeHeader = "Content-Type"
+
+// bodyDecoder decodes a request body into target, which is always a pointer.
+type bodyDecoder func(body io.Reader, target interface{}) error
+
+type decoderRegistration struct {
+	mimeType string
+	decode   bodyDecoder
+}
+
+var jsonDecoder = decoderRegistration{mimeType: jsonMimeType, decode: decodeJSON}
+var protobufDecoder = decoderRegistration{mimeType: protobufMimeType, decode: decodeProtobuf}
+
+// requestLoader decodes and validates request bodies, choosing a decoder based on the request's Content-Type.
+type requestLoader struct {
+	validator  *validator.Validate
+	translator ut.Translator
+	decoders   []decoderRegistration
+}
+
+// newRequestLoader returns a loader that validates requests against the applications in registry, and only accepts
+// request bodies in the formats handled by decoders.
+func newRequestLoader(registry *applications.Registry, decoders ...decoderRegistration) (*requestLoader, error) {
+	v, trans, err := validation.CreateValidatorForRegistry(registry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &requestLoader{
+		validator:  v,
+		translator: trans,
+		decoders:   decoders,
+	}, nil
+}
+
+func decodeJSON(body io.Reader, target interface{}) error {
+	return decoding.NewJSONDecoder(body).Decode(target)
+}
+
+func decodeProtobuf(body io.Reader, target interface{}) error {
+	session, ok := target.(*types.Session)
+
+	if !ok {
+		return fmt.Errorf("protobuf is not supported for %T", target)
+	}
+
+	return decoding.DecodeProtobufSession(body, session)
+}
+
+// Decode decodes the body of req into target, writing an error response and returning false if this fails.
+func (l *requestLoader) Decode(w http.ResponseWriter, req *http.Request, target interface{}) bool {
+	decode := l.decoderFor(req.Header.Get(contentTypeHeader))
+
+	if decode == nil {
+		badRequest(req.Context(), w, errorCodeUnsupportedContentType, fmt.Sprintf("Content-Type must be %v", l.supportedMimeTypes()))
+		return false
+	}
+
+	if err := decode(req.Body, target); err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		if errors.As(err, &maxBytesError) {
+			requestTooLarge(req.Context(), w, maxBytesError.Limit)
+			return false
+		}
+
+		badRequest(req.Context(), w, errorCodeMalformedBody, fmt.Sprintf("Request body is not valid: %s", strings.TrimPrefix(err.Error(), "json: ")))
+		return false
+	}
+
+	return true
+}
+
+// Check validates target, writing an error response and returning its validation errors and false if it is not valid.
+func (l *requestLoader) Check(w http.ResponseWriter, req *http.Request, target interface{}) ([]validation.Error, bool) {
+	validationErrors, err := l.Validate(target)
+
+	if err != nil {
+		badRequest(req.Context(), w, errorCodeMalformedBody, fmt.Sprintf("Request body is not valid: %s", err))
+		return nil, false
+	}
+
+	if len(validationErrors) > 0 {
+		invalidBody(req.Context(), w, validationErrors)
+		return validationErrors, false
+	}
+
+	return nil, true
+}
+
+func (l *requestLoader) decoderFor(contentType string) bodyDecoder {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, d := range l.decoders {
+		if d.mimeType == mimeType {
+			return d.decode
+		}
+	}
+
+	return nil
+}
+
+func (l *requestLoader) supportedMimeTypes() string {
+	quoted := make([]string, 0, len(l.decoders))
+
+	for _, d := range l.decoders {
+		quoted = append(quoted, fmt.Sprintf("'%v'", d.mimeType))
+	}
+
+	return strings.Join(quoted, " or ")
+}
+
+// Validate returns the validation errors for target, or an error if target can't be validated at all.
+func (l *requestLoader) Validate(target interface{}) ([]validation.Error, error) {
+	err := l.validator.Struct(target)
+
+	if err == nil {
+		return nil, nil
+	}
+
+	var validationErrors validator.ValidationErrors
+
+	if errors.As(err, &validationErrors) {
+		return validation.ToValidationErrors(validationErrors, l.translator), nil
+	}
+
+	return nil, err
+}
+
+// IsKnownApplication returns true if applicationID is the ID of a registered application.
+func (l *requestLoader) IsKnownApplication(applicationID string) bool {
+	return l.validator.Var(applicationID, "applicationId") == nil
+}
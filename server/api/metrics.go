@@ -0,0 +1,55 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"github.com/batect/abacus/server/metrics"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/versions"
+)
+
+// recordOutcome records what happened to a session received by one of the ingest endpoints.
+//
+// The session's application ID is only used as a label if the application is registered, so that clients can't create
+// arbitrary numbers of time series. For the same reason, see versionLabel for how the version is labelled.
+func (h *ingestHandler) recordOutcome(session types.Session, outcome metrics.Outcome) {
+	app, version := metrics.UnknownLabelValue, metrics.UnknownLabelValue
+
+	if h.loader.IsKnownApplication(session.ApplicationID) {
+		app = session.ApplicationID
+
+		if v := session.ParsedApplicationVersion; v != nil {
+			version = h.versionLabel(app, *v)
+		}
+	}
+
+	metrics.RecordIngestedSession(app, version, outcome, &session)
+}
+
+// versionLabel returns the label for version of applicationID. Only versions listed in the application's version policy
+// as released are used as labels, as any other version could have been made up by the client. All other versions,
+// including every version of applications that don't list their released versions, are labelled as unknown.
+func (h *ingestHandler) versionLabel(applicationID string, version versions.Version) string {
+	if h.versions.IsReleased(applicationID, version) {
+		return version.String()
+	}
+
+	return metrics.UnknownLabelValue
+}
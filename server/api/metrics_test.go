@@ -0,0 +1,181 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/metrics"
+	"github.com/batect/abacus/server/versions"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Ingest endpoint metrics", func() {
+	var handler http.Handler
+	var store *mockStore
+	var optOuts *mockOptOutStore
+
+	timeSource := func() time.Time { return time.Date(2019, 1, 2, 10, 12, 14, 123, time.UTC) }
+
+	BeforeEach(func() {
+		store = &mockStore{}
+		optOuts = &mockOptOutStore{}
+
+		var err error
+		handler, err = api.NewIngestHandlerWithTimeSource(store, optOuts, applications.DefaultRegistry(), testEnrichment(), testVersionPolicies(), timeSource)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	sendSession := func(applicationID string, applicationVersion string) {
+		body := `{
+			"sessionId": "11112222-3333-4444-a555-666677778888",
+			"userId": "99990000-3333-4444-a555-666677778888",
+			"sessionStartTime": "2019-01-02T03:04:05.678Z",
+			"sessionEndTime": "2019-01-02T09:04:05.678Z",
+			"applicationId": "` + applicationID + `",
+			"applicationVersion": "` + applicationVersion + `"
+		}`
+
+		req := httptest.NewRequest(http.MethodPut, "/v1/sessions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req, _ = testutils.RequestWithTestLogger(req)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	ItCountsTheSession := func(application string, version string, outcome metrics.Outcome, send func()) {
+		It("counts the session with the expected labels", func() {
+			counter := metrics.IngestedSessions.WithLabelValues(application, version, string(outcome))
+			before := testutil.ToFloat64(counter)
+
+			send()
+
+			Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+		})
+	}
+
+	// test-app's version policy doesn't list its released versions, so its versions are never used as labels.
+	Context("when the session is stored", func() {
+		ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Created, func() { sendSession("test-app", "1.0.0") })
+	})
+
+	Context("when the session is for a version dropped by the application's version policy", func() {
+		ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Dropped, func() { sendSession("test-app", "1.0.0-beta.1+abc123") })
+	})
+
+	Context("when the session has already been stored", func() {
+		BeforeEach(func() {
+			store.SessionExists = true
+		})
+
+		ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Duplicate, func() { sendSession("test-app", "1.0.0") })
+	})
+
+	Context("when storing the session fails", func() {
+		BeforeEach(func() {
+			store.ErrorToReturnFromStore = errors.New("something went wrong")
+		})
+
+		ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Failed, func() { sendSession("test-app", "1.0.0") })
+	})
+
+	Context("when the session's user has opted out", func() {
+		BeforeEach(func() {
+			optOuts.OptedOutUserIDs = []string{"99990000-3333-4444-a555-666677778888"}
+		})
+
+		ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Dropped, func() { sendSession("test-app", "1.0.0") })
+	})
+
+	Context("when the session is for an unknown application", func() {
+		ItCountsTheSession(metrics.UnknownLabelValue, metrics.UnknownLabelValue, metrics.Invalid, func() { sendSession("some-other-app", "1.0.0") })
+
+		It("counts the validation failure by its type", func() {
+			counter := metrics.ValidationFailures.WithLabelValues("applicationId")
+			before := testutil.ToFloat64(counter)
+
+			sendSession("some-other-app", "1.0.0")
+
+			Expect(testutil.ToFloat64(counter)).To(Equal(before + 1))
+		})
+	})
+
+	Context("when the session is for a version rejected by the application's version policy", func() {
+		ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Invalid, func() { sendSession("test-app", "0.39.0") })
+	})
+
+	Context("when the application's version policy lists its released versions", func() {
+		BeforeEach(func() {
+			policies, err := versions.NewPolicies(applications.NewRegistry(
+				applications.Application{ID: "test-app", VersionPolicy: applications.VersionPolicy{ReleasedVersions: []string{"1.0.0"}}},
+			))
+			Expect(err).ToNot(HaveOccurred())
+
+			handler, err = api.NewIngestHandlerWithTimeSource(store, optOuts, applications.DefaultRegistry(), testEnrichment(), policies, timeSource)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when the session is for a released version", func() {
+			ItCountsTheSession("test-app", "1.0.0", metrics.Created, func() { sendSession("test-app", "1.0.0") })
+		})
+
+		Context("when the session is for a version that has not been released", func() {
+			ItCountsTheSession("test-app", metrics.UnknownLabelValue, metrics.Dropped, func() { sendSession("test-app", "1.1.0") })
+		})
+	})
+})
+
+var _ = Describe("Validate endpoint metrics", func() {
+	It("does not count validation failures", func() {
+		handler, err := api.NewValidateHandler(applications.DefaultRegistry(), testEnrichment(), testVersionPolicies())
+		Expect(err).ToNot(HaveOccurred())
+
+		counter := metrics.ValidationFailures.WithLabelValues("applicationId")
+		before := testutil.ToFloat64(counter)
+
+		body := `{
+			"sessionId": "11112222-3333-4444-a555-666677778888",
+			"userId": "99990000-3333-4444-a555-666677778888",
+			"sessionStartTime": "2019-01-02T03:04:05.678Z",
+			"sessionEndTime": "2019-01-02T09:04:05.678Z",
+			"applicationId": "some-other-app",
+			"applicationVersion": "1.0.0"
+		}`
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/sessions/validate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req, _ = testutils.RequestWithTestLogger(req)
+		resp := httptest.NewRecorder()
+
+		handler.ServeHTTP(resp, req)
+
+		Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		Expect(testutil.ToFloat64(counter)).To(Equal(before))
+	})
+})
@@ -0,0 +1,90 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/services-common/middleware"
+)
+
+type optOutHandler struct {
+	loader     *requestLoader
+	store      storage.OptOutStore
+	timeSource timeSource
+}
+
+// NewOptOutHandler returns a handler that records that a user has opted out of telemetry. Sessions from the user received
+// afterwards are discarded by the ingest and traces endpoints.
+//
+// If the opt-out asks for the user's existing sessions to be deleted, they are deleted the next time opt-outs are enforced
+// (see the optouts package), rather than during the request.
+func NewOptOutHandler(store storage.OptOutStore) (http.Handler, error) {
+	return NewOptOutHandlerWithTimeSource(store, time.Now)
+}
+
+func NewOptOutHandlerWithTimeSource(store storage.OptOutStore, timeSource timeSource) (http.Handler, error) {
+	// Opt-outs apply to all applications, so they don't need to be validated against any.
+	loader, err := newRequestLoader(applications.NewRegistry(), jsonDecoder)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create request loader: %w", err)
+	}
+
+	return &optOutHandler{loader: loader, store: store, timeSource: timeSource}, nil
+}
+
+func (h *optOutHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !requireMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	optOut := types.OptOut{}
+
+	if ok := h.loader.Decode(w, req, &optOut); !ok {
+		return
+	}
+
+	if _, ok := h.loader.Check(w, req, &optOut); !ok {
+		return
+	}
+
+	optOut.OptOutTime = h.timeSource()
+
+	ctx := req.Context()
+	log := middleware.LoggerFromContext(ctx).WithField("deleteExistingSessions", optOut.DeleteExistingSessions)
+
+	if err := h.store.RecordOptOut(ctx, &optOut); err != nil {
+		log.WithError(err).Error("Recording opt-out failed.")
+		storageUnavailable(ctx, w, err)
+
+		return
+	}
+
+	log.Info("Recorded opt-out successfully.")
+
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,194 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Opt-out endpoint", func() {
+	var handler http.Handler
+	var resp *httptest.ResponseRecorder
+	var store *mockOptOutStore
+	currentTime := time.Date(2019, 1, 2, 10, 12, 14, 123, time.UTC)
+
+	BeforeEach(func() {
+		store = &mockOptOutStore{}
+
+		var err error
+		handler, err = api.NewOptOutHandlerWithTimeSource(store, func() time.Time { return currentTime })
+		Expect(err).ToNot(HaveOccurred())
+
+		resp = httptest.NewRecorder()
+	})
+
+	createRequest := func(method string, body string) *http.Request {
+		req := httptest.NewRequest(method, "/v1/opt-outs", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		req, _ = testutils.RequestWithTestLogger(req)
+
+		return req
+	}
+
+	Context("when invoked with a HTTP method other than POST", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest("PUT", `{}`))
+		})
+
+		It("returns a HTTP 405 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("sets the response Allow header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Allow", []string{"POST"}))
+		})
+	})
+
+	Context("when invoked with a protobuf body", func() {
+		BeforeEach(func() {
+			req := createRequest("POST", "")
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("returns a JSON error payload that only lists JSON as a supported content type", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-content-type","title":"Unsupported content type","status":400,"code":"unsupported-content-type","detail":"Content-Type must be 'application/json'","message":"Content-Type must be 'application/json'"}`))
+		})
+
+		It("does not record an opt-out", func() {
+			Expect(store.RecordedOptOuts).To(BeEmpty())
+		})
+	})
+
+	Context("when invoked with a valid opt-out", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest("POST", `{ "userId": "99990000-3333-4444-a555-666677778888", "deleteExistingSessions": true }`))
+		})
+
+		It("returns a HTTP 201 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusCreated))
+		})
+
+		It("records the opt-out with the current time", func() {
+			Expect(store.RecordedOptOuts).To(ConsistOf(types.OptOut{
+				UserID:                 "99990000-3333-4444-a555-666677778888",
+				DeleteExistingSessions: true,
+				OptOutTime:             currentTime,
+			}))
+		})
+	})
+
+	Context("when invoked with an opt-out that does not ask for existing sessions to be deleted", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest("POST", `{ "userId": "99990000-3333-4444-a555-666677778888" }`))
+		})
+
+		It("records the opt-out", func() {
+			Expect(store.RecordedOptOuts).To(ConsistOf(types.OptOut{
+				UserID:     "99990000-3333-4444-a555-666677778888",
+				OptOutTime: currentTime,
+			}))
+		})
+	})
+
+	Context("when invoked with an opt-out that contains an opt-out time", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest("POST", `{ "userId": "99990000-3333-4444-a555-666677778888", "optOutTime": "2010-01-01T00:00:00Z" }`))
+		})
+
+		It("records the opt-out with the current time, not the time from the request", func() {
+			Expect(store.RecordedOptOuts).To(ConsistOf(types.OptOut{
+				UserID:     "99990000-3333-4444-a555-666677778888",
+				OptOutTime: currentTime,
+			}))
+		})
+	})
+
+	Context("when invoked with an invalid user ID", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest("POST", `{ "userId": "abc123" }`))
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+				"title":"Validation failed",
+				"status":400,
+				"code":"validation-failed",
+				"detail":"Request body has validation errors",
+				"message":"Request body has validation errors",
+				"validationErrors":[
+					{ "key": "userId", "type": "uuid4", "invalidValue": "abc123", "message": "userId must be a valid version 4 UUID" }
+				]
+			}`))
+		})
+
+		It("does not record an opt-out", func() {
+			Expect(store.RecordedOptOuts).To(BeEmpty())
+		})
+	})
+
+	Context("when invoked with a body that is not valid JSON", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest("POST", `{`))
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("does not record an opt-out", func() {
+			Expect(store.RecordedOptOuts).To(BeEmpty())
+		})
+	})
+
+	Context("when recording the opt-out fails", func() {
+		BeforeEach(func() {
+			store.ErrorToReturn = errors.New("could not record opt-out")
+			handler.ServeHTTP(resp, createRequest("POST", `{ "userId": "99990000-3333-4444-a555-666677778888" }`))
+		})
+
+		It("returns a HTTP 503 response so that the client retries", func() {
+			Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#storage-unavailable","title":"Storage unavailable","status":503,"code":"storage-unavailable","detail":"Could not process request","message":"Could not process request"}`))
+		})
+	})
+})
@@ -0,0 +1,233 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/metrics"
+	"github.com/batect/abacus/server/otlp"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	"github.com/batect/abacus/server/versions"
+	"github.com/batect/services-common/middleware"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+type tracesHandler struct {
+	ingest         *ingestHandler
+	maxRequestSize func() int64
+}
+
+// NewTracesHandler returns a handler for OTLP/HTTP traces export requests, in either the protobuf or JSON encoding.
+// Each resource in the request is converted into a session (see otlp.SessionsFromTraces), which is then validated and stored
+// in the same way as sessions sent to the ingest endpoint.
+//
+// Sessions that fail validation or are rejected by their application's version policy are reported back to the client as
+// rejected spans in a partial success response, as retrying them will never succeed. Sessions dropped by the version policy
+// and sessions from users who have opted out are not stored, and are not reported back to the client.
+//
+// Request bodies larger than maxRequestSize bytes, either before or after decompression, are rejected.
+func NewTracesHandler(
+	sessionStore storage.SessionStore,
+	optOuts storage.OptOutStore,
+	registry *applications.Registry,
+	enrichment *enrichment.Pipeline,
+	versionPolicies *versions.Policies,
+	maxRequestSize func() int64,
+) (http.Handler, error) {
+	return NewTracesHandlerWithTimeSource(sessionStore, optOuts, registry, enrichment, versionPolicies, maxRequestSize, time.Now)
+}
+
+func NewTracesHandlerWithTimeSource(
+	sessionStore storage.SessionStore,
+	optOuts storage.OptOutStore,
+	registry *applications.Registry,
+	enrichment *enrichment.Pipeline,
+	versionPolicies *versions.Policies,
+	maxRequestSize func() int64,
+	timeSource timeSource,
+) (http.Handler, error) {
+	// Request bodies are decoded by the traces handler itself, so the ingest handler doesn't need any decoders.
+	ingest, err := newIngestHandler(sessionStore, optOuts, registry, enrichment, versionPolicies, timeSource)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracesHandler{ingest: ingest, maxRequestSize: maxRequestSize}, nil
+}
+
+func (h *tracesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !requireMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get(contentTypeHeader))
+
+	if contentType != otlp.ProtobufContentType && contentType != otlp.JSONContentType {
+		resp := errorResponse{Code: errorCodeUnsupportedContentType, Message: fmt.Sprintf("Content-Type must be '%v' or '%v'", otlp.ProtobufContentType, otlp.JSONContentType)}
+		resp.Write(req.Context(), w, http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	body, err := readTracesRequestBody(w, req, h.maxRequestSize())
+
+	var maxBytesError *http.MaxBytesError
+
+	if errors.As(err, &maxBytesError) {
+		requestTooLarge(req.Context(), w, maxBytesError.Limit)
+		return
+	} else if err != nil {
+		badRequest(req.Context(), w, errorCodeMalformedBody, fmt.Sprintf("Could not read request body: %s", err))
+		return
+	}
+
+	export, err := otlp.UnmarshalTracesRequest(contentType, body)
+
+	if err != nil {
+		badRequest(req.Context(), w, errorCodeMalformedBody, fmt.Sprintf("Request body is not valid: %s", err))
+		return
+	}
+
+	resp := &coltracepb.ExportTraceServiceResponse{}
+
+	receivedAt := h.ingest.timeSource()
+
+	for _, session := range otlp.SessionsFromTraces(export.GetResourceSpans()) {
+		session = h.ingest.cleanSession(session, receivedAt)
+		ctx := h.ingest.contextForSession(req.Context(), session)
+		log := middleware.LoggerFromContext(ctx)
+
+		validationErrors, err := h.ingest.loader.Validate(&session)
+
+		if err != nil || len(validationErrors) > 0 {
+			message := rejectionMessage(session, validationErrors, err)
+			log.WithField("reason", message).Warn("Rejecting invalid session from traces request.")
+			rejectSpans(resp, session, message)
+			metrics.RecordValidationFailures(validationErrors)
+			h.ingest.recordOutcome(session, metrics.Invalid)
+
+			continue
+		}
+
+		decision, reason := h.ingest.applyVersionPolicy(ctx, session)
+
+		if decision == versions.Reject {
+			rejectSpans(resp, session, fmt.Sprintf("session '%v' is not accepted: application %v", session.SessionID, reason))
+			h.ingest.recordOutcome(session, metrics.Invalid)
+
+			continue
+		} else if decision == versions.Drop {
+			h.ingest.recordOutcome(session, metrics.Dropped)
+
+			continue
+		}
+
+		h.ingest.enrichment.Enrich(req, &session)
+
+		if err := h.ingest.storeSession(ctx, session); err != nil && !errors.Is(err, storage.ErrAlreadyExists) {
+			storageUnavailable(ctx, w, err)
+
+			return
+		}
+	}
+
+	bytes, err := otlp.MarshalTracesResponse(contentType, resp)
+
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set(contentTypeHeader, contentType)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(bytes); err != nil {
+		panic(err)
+	}
+}
+
+func readTracesRequestBody(w http.ResponseWriter, req *http.Request, limit int64) ([]byte, error) {
+	var reader io.Reader = http.MaxBytesReader(w, req.Body, limit)
+
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer gzipReader.Close()
+
+		reader = gzipReader
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding '%v'", req.Header.Get("Content-Encoding"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, limit+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, &http.MaxBytesError{Limit: limit}
+	}
+
+	return body, nil
+}
+
+// rejectSpans records the spans in session as rejected in resp, for the reason given in message.
+func rejectSpans(resp *coltracepb.ExportTraceServiceResponse, session types.Session, message string) {
+	if resp.PartialSuccess == nil {
+		resp.PartialSuccess = &coltracepb.ExportTracePartialSuccess{ErrorMessage: message}
+	} else {
+		resp.PartialSuccess.ErrorMessage += "; " + message
+	}
+
+	resp.PartialSuccess.RejectedSpans += int64(len(session.Spans))
+}
+
+func rejectionMessage(session types.Session, validationErrors []validation.Error, err error) string {
+	if err != nil {
+		return fmt.Sprintf("session '%v' is not valid: %s", session.SessionID, err)
+	}
+
+	messages := make([]string, 0, len(validationErrors))
+
+	for _, e := range validationErrors {
+		messages = append(messages, e.Message)
+	}
+
+	return fmt.Sprintf("session '%v' is not valid: %v", session.SessionID, strings.Join(messages, ", "))
+}
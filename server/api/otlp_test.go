@@ -0,0 +1,412 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/versions"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Traces endpoint", func() {
+	var handler http.Handler
+	var resp *httptest.ResponseRecorder
+	var store *mockStore
+	var optOuts *mockOptOutStore
+	currentTime := time.Date(2019, 1, 2, 10, 12, 14, 123, time.UTC)
+	maxRequestSize := int64(100 * 1024)
+
+	BeforeEach(func() {
+		store = &mockStore{}
+		optOuts = &mockOptOutStore{}
+		timeSource := func() time.Time { return currentTime }
+
+		var err error
+		handler, err = api.NewTracesHandlerWithTimeSource(store, optOuts, applications.DefaultRegistry(), testEnrichment(), testVersionPolicies(), func() int64 { return maxRequestSize }, timeSource)
+		Expect(err).ToNot(HaveOccurred())
+
+		resp = httptest.NewRecorder()
+	})
+
+	stringAttribute := func(key string, value string) *commonpb.KeyValue {
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+	}
+
+	startTime := time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)
+	endTime := time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC)
+
+	exportRequest := func(sessionID string) *coltracepb.ExportTraceServiceRequest {
+		return &coltracepb.ExportTraceServiceRequest{
+			ResourceSpans: []*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{
+							stringAttribute("service.name", "test-app"),
+							stringAttribute("service.version", "1.0.0"),
+							stringAttribute("session.id", sessionID),
+							stringAttribute("enduser.id", "99990000-3333-4444-a555-666677778888"),
+							stringAttribute("os.type", "linux"),
+						},
+					},
+					ScopeSpans: []*tracepb.ScopeSpans{
+						{
+							Spans: []*tracepb.Span{
+								{
+									TraceId:           []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c},
+									SpanId:            []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74},
+									Name:              "build",
+									StartTimeUnixNano: uint64(startTime.UnixNano()),
+									EndTimeUnixNano:   uint64(endTime.UnixNano()),
+									Events:            []*tracepb.Span_Event{{Name: "warning", TimeUnixNano: uint64(startTime.UnixNano())}},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	expectedSession := types.Session{
+		SessionID:                "11112222-3333-4444-a555-666677778888",
+		UserID:                   "99990000-3333-4444-a555-666677778888",
+		TraceID:                  "5b8efff7-9803-4103-9269-b633813fc60c",
+		SessionStartTime:         startTime,
+		SessionEndTime:           endTime,
+		IngestionTime:            currentTime,
+		ParsedApplicationVersion: &versions.Version{Major: 1},
+		ApplicationID:            "test-app",
+		ApplicationVersion:       "1.0.0",
+		Attributes:               map[string]interface{}{"osType": "linux"},
+		Events:                   []types.Event{{Type: "warning", Time: startTime, SpanID: "eee19b7ec3c1b174", Attributes: map[string]interface{}{}}},
+		Spans:                    []types.Span{{ID: "eee19b7ec3c1b174", Type: "build", StartTime: startTime, EndTime: endTime, Attributes: map[string]interface{}{}}},
+	}
+
+	protobufRequest := func(export *coltracepb.ExportTraceServiceRequest) *http.Request {
+		body, err := proto.Marshal(export)
+		Expect(err).ToNot(HaveOccurred())
+
+		req := httptest.NewRequest("POST", "/v1/traces", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req, _ = testutils.RequestWithTestLogger(req)
+
+		return req
+	}
+
+	decodeProtobufResponse := func() *coltracepb.ExportTraceServiceResponse {
+		decoded := &coltracepb.ExportTraceServiceResponse{}
+		Expect(proto.Unmarshal(resp.Body.Bytes(), decoded)).To(Succeed())
+
+		return decoded
+	}
+
+	Context("when invoked with a HTTP method other than POST", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("PUT", "/v1/traces", nil))
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 405 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("sets the response Allow header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Allow", []string{"POST"}))
+		})
+	})
+
+	Context("when invoked with an unsupported Content-Type header", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/traces", strings.NewReader("blah")))
+			req.Header.Set("Content-Type", "text/plain")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 415 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusUnsupportedMediaType))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-content-type","title":"Unsupported content type","status":415,"code":"unsupported-content-type","detail":"Content-Type must be 'application/x-protobuf' or 'application/json'","message":"Content-Type must be 'application/x-protobuf' or 'application/json'"}`))
+		})
+
+		It("does not store any sessions", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	Context("when invoked with a body that is not a valid protobuf message", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/traces", strings.NewReader("\xff\xff\xff")))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("does not store any sessions", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	Context("when invoked with a valid protobuf request", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, protobufRequest(exportRequest("11112222-3333-4444-a555-666677778888")))
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns a protobuf response", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/x-protobuf"}))
+		})
+
+		It("does not report a partial success", func() {
+			Expect(decodeProtobufResponse().GetPartialSuccess()).To(BeNil())
+		})
+
+		It("stores the session with the ingestion time", func() {
+			Expect(store.StoredSessions).To(ConsistOf(expectedSession))
+		})
+	})
+
+	Context("when invoked with a gzipped protobuf request", func() {
+		BeforeEach(func() {
+			body, err := proto.Marshal(exportRequest("11112222-3333-4444-a555-666677778888"))
+			Expect(err).ToNot(HaveOccurred())
+
+			var compressed bytes.Buffer
+			gzipWriter := gzip.NewWriter(&compressed)
+			_, err = gzipWriter.Write(body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gzipWriter.Close()).To(Succeed())
+
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/traces", &compressed))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			req.Header.Set("Content-Encoding", "gzip")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("stores the session", func() {
+			Expect(store.StoredSessions).To(ConsistOf(expectedSession))
+		})
+	})
+
+	Context("when invoked with a gzipped request that is larger than the limit once decompressed", func() {
+		BeforeEach(func() {
+			var compressed bytes.Buffer
+			gzipWriter := gzip.NewWriter(&compressed)
+			_, err := gzipWriter.Write(make([]byte, maxRequestSize+1))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gzipWriter.Close()).To(Succeed())
+			Expect(int64(compressed.Len())).To(BeNumerically("<", maxRequestSize))
+
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/traces", &compressed))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			req.Header.Set("Content-Encoding", "gzip")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 413 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+
+		It("returns an error message that includes the configured limit", func() {
+			Expect(resp.Body.String()).To(ContainSubstring(`"detail":"Request body must be no more than 102400 bytes"`))
+		})
+
+		It("does not store any sessions", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	Context("when invoked with a valid JSON request", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/traces", strings.NewReader(`{
+				"resourceSpans": [{
+					"resource": {
+						"attributes": [
+							{ "key": "service.name", "value": { "stringValue": "test-app" } },
+							{ "key": "service.version", "value": { "stringValue": "1.0.0" } },
+							{ "key": "session.id", "value": { "stringValue": "11112222-3333-4444-a555-666677778888" } },
+							{ "key": "enduser.id", "value": { "stringValue": "99990000-3333-4444-a555-666677778888" } },
+							{ "key": "os.type", "value": { "stringValue": "linux" } }
+						]
+					},
+					"scopeSpans": [{
+						"spans": [{
+							"traceId": "5b8efff798038103d269b633813fc60c",
+							"spanId": "eee19b7ec3c1b174",
+							"name": "build",
+							"startTimeUnixNano": "1546398245678000000",
+							"endTimeUnixNano": "1546419845678000000",
+							"events": [{ "name": "warning", "timeUnixNano": "1546398245678000000" }]
+						}]
+					}]
+				}]
+			}`)))
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("returns a JSON response", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+			Expect(resp.Body).To(MatchJSON(`{}`))
+		})
+
+		It("stores the session", func() {
+			Expect(store.StoredSessions).To(ConsistOf(expectedSession))
+		})
+	})
+
+	Context("when the request contains a session that fails validation", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, protobufRequest(exportRequest("abc123")))
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("reports the session's spans as rejected", func() {
+			partialSuccess := decodeProtobufResponse().GetPartialSuccess()
+			Expect(partialSuccess.GetRejectedSpans()).To(Equal(int64(1)))
+			Expect(partialSuccess.GetErrorMessage()).To(Equal("session 'abc123' is not valid: sessionId must be a valid version 4 UUID"))
+		})
+
+		It("does not store the session", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	exportRequestForVersion := func(version string) *coltracepb.ExportTraceServiceRequest {
+		export := exportRequest("11112222-3333-4444-a555-666677778888")
+		export.ResourceSpans[0].Resource.Attributes[1] = stringAttribute("service.version", version)
+
+		return export
+	}
+
+	Context("when the request contains a session from a version older than the application's minimum version", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, protobufRequest(exportRequestForVersion("0.39.2")))
+		})
+
+		It("reports the session's spans as rejected", func() {
+			partialSuccess := decodeProtobufResponse().GetPartialSuccess()
+			Expect(partialSuccess.GetRejectedSpans()).To(Equal(int64(1)))
+			Expect(partialSuccess.GetErrorMessage()).To(Equal("session '11112222-3333-4444-a555-666677778888' is not accepted: application version 0.39.2 is older than the minimum supported version 0.40.0"))
+		})
+
+		It("does not store the session", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	Context("when the request contains a session from a version the application's version policy drops", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, protobufRequest(exportRequestForVersion("1.0.0-rc.1")))
+		})
+
+		It("does not report a partial success, so that the client does not retry", func() {
+			Expect(decodeProtobufResponse().GetPartialSuccess()).To(BeNil())
+		})
+
+		It("does not store the session", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	Context("when the request contains a session from a user who has opted out", func() {
+		BeforeEach(func() {
+			optOuts.OptedOutUserIDs = []string{"99990000-3333-4444-a555-666677778888"}
+			handler.ServeHTTP(resp, protobufRequest(exportRequest("11112222-3333-4444-a555-666677778888")))
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("does not report a partial success, so that the client does not retry", func() {
+			Expect(decodeProtobufResponse().GetPartialSuccess()).To(BeNil())
+		})
+
+		It("does not store the session", func() {
+			Expect(store.StoredSessions).To(BeEmpty())
+		})
+	})
+
+	Context("when the session has already been stored", func() {
+		BeforeEach(func() {
+			store.SessionExists = true
+			handler.ServeHTTP(resp, protobufRequest(exportRequest("11112222-3333-4444-a555-666677778888")))
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("does not report a partial success", func() {
+			Expect(decodeProtobufResponse().GetPartialSuccess()).To(BeNil())
+		})
+	})
+
+	Context("when storing the session fails", func() {
+		BeforeEach(func() {
+			store.ErrorToReturnFromStore = errors.New("Something went wrong")
+			handler.ServeHTTP(resp, protobufRequest(exportRequest("11112222-3333-4444-a555-666677778888")))
+		})
+
+		It("returns a HTTP 503 response so that the client retries", func() {
+			Expect(resp.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#storage-unavailable","title":"Storage unavailable","status":503,"code":"storage-unavailable","detail":"Could not process request","message":"Could not process request"}`))
+		})
+	})
+})
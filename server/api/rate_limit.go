@@ -0,0 +1,86 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/batect/abacus/server/enrichment"
+)
+
+// RateLimitByClient wraps next, rejecting requests with a HTTP 429 response once a client has made more requests than
+// the number returned by limit in the current window. limit is called for each request, so the limit can be changed
+// while the service is running.
+//
+// Clients are identified by the IP address hidden by enrichment.HideClientIP, and requests from clients with unknown
+// addresses share a single limit. Addresses are only held in memory until the end of the window. Each instance of the
+// service counts requests separately.
+func RateLimitByClient(limit func() int64, window time.Duration, next http.Handler) http.Handler {
+	return RateLimitByClientWithTimeSource(limit, window, next, time.Now)
+}
+
+func RateLimitByClientWithTimeSource(limit func() int64, window time.Duration, next http.Handler, timeSource timeSource) http.Handler {
+	limiter := &rateLimiter{window: window, timeSource: timeSource}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		client := enrichment.ClientIPFromContext(req.Context()).String()
+
+		if retryAfter, ok := limiter.allow(client, limit()); !ok {
+			tooManyRequests(req.Context(), w, retryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// rateLimiter counts requests from each client in fixed windows.
+type rateLimiter struct {
+	window     time.Duration
+	timeSource timeSource
+
+	mutex     sync.Mutex
+	windowEnd time.Time
+	counts    map[string]int64
+}
+
+// allow records a request from client, and returns false and the time until the next window starts if client has
+// already made limit requests in the current window.
+func (l *rateLimiter) allow(client string, limit int64) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.timeSource()
+
+	if !now.Before(l.windowEnd) {
+		l.windowEnd = now.Truncate(l.window).Add(l.window)
+		l.counts = map[string]int64{}
+	}
+
+	if l.counts[client] >= limit {
+		return l.windowEnd.Sub(now), false
+	}
+
+	l.counts[client]++
+
+	return 0, true
+}
@@ -0,0 +1,111 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiting request rates", func() {
+	var limit int64
+	var currentTime time.Time
+	var handled int
+	var handler http.Handler
+
+	BeforeEach(func() {
+		limit = 2
+		currentTime = time.Date(2019, 1, 2, 10, 12, 15, 0, time.UTC)
+		handled = 0
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			handled++
+			w.WriteHeader(http.StatusCreated)
+		})
+
+		handler = enrichment.HideClientIP(api.RateLimitByClientWithTimeSource(func() int64 { return limit }, time.Minute, next, func() time.Time { return currentTime }))
+	})
+
+	sendRequest := func(remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := testutils.RequestWithTestLogger(httptest.NewRequest(http.MethodPost, "/v1/opt-outs", nil))
+		req.RemoteAddr = remoteAddr
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	Context("when a client has not exceeded the limit", func() {
+		It("passes each request to the wrapped handler", func() {
+			Expect(sendRequest("81.2.69.160:1234").Code).To(Equal(http.StatusCreated))
+			Expect(sendRequest("81.2.69.160:1234").Code).To(Equal(http.StatusCreated))
+			Expect(handled).To(Equal(2))
+		})
+	})
+
+	Context("when a client exceeds the limit", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			sendRequest("81.2.69.160:1234")
+			sendRequest("81.2.69.160:5678")
+			resp = sendRequest("81.2.69.160:1234")
+		})
+
+		It("returns a HTTP 429 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusTooManyRequests))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#too-many-requests","title":"Too many requests","status":429,"code":"too-many-requests","detail":"Too many requests, try again later","message":"Too many requests, try again later"}`))
+		})
+
+		It("tells the client how long to wait before the next window starts", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Retry-After", []string{"45"}))
+		})
+
+		It("does not pass the request to the wrapped handler", func() {
+			Expect(handled).To(Equal(2))
+		})
+
+		It("continues to accept requests from other clients", func() {
+			Expect(sendRequest("81.2.69.161:1234").Code).To(Equal(http.StatusCreated))
+		})
+
+		It("accepts requests from the client again once the next window starts", func() {
+			currentTime = currentTime.Add(45 * time.Second)
+
+			Expect(sendRequest("81.2.69.160:1234").Code).To(Equal(http.StatusCreated))
+		})
+
+		It("accepts requests from the client again if the limit is raised", func() {
+			limit = 3
+
+			Expect(sendRequest("81.2.69.160:1234").Code).To(Equal(http.StatusCreated))
+		})
+	})
+})
@@ -0,0 +1,48 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/batect/abacus/server/schema"
+)
+
+func SessionSchema(w http.ResponseWriter, req *http.Request) {
+	serveDocument(w, req, "application/schema+json", schema.SessionSchema)
+}
+
+func OpenAPIDocument(w http.ResponseWriter, req *http.Request) {
+	serveDocument(w, req, jsonMimeType, schema.OpenAPIDocument)
+}
+
+func serveDocument(w http.ResponseWriter, req *http.Request, contentType string, document []byte) {
+	if !requireMethod(w, req, http.MethodGet) {
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentType)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(document); err != nil {
+		panic(err)
+	}
+}
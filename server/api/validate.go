@@ -0,0 +1,91 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/versions"
+	"github.com/batect/services-common/middleware"
+)
+
+type validateHandler struct {
+	ingest *ingestHandler
+}
+
+// NewValidateHandler returns a handler that decodes, validates, cleans and enriches a session in exactly the same way as the ingest endpoint,
+// then returns the session as it would have been passed to storage, without storing it.
+//
+// The user ID is returned as sent, as it is only pseudonymised by the session store (see the pseudonymisation package).
+// Returning pseudonymised IDs would let anyone work out the pseudonym for any user ID.
+//
+// Sessions rejected by their application's version policy receive the same error response as from the ingest endpoint.
+// Sessions that would be dropped by the policy are returned as normal.
+func NewValidateHandler(registry *applications.Registry, enrichment *enrichment.Pipeline, versionPolicies *versions.Policies) (http.Handler, error) {
+	return NewValidateHandlerWithTimeSource(registry, enrichment, versionPolicies, time.Now)
+}
+
+func NewValidateHandlerWithTimeSource(
+	registry *applications.Registry,
+	enrichment *enrichment.Pipeline,
+	versionPolicies *versions.Policies,
+	timeSource timeSource,
+) (http.Handler, error) {
+	ingest, err := newIngestHandler(nil, nil, registry, enrichment, versionPolicies, timeSource, jsonDecoder, protobufDecoder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &validateHandler{ingest: ingest}, nil
+}
+
+func (h *validateHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !requireMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	session, _, _, ok := h.ingest.loadSession(w, req)
+
+	if !ok {
+		return
+	}
+
+	ctx := h.ingest.contextForSession(req.Context(), session)
+
+	bytes, err := json.Marshal(session)
+
+	if err != nil {
+		panic(err)
+	}
+
+	middleware.LoggerFromContext(ctx).Info("Validated session successfully.")
+
+	w.Header().Set(contentTypeHeader, jsonMimeType)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(bytes); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate endpoint", func() {
+	var handler http.Handler
+	var resp *httptest.ResponseRecorder
+	currentTime := time.Date(2019, 1, 2, 10, 12, 14, 123, time.UTC)
+
+	BeforeEach(func() {
+		timeSource := func() time.Time { return currentTime }
+
+		var err error
+		handler, err = api.NewValidateHandlerWithTimeSource(applications.DefaultRegistry(), testEnrichment(), testVersionPolicies(), timeSource)
+		Expect(err).ToNot(HaveOccurred())
+
+		resp = httptest.NewRecorder()
+	})
+
+	createRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/sessions/validate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req, _ = testutils.RequestWithTestLogger(req)
+
+		return req
+	}
+
+	Context("when invoked with a HTTP method other than POST", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("PUT", "/v1/sessions/validate", nil))
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 405 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+
+		It("sets the response Allow header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Allow", []string{"POST"}))
+		})
+	})
+
+	Context("when invoked with an invalid Content-Type header", func() {
+		BeforeEach(func() {
+			req, _ := testutils.RequestWithTestLogger(httptest.NewRequest("POST", "/v1/sessions/validate", nil))
+			req.Header.Set("Content-Type", "text/plain")
+			handler.ServeHTTP(resp, req)
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("returns a JSON error payload", func() {
+			Expect(resp.Body).To(MatchJSON(`{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-content-type","title":"Unsupported content type","status":400,"code":"unsupported-content-type","detail":"Content-Type must be 'application/json' or 'application/x-protobuf'","message":"Content-Type must be 'application/json' or 'application/x-protobuf'"}`))
+		})
+	})
+
+	Context("when the session has validation errors", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest(`{
+				"sessionId": "abc123",
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"applicationId": "test-app",
+				"applicationVersion": "1.0.0",
+				"attributes": { "not-valid": "value" }
+			}`))
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("returns the validation errors", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"type": "https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed",
+				"title": "Validation failed",
+				"status": 400,
+				"code": "validation-failed",
+				"detail": "Request body has validation errors",
+				"message": "Request body has validation errors",
+				"validationErrors": [
+					{ "key": "sessionId", "type": "uuid4", "invalidValue": "abc123", "message": "sessionId must be a valid version 4 UUID" },
+					{ "key": "attributes[not-valid]", "type": "attributeName", "invalidValue": "not-valid", "message": "attributes[not-valid] must have a valid attribute name" }
+				]
+			}`))
+		})
+	})
+
+	Context("when the session is valid", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest(`{
+				"sessionId": "11112222-3333-4444-a555-666677778888",
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"ingestionTime": "2019-01-03T00:00:00.000Z",
+				"applicationId": "test-app",
+				"applicationVersion": "1.0.0",
+				"attributes": { "cpuCount": 8 },
+				"spans": [
+					{ "type": "LoadingThings", "startTime": "2019-01-02T03:04:07.678Z", "endTime": "2019-01-02T03:04:08.678Z" }
+				]
+			}`))
+		})
+
+		It("returns a HTTP 200 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("sets the response Content-Type header", func() {
+			Expect(resp.Result().Header).To(HaveKeyWithValue("Content-Type", []string{"application/json"}))
+		})
+
+		It("returns the session as it would be passed to storage, with the user ID as sent", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"sessionId": "11112222-3333-4444-a555-666677778888",
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"ingestionTime": "2019-01-02T10:12:14.000000123Z",
+				"applicationId": "test-app",
+				"applicationVersion": "1.0.0",
+				"parsedApplicationVersion": { "major": 1, "minor": 0, "patch": 0 },
+				"attributes": { "cpuCount": 8 },
+				"events": [],
+				"spans": [
+					{ "type": "LoadingThings", "startTime": "2019-01-02T03:04:07.678Z", "endTime": "2019-01-02T03:04:08.678Z", "attributes": {} }
+				]
+			}`))
+		})
+	})
+
+	Context("when the session is from a version older than the application's minimum version", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest(`{
+				"sessionId": "11112222-3333-4444-a555-666677778888",
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"applicationId": "test-app",
+				"applicationVersion": "0.39.2"
+			}`))
+		})
+
+		It("returns a HTTP 400 response", func() {
+			Expect(resp.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("returns an error payload explaining why the session would be rejected", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#unsupported-version",
+				"title":"Unsupported application version",
+				"status":400,
+				"code":"unsupported-version",
+				"detail":"Application version 0.39.2 is older than the minimum supported version 0.40.0",
+				"message":"Application version 0.39.2 is older than the minimum supported version 0.40.0"
+			}`))
+		})
+	})
+
+	Context("when the session is valid and the application has enrichers configured", func() {
+		BeforeEach(func() {
+			handler.ServeHTTP(resp, createRequest(`{
+				"sessionId": "11112222-3333-4444-a555-666677778888",
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"applicationId": "smoke-test-app",
+				"applicationVersion": "1.0.0"
+			}`))
+		})
+
+		It("returns the session with the attributes from the enrichers added", func() {
+			Expect(resp.Body).To(MatchJSON(`{
+				"sessionId": "11112222-3333-4444-a555-666677778888",
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"ingestionTime": "2019-01-02T10:12:14.000000123Z",
+				"applicationId": "smoke-test-app",
+				"applicationVersion": "1.0.0",
+				"parsedApplicationVersion": { "major": 1, "minor": 0, "patch": 0 },
+				"attributes": { "abacusReceivedBy": "test" },
+				"events": [],
+				"spans": []
+			}`))
+		})
+	})
+})
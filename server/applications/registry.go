@@ -0,0 +1,151 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package applications
+
+import (
+	"sort"
+	"time"
+)
+
+type Application struct {
+	ID string
+
+	// RetentionPeriod is how long raw sessions are kept for after they are ingested. Zero means sessions are kept forever.
+	RetentionPeriod time.Duration
+
+	// Enrichers lists the names of the enrichers that add server-derived attributes to the application's sessions, in the order they run.
+	// See the enrichment package for the available enrichers.
+	Enrichers []string
+
+	// VersionPolicy controls which versions of the application may upload sessions. See the versions package for details.
+	VersionPolicy VersionPolicy
+
+	// ConsentPolicy controls whether sessions must record the user's consent to a privacy policy, and which policy versions are accepted.
+	ConsentPolicy ConsentPolicy
+
+	// ClientConfig is the configuration clients fetch from the server to decide what telemetry to send.
+	ClientConfig ClientConfig
+
+	// ClientConfigOverrides replace ClientConfig for particular ranges of versions. The first override that applies to a version is used.
+	ClientConfigOverrides []ClientConfigOverride
+}
+
+// ConsentPolicy controls whether sessions must record the user's consent to a privacy policy, and which policy versions are accepted.
+// The zero value does not require consent, and accepts consent to any policy version.
+type ConsentPolicy struct {
+	// Required is true if sessions without consent are rejected.
+	Required bool
+
+	// AcceptedPolicyVersions lists the privacy policy versions users can consent to. Sessions with consent to any other
+	// version are rejected, so removing a version from this list withdraws it. If it is empty, consent to any version
+	// is accepted.
+	AcceptedPolicyVersions []string
+}
+
+// ClientConfig is the configuration clients fetch from the server to decide what telemetry to send.
+type ClientConfig struct {
+	// Enabled is false if clients should not send any telemetry.
+	Enabled bool
+
+	// SamplingRate is the proportion of sessions clients should upload, between 0 and 1.
+	SamplingRate float64
+
+	// UploadInterval is how often clients should upload the sessions they have recorded.
+	UploadInterval time.Duration
+
+	// DisabledEventTypes lists the types of events clients should not record.
+	DisabledEventTypes []string
+}
+
+// ClientConfigOverride replaces an application's client configuration for a range of its versions.
+type ClientConfigOverride struct {
+	// MinimumVersion is the earliest version the override applies to, if set.
+	MinimumVersion string
+
+	// MaximumVersion is the earliest version after MinimumVersion that the override does not apply to, if set.
+	MaximumVersion string
+
+	Config ClientConfig
+}
+
+// VersionPolicy controls which versions of an application may upload sessions. The zero value accepts all versions.
+type VersionPolicy struct {
+	// MinimumVersion is the oldest version accepted, if set (eg. '0.40'). Sessions from older versions are rejected.
+	MinimumVersion string
+
+	// DropPrereleases discards sessions from prerelease versions and development builds (ie. versions with a prerelease
+	// component or build metadata, such as '1.2.3-rc.1' or '1.2.3+dev').
+	DropPrereleases bool
+
+	// ReleasedVersions lists the only versions accepted, if set. Sessions from other versions are discarded.
+	ReleasedVersions []string
+}
+
+type Registry struct {
+	applications map[string]Application
+}
+
+func NewRegistry(applications ...Application) *Registry {
+	r := &Registry{applications: make(map[string]Application, len(applications))}
+
+	for _, app := range applications {
+		r.applications[app.ID] = app
+	}
+
+	return r
+}
+
+// DefaultRegistry returns the built-in applications. None of them have a retention period, so their sessions are only
+// deleted once a retention period is configured for them (see the serviceconfig package).
+func DefaultRegistry() *Registry {
+	return NewRegistry(
+		Application{ID: "batect", Enrichers: []string{"region", "clientPlatform", "country"}, ClientConfig: DefaultClientConfig()},
+		Application{ID: "test-app", Enrichers: []string{"region", "clientPlatform"}, ClientConfig: DefaultClientConfig()},
+		Application{ID: "smoke-test-app", ClientConfig: DefaultClientConfig()},
+	)
+}
+
+// DefaultClientConfig returns the configuration that asks clients to upload all of their sessions every hour.
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		Enabled:        true,
+		SamplingRate:   1,
+		UploadInterval: time.Hour,
+	}
+}
+
+func (r *Registry) Get(id string) (Application, bool) {
+	app, ok := r.applications[id]
+
+	return app, ok
+}
+
+// All returns all registered applications, ordered by ID.
+func (r *Registry) All() []Application {
+	apps := make([]Application, 0, len(r.applications))
+
+	for _, app := range r.applications {
+		apps = append(apps, app)
+	}
+
+	sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+
+	return apps
+}
@@ -0,0 +1,75 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package applications_test
+
+import (
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("An application registry", func() {
+	registry := applications.NewRegistry(
+		applications.Application{ID: "second-app", RetentionPeriod: time.Hour},
+		applications.Application{ID: "first-app"},
+	)
+
+	Describe("getting an application", func() {
+		Context("given the application is registered", func() {
+			It("returns the application", func() {
+				app, ok := registry.Get("second-app")
+
+				Expect(ok).To(BeTrue())
+				Expect(app).To(Equal(applications.Application{ID: "second-app", RetentionPeriod: time.Hour}))
+			})
+		})
+
+		Context("given the application is not registered", func() {
+			It("reports that the application could not be found", func() {
+				_, ok := registry.Get("unknown-app")
+
+				Expect(ok).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("getting all applications", func() {
+		It("returns every registered application, ordered by ID", func() {
+			Expect(registry.All()).To(Equal([]applications.Application{
+				{ID: "first-app"},
+				{ID: "second-app", RetentionPeriod: time.Hour},
+			}))
+		})
+	})
+
+	Describe("the default registry", func() {
+		It("contains the applications permitted to upload sessions", func() {
+			ids := []string{}
+
+			for _, app := range applications.DefaultRegistry().All() {
+				ids = append(ids, app.ID)
+			}
+
+			Expect(ids).To(Equal([]string{"batect", "smoke-test-app", "test-app"}))
+		})
+	})
+})
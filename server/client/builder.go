@@ -0,0 +1,243 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+)
+
+// SessionBuilder records a session while an application runs. It is safe for concurrent use.
+type SessionBuilder struct {
+	mutex      sync.Mutex
+	session    types.Session
+	timeSource func() time.Time
+}
+
+// Span records an operation within a session. Spans are created with SessionBuilder.StartSpan or Span.StartChildSpan.
+type Span struct {
+	builder *SessionBuilder
+	index   int
+}
+
+// NewSessionBuilder starts a new session for userID, with a newly generated session ID and the current time as its start time.
+func NewSessionBuilder(applicationID string, applicationVersion string, userID string) (*SessionBuilder, error) {
+	return NewSessionBuilderWithTimeSource(applicationID, applicationVersion, userID, time.Now)
+}
+
+func NewSessionBuilderWithTimeSource(applicationID string, applicationVersion string, userID string, timeSource func() time.Time) (*SessionBuilder, error) {
+	sessionID, err := uuid.NewRandom()
+
+	if err != nil {
+		return nil, fmt.Errorf("could not generate session ID: %w", err)
+	}
+
+	return &SessionBuilder{
+		session: types.Session{
+			SessionID:          sessionID.String(),
+			UserID:             userID,
+			SessionStartTime:   timeSource(),
+			ApplicationID:      applicationID,
+			ApplicationVersion: applicationVersion,
+			Attributes:         map[string]interface{}{},
+			Events:             []types.Event{},
+			Spans:              []types.Span{},
+		},
+		timeSource: timeSource,
+	}, nil
+}
+
+// SessionID returns the ID of the session, so that it can be passed to other processes as their parent session ID.
+func (b *SessionBuilder) SessionID() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.session.SessionID
+}
+
+// SetAttribute sets the session attribute name to value, which must be a string, integer, boolean or nil.
+func (b *SessionBuilder) SetAttribute(name string, value interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.session.Attributes[name] = value
+}
+
+// SetParentSessionID records that this session was started by the session with ID parentSessionID.
+func (b *SessionBuilder) SetParentSessionID(parentSessionID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.session.ParentSessionID = parentSessionID
+}
+
+// SetTraceID records the ID shared by all of the sessions in the user's workflow.
+func (b *SessionBuilder) SetTraceID(traceID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.session.TraceID = traceID
+}
+
+// SetConsent records that the user agreed to the privacy policy with version policyVersion at time consentedAt.
+func (b *SessionBuilder) SetConsent(policyVersion string, consentedAt time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.session.Consent = &types.Consent{PolicyVersion: policyVersion, Time: consentedAt}
+}
+
+// RecordEvent records an event of type eventType at the current time. attributes may be nil.
+func (b *SessionBuilder) RecordEvent(eventType string, attributes map[string]interface{}) {
+	b.recordEvent(eventType, "", attributes)
+}
+
+func (b *SessionBuilder) recordEvent(eventType string, spanID string, attributes map[string]interface{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.session.Events = append(b.session.Events, types.Event{
+		Type:       eventType,
+		Time:       b.timeSource(),
+		SpanID:     spanID,
+		Attributes: copyAttributes(attributes),
+	})
+}
+
+// StartSpan starts a top-level span of type spanType at the current time. attributes may be nil.
+// The span must be ended with Span.End.
+func (b *SessionBuilder) StartSpan(spanType string, attributes map[string]interface{}) *Span {
+	return b.startSpan(spanType, "", attributes)
+}
+
+func (b *SessionBuilder) startSpan(spanType string, parentID string, attributes map[string]interface{}) *Span {
+	id := newSpanID()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.session.Spans = append(b.session.Spans, types.Span{
+		ID:         id,
+		ParentID:   parentID,
+		Type:       spanType,
+		StartTime:  b.timeSource(),
+		Attributes: copyAttributes(attributes),
+	})
+
+	return &Span{builder: b, index: len(b.session.Spans) - 1}
+}
+
+// Build ends the session at the current time and returns it. Any spans that have not been ended are ended at the same time.
+//
+// The builder can continue to be used after calling Build, and later calls return the session with any changes since.
+func (b *SessionBuilder) Build() types.Session {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	session := b.session
+	session.SessionEndTime = b.timeSource()
+	session.Attributes = copyAttributes(b.session.Attributes)
+	session.Events = append([]types.Event{}, b.session.Events...)
+	session.Spans = append([]types.Span{}, b.session.Spans...)
+
+	for i := range session.Events {
+		session.Events[i].Attributes = copyAttributes(session.Events[i].Attributes)
+	}
+
+	for i := range session.Spans {
+		session.Spans[i].Attributes = copyAttributes(session.Spans[i].Attributes)
+
+		if session.Spans[i].EndTime.IsZero() {
+			session.Spans[i].EndTime = session.SessionEndTime
+		}
+	}
+
+	if b.session.Consent != nil {
+		consent := *b.session.Consent
+		session.Consent = &consent
+	}
+
+	return session
+}
+
+// ID returns the span's ID.
+func (s *Span) ID() string {
+	s.builder.mutex.Lock()
+	defer s.builder.mutex.Unlock()
+
+	return s.builder.session.Spans[s.index].ID
+}
+
+// SetAttribute sets the span attribute name to value, which must be a string, integer, boolean or nil.
+func (s *Span) SetAttribute(name string, value interface{}) {
+	s.builder.mutex.Lock()
+	defer s.builder.mutex.Unlock()
+
+	s.builder.session.Spans[s.index].Attributes[name] = value
+}
+
+// RecordEvent records an event of type eventType at the current time, associated with this span. attributes may be nil.
+func (s *Span) RecordEvent(eventType string, attributes map[string]interface{}) {
+	s.builder.recordEvent(eventType, s.ID(), attributes)
+}
+
+// StartChildSpan starts a span of type spanType at the current time, with this span as its parent. attributes may be nil.
+func (s *Span) StartChildSpan(spanType string, attributes map[string]interface{}) *Span {
+	return s.builder.startSpan(spanType, s.ID(), attributes)
+}
+
+// End ends the span at the current time. Calling End on a span that has already ended has no effect.
+func (s *Span) End() {
+	s.builder.mutex.Lock()
+	defer s.builder.mutex.Unlock()
+
+	span := &s.builder.session.Spans[s.index]
+
+	if span.EndTime.IsZero() {
+		span.EndTime = s.builder.timeSource()
+	}
+}
+
+func copyAttributes(attributes map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(attributes))
+
+	for name, value := range attributes {
+		copied[name] = value
+	}
+
+	return copied
+}
+
+// newSpanID returns a random span ID in the format required by validation.SpanIDPattern.
+func newSpanID() string {
+	bytes := make([]byte, 8)
+
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(bytes)
+}
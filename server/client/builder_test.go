@@ -0,0 +1,232 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package client_test
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/client"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Building a session", func() {
+	const userID = "11112222-3333-4444-a555-666677778888"
+
+	var builder *client.SessionBuilder
+	var currentTime time.Time
+
+	startTime := time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+
+	advanceTime := func() time.Time {
+		currentTime = currentTime.Add(time.Second)
+		return currentTime
+	}
+
+	BeforeEach(func() {
+		currentTime = startTime
+
+		var err error
+		builder, err = client.NewSessionBuilderWithTimeSource("my-app", "1.2.3", userID, func() time.Time { return currentTime })
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("when nothing is recorded in the session", func() {
+		var session types.Session
+
+		BeforeEach(func() {
+			advanceTime()
+			session = builder.Build()
+		})
+
+		It("generates a session ID", func() {
+			Expect(session.SessionID).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+			Expect(builder.SessionID()).To(Equal(session.SessionID))
+		})
+
+		It("records the application, user and timing of the session", func() {
+			Expect(session.ApplicationID).To(Equal("my-app"))
+			Expect(session.ApplicationVersion).To(Equal("1.2.3"))
+			Expect(session.UserID).To(Equal(userID))
+			Expect(session.SessionStartTime).To(Equal(startTime))
+			Expect(session.SessionEndTime).To(Equal(startTime.Add(time.Second)))
+		})
+
+		It("returns empty attributes, events and spans", func() {
+			Expect(session.Attributes).To(BeEmpty())
+			Expect(session.Events).To(BeEmpty())
+			Expect(session.Spans).To(BeEmpty())
+		})
+	})
+
+	Context("when attributes, events and spans are recorded in the session", func() {
+		var session types.Session
+		var outerSpan, innerSpan *client.Span
+
+		BeforeEach(func() {
+			builder.SetAttribute("operatingSystem", "Linux")
+			builder.SetParentSessionID("aaaabbbb-cccc-4ddd-aeee-ffff00001111")
+			builder.SetTraceID("99998888-7777-4666-a555-444433332222")
+			builder.SetConsent("2023-06", startTime.Add(-time.Hour))
+
+			advanceTime()
+			builder.RecordEvent("StartedUp", map[string]interface{}{"mode": "interactive"})
+
+			advanceTime()
+			outerSpan = builder.StartSpan("RunTask", map[string]interface{}{"taskName": "build"})
+
+			advanceTime()
+			innerSpan = outerSpan.StartChildSpan("PullImage", nil)
+			innerSpan.SetAttribute("imageName", "alpine:3.18")
+			innerSpan.RecordEvent("DownloadedLayer", nil)
+
+			advanceTime()
+			innerSpan.End()
+
+			advanceTime()
+			innerSpan.End()
+
+			advanceTime()
+			session = builder.Build()
+		})
+
+		It("records the session's attributes and relationships", func() {
+			Expect(session.Attributes).To(Equal(map[string]interface{}{"operatingSystem": "Linux"}))
+			Expect(session.ParentSessionID).To(Equal("aaaabbbb-cccc-4ddd-aeee-ffff00001111"))
+			Expect(session.TraceID).To(Equal("99998888-7777-4666-a555-444433332222"))
+			Expect(session.Consent).To(Equal(&types.Consent{PolicyVersion: "2023-06", Time: startTime.Add(-time.Hour)}))
+		})
+
+		It("records each event with the time it occurred and the span it occurred in", func() {
+			Expect(session.Events).To(Equal([]types.Event{
+				{Type: "StartedUp", Time: startTime.Add(time.Second), Attributes: map[string]interface{}{"mode": "interactive"}},
+				{Type: "DownloadedLayer", Time: startTime.Add(3 * time.Second), SpanID: innerSpan.ID(), Attributes: map[string]interface{}{}},
+			}))
+		})
+
+		It("generates valid, distinct span IDs", func() {
+			spanIDPattern := regexp.MustCompile(validation.SpanIDPattern)
+			Expect(spanIDPattern.MatchString(outerSpan.ID())).To(BeTrue())
+			Expect(spanIDPattern.MatchString(innerSpan.ID())).To(BeTrue())
+			Expect(outerSpan.ID()).ToNot(Equal(innerSpan.ID()))
+		})
+
+		It("records each span with its parent, ending spans that were not ended when the session ends", func() {
+			Expect(session.Spans).To(Equal([]types.Span{
+				{
+					ID:         outerSpan.ID(),
+					Type:       "RunTask",
+					StartTime:  startTime.Add(2 * time.Second),
+					EndTime:    startTime.Add(6 * time.Second),
+					Attributes: map[string]interface{}{"taskName": "build"},
+				},
+				{
+					ID:         innerSpan.ID(),
+					ParentID:   outerSpan.ID(),
+					Type:       "PullImage",
+					StartTime:  startTime.Add(3 * time.Second),
+					EndTime:    startTime.Add(4 * time.Second),
+					Attributes: map[string]interface{}{"imageName": "alpine:3.18"},
+				},
+			}))
+		})
+
+		Context("when the builder is used after the session is built", func() {
+			BeforeEach(func() {
+				builder.SetAttribute("operatingSystem", "Windows")
+				outerSpan.SetAttribute("taskName", "test")
+				builder.RecordEvent("ShutDown", nil)
+			})
+
+			It("does not modify the built session", func() {
+				Expect(session.Attributes).To(Equal(map[string]interface{}{"operatingSystem": "Linux"}))
+				Expect(session.Spans[0].Attributes).To(Equal(map[string]interface{}{"taskName": "build"}))
+				Expect(session.Events).To(HaveLen(2))
+			})
+
+			It("includes the changes in later sessions built", func() {
+				Expect(builder.Build().Events).To(HaveLen(3))
+			})
+		})
+
+		It("builds a session that passes validation", func() {
+			validator, err := client.NewValidator()
+			Expect(err).ToNot(HaveOccurred())
+
+			session.ApplicationID = "test-app"
+			session.Consent = nil
+
+			Expect(validator.Validate(session)).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Validating a session", func() {
+	var validator *client.Validator
+
+	BeforeEach(func() {
+		var err error
+		validator, err = client.NewValidator()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("when the session is not valid", func() {
+		It("returns the same errors as the server", func() {
+			builder, err := client.NewSessionBuilder("unknown-app", "1.2.3", "not-a-uuid")
+			Expect(err).ToNot(HaveOccurred())
+
+			errs, err := validator.Validate(builder.Build())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(ConsistOf(
+				validation.Error{Key: "userId", Type: "uuid4", InvalidValue: "not-a-uuid", Message: "userId must be a valid version 4 UUID"},
+				validation.Error{Key: "applicationId", Type: "applicationId", InvalidValue: "unknown-app", Message: "applicationId must be a valid application ID"},
+			))
+		})
+	})
+
+	Context("when the session is from an application that is only known to a custom registry", func() {
+		var session types.Session
+
+		BeforeEach(func() {
+			builder, err := client.NewSessionBuilder("my-app", "1.2.3", "11112222-3333-4444-a555-666677778888")
+			Expect(err).ToNot(HaveOccurred())
+
+			session = builder.Build()
+			session.Consent = nil
+		})
+
+		It("rejects the session when validating against the default registry", func() {
+			Expect(validator.Validate(session)).To(ConsistOf(
+				validation.Error{Key: "applicationId", Type: "applicationId", InvalidValue: "my-app", Message: "applicationId must be a valid application ID"},
+			))
+		})
+
+		It("accepts the session when validating against the custom registry", func() {
+			customValidator, err := client.NewValidatorForRegistry(applications.NewRegistry(applications.Application{ID: "my-app"}))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(customValidator.Validate(session)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,299 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package client records sessions and uploads them to Abacus.
+//
+// Sessions are recorded with a SessionBuilder, can be checked with a Validator before they are uploaded, and are uploaded
+// with an Uploader, which retries uploads that fail because of a temporary problem.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+)
+
+// UploadResult describes a successful upload.
+type UploadResult int
+
+const (
+	// Created means the server stored the session.
+	Created UploadResult = iota
+
+	// AlreadyUploaded means the server already had the session, for example because an earlier attempt succeeded but its
+	// response was lost.
+	AlreadyUploaded
+)
+
+type RetryOptions struct {
+	// AttemptTimeout limits how long each individual attempt to upload a session can take.
+	AttemptTimeout time.Duration
+
+	// MaxAttempts is the maximum number of attempts made to upload a session, including the first.
+	MaxAttempts int
+
+	// InitialBackoff and MaxBackoff control the delay between attempts: the delay is chosen at random between zero and
+	// InitialBackoff * 2^(attempt - 1), capped at MaxBackoff. If the server asks the client to wait longer with a
+	// Retry-After header, that delay is used instead, unless it is longer than MaxBackoff, in which case the upload is
+	// not retried.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		AttemptTimeout: 30 * time.Second,
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+	}
+}
+
+// RejectedError is returned when the server rejects a session, for example because it is not valid. Retrying the upload
+// will never succeed.
+type RejectedError struct {
+	StatusCode int
+
+	// Code identifies the kind of problem. See docs/errors.md for the possible codes.
+	Code             string
+	Detail           string
+	ValidationErrors []validation.Error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("server rejected session with HTTP %v (%v): %v", e.StatusCode, e.Code, e.Detail)
+}
+
+// UnavailableError is returned when the server could not accept a session because of a temporary problem, and the upload
+// was not retried because all attempts were used or the context was cancelled.
+type UnavailableError struct {
+	StatusCode int
+
+	// RetryAfter is how long the server asked the client to wait before trying again, or zero if it didn't say.
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("server is unavailable, it responded with HTTP %v", e.StatusCode)
+}
+
+const sessionsPath = "/v1/sessions"
+
+// sentAtHeader lets the server correct timestamps in the session for any error in the client's clock.
+const sentAtHeader = "Abacus-Sent-At"
+
+// Uploader uploads sessions to an Abacus server.
+type Uploader struct {
+	baseURL    string
+	httpClient *http.Client
+	options    RetryOptions
+	timeSource func() time.Time
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+// NewUploader returns an uploader that sends sessions to the server at baseURL (eg. 'https://api.abacus.batect.dev') with httpClient.
+func NewUploader(baseURL string, httpClient *http.Client, options RetryOptions) *Uploader {
+	return NewUploaderWithClock(baseURL, httpClient, options, time.Now, sleepWithContext)
+}
+
+func NewUploaderWithClock(
+	baseURL string,
+	httpClient *http.Client,
+	options RetryOptions,
+	timeSource func() time.Time,
+	sleep func(ctx context.Context, d time.Duration) error,
+) *Uploader {
+	return &Uploader{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+		options:    options,
+		timeSource: timeSource,
+		sleep:      sleep,
+	}
+}
+
+// Upload uploads session, retrying if the server is temporarily unavailable or the request fails.
+//
+// It returns a *RejectedError if the server rejects the session, and a *UnavailableError if the server is still
+// unavailable after all attempts have been made, or asks the client to wait longer than RetryOptions.MaxBackoff before
+// trying again. Uploading the same session more than once is safe.
+func (u *Uploader) Upload(ctx context.Context, session types.Session) (UploadResult, error) {
+	body, err := json.Marshal(session)
+
+	if err != nil {
+		return 0, fmt.Errorf("could not encode session: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, retryAfter, err := u.attempt(ctx, body)
+
+		if !isRetryable(ctx, err) || attempt >= u.options.MaxAttempts {
+			return result, err
+		}
+
+		if retryAfter > u.options.MaxBackoff {
+			// Don't hold up the caller for longer than they expect: they can decide when to try again from the error.
+			return result, err
+		}
+
+		delay := u.backoff(attempt)
+
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		if sleepErr := u.sleep(ctx, delay); sleepErr != nil {
+			return result, err
+		}
+	}
+}
+
+func (u *Uploader) attempt(ctx context.Context, body []byte) (UploadResult, time.Duration, error) {
+	if u.options.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.options.AttemptTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.baseURL+sessionsPath, bytes.NewReader(body))
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(sentAtHeader, u.timeSource().Format(time.RFC3339Nano))
+
+	resp, err := u.httpClient.Do(req)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusCreated:
+		return Created, 0, nil
+	case resp.StatusCode == http.StatusNotModified:
+		return AlreadyUploaded, 0, nil
+	case isUnavailableStatus(resp.StatusCode):
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), u.timeSource())
+
+		return 0, retryAfter, &UnavailableError{StatusCode: resp.StatusCode, RetryAfter: retryAfter}
+	default:
+		return 0, 0, newRejectedError(resp)
+	}
+}
+
+// isUnavailableStatus returns true if statusCode means the server could not handle the request at the moment, but might
+// be able to later.
+func isUnavailableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// newRejectedError reads the RFC 7807 problem details object in resp, if there is one.
+func newRejectedError(resp *http.Response) *RejectedError {
+	rejected := &RejectedError{StatusCode: resp.StatusCode}
+	problem := struct {
+		Code             string             `json:"code"`
+		Detail           string             `json:"detail"`
+		ValidationErrors []validation.Error `json:"validationErrors"`
+	}{}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&problem); err != nil {
+		rejected.Detail = http.StatusText(resp.StatusCode)
+
+		return rejected
+	}
+
+	rejected.Code = problem.Code
+	rejected.Detail = problem.Detail
+	rejected.ValidationErrors = problem.ValidationErrors
+
+	return rejected
+}
+
+// parseRetryAfter parses a Retry-After header value, which is either a number of seconds or a HTTP date, returning zero
+// if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+// isRetryable returns true if the upload might succeed if attempted again: the server was unavailable, or the request
+// couldn't be sent or timed out. Sessions the server rejected are never retried, and nor is anything once the caller's
+// context is done, as the caller has stopped waiting for the result.
+func isRetryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var rejected *RejectedError
+
+	return !errors.As(err, &rejected)
+}
+
+func (u *Uploader) backoff(attempt int) time.Duration {
+	maximum := u.options.InitialBackoff << (attempt - 1)
+
+	if maximum > u.options.MaxBackoff || maximum <= 0 {
+		maximum = u.options.MaxBackoff
+	}
+
+	if maximum <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(maximum))) //nolint:gosec
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/client"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	"github.com/batect/abacus/server/versions"
+	"github.com/batect/services-common/middleware/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Uploading a session", func() {
+	var server *httptest.Server
+	var responses []scriptedResponse
+	var requests []*http.Request
+	var requestBodies [][]byte
+	var sleeps []time.Duration
+	var uploader *client.Uploader
+	var result client.UploadResult
+	var err error
+
+	currentTime := time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+
+	session := types.Session{
+		SessionID:          "11112222-3333-4444-a555-666677778888",
+		UserID:             "99998888-7777-4666-a555-444433332222",
+		SessionStartTime:   currentTime.Add(-time.Minute),
+		SessionEndTime:     currentTime,
+		ApplicationID:      "test-app",
+		ApplicationVersion: "1.2.3",
+		Attributes:         map[string]interface{}{},
+		Events:             []types.Event{},
+		Spans:              []types.Span{},
+	}
+
+	options := client.RetryOptions{
+		AttemptTimeout: time.Second,
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     150 * time.Millisecond,
+	}
+
+	BeforeEach(func() {
+		responses = nil
+		requests = nil
+		requestBodies = nil
+		sleeps = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			requests = append(requests, req)
+			requestBodies = append(requestBodies, body)
+
+			response := responses[0]
+			responses = responses[1:]
+
+			for name, value := range response.headers {
+				w.Header().Set(name, value)
+			}
+
+			w.WriteHeader(response.status)
+			_, _ = w.Write([]byte(response.body))
+		}))
+
+		DeferCleanup(server.Close)
+
+		sleep := func(_ context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			return nil
+		}
+
+		uploader = client.NewUploaderWithClock(server.URL+"/", server.Client(), options, func() time.Time { return currentTime }, sleep)
+	})
+
+	upload := func() {
+		result, err = uploader.Upload(context.Background(), session)
+	}
+
+	Context("when the server stores the session", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{{status: http.StatusCreated}}
+			upload()
+		})
+
+		It("reports that the session was created", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(client.Created))
+		})
+
+		It("sends the session to the sessions endpoint as JSON", func() {
+			Expect(requests).To(HaveLen(1))
+			Expect(requests[0].Method).To(Equal(http.MethodPut))
+			Expect(requests[0].URL.Path).To(Equal("/v1/sessions"))
+			Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/json"))
+
+			var sent types.Session
+			Expect(json.Unmarshal(requestBodies[0], &sent)).To(Succeed())
+			Expect(sent).To(Equal(session))
+		})
+
+		It("includes the time the request was sent so that the server can correct for clock skew", func() {
+			Expect(requests[0].Header.Get("Abacus-Sent-At")).To(Equal("2020-05-24T10:12:14Z"))
+		})
+	})
+
+	Context("when the server already has the session", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{{status: http.StatusNotModified}}
+			upload()
+		})
+
+		It("reports that the session was already uploaded", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(client.AlreadyUploaded))
+		})
+	})
+
+	Context("when the server rejects the session", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{{
+				status: http.StatusBadRequest,
+				body:   `{"type":"https://github.com/batect/abacus/blob/main/docs/errors.md#validation-failed","title":"Validation failed","status":400,"code":"validation-failed","detail":"Request body has validation errors","message":"Request body has validation errors","validationErrors":[{"key":"userId","type":"uuid4","invalidValue":"abc","message":"userId must be a valid version 4 UUID"}]}`,
+			}}
+
+			upload()
+		})
+
+		It("returns the details of the problem", func() {
+			var rejected *client.RejectedError
+			Expect(errors.As(err, &rejected)).To(BeTrue())
+			Expect(rejected).To(Equal(&client.RejectedError{
+				StatusCode: http.StatusBadRequest,
+				Code:       "validation-failed",
+				Detail:     "Request body has validation errors",
+				ValidationErrors: []validation.Error{
+					{Key: "userId", Type: "uuid4", InvalidValue: "abc", Message: "userId must be a valid version 4 UUID"},
+				},
+			}))
+		})
+
+		It("does not retry the upload", func() {
+			Expect(requests).To(HaveLen(1))
+		})
+	})
+
+	Context("when the server rejects the session without a problem details response", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{{status: http.StatusNotFound, body: "Not found"}}
+			upload()
+		})
+
+		It("returns an error with the status of the response", func() {
+			Expect(err).To(MatchError("server rejected session with HTTP 404 (): Not Found"))
+		})
+	})
+
+	Context("when the server is unavailable and then stores the session", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{
+				{status: http.StatusServiceUnavailable},
+				{status: http.StatusCreated},
+			}
+
+			upload()
+		})
+
+		It("retries the upload", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(client.Created))
+			Expect(requests).To(HaveLen(2))
+		})
+
+		It("waits before retrying", func() {
+			Expect(sleeps).To(HaveLen(1))
+			Expect(sleeps[0]).To(BeNumerically("<", options.InitialBackoff))
+		})
+	})
+
+	Context("when the server is unavailable and asks the client to retry after a delay no longer than the maximum backoff", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{
+				{status: http.StatusServiceUnavailable, headers: map[string]string{"Retry-After": "30"}},
+				{status: http.StatusServiceUnavailable, headers: map[string]string{"Retry-After": currentTime.Add(time.Minute).Format(http.TimeFormat)}},
+				{status: http.StatusNotModified},
+			}
+
+			longerBackoffOptions := options
+			longerBackoffOptions.MaxBackoff = time.Minute
+
+			sleep := func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			uploader = client.NewUploaderWithClock(server.URL, server.Client(), longerBackoffOptions, func() time.Time { return currentTime }, sleep)
+			upload()
+		})
+
+		It("waits for the requested delay before retrying", func() {
+			Expect(sleeps).To(Equal([]time.Duration{30 * time.Second, time.Minute}))
+		})
+
+		It("reports the result of the final attempt", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(client.AlreadyUploaded))
+		})
+	})
+
+	Context("when the server is unavailable and asks the client to retry after a delay longer than the maximum backoff", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{
+				{status: http.StatusServiceUnavailable, headers: map[string]string{"Retry-After": "3600"}},
+			}
+
+			upload()
+		})
+
+		It("does not wait or retry the upload", func() {
+			Expect(requests).To(HaveLen(1))
+			Expect(sleeps).To(BeEmpty())
+		})
+
+		It("returns an error that includes the requested delay", func() {
+			Expect(err).To(Equal(&client.UnavailableError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}))
+		})
+	})
+
+	Context("when the server is unavailable on every attempt", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{
+				{status: http.StatusServiceUnavailable},
+				{status: http.StatusInternalServerError},
+				{status: http.StatusServiceUnavailable, headers: map[string]string{"Retry-After": "12"}},
+			}
+
+			upload()
+		})
+
+		It("stops after the maximum number of attempts", func() {
+			Expect(requests).To(HaveLen(3))
+			Expect(sleeps).To(HaveLen(2))
+		})
+
+		It("returns the error from the final attempt", func() {
+			Expect(err).To(Equal(&client.UnavailableError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 12 * time.Second}))
+		})
+	})
+
+	Context("when the server can't be reached", func() {
+		BeforeEach(func() {
+			server.Close()
+			upload()
+		})
+
+		It("retries the upload and then returns the error", func() {
+			Expect(err).To(MatchError(ContainSubstring("could not send request")))
+			Expect(sleeps).To(HaveLen(2))
+		})
+	})
+
+	Context("when the context is cancelled while waiting to retry", func() {
+		BeforeEach(func() {
+			responses = []scriptedResponse{{status: http.StatusServiceUnavailable}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			sleep := func(ctx context.Context, _ time.Duration) error {
+				cancel()
+				return ctx.Err()
+			}
+
+			uploader = client.NewUploaderWithClock(server.URL, server.Client(), options, func() time.Time { return currentTime }, sleep)
+			result, err = uploader.Upload(ctx, session)
+		})
+
+		It("stops retrying and returns the error from the last attempt", func() {
+			Expect(requests).To(HaveLen(1))
+			Expect(err).To(Equal(&client.UnavailableError{StatusCode: http.StatusServiceUnavailable}))
+		})
+	})
+
+	Context("when uploading to the ingest endpoint", func() {
+		var store *memoryStore
+
+		BeforeEach(func() {
+			store = &memoryStore{}
+			registry := applications.NewRegistry(applications.Application{ID: "test-app"})
+
+			pipeline, err := enrichment.NewPipeline(registry, map[string]enrichment.Enricher{})
+			Expect(err).ToNot(HaveOccurred())
+
+			policies, err := versions.NewPolicies(registry)
+			Expect(err).ToNot(HaveOccurred())
+
+			handler, err := api.NewIngestHandlerWithTimeSource(store, store, registry, pipeline, policies, func() time.Time { return currentTime })
+			Expect(err).ToNot(HaveOccurred())
+
+			server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				req, _ = testutils.RequestWithTestLogger(req)
+				handler.ServeHTTP(w, req)
+			})
+		})
+
+		It("stores a session built with the session builder", func() {
+			builder, err := client.NewSessionBuilderWithTimeSource("test-app", "1.2.3", session.UserID, func() time.Time { return currentTime })
+			Expect(err).ToNot(HaveOccurred())
+
+			builder.StartSpan("RunTask", nil).RecordEvent("Started", nil)
+			built := builder.Build()
+
+			Expect(uploader.Upload(context.Background(), built)).To(Equal(client.Created))
+			Expect(store.sessionIDs).To(ConsistOf(built.SessionID))
+		})
+
+		It("reports sessions that have already been uploaded", func() {
+			Expect(uploader.Upload(context.Background(), session)).To(Equal(client.Created))
+			Expect(uploader.Upload(context.Background(), session)).To(Equal(client.AlreadyUploaded))
+		})
+
+		It("reports invalid sessions", func() {
+			invalid := session
+			invalid.UserID = "abc"
+
+			_, err := uploader.Upload(context.Background(), invalid)
+
+			var rejected *client.RejectedError
+			Expect(errors.As(err, &rejected)).To(BeTrue())
+			Expect(rejected.Code).To(Equal("validation-failed"))
+			Expect(rejected.ValidationErrors).To(ContainElement(HaveField("Key", "userId")))
+		})
+	})
+})
+
+type scriptedResponse struct {
+	status  int
+	headers map[string]string
+	body    string
+}
+
+type memoryStore struct {
+	sessionIDs []string
+}
+
+func (s *memoryStore) Store(_ context.Context, session *types.Session) error {
+	for _, id := range s.sessionIDs {
+		if id == session.SessionID {
+			return storage.ErrAlreadyExists
+		}
+	}
+
+	s.sessionIDs = append(s.sessionIDs, session.SessionID)
+
+	return nil
+}
+
+func (s *memoryStore) RecordOptOut(_ context.Context, _ *types.OptOut) error {
+	return nil
+}
+
+func (s *memoryStore) IsOptedOut(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
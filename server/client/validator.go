@@ -0,0 +1,73 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package client
+
+import (
+	"errors"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+// Validator checks sessions against the same rules as the server, so that invalid sessions can be found before they are uploaded.
+//
+// Checks that depend on when the session is uploaded, such as whether the session is too old, are left to the server.
+type Validator struct {
+	validator  *validator.Validate
+	translator ut.Translator
+}
+
+// NewValidator returns a Validator that only accepts sessions from applications in the default application registry.
+// Use NewValidatorForRegistry for servers that are configured with other applications.
+func NewValidator() (*Validator, error) {
+	return NewValidatorForRegistry(applications.DefaultRegistry())
+}
+
+// NewValidatorForRegistry returns a Validator that only accepts sessions from applications in registry. registry should
+// match the applications configured on the server that sessions are uploaded to.
+func NewValidatorForRegistry(registry *applications.Registry) (*Validator, error) {
+	v, trans, err := validation.CreateValidatorForRegistry(registry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{validator: v, translator: trans}, nil
+}
+
+// Validate returns the problems with session, or an error if session can't be validated at all.
+func (v *Validator) Validate(session types.Session) ([]validation.Error, error) {
+	err := v.validator.Struct(session)
+
+	if err == nil {
+		return nil, nil
+	}
+
+	var validationErrors validator.ValidationErrors
+
+	if errors.As(err, &validationErrors) {
+		return validation.ToValidationErrors(validationErrors, v.translator), nil
+	}
+
+	return nil, err
+}
@@ -28,10 +28,16 @@ import (
 
 	cloudstorage "cloud.google.com/go/storage"
 	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/encryption"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/metrics"
+	"github.com/batect/abacus/server/observability"
+	"github.com/batect/abacus/server/pseudonymisation"
+	"github.com/batect/abacus/server/serviceconfig"
 	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/versions"
 	"github.com/batect/services-common/graceful"
 	"github.com/batect/services-common/middleware"
-	"github.com/batect/services-common/startup"
 	"github.com/batect/services-common/tracing"
 	"github.com/sirupsen/logrus"
 	"github.com/unrolled/secure"
@@ -41,51 +47,194 @@ import (
 )
 
 func main() {
-	config, err := getConfig()
+	command, args := "serve", []string{}
+
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
+	load := getConfig
+
+	if command == "serve" {
+		load = getServerConfig
+	}
+
+	config, err := load()
 
 	if err != nil {
 		logrus.WithError(err).Error("Could not load application configuration.")
 		os.Exit(1)
 	}
 
-	flush, err := startup.InitialiseObservability(config.ServiceName, config.ServiceVersion, config.ProjectID, config.HoneycombAPIKey)
+	flush, err := observability.Initialise(config.ObservabilityConfig())
 
 	if err != nil {
 		logrus.WithError(err).Error("Could not initialise observability tooling.")
 		os.Exit(1)
 	}
 
+	logrus.SetLevel(config.LogLevel())
+
 	defer flush()
 
-	runServer(config)
+	switch command {
+	case "serve":
+		runServer(config)
+	case "compact":
+		runCompaction(config, args)
+	case "enforce-retention":
+		runRetentionEnforcement(config)
+	case "enforce-opt-outs":
+		runOptOutEnforcement(config)
+	case "export":
+		runExport(config, args)
+	case "export-traces":
+		runTraceExport(config, args)
+	default:
+		logrus.WithField("command", command).Error("Unknown command.")
+		os.Exit(1)
+	}
 }
 
-func runServer(config *serviceConfig) {
-	srv, err := createServer(config)
+func runServer(config *serviceconfig.Config) {
+	settings, err := newReloadableSettings(config)
+
+	if err != nil {
+		logrus.WithError(err).Error("Could not create server.")
+		os.Exit(1)
+	}
+
+	srv, err := createServer(config, settings)
 
 	if err != nil {
 		logrus.WithError(err).Error("Could not create server.")
 		os.Exit(1)
 	}
 
+	reloadOnSignal(config, settings)
+
+	if config.Server.MetricsPort != "" {
+		go runMetricsServer(config.Server.MetricsPort)
+	}
+
 	if err := graceful.RunServerWithGracefulShutdown(srv); err != nil {
 		logrus.WithError(err).Error("Could not run server.")
 		os.Exit(1)
 	}
 }
 
-func createServer(config *serviceConfig) (*http.Server, error) {
+// runMetricsServer serves Prometheus metrics on port, separately from the public API.
+func runMetricsServer(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%s", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if err := graceful.RunServerWithGracefulShutdown(srv); err != nil {
+		logrus.WithError(err).Error("Could not run metrics server.")
+		os.Exit(1)
+	}
+}
+
+const readinessCacheDuration = 10 * time.Second
+
+// optOutCacheDuration is how long the result of checking whether a user has opted out is reused for. Opt-outs recorded by
+// other instances of the service can take this long to be seen, so sessions sent by the user in that time may still be stored.
+const optOutCacheDuration = 30 * time.Second
+
+func createServer(config *serviceconfig.Config, settings *reloadableSettings) (*http.Server, error) {
 	mux := http.NewServeMux()
 	mux.Handle("/", otelhttp.WithRouteTag("/", http.HandlerFunc(api.Home)))
 	mux.Handle("/ping", otelhttp.WithRouteTag("/ping", http.HandlerFunc(api.Ping)))
+	mux.Handle("/health/live", otelhttp.WithRouteTag("/health/live", http.HandlerFunc(api.Live)))
+	mux.Handle("/v1/openapi.json", otelhttp.WithRouteTag("/v1/openapi.json", http.HandlerFunc(api.OpenAPIDocument)))
+	mux.Handle("/v1/schemas/session.json", otelhttp.WithRouteTag("/v1/schemas/session.json", http.HandlerFunc(api.SessionSchema)))
+
+	store, err := createSessionStore(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	registry := applicationRegistry(config)
+	enrichers, err := enrichment.DefaultEnrichers(enrichment.Config{Region: config.Enrichment.Region, CountryDatabaseFile: config.Enrichment.GeoIPDatabaseFile})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create enrichers: %w", err)
+	}
+
+	enrichmentPipeline, err := enrichment.NewPipeline(registry, enrichers)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create enrichment pipeline: %w", err)
+	}
+
+	versionPolicies, err := versions.NewPolicies(registry)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create version policies: %w", err)
+	}
+
+	optOutStore, err := createOptOutStore(config)
+
+	if err != nil {
+		return nil, err
+	}
 
-	ingestHandler, err := createIngestHandler(config)
+	pseudonymiser, err := createPseudonymiser(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resilientStore := storage.NewResilientSessionStore(pseudonymisation.NewSessionStore(store, pseudonymiser), config.ResilienceOptions())
+	instrumentedStore := metrics.NewInstrumentedSessionStore(resilientStore)
+	resilientOptOutStore := storage.NewResilientOptOutStore(optOutStore, config.ResilienceOptions())
+	instrumentedOptOutStore := metrics.NewInstrumentedOptOutStore(resilientOptOutStore)
+	cachingOptOutStore := storage.NewCachingOptOutStore(instrumentedOptOutStore, optOutCacheDuration)
+	ingestHandler, err := api.NewIngestHandler(instrumentedStore, cachingOptOutStore, registry, enrichmentPipeline, versionPolicies)
 
 	if err != nil {
 		return nil, fmt.Errorf("could not create ingest endpoint handler: %w", err)
 	}
 
-	mux.Handle("/v1/sessions", otelhttp.WithRouteTag("/v1/sessions", ingestHandler))
+	tracesHandler, err := api.NewTracesHandler(instrumentedStore, cachingOptOutStore, registry, enrichmentPipeline, versionPolicies, settings.maxTracesRequestSize.Load)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create traces endpoint handler: %w", err)
+	}
+
+	validateHandler, err := api.NewValidateHandler(registry, enrichmentPipeline, versionPolicies)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create validation endpoint handler: %w", err)
+	}
+
+	readinessHandler := api.NewReadinessHandler(map[string]storage.HealthChecker{
+		"sessionStore": resilientStore,
+		"optOutStore":  resilientOptOutStore,
+	}, readinessCacheDuration)
+
+	optOutHandler, err := api.NewOptOutHandler(cachingOptOutStore)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create opt-out endpoint handler: %w", err)
+	}
+
+	limitSessionRequestSize := func(handler http.Handler) http.Handler {
+		return api.LimitRequestBodySize(settings.maxSessionRequestSize.Load, handler)
+	}
+
+	mux.Handle("/v1/sessions", otelhttp.WithRouteTag("/v1/sessions", metrics.MeasureRequestBodySize("/v1/sessions", limitSessionRequestSize(ingestHandler))))
+	mux.Handle("/v1/sessions/validate", otelhttp.WithRouteTag("/v1/sessions/validate", limitSessionRequestSize(validateHandler)))
+	mux.Handle("/v1/traces", otelhttp.WithRouteTag("/v1/traces", metrics.MeasureRequestBodySize("/v1/traces", api.LimitRequestBodySize(settings.maxTracesRequestSize.Load, tracesHandler))))
+	mux.Handle("/v1/opt-outs", otelhttp.WithRouteTag("/v1/opt-outs", api.RateLimitByClient(settings.maxOptOutRequests.Load, time.Minute, limitSessionRequestSize(optOutHandler))))
+	mux.Handle("/health/ready", otelhttp.WithRouteTag("/health/ready", readinessHandler))
+	mux.Handle(api.ConfigPathPrefix, otelhttp.WithRouteTag(api.ConfigPathPrefix+"{applicationId}", &settings.configHandler))
 
 	securityHeaders := secure.New(secure.Options{
 		FrameDeny:             true,
@@ -97,48 +246,102 @@ func createServer(config *serviceConfig) (*http.Server, error) {
 	wrappedMux := middleware.TraceIDExtractionMiddleware(
 		middleware.LoggerMiddleware(
 			logrus.StandardLogger(),
-			config.ProjectID,
+			config.Server.ProjectID,
 			securityHeaders.Handler(mux),
 		),
 	)
 
 	srv := &http.Server{
-		Addr: fmt.Sprintf(":%s", config.Port),
-		Handler: otelhttp.NewHandler(
+		Addr: fmt.Sprintf(":%s", config.Server.Port),
+		// The client's IP address is removed before anything else sees the request, so that it is never logged or traced.
+		Handler: enrichment.HideClientIP(otelhttp.NewHandler(
 			wrappedMux,
 			"Abacus",
 			otelhttp.WithMessageEvents(otelhttp.ReadEvents, otelhttp.WriteEvents),
 			otelhttp.WithSpanNameFormatter(tracing.NameHTTPRequestSpan),
-		),
+		)),
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
 	return srv, nil
 }
 
-func createIngestHandler(config *serviceConfig) (http.Handler, error) {
-	scopesOption := option.WithScopes(cloudstorage.ScopeReadWrite)
-	credsOption := option.WithCredentialsFile(getCredentialsFilePath())
-	tracingClientOption, err := withTracingClient(scopesOption, credsOption)
+func createSessionStore(config *serviceconfig.Config) (storage.SessionStore, error) {
+	tracingClientOption, err := cloudStorageClientOption()
 
 	if err != nil {
-		return nil, fmt.Errorf("could not create tracing client: %w", err)
+		return nil, err
+	}
+
+	encryptor, err := createEncryptor(config)
+
+	if err != nil {
+		return nil, err
 	}
 
-	bucketName := fmt.Sprintf("%v-sessions", config.ProjectID)
-	store, err := storage.NewCloudStorageSessionStore(bucketName, tracingClientOption)
+	store, err := storage.NewEncryptedCloudStorageSessionStore(config.Storage.Bucket, encryptor, tracingClientOption)
 
 	if err != nil {
 		return nil, fmt.Errorf("could not create session store: %w", err)
 	}
 
-	handler, err := api.NewIngestHandler(store)
+	return store, nil
+}
+
+func createOptOutStore(config *serviceconfig.Config) (*storage.CloudStorageOptOutStore, error) {
+	tracingClientOption, err := cloudStorageClientOption()
 
 	if err != nil {
-		return nil, fmt.Errorf("could not instantiate ingest API handler: %w", err)
+		return nil, err
+	}
+
+	store, err := storage.NewCloudStorageOptOutStore(config.Storage.Bucket, tracingClientOption)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create opt-out store: %w", err)
+	}
+
+	return store, nil
+}
+
+func createEncryptor(config *serviceconfig.Config) (*encryption.Encryptor, error) {
+	if config.Storage.EncryptionKeyFile == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	wrapper, err := encryption.LoadLocalKeyFile(config.Storage.EncryptionKeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load encryption keys: %w", err)
+	}
+
+	return encryption.NewEncryptor(wrapper), nil
+}
+
+func createPseudonymiser(config *serviceconfig.Config) (*pseudonymisation.Pseudonymiser, error) {
+	if config.Storage.PseudonymisationKeyFile == "" {
+		return pseudonymisation.NewPseudonymiser()
+	}
+
+	pseudonymiser, err := pseudonymisation.LoadKeyFile(config.Storage.PseudonymisationKeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load pseudonymisation keys: %w", err)
+	}
+
+	return pseudonymiser, nil
+}
+
+func cloudStorageClientOption() (option.ClientOption, error) {
+	scopesOption := option.WithScopes(cloudstorage.ScopeReadWrite)
+	credsOption := option.WithCredentialsFile(getCredentialsFilePath())
+	tracingClientOption, err := withTracingClient(scopesOption, credsOption)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tracing client: %w", err)
 	}
 
-	return handler, nil
+	return tracingClientOption, nil
 }
 
 func withTracingClient(opts ...option.ClientOption) (option.ClientOption, error) {
@@ -0,0 +1,88 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/batect/abacus/server/serviceconfig"
+	"github.com/batect/abacus/server/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func runCompaction(config *serviceconfig.Config, args []string) {
+	flags := flag.NewFlagSet("compact", flag.ExitOnError)
+	applicationID := flags.String("application", "", "Application to compact sessions for (default: all applications)")
+	minimumAge := flags.Duration("minimum-age", 7*24*time.Hour, "Only compact sessions ingested on days that ended at least this long ago")
+
+	if err := flags.Parse(args); err != nil {
+		logrus.WithError(err).Error("Could not parse command line arguments.")
+		os.Exit(1)
+	}
+
+	if err := compact(context.Background(), config, *applicationID, *minimumAge); err != nil {
+		logrus.WithError(err).Error("Could not compact sessions.")
+		os.Exit(1)
+	}
+}
+
+func compact(ctx context.Context, config *serviceconfig.Config, applicationID string, minimumAge time.Duration) error {
+	tracingClientOption, err := cloudStorageClientOption()
+
+	if err != nil {
+		return err
+	}
+
+	encryptor, err := createEncryptor(config)
+
+	if err != nil {
+		return err
+	}
+
+	compactor, err := storage.NewCloudStorageCompactor(config.Storage.Bucket, minimumAge, encryptor, tracingClientOption)
+
+	if err != nil {
+		return fmt.Errorf("could not create compactor: %w", err)
+	}
+
+	var reports []storage.CompactionReport
+
+	if applicationID == "" {
+		reports, err = compactor.CompactAll(ctx)
+	} else {
+		var report storage.CompactionReport
+		report, err = compactor.Compact(ctx, applicationID)
+		reports = []storage.CompactionReport{report}
+	}
+
+	for _, report := range reports {
+		logrus.
+			WithField("applicationId", report.ApplicationID).
+			WithField("bundlesWritten", report.BundlesWritten).
+			WithField("sessionsCompacted", report.SessionsCompacted).
+			Info("Compaction finished for application.")
+	}
+
+	return err
+}
@@ -20,74 +20,39 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/serviceconfig"
 	"github.com/sirupsen/logrus"
 )
 
-type serviceConfig struct {
-	ServiceName     string
-	ServiceVersion  string
-	Port            string
-	ProjectID       string
-	HoneycombAPIKey string
-}
-
-func getConfig() (*serviceConfig, error) {
-	port, err := getPort()
+// configFileVariableName is the environment variable that holds the path to the configuration file. The configuration is
+// taken entirely from other environment variables if it is not set.
+const configFileVariableName = "CONFIG_FILE"
 
-	if err != nil {
-		return nil, fmt.Errorf("could not get port for service to listen to: %w", err)
-	}
+func getConfig() (*serviceconfig.Config, error) {
+	return serviceconfig.Load(os.Getenv(configFileVariableName), os.LookupEnv)
+}
 
-	projectID, err := getProjectID()
+// getServerConfig is like getConfig, but also checks the settings that are only used by the serve command.
+func getServerConfig() (*serviceconfig.Config, error) {
+	config, err := getConfig()
 
 	if err != nil {
-		return nil, fmt.Errorf("could not get project ID: %w", err)
+		return nil, err
 	}
 
-	honeycombAPIKey, err := getHoneycombAPIKey()
-
-	if err != nil {
-		return nil, fmt.Errorf("could not get Honeycomb API key: %w", err)
+	if err := config.ValidateServer(); err != nil {
+		return nil, err
 	}
 
-	return &serviceConfig{
-		ServiceName:     getServiceName(),
-		ServiceVersion:  getServiceVersion(),
-		Port:            port,
-		ProjectID:       projectID,
-		HoneycombAPIKey: honeycombAPIKey,
-	}, nil
+	return config, nil
 }
 
-func getServiceName() string {
-	return getEnvOrDefault("K_SERVICE", "abacus")
-}
-
-func getServiceVersion() string {
-	return getEnvOrDefault("K_REVISION", "local")
-}
-
-func getEnvOrDefault(name string, fallback string) string {
-	if value, ok := os.LookupEnv(name); ok {
-		return value
-	}
-
-	return fallback
-}
-
-func getPort() (string, error) {
-	return getEnv("PORT")
-}
-
-func getProjectID() (string, error) {
-	return getEnv("GOOGLE_PROJECT")
-}
-
-func getHoneycombAPIKey() (string, error) {
-	return getEnv("HONEYCOMB_API_KEY")
+// applicationRegistry returns the built-in application registry, with the applications from config applied.
+func applicationRegistry(config *serviceconfig.Config) *applications.Registry {
+	return config.Registry(applications.DefaultRegistry())
 }
 
 func getCredentialsFilePath() string {
@@ -100,13 +65,3 @@ func getCredentialsFilePath() string {
 
 	return value
 }
-
-func getEnv(name string) (string, error) {
-	value := os.Getenv(name)
-
-	if value == "" {
-		return "", fmt.Errorf("environment variable '%v' is not set", name)
-	}
-
-	return value, nil
-}
@@ -0,0 +1,95 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/batect/abacus/server/serviceconfig"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/sirupsen/logrus"
+)
+
+func runExport(config *serviceconfig.Config, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	applicationID := flags.String("application", "", "Application to export sessions for")
+	relatedTo := flags.String("related-to", "", "Only export sessions from the same workflow as this session ID")
+
+	if err := flags.Parse(args); err != nil {
+		logrus.WithError(err).Error("Could not parse command line arguments.")
+		os.Exit(1)
+	}
+
+	if *applicationID == "" {
+		logrus.Error("No application provided, use -application to provide one.")
+		os.Exit(1)
+	}
+
+	if err := export(context.Background(), config, *applicationID, *relatedTo, os.Stdout); err != nil {
+		logrus.WithError(err).Error("Could not export sessions.")
+		os.Exit(1)
+	}
+}
+
+// export writes all stored sessions for the application to w as newline-delimited JSON, decrypting them if required.
+// If relatedTo is not empty, only sessions from the same workflow as that session are written.
+func export(ctx context.Context, config *serviceconfig.Config, applicationID string, relatedTo string, w io.Writer) error {
+	store, err := createSessionStore(config)
+
+	if err != nil {
+		return err
+	}
+
+	reader, ok := store.(storage.SessionReader)
+
+	if !ok {
+		return errors.New("session store does not support reading sessions")
+	}
+
+	encoder := json.NewEncoder(w)
+	count := 0
+
+	write := func(session *types.Session) error {
+		count++
+
+		return encoder.Encode(session)
+	}
+
+	if relatedTo == "" {
+		err = reader.ReadSessions(ctx, applicationID, write)
+	} else {
+		err = storage.ReadRelatedSessions(ctx, reader, applicationID, relatedTo, write)
+	}
+
+	if err != nil {
+		return fmt.Errorf("reading sessions failed: %w", err)
+	}
+
+	logrus.WithField("applicationId", applicationID).WithField("sessionCount", count).Info("Export finished.")
+
+	return nil
+}
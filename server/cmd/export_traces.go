@@ -0,0 +1,151 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/batect/abacus/server/otlp"
+	"github.com/batect/abacus/server/serviceconfig"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/sirupsen/logrus"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(value string) error {
+	name, headerValue, found := strings.Cut(value, "=")
+
+	if !found || name == "" {
+		return fmt.Errorf("header '%v' must be in the format 'Name=value'", value)
+	}
+
+	h[name] = headerValue
+
+	return nil
+}
+
+func runTraceExport(config *serviceconfig.Config, args []string) {
+	flags := flag.NewFlagSet("export-traces", flag.ExitOnError)
+	applicationID := flags.String("application", "", "Application to export sessions for")
+	endpoint := flags.String("endpoint", "", "OTLP/HTTP traces endpoint to send traces to, eg. http://localhost:4318/v1/traces")
+	batchSize := flags.Int("batch-size", 50, "Number of sessions to send in each request")
+	headers := headerFlags{}
+	flags.Var(headers, "header", "Header to add to each request, in the format 'Name=value' (can be given multiple times)")
+
+	if err := flags.Parse(args); err != nil {
+		logrus.WithError(err).Error("Could not parse command line arguments.")
+		os.Exit(1)
+	}
+
+	if *applicationID == "" {
+		logrus.Error("No application provided, use -application to provide one.")
+		os.Exit(1)
+	}
+
+	if *endpoint == "" {
+		logrus.Error("No endpoint provided, use -endpoint to provide one.")
+		os.Exit(1)
+	}
+
+	if *batchSize < 1 {
+		logrus.Error("Batch size must be at least 1.")
+		os.Exit(1)
+	}
+
+	exporter := otlp.NewExporter(*endpoint, headers)
+
+	if err := exportTraces(context.Background(), config, *applicationID, exporter, *batchSize); err != nil {
+		logrus.WithError(err).Error("Could not export sessions as traces.")
+		os.Exit(1)
+	}
+}
+
+// exportTraces sends all stored sessions for the application to exporter, one trace per session.
+func exportTraces(ctx context.Context, config *serviceconfig.Config, applicationID string, exporter *otlp.Exporter, batchSize int) error {
+	store, err := createSessionStore(config)
+
+	if err != nil {
+		return err
+	}
+
+	reader, ok := store.(storage.SessionReader)
+
+	if !ok {
+		return errors.New("session store does not support reading sessions")
+	}
+
+	batch := make([]*tracepb.ResourceSpans, 0, batchSize)
+	count := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := exporter.Export(ctx, batch); err != nil {
+			return fmt.Errorf("exporting traces failed: %w", err)
+		}
+
+		count += len(batch)
+		batch = batch[:0]
+
+		return nil
+	}
+
+	err = reader.ReadSessions(ctx, applicationID, func(session *types.Session) error {
+		trace, err := otlp.TracesFromSession(session)
+
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, trace)
+
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		return flush()
+	})
+
+	if err != nil {
+		return fmt.Errorf("reading sessions failed: %w", err)
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	logrus.WithField("applicationId", applicationID).WithField("sessionCount", count).Info("Trace export finished.")
+
+	return nil
+}
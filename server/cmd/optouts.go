@@ -0,0 +1,87 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/batect/abacus/server/optouts"
+	"github.com/batect/abacus/server/serviceconfig"
+	"github.com/batect/abacus/server/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func runOptOutEnforcement(config *serviceconfig.Config) {
+	if err := enforceOptOuts(context.Background(), config); err != nil {
+		logrus.WithError(err).Error("Could not enforce opt-outs.")
+		os.Exit(1)
+	}
+}
+
+func enforceOptOuts(ctx context.Context, config *serviceconfig.Config) error {
+	store, err := createSessionStore(config)
+
+	if err != nil {
+		return err
+	}
+
+	deleter, ok := store.(storage.SessionDeleter)
+
+	if !ok {
+		return errors.New("session store does not support deleting sessions")
+	}
+
+	optOutStore, err := createOptOutStore(config)
+
+	if err != nil {
+		return err
+	}
+
+	pseudonymiser, err := createPseudonymiser(config)
+
+	if err != nil {
+		return err
+	}
+
+	enforcer := optouts.NewEnforcer(applicationRegistry(config), optOutStore, deleter, pseudonymiser)
+	report, err := enforcer.Enforce(ctx)
+
+	for _, app := range report.Applications {
+		logrus.
+			WithField("applicationId", app.ApplicationID).
+			WithField("sessionsScanned", app.SessionsScanned).
+			WithField("sessionsDeleted", app.SessionsDeleted).
+			Info("Opt-outs enforced for application.")
+	}
+
+	logrus.
+		WithField("usersWithDeletionRequested", report.UsersWithDeletionRequested).
+		WithField("sessionsDeleted", report.TotalSessionsDeleted()).
+		Info("Opt-out enforcement finished.")
+
+	if err != nil {
+		return fmt.Errorf("enforcing opt-outs failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/batect/abacus/server/api"
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/serviceconfig"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadableSettings holds the parts of the server that can be changed while it is running.
+type reloadableSettings struct {
+	maxSessionRequestSize atomic.Int64
+	maxTracesRequestSize  atomic.Int64
+	maxOptOutRequests     atomic.Int64
+	configHandler         reloadableHandler
+}
+
+func newReloadableSettings(config *serviceconfig.Config) (*reloadableSettings, error) {
+	settings := &reloadableSettings{}
+
+	if err := settings.apply(config); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// apply updates settings to match config. Settings are left unchanged if config can't be applied.
+func (s *reloadableSettings) apply(config *serviceconfig.Config) error {
+	configHandler, err := api.NewConfigHandler(applicationRegistry(config))
+
+	if err != nil {
+		return fmt.Errorf("could not create config endpoint handler: %w", err)
+	}
+
+	s.configHandler.set(configHandler)
+	s.maxSessionRequestSize.Store(config.Limits.MaxSessionRequestSize)
+	s.maxTracesRequestSize.Store(config.Limits.MaxTracesRequestSize)
+	s.maxOptOutRequests.Store(config.Limits.MaxOptOutRequestsPerMinute)
+	logrus.SetLevel(config.LogLevel())
+
+	return nil
+}
+
+// reloadOnSignal reloads the configuration each time the process receives SIGHUP, and applies it to settings.
+// running is the configuration the server was started with, and is used to warn about changes that need a restart.
+func reloadOnSignal(running *serviceconfig.Config, settings *reloadableSettings) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			running = reload(running, settings)
+		}
+	}()
+}
+
+// reload loads the configuration and applies it to settings, returning the configuration that is now running.
+// Changes that need a restart are only reported by the first reload that sees them.
+func reload(running *serviceconfig.Config, settings *reloadableSettings) *serviceconfig.Config {
+	logrus.Info("Reloading configuration.")
+
+	updated, err := getServerConfig()
+
+	if err != nil {
+		logrus.WithError(err).Error("Could not reload configuration, continuing with previous configuration.")
+		return running
+	}
+
+	if changes := running.ChangesRequiringRestart(updated, applications.DefaultRegistry()); len(changes) > 0 {
+		logrus.WithField("settings", changes).Warn("Some changed settings will only take effect when the service is restarted.")
+	}
+
+	if err := settings.apply(updated); err != nil {
+		logrus.WithError(err).Error("Could not apply reloaded configuration, continuing with previous configuration.")
+		return running
+	}
+
+	logrus.Info("Configuration reloaded.")
+
+	return updated
+}
+
+// reloadableHandler passes requests to a handler that can be replaced while the server is running.
+type reloadableHandler struct {
+	mutex   sync.RWMutex
+	handler http.Handler
+}
+
+func (h *reloadableHandler) set(handler http.Handler) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.handler = handler
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mutex.RLock()
+	handler := h.handler
+	h.mutex.RUnlock()
+
+	handler.ServeHTTP(w, req)
+}
@@ -0,0 +1,221 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package decoding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/batect/abacus/server/sessionpb"
+	"github.com/batect/abacus/server/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var ErrUnknownFields = errors.New("message contains unknown fields")
+
+// DecodeProtobufSession decodes a sessionpb.Session from r into session.
+//
+// Like the JSON decoder, messages containing unknown fields are rejected, and attribute values are converted
+// to the same types that decoding a JSON session produces so that both formats are validated and stored identically.
+func DecodeProtobufSession(r io.Reader, session *types.Session) error {
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return err
+	}
+
+	msg := &sessionpb.Session{}
+
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return err
+	}
+
+	if hasUnknownFields(msg.ProtoReflect()) {
+		return ErrUnknownFields
+	}
+
+	converted, err := sessionFromProtobuf(msg)
+
+	if err != nil {
+		return err
+	}
+
+	*session = converted
+
+	return nil
+}
+
+func sessionFromProtobuf(msg *sessionpb.Session) (types.Session, error) {
+	startTime, err := timeFromProtobuf("sessionStartTime", msg.GetSessionStartTime())
+
+	if err != nil {
+		return types.Session{}, err
+	}
+
+	endTime, err := timeFromProtobuf("sessionEndTime", msg.GetSessionEndTime())
+
+	if err != nil {
+		return types.Session{}, err
+	}
+
+	session := types.Session{
+		SessionID:          msg.GetSessionId(),
+		UserID:             msg.GetUserId(),
+		SessionStartTime:   startTime,
+		SessionEndTime:     endTime,
+		ApplicationID:      msg.GetApplicationId(),
+		ApplicationVersion: msg.GetApplicationVersion(),
+		Attributes:         attributesFromProtobuf(msg.GetAttributes()),
+		ParentSessionID:    msg.GetParentSessionId(),
+		TraceID:            msg.GetTraceId(),
+	}
+
+	if c := msg.GetConsent(); c != nil {
+		consentTime, err := timeFromProtobuf("consent.time", c.GetTime())
+
+		if err != nil {
+			return types.Session{}, err
+		}
+
+		session.Consent = &types.Consent{PolicyVersion: c.GetPolicyVersion(), Time: consentTime}
+	}
+
+	for _, e := range msg.GetEvents() {
+		t, err := timeFromProtobuf("time", e.GetTime())
+
+		if err != nil {
+			return types.Session{}, err
+		}
+
+		session.Events = append(session.Events, types.Event{
+			Type:       e.GetType(),
+			Time:       t,
+			SpanID:     e.GetSpanId(),
+			Attributes: attributesFromProtobuf(e.GetAttributes()),
+		})
+	}
+
+	for _, s := range msg.GetSpans() {
+		spanStartTime, err := timeFromProtobuf("startTime", s.GetStartTime())
+
+		if err != nil {
+			return types.Session{}, err
+		}
+
+		spanEndTime, err := timeFromProtobuf("endTime", s.GetEndTime())
+
+		if err != nil {
+			return types.Session{}, err
+		}
+
+		session.Spans = append(session.Spans, types.Span{
+			ID:         s.GetId(),
+			ParentID:   s.GetParentId(),
+			Type:       s.GetType(),
+			StartTime:  spanStartTime,
+			EndTime:    spanEndTime,
+			Attributes: attributesFromProtobuf(s.GetAttributes()),
+		})
+	}
+
+	return session, nil
+}
+
+// timeFromProtobuf returns the zero time for missing timestamps, so that validation reports them as missing.
+func timeFromProtobuf(field string, ts *timestamppb.Timestamp) (time.Time, error) {
+	if ts == nil {
+		return time.Time{}, nil
+	}
+
+	if err := ts.CheckValid(); err != nil {
+		return time.Time{}, fmt.Errorf("%v is not a valid timestamp: %w", field, err)
+	}
+
+	return ts.AsTime(), nil
+}
+
+func attributesFromProtobuf(values map[string]*sessionpb.AttributeValue) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+
+	attributes := make(map[string]interface{}, len(values))
+
+	for key, value := range values {
+		attributes[key] = attributeValueFromProtobuf(value)
+	}
+
+	return attributes
+}
+
+func attributeValueFromProtobuf(value *sessionpb.AttributeValue) interface{} {
+	switch v := value.GetValue().(type) {
+	case *sessionpb.AttributeValue_StringValue:
+		return v.StringValue
+	case *sessionpb.AttributeValue_BoolValue:
+		return v.BoolValue
+	case *sessionpb.AttributeValue_IntValue:
+		return json.Number(strconv.FormatInt(v.IntValue, 10))
+	case *sessionpb.AttributeValue_DoubleValue:
+		// Infinity and NaN can't be represented in JSON, so these are passed through as-is to be rejected by validation.
+		if math.IsInf(v.DoubleValue, 0) || math.IsNaN(v.DoubleValue) {
+			return v.DoubleValue
+		}
+
+		return json.Number(strconv.FormatFloat(v.DoubleValue, 'g', -1, 64))
+	default:
+		return nil
+	}
+}
+
+func hasUnknownFields(msg protoreflect.Message) bool {
+	if len(msg.GetUnknown()) > 0 {
+		return true
+	}
+
+	found := false
+
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			for i := 0; i < v.List().Len(); i++ {
+				found = found || hasUnknownFields(v.List().Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				found = found || hasUnknownFields(mv.Message())
+				return !found
+			})
+		case fd.Message() != nil && !fd.IsMap():
+			found = hasUnknownFields(v.Message())
+		}
+
+		return !found
+	})
+
+	return found
+}
@@ -0,0 +1,187 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package decoding_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/batect/abacus/server/decoding"
+	"github.com/batect/abacus/server/sessionpb"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ = Describe("Decoding protobuf sessions", func() {
+	decode := func(body []byte) (types.Session, error) {
+		session := types.Session{}
+		err := decoding.DecodeProtobufSession(bytes.NewReader(body), &session)
+
+		return session, err
+	}
+
+	marshal := func(msg proto.Message) []byte {
+		body, err := proto.Marshal(msg)
+		Expect(err).ToNot(HaveOccurred())
+
+		return body
+	}
+
+	Context("given a message with attributes of each type", func() {
+		var session types.Session
+
+		BeforeEach(func() {
+			var err error
+			session, err = decode(marshal(&sessionpb.Session{
+				SessionId:        "11112222-3333-4444-a555-666677778888",
+				SessionStartTime: timestamppb.New(time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)),
+				Attributes: map[string]*sessionpb.AttributeValue{
+					"string":   {Value: &sessionpb.AttributeValue_StringValue{StringValue: "value"}},
+					"bool":     {Value: &sessionpb.AttributeValue_BoolValue{BoolValue: true}},
+					"int":      {Value: &sessionpb.AttributeValue_IntValue{IntValue: -12}},
+					"double":   {Value: &sessionpb.AttributeValue_DoubleValue{DoubleValue: 1.5}},
+					"infinity": {Value: &sessionpb.AttributeValue_DoubleValue{DoubleValue: math.Inf(-1)}},
+					"null":     {},
+				},
+			}))
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("decodes the session's fields", func() {
+			Expect(session.SessionID).To(Equal("11112222-3333-4444-a555-666677778888"))
+			Expect(session.SessionStartTime).To(Equal(time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)))
+		})
+
+		It("leaves missing timestamps as the zero time so that validation reports them", func() {
+			Expect(session.SessionEndTime).To(BeZero())
+		})
+
+		It("converts attribute values to the same types as the JSON decoder", func() {
+			Expect(session.Attributes).To(Equal(map[string]interface{}{
+				"string":   "value",
+				"bool":     true,
+				"int":      json.Number("-12"),
+				"double":   json.Number("1.5"),
+				"infinity": math.Inf(-1),
+				"null":     nil,
+			}))
+		})
+	})
+
+	Context("given a message with nested spans and events associated with spans", func() {
+		var session types.Session
+
+		BeforeEach(func() {
+			var err error
+			session, err = decode(marshal(&sessionpb.Session{
+				Events: []*sessionpb.Event{
+					{Type: "ThingHappened", SpanId: "0000000000000002"},
+				},
+				Spans: []*sessionpb.Span{
+					{Type: "RunningTask", Id: "0000000000000001"},
+					{Type: "PullingImage", Id: "0000000000000002", ParentId: "0000000000000001"},
+				},
+			}))
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("decodes the span IDs and parent span IDs", func() {
+			Expect(session.Spans).To(HaveLen(2))
+			Expect(session.Spans[0].ID).To(Equal("0000000000000001"))
+			Expect(session.Spans[0].ParentID).To(BeEmpty())
+			Expect(session.Spans[1].ID).To(Equal("0000000000000002"))
+			Expect(session.Spans[1].ParentID).To(Equal("0000000000000001"))
+		})
+
+		It("decodes the span each event is associated with", func() {
+			Expect(session.Events).To(HaveLen(1))
+			Expect(session.Events[0].SpanID).To(Equal("0000000000000002"))
+		})
+	})
+
+	Context("given a message linked to a parent session and a trace", func() {
+		It("decodes the parent session ID and trace ID", func() {
+			session, err := decode(marshal(&sessionpb.Session{
+				ParentSessionId: "aaaa2222-3333-4444-a555-666677778888",
+				TraceId:         "bbbb2222-3333-4444-a555-666677778888",
+			}))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.ParentSessionID).To(Equal("aaaa2222-3333-4444-a555-666677778888"))
+			Expect(session.TraceID).To(Equal("bbbb2222-3333-4444-a555-666677778888"))
+		})
+	})
+
+	Context("given a message with consent", func() {
+		It("decodes the consent", func() {
+			session, err := decode(marshal(&sessionpb.Session{
+				Consent: &sessionpb.Consent{PolicyVersion: "2023-06", Time: timestamppb.New(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC))},
+			}))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.Consent).To(Equal(&types.Consent{PolicyVersion: "2023-06", Time: time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)}))
+		})
+	})
+
+	Context("given a message with an unknown field", func() {
+		It("returns an error", func() {
+			body := protowire.AppendTag(marshal(&sessionpb.Session{SessionId: "abc"}), 99, protowire.VarintType)
+			body = protowire.AppendVarint(body, 1)
+
+			_, err := decode(body)
+			Expect(err).To(MatchError(decoding.ErrUnknownFields))
+		})
+	})
+
+	Context("given a message with an unknown field in a nested message", func() {
+		It("returns an error", func() {
+			event := protowire.AppendTag(marshal(&sessionpb.Event{Type: "thing"}), 99, protowire.VarintType)
+			event = protowire.AppendVarint(event, 1)
+
+			body := protowire.AppendTag(nil, 8, protowire.BytesType)
+			body = protowire.AppendBytes(body, event)
+
+			_, err := decode(body)
+			Expect(err).To(MatchError(decoding.ErrUnknownFields))
+		})
+	})
+
+	Context("given a message with an invalid timestamp", func() {
+		It("returns an error", func() {
+			_, err := decode(marshal(&sessionpb.Session{SessionEndTime: &timestamppb.Timestamp{Nanos: -1}}))
+			Expect(err).To(MatchError(ContainSubstring("sessionEndTime is not a valid timestamp")))
+		})
+	})
+
+	Context("given a body that is not a valid protobuf message", func() {
+		It("returns an error", func() {
+			_, err := decode([]byte{0xff, 0xff, 0xff})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
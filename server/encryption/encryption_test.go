@@ -0,0 +1,202 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package encryption_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+
+	"github.com/batect/abacus/server/encryption"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypting payloads", func() {
+	ctx := context.Background()
+	firstKey := bytes.Repeat([]byte{1}, 32)
+	secondKey := bytes.Repeat([]byte{2}, 32)
+	plaintext := []byte(`{"sessionId":"11112222-3333-4444-a555-666677778888"}`)
+
+	var encryptor *encryption.Encryptor
+
+	BeforeEach(func() {
+		wrapper, err := encryption.NewLocalKeyWrapper(firstKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		encryptor = encryption.NewEncryptor(wrapper)
+	})
+
+	Describe("encrypting a payload", func() {
+		var ciphertext []byte
+		var metadata map[string]string
+
+		BeforeEach(func() {
+			var err error
+			ciphertext, metadata, err = encryptor.Encrypt(ctx, "my-app", "first-object", plaintext)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("does not include the plaintext in the ciphertext", func() {
+			Expect(bytes.Contains(ciphertext, plaintext)).To(BeFalse())
+		})
+
+		It("returns metadata describing the algorithm and key used", func() {
+			Expect(metadata).To(HaveKeyWithValue("encryptionAlgorithm", "AES-256-GCM"))
+			Expect(metadata).To(HaveKeyWithValue("encryptionKeyId", HavePrefix("local:")))
+			Expect(metadata).To(HaveKey("encryptionWrappedDataKey"))
+		})
+
+		It("marks the payload as encrypted", func() {
+			Expect(encryption.IsEncrypted(metadata)).To(BeTrue())
+		})
+
+		It("uses the same data key for subsequent payloads for the same application", func() {
+			_, secondMetadata, err := encryptor.Encrypt(ctx, "my-app", "first-object", plaintext)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secondMetadata).To(Equal(metadata))
+		})
+
+		It("uses a different data key for payloads for other applications", func() {
+			_, otherMetadata, err := encryptor.Encrypt(ctx, "other-app", "first-object", plaintext)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(otherMetadata["encryptionWrappedDataKey"]).ToNot(Equal(metadata["encryptionWrappedDataKey"]))
+		})
+
+		It("can be decrypted by the same encryptor", func() {
+			Expect(encryptor.Decrypt(ctx, "my-app", "first-object", ciphertext, metadata)).To(Equal(plaintext))
+		})
+
+		It("can be decrypted by another encryptor with the same key encryption key", func() {
+			wrapper, err := encryption.NewLocalKeyWrapper(firstKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(encryption.NewEncryptor(wrapper).Decrypt(ctx, "my-app", "first-object", ciphertext, metadata)).To(Equal(plaintext))
+		})
+
+		It("can be decrypted by an encryptor that has since rotated to a new key encryption key", func() {
+			wrapper, err := encryption.NewLocalKeyWrapper(secondKey, firstKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(encryption.NewEncryptor(wrapper).Decrypt(ctx, "my-app", "first-object", ciphertext, metadata)).To(Equal(plaintext))
+		})
+
+		It("cannot be decrypted by an encryptor without the key encryption key", func() {
+			wrapper, err := encryption.NewLocalKeyWrapper(secondKey)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = encryption.NewEncryptor(wrapper).Decrypt(ctx, "my-app", "first-object", ciphertext, metadata)
+			Expect(err).To(MatchError(encryption.ErrUnknownKey))
+		})
+
+		It("cannot be decrypted as a payload for another application", func() {
+			_, err := encryptor.Decrypt(ctx, "other-app", "first-object", ciphertext, metadata)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("cannot be decrypted as the payload for another object", func() {
+			_, err := encryptor.Decrypt(ctx, "my-app", "second-object", ciphertext, metadata)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("cannot be decrypted if it has been modified", func() {
+			modified := append([]byte{}, ciphertext...)
+			modified[len(modified)-1] ^= 0xFF
+
+			_, err := encryptor.Decrypt(ctx, "my-app", "first-object", modified, metadata)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("decrypting a payload that is not encrypted", func() {
+		It("returns an error", func() {
+			_, err := encryptor.Decrypt(ctx, "my-app", "first-object", plaintext, map[string]string{})
+			Expect(err).To(MatchError(encryption.ErrNotEncrypted))
+		})
+	})
+})
+
+var _ = Describe("Loading key encryption keys from a file", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "keys")
+	})
+
+	writeKeyFile := func(content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	}
+
+	Context("given the file contains valid keys", func() {
+		BeforeEach(func() {
+			writeKeyFile("# Current key\n" +
+				base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)) + "\n" +
+				"\n" +
+				base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)) + "\n")
+		})
+
+		It("wraps data keys with the first key", func() {
+			wrapper, err := encryption.LoadLocalKeyFile(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			expected, err := encryption.NewLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+			Expect(err).ToNot(HaveOccurred())
+
+			actualKeyID, _, err := wrapper.WrapKey(context.Background(), bytes.Repeat([]byte{3}, 32))
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedKeyID, _, err := expected.WrapKey(context.Background(), bytes.Repeat([]byte{3}, 32))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(actualKeyID).To(Equal(expectedKeyID))
+		})
+	})
+
+	Context("given the file contains a key of the wrong length", func() {
+		BeforeEach(func() {
+			writeKeyFile(base64.StdEncoding.EncodeToString([]byte("too short")))
+		})
+
+		It("returns an error", func() {
+			_, err := encryption.LoadLocalKeyFile(path)
+			Expect(err).To(MatchError("key 1 is not valid: key must be 32 bytes long, but is 9 bytes long"))
+		})
+	})
+
+	Context("given the file contains no keys", func() {
+		BeforeEach(func() {
+			writeKeyFile("# Nothing here\n")
+		})
+
+		It("returns an error", func() {
+			_, err := encryption.LoadLocalKeyFile(path)
+			Expect(err).To(MatchError("at least one key is required"))
+		})
+	})
+
+	Context("given the file does not exist", func() {
+		It("returns an error", func() {
+			_, err := encryption.LoadLocalKeyFile(path)
+			Expect(err).To(MatchError(ContainSubstring("could not open key file")))
+		})
+	})
+})
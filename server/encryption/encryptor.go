@@ -0,0 +1,192 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Encryptor encrypts payloads with AES-256-GCM using a data key per application.
+//
+// Each data key is generated the first time it is needed and wrapped with a KeyWrapper. The ID of the key encryption key and the wrapped
+// data key are returned as metadata to store alongside the encrypted payload, so that the payload can be decrypted later without having to
+// keep track of data keys separately.
+type Encryptor struct {
+	wrapper KeyWrapper
+
+	mutex       sync.Mutex
+	dataKeys    map[string]*dataKey
+	unwrapCache map[string]cipher.AEAD
+}
+
+type dataKey struct {
+	aead       cipher.AEAD
+	keyID      string
+	wrappedKey string
+}
+
+const Algorithm = "AES-256-GCM"
+
+const AlgorithmMetadataKey = "encryptionAlgorithm"
+const KeyIDMetadataKey = "encryptionKeyId"
+const WrappedDataKeyMetadataKey = "encryptionWrappedDataKey"
+
+var ErrNotEncrypted = errors.New("payload is not encrypted")
+
+func NewEncryptor(wrapper KeyWrapper) *Encryptor {
+	return &Encryptor{
+		wrapper:     wrapper,
+		dataKeys:    map[string]*dataKey{},
+		unwrapCache: map[string]cipher.AEAD{},
+	}
+}
+
+// IsEncrypted returns true if metadata describes a payload encrypted by an Encryptor.
+func IsEncrypted(metadata map[string]string) bool {
+	_, ok := metadata[AlgorithmMetadataKey]
+
+	return ok
+}
+
+// Encrypt encrypts plaintext with applicationID's data key. The ciphertext can only be decrypted with the same applicationID
+// and name (such as the name of the object it is stored in), so that ciphertexts can't be swapped between applications or objects.
+func (e *Encryptor) Encrypt(ctx context.Context, applicationID string, name string, plaintext []byte) ([]byte, map[string]string, error) {
+	key, err := e.dataKeyFor(ctx, applicationID)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ciphertext, err := seal(key.aead, plaintext, additionalData(applicationID, name))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := map[string]string{
+		AlgorithmMetadataKey:      Algorithm,
+		KeyIDMetadataKey:          key.keyID,
+		WrappedDataKeyMetadataKey: key.wrappedKey,
+	}
+
+	return ciphertext, metadata, nil
+}
+
+// Decrypt decrypts ciphertext produced by Encrypt with the same applicationID and name, using the data key described by metadata.
+func (e *Encryptor) Decrypt(ctx context.Context, applicationID string, name string, ciphertext []byte, metadata map[string]string) ([]byte, error) {
+	if !IsEncrypted(metadata) {
+		return nil, ErrNotEncrypted
+	}
+
+	if algorithm := metadata[AlgorithmMetadataKey]; algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm '%v'", algorithm)
+	}
+
+	aead, err := e.unwrap(ctx, metadata[KeyIDMetadataKey], metadata[WrappedDataKeyMetadataKey])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, ciphertext, additionalData(applicationID, name))
+}
+
+// additionalData binds a ciphertext to an application and name. Application IDs can't contain NUL, so the separator keeps
+// different combinations of application ID and name distinct.
+func additionalData(applicationID string, name string) []byte {
+	return []byte(applicationID + "\x00" + name)
+}
+
+func (e *Encryptor) dataKeyFor(ctx context.Context, applicationID string) (*dataKey, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if key, ok := e.dataKeys[applicationID]; ok {
+		return key, nil
+	}
+
+	rawKey := make([]byte, keySize)
+
+	if _, err := rand.Read(rawKey); err != nil {
+		return nil, fmt.Errorf("could not generate data key: %w", err)
+	}
+
+	aead, err := newAEAD(rawKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, wrappedKey, err := e.wrapper.WrapKey(ctx, rawKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not wrap data key: %w", err)
+	}
+
+	key := &dataKey{
+		aead:       aead,
+		keyID:      keyID,
+		wrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+	}
+
+	e.dataKeys[applicationID] = key
+	e.unwrapCache[keyID+"/"+key.wrappedKey] = aead
+
+	return key, nil
+}
+
+func (e *Encryptor) unwrap(ctx context.Context, keyID string, encodedWrappedKey string) (cipher.AEAD, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	cacheKey := keyID + "/" + encodedWrappedKey
+
+	if aead, ok := e.unwrapCache[cacheKey]; ok {
+		return aead, nil
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(encodedWrappedKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("wrapped data key is not valid base64: %w", err)
+	}
+
+	rawKey, err := e.wrapper.UnwrapKey(ctx, keyID, wrappedKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(rawKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.unwrapCache[cacheKey] = aead
+
+	return aead, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyWrapper protects data keys with a key encryption key that never leaves the wrapper.
+//
+// The local implementation below holds key encryption keys in memory, and stands in for a key management service.
+type KeyWrapper interface {
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+	UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+var ErrUnknownKey = errors.New("unknown key encryption key")
+
+const keySize = 32
+
+type localKeyWrapper struct {
+	primaryKeyID string
+	keys         map[string]cipher.AEAD
+}
+
+// NewLocalKeyWrapper creates a KeyWrapper from one or more 256-bit keys.
+// New data keys are always wrapped with the first key, while data keys wrapped with any of the keys can be unwrapped, which allows keys to be rotated.
+func NewLocalKeyWrapper(keys ...[]byte) (KeyWrapper, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+
+	wrapper := &localKeyWrapper{keys: make(map[string]cipher.AEAD, len(keys))}
+
+	for i, key := range keys {
+		aead, err := newAEAD(key)
+
+		if err != nil {
+			return nil, fmt.Errorf("key %v is not valid: %w", i+1, err)
+		}
+
+		id := localKeyID(key)
+		wrapper.keys[id] = aead
+
+		if i == 0 {
+			wrapper.primaryKeyID = id
+		}
+	}
+
+	return wrapper, nil
+}
+
+// LoadLocalKeyFile creates a KeyWrapper from a file containing one base64-encoded 256-bit key per line, with the key used for new data keys first.
+// Blank lines and lines starting with '#' are ignored.
+func LoadLocalKeyFile(path string) (KeyWrapper, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open key file: %w", err)
+	}
+
+	defer file.Close()
+
+	keys := [][]byte{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(line)
+
+		if err != nil {
+			return nil, fmt.Errorf("key file contains a line that is not valid base64: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+
+	return NewLocalKeyWrapper(keys...)
+}
+
+func (w *localKeyWrapper) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(w.keys[w.primaryKeyID], dataKey, []byte(w.primaryKeyID))
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return w.primaryKeyID, wrapped, nil
+}
+
+func (w *localKeyWrapper) UnwrapKey(_ context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	aead, ok := w.keys[keyID]
+
+	if !ok {
+		return nil, fmt.Errorf("%w '%v'", ErrUnknownKey, keyID)
+	}
+
+	return open(aead, wrappedKey, []byte(keyID))
+}
+
+func localKeyID(key []byte) string {
+	hash := sha256.Sum256(key)
+
+	return "local:" + hex.EncodeToString(hash[:8])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %v bytes long, but is %v bytes long", keySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create GCM cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+// seal encrypts plaintext and returns the nonce followed by the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, encrypted := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, encrypted, additionalData)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// Headers that proxies use to pass on the client's IP address.
+var clientAddressHeaders = []string{"X-Forwarded-For", "X-Real-Ip", "Forwarded"}
+
+// HideClientIP removes the client's IP address from each request before passing it to next, so that it can't be
+// logged, recorded in traces or stored by anything further down the chain. The address is kept in the request's context
+// for enrichers that derive coarse information from it (see ClientIPFromContext).
+//
+// This must be the outermost handler for the guarantee to hold.
+func HideClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip := clientIP(req)
+
+		req = req.Clone(context.WithValue(req.Context(), clientIPKey{}, ip))
+		req.RemoteAddr = ""
+
+		for _, header := range clientAddressHeaders {
+			req.Header.Del(header)
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ClientIPFromContext returns the client IP address hidden by HideClientIP, or nil if it is not known.
+// Callers must not log or store the returned address.
+func ClientIPFromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(clientIPKey{}).(net.IP)
+
+	return ip
+}
+
+// Cloud Run appends the address of the client that connected to it to X-Forwarded-For, so the last entry is the only one that can be trusted.
+func clientIP(req *http.Request) net.IP {
+	if forwardedFor := req.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		entries := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+
+		return net.ParseIP(strings.TrimSpace(entries[len(entries)-1]))
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/services-common/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("Hiding the client's IP address", func() {
+	var seenRequest *http.Request
+	var loggingHook *test.Hook
+
+	BeforeEach(func() {
+		var logger *logrus.Logger
+		logger, loggingHook = test.NewNullLogger()
+		logger.SetLevel(logrus.DebugLevel)
+
+		handler := enrichment.HideClientIP(middleware.TraceIDExtractionMiddleware(middleware.LoggerMiddleware(logger, "my-project", http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			seenRequest = req
+
+			middleware.LoggerFromContext(req.Context()).
+				WithField("remoteAddr", req.RemoteAddr).
+				WithField("headers", fmt.Sprint(req.Header)).
+				Info("Handling request.")
+		}))))
+
+		req := httptest.NewRequest("PUT", "/v1/sessions", nil)
+		req.RemoteAddr = "169.254.8.129:4567"
+		req.Header.Set("X-Forwarded-For", "10.1.2.3, 81.2.69.160")
+		req.Header.Set("X-Real-IP", "81.2.69.160")
+		req.Header.Set("Forwarded", "for=81.2.69.160")
+		req.Header.Set("User-Agent", "batect/1.0.0")
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	})
+
+	It("removes the client's address and any headers containing it from the request", func() {
+		Expect(seenRequest.RemoteAddr).To(BeEmpty())
+		Expect(seenRequest.Header).ToNot(HaveKey("X-Forwarded-For"))
+		Expect(seenRequest.Header).ToNot(HaveKey("X-Real-Ip"))
+		Expect(seenRequest.Header).ToNot(HaveKey("Forwarded"))
+	})
+
+	It("leaves other headers untouched", func() {
+		Expect(seenRequest.Header.Get("User-Agent")).To(Equal("batect/1.0.0"))
+	})
+
+	It("makes the address added by the last proxy available from the request's context", func() {
+		Expect(enrichment.ClientIPFromContext(seenRequest.Context())).To(Equal(net.ParseIP("81.2.69.160")))
+	})
+
+	It("never logs the client's address", func() {
+		Expect(loggingHook.AllEntries()).ToNot(BeEmpty())
+
+		for _, entry := range loggingHook.AllEntries() {
+			line, err := entry.String()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(line).ToNot(ContainSubstring("81.2.69.160"))
+			Expect(line).ToNot(ContainSubstring("169.254.8.129"))
+		}
+	})
+})
+
+var _ = Describe("Finding the client's IP address", func() {
+	var ip net.IP
+
+	find := func(remoteAddr string, forwardedFor string) net.IP {
+		var found net.IP
+
+		handler := enrichment.HideClientIP(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			found = enrichment.ClientIPFromContext(req.Context())
+		}))
+
+		req := httptest.NewRequest("PUT", "/v1/sessions", nil)
+		req.RemoteAddr = remoteAddr
+
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		return found
+	}
+
+	Context("given the request did not come through a proxy", func() {
+		BeforeEach(func() {
+			ip = find("81.2.69.160:1234", "")
+		})
+
+		It("uses the address of the connection", func() {
+			Expect(ip).To(Equal(net.ParseIP("81.2.69.160")))
+		})
+	})
+
+	Context("given the request has an invalid X-Forwarded-For header", func() {
+		BeforeEach(func() {
+			ip = find("169.254.8.129:4567", "not-an-ip")
+		})
+
+		It("does not return an address", func() {
+			Expect(ip).To(BeNil())
+		})
+	})
+})
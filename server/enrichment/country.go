@@ -0,0 +1,82 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// CountryEnricher adds the ISO 3166-1 alpha-2 code of the country the client's IP address is in as the 'country' attribute,
+// using a MaxMind-format database such as GeoLite2 Country. The IP address itself is never added to the session.
+//
+// The client's IP address is taken from the request's context, so HideClientIP must be used to populate it.
+type CountryEnricher struct {
+	db *maxminddb.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// NewCountryEnricher opens the database at databasePath. If databasePath is empty, the returned enricher never adds any attributes.
+func NewCountryEnricher(databasePath string) (*CountryEnricher, error) {
+	if databasePath == "" {
+		return &CountryEnricher{}, nil
+	}
+
+	db, err := maxminddb.Open(databasePath)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open GeoIP database: %w", err)
+	}
+
+	return &CountryEnricher{db: db}, nil
+}
+
+func (e *CountryEnricher) Enrich(req *http.Request, _ *types.Session) map[string]interface{} {
+	ip := ClientIPFromContext(req.Context())
+
+	if e.db == nil || ip == nil {
+		return nil
+	}
+
+	var record countryRecord
+
+	// Errors are deliberately not logged, as they include the IP address.
+	if err := e.db.Lookup(ip, &record); err != nil || record.Country.ISOCode == "" {
+		return nil
+	}
+
+	return map[string]interface{}{"country": record.Country.ISOCode}
+}
+
+func (e *CountryEnricher) Close() error {
+	if e.db == nil {
+		return nil
+	}
+
+	return e.db.Close()
+}
@@ -0,0 +1,176 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("A country enricher", func() {
+	var enricher *enrichment.CountryEnricher
+
+	enrich := func(remoteAddr string) map[string]interface{} {
+		var result map[string]interface{}
+
+		handler := enrichment.HideClientIP(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+			result = enricher.Enrich(req, &types.Session{})
+		}))
+
+		req := httptest.NewRequest("PUT", "/v1/sessions", nil)
+		req.RemoteAddr = remoteAddr
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		return result
+	}
+
+	Context("given a database", func() {
+		BeforeEach(func() {
+			path := filepath.Join(GinkgoT().TempDir(), "countries.mmdb")
+			Expect(os.WriteFile(path, countryDatabase(net.IPv4(81, 2, 69, 0), 24, "GB"), 0o600)).To(Succeed())
+
+			var err error
+			enricher, err = enrichment.NewCountryEnricher(path)
+			Expect(err).ToNot(HaveOccurred())
+
+			DeferCleanup(enricher.Close)
+		})
+
+		Context("given the client's IP address is in the database", func() {
+			It("returns the country code, and not the IP address", func() {
+				Expect(enrich("81.2.69.160:1234")).To(Equal(map[string]interface{}{"country": "GB"}))
+			})
+		})
+
+		Context("given the client's IP address is not in the database", func() {
+			It("returns no attributes", func() {
+				Expect(enrich("81.2.70.160:1234")).To(BeEmpty())
+			})
+		})
+
+		Context("given the client's IP address is not known", func() {
+			It("returns no attributes", func() {
+				Expect(enrich("")).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("given no database", func() {
+		BeforeEach(func() {
+			var err error
+			enricher, err = enrichment.NewCountryEnricher("")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns no attributes", func() {
+			Expect(enrich("81.2.69.160:1234")).To(BeEmpty())
+		})
+	})
+
+	Context("given the database file does not exist", func() {
+		It("returns an error", func() {
+			_, err := enrichment.NewCountryEnricher(filepath.Join(GinkgoT().TempDir(), "does-not-exist.mmdb"))
+			Expect(err).To(MatchError(ContainSubstring("could not open GeoIP database")))
+		})
+	})
+})
+
+// countryDatabase returns a MaxMind DB file for IPv4 addresses that maps the network with the given prefix to country,
+// and has no data for any other address. See https://maxmind.github.io/MaxMind-DB/ for details of the format.
+func countryDatabase(network net.IP, prefixLength int, country string) []byte {
+	const recordSize = 24
+	const dataSectionSeparatorSize = 16
+
+	nodeCount := uint32(prefixLength)
+	network = network.To4()
+	buf := &bytes.Buffer{}
+
+	// The search tree has one node per bit of the prefix. Each node leads to the next node for the network's bit,
+	// and to an empty record for the other bit. The last node leads to the only record in the data section.
+	for i := 0; i < prefixLength; i++ {
+		next := uint32(i + 1)
+
+		if i == prefixLength-1 {
+			next = nodeCount + dataSectionSeparatorSize
+		}
+
+		left, right := next, nodeCount
+
+		if network[i/8]&(0x80>>(i%8)) != 0 {
+			left, right = nodeCount, next
+		}
+
+		writeUint24(buf, left)
+		writeUint24(buf, right)
+	}
+
+	buf.Write(make([]byte, dataSectionSeparatorSize))
+
+	writeMapHeader(buf, 1)
+	writeString(buf, "country")
+	writeMapHeader(buf, 1)
+	writeString(buf, "iso_code")
+	writeString(buf, country)
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+
+	writeMapHeader(buf, 5)
+	writeString(buf, "node_count")
+	writeUint32(buf, nodeCount)
+	writeString(buf, "record_size")
+	writeUint32(buf, recordSize)
+	writeString(buf, "ip_version")
+	writeUint32(buf, 4)
+	writeString(buf, "binary_format_major_version")
+	writeUint32(buf, 2)
+	writeString(buf, "database_type")
+	writeString(buf, "Test-Country")
+
+	return buf.Bytes()
+}
+
+func writeUint24(buf *bytes.Buffer, value uint32) {
+	buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+}
+
+// Data section fields start with a control byte: the type in the top three bits, and the size in the bottom five bits.
+func writeMapHeader(buf *bytes.Buffer, size int) {
+	buf.WriteByte(7<<5 | byte(size))
+}
+
+func writeString(buf *bytes.Buffer, value string) {
+	buf.WriteByte(2<<5 | byte(len(value)))
+	buf.WriteString(value)
+}
+
+func writeUint32(buf *bytes.Buffer, value uint32) {
+	buf.WriteByte(6<<5 | 4)
+	Expect(binary.Write(buf, binary.BigEndian, value)).To(Succeed())
+}
@@ -0,0 +1,63 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment_test
+
+import (
+	"net/http/httptest"
+
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = DescribeTable("determining the client platform",
+	func(userAgent string, expected map[string]interface{}) {
+		req := httptest.NewRequest("PUT", "/v1/sessions", nil)
+		req.Header.Set("User-Agent", userAgent)
+
+		enricher := &enrichment.PlatformEnricher{}
+		Expect(enricher.Enrich(req, &types.Session{})).To(Equal(expected))
+	},
+	Entry("no User-Agent header", "", map[string]interface{}(nil)),
+	Entry("macOS", "batect/0.80.0 (Java HotSpot 11; Mac OS X 10.15.7; x86_64)", map[string]interface{}{"clientPlatform": "mac"}),
+	Entry("macOS, as reported by Darwin", "curl/7.64.1 (x86_64-apple-darwin19.0)", map[string]interface{}{"clientPlatform": "mac"}),
+	Entry("Linux", "batect/0.80.0 (OpenJDK 17; Linux 5.15.0-1023-gcp; amd64)", map[string]interface{}{"clientPlatform": "linux"}),
+	Entry("Windows", "batect/0.80.0 (OpenJDK 17; Windows 10 10.0; amd64)", map[string]interface{}{"clientPlatform": "windows"}),
+	Entry("an unknown platform", "batect/0.80.0 (OpenJDK 17; FreeBSD 13.1; amd64)", map[string]interface{}{"clientPlatform": "other"}),
+)
+
+var _ = Describe("A region enricher", func() {
+	req := httptest.NewRequest("PUT", "/v1/sessions", nil)
+
+	Context("given the region is known", func() {
+		It("returns the region", func() {
+			enricher := &enrichment.RegionEnricher{Region: "us-central1"}
+			Expect(enricher.Enrich(req, &types.Session{})).To(Equal(map[string]interface{}{"region": "us-central1"}))
+		})
+	})
+
+	Context("given the region is not known", func() {
+		It("returns no attributes", func() {
+			enricher := &enrichment.RegionEnricher{}
+			Expect(enricher.Enrich(req, &types.Session{})).To(BeEmpty())
+		})
+	})
+})
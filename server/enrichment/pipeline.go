@@ -0,0 +1,119 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package enrichment adds attributes to sessions that are derived from information the server has, rather than
+// information sent by the client, such as the region that received the session or the client's platform.
+//
+// Enriched attributes are added under a reserved namespace (see validation.ReservedAttributePrefix), which clients
+// are not permitted to use, so they can't be confused with attributes sent by the client.
+package enrichment
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+)
+
+// Enricher derives attributes for a session from the request it was received in.
+type Enricher interface {
+	// Enrich returns the attributes to add to session, keyed by name without the reserved prefix (eg. 'region' rather than 'abacusRegion').
+	// Attributes that can't be determined should be omitted. session has already been validated, and must not be modified.
+	Enrich(req *http.Request, session *types.Session) map[string]interface{}
+}
+
+// Config holds the settings for the enrichers returned by DefaultEnrichers.
+type Config struct {
+	// Region is the region the server is running in. The region enricher adds nothing if this is empty.
+	Region string
+
+	// CountryDatabaseFile is the path to a MaxMind-format database used to find the client's country.
+	// The country enricher adds nothing if this is empty.
+	CountryDatabaseFile string
+}
+
+// EnricherNames lists the names of the enrichers returned by DefaultEnrichers, as used in application configuration.
+var EnricherNames = []string{"region", "clientPlatform", "country"}
+
+// DefaultEnrichers returns all of the available enrichers, keyed by the names used in application configuration.
+func DefaultEnrichers(config Config) (map[string]Enricher, error) {
+	country, err := NewCountryEnricher(config.CountryDatabaseFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]Enricher{
+		"region":         &RegionEnricher{Region: config.Region},
+		"clientPlatform": &PlatformEnricher{},
+		"country":        country,
+	}, nil
+}
+
+// Pipeline runs the enrichers configured for each application.
+type Pipeline struct {
+	registry  *applications.Registry
+	enrichers map[string]Enricher
+}
+
+// NewPipeline returns a pipeline that runs the enrichers listed in each application's configuration in registry, using the
+// implementations in enrichers. It returns an error if any application refers to an enricher that is not in enrichers.
+func NewPipeline(registry *applications.Registry, enrichers map[string]Enricher) (*Pipeline, error) {
+	for _, app := range registry.All() {
+		for _, name := range app.Enrichers {
+			if _, ok := enrichers[name]; !ok {
+				return nil, fmt.Errorf("application '%v' uses unknown enricher '%v'", app.ID, name)
+			}
+		}
+	}
+
+	return &Pipeline{registry: registry, enrichers: enrichers}, nil
+}
+
+// Enrich adds the attributes from each of the enrichers configured for session's application to session.
+// Later enrichers' attributes replace earlier enrichers' attributes with the same name.
+func (p *Pipeline) Enrich(req *http.Request, session *types.Session) {
+	app, ok := p.registry.Get(session.ApplicationID)
+
+	if !ok {
+		return
+	}
+
+	for _, name := range app.Enrichers {
+		for key, value := range p.enrichers[name].Enrich(req, session) {
+			if session.Attributes == nil {
+				session.Attributes = map[string]interface{}{}
+			}
+
+			session.Attributes[AttributeName(key)] = value
+		}
+	}
+}
+
+// AttributeName returns the name of the session attribute used for the enriched attribute called name (eg. 'abacusRegion' for 'region').
+func AttributeName(name string) string {
+	if name == "" {
+		return validation.ReservedAttributePrefix
+	}
+
+	return validation.ReservedAttributePrefix + strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,126 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type staticEnricher map[string]interface{}
+
+func (e staticEnricher) Enrich(_ *http.Request, _ *types.Session) map[string]interface{} {
+	return e
+}
+
+var _ = Describe("An enrichment pipeline", func() {
+	enrichers := map[string]enrichment.Enricher{
+		"first":  staticEnricher{"colour": "red", "size": "large"},
+		"second": staticEnricher{"colour": "blue"},
+		"empty":  staticEnricher{},
+	}
+
+	registry := applications.NewRegistry(
+		applications.Application{ID: "enriched-app", Enrichers: []string{"first", "second", "empty"}},
+		applications.Application{ID: "plain-app"},
+	)
+
+	var pipeline *enrichment.Pipeline
+	req := httptest.NewRequest("PUT", "/v1/sessions", nil)
+
+	BeforeEach(func() {
+		var err error
+		pipeline, err = enrichment.NewPipeline(registry, enrichers)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("given a session for an application with enrichers configured", func() {
+		It("adds the attributes from each enricher under the reserved namespace, with later enrichers taking precedence", func() {
+			session := &types.Session{ApplicationID: "enriched-app", Attributes: map[string]interface{}{"colour": "green"}}
+			pipeline.Enrich(req, session)
+
+			Expect(session.Attributes).To(Equal(map[string]interface{}{
+				"colour":       "green",
+				"abacusColour": "blue",
+				"abacusSize":   "large",
+			}))
+		})
+
+		It("creates the session's attributes if it has none", func() {
+			session := &types.Session{ApplicationID: "enriched-app"}
+			pipeline.Enrich(req, session)
+
+			Expect(session.Attributes).To(HaveLen(2))
+		})
+	})
+
+	Context("given a session for an application with no enrichers configured", func() {
+		It("does not change the session's attributes", func() {
+			session := &types.Session{ApplicationID: "plain-app", Attributes: map[string]interface{}{"colour": "green"}}
+			pipeline.Enrich(req, session)
+
+			Expect(session.Attributes).To(Equal(map[string]interface{}{"colour": "green"}))
+		})
+	})
+
+	Context("given a session for an unknown application", func() {
+		It("does not change the session's attributes", func() {
+			session := &types.Session{ApplicationID: "unknown-app"}
+			pipeline.Enrich(req, session)
+
+			Expect(session.Attributes).To(BeNil())
+		})
+	})
+
+	Context("given an application that uses an enricher that does not exist", func() {
+		It("returns an error when creating the pipeline", func() {
+			_, err := enrichment.NewPipeline(
+				applications.NewRegistry(applications.Application{ID: "my-app", Enrichers: []string{"missing"}}),
+				enrichers,
+			)
+
+			Expect(err).To(MatchError("application 'my-app' uses unknown enricher 'missing'"))
+		})
+	})
+
+	Context("given the default application registry", func() {
+		It("only uses enrichers that are available", func() {
+			enrichers, err := enrichment.DefaultEnrichers(enrichment.Config{})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = enrichment.NewPipeline(applications.DefaultRegistry(), enrichers)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+})
+
+var _ = DescribeTable("converting enriched attribute names to session attribute names",
+	func(name string, expected string) {
+		Expect(enrichment.AttributeName(name)).To(Equal(expected))
+	},
+	Entry("a single word", "region", "abacusRegion"),
+	Entry("a camel case name", "clientPlatform", "abacusClientPlatform"),
+)
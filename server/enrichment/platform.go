@@ -0,0 +1,60 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/batect/abacus/server/types"
+)
+
+// PlatformEnricher adds the client's operating system family, as reported in its User-Agent header, as the 'clientPlatform' attribute.
+// Only the family ('linux', 'mac' or 'windows') is recorded, not the operating system version or any other details.
+type PlatformEnricher struct{}
+
+// Checked in order, so that user agents that mention more than one platform are classified consistently.
+var platformMarkers = []struct {
+	marker   string
+	platform string
+}{
+	{"windows", "windows"},
+	{"mac os", "mac"},
+	{"macos", "mac"},
+	{"macintosh", "mac"},
+	{"darwin", "mac"},
+	{"linux", "linux"},
+}
+
+func (e *PlatformEnricher) Enrich(req *http.Request, _ *types.Session) map[string]interface{} {
+	userAgent := strings.ToLower(req.UserAgent())
+
+	if userAgent == "" {
+		return nil
+	}
+
+	for _, m := range platformMarkers {
+		if strings.Contains(userAgent, m.marker) {
+			return map[string]interface{}{"clientPlatform": m.platform}
+		}
+	}
+
+	return map[string]interface{}{"clientPlatform": "other"}
+}
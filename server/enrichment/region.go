@@ -0,0 +1,39 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package enrichment
+
+import (
+	"net/http"
+
+	"github.com/batect/abacus/server/types"
+)
+
+// RegionEnricher adds the region of the server that received the session as the 'region' attribute.
+type RegionEnricher struct {
+	Region string
+}
+
+func (e *RegionEnricher) Enrich(_ *http.Request, _ *types.Session) map[string]interface{} {
+	if e.Region == "" {
+		return nil
+	}
+
+	return map[string]interface{}{"region": e.Region}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package metrics_test
+
+import (
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// histogramCount returns the total number of observations recorded across all of the histograms in the family called name.
+func histogramCount(gatherer prometheus.Gatherer, name string) uint64 {
+	total := uint64(0)
+
+	for _, m := range metricFamily(gatherer, name) {
+		total += m.GetHistogram().GetSampleCount()
+	}
+
+	return total
+}
+
+// histogramCountWithLabel returns the number of observations recorded by the histogram in the family called name that has labels with all of labelValues.
+func histogramCountWithLabel(gatherer prometheus.Gatherer, name string, labelValues ...string) uint64 {
+	if m := histogramWithLabel(gatherer, name, labelValues...); m != nil {
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	return 0
+}
+
+// histogramSum returns the sum of observations recorded by the histogram in the family called name that has labels with all of labelValues.
+func histogramSum(gatherer prometheus.Gatherer, name string, labelValues ...string) float64 {
+	if m := histogramWithLabel(gatherer, name, labelValues...); m != nil {
+		return m.GetHistogram().GetSampleSum()
+	}
+
+	return 0
+}
+
+func histogramWithLabel(gatherer prometheus.Gatherer, name string, labelValues ...string) *dto.Metric {
+	for _, m := range metricFamily(gatherer, name) {
+		if hasLabelValues(m, labelValues) {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func hasLabelValues(m *dto.Metric, labelValues []string) bool {
+	for _, value := range labelValues {
+		found := false
+
+		for _, label := range m.GetLabel() {
+			if label.GetValue() == value {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func metricFamily(gatherer prometheus.Gatherer, name string) []*dto.Metric {
+	families, err := gatherer.Gather()
+	Expect(err).ToNot(HaveOccurred())
+
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()
+		}
+	}
+
+	return nil
+}
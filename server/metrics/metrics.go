@@ -0,0 +1,136 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package metrics records Prometheus metrics about ingested sessions and exposes them for scraping.
+//
+// Collectors are package-level and registered with Registry, in the same way that tracing uses the global OpenTelemetry provider,
+// so that they can be recorded from anywhere in the request path without being threaded through every constructor.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcome is what happened to a session received by one of the ingest endpoints.
+type Outcome string
+
+const (
+	// Created sessions were stored.
+	Created Outcome = "created"
+
+	// Duplicate sessions had already been stored.
+	Duplicate Outcome = "duplicate"
+
+	// Invalid sessions could not be decoded, failed validation or were rejected by their application's version policy.
+	Invalid Outcome = "invalid"
+
+	// Dropped sessions were acknowledged but not stored, because of their application's version policy or because their user has opted out.
+	Dropped Outcome = "dropped"
+
+	// Failed sessions could not be stored.
+	Failed Outcome = "failed"
+)
+
+// UnknownLabelValue replaces label values that can't be trusted, such as the application ID of an invalid session, so that
+// clients can't create arbitrary numbers of time series.
+const UnknownLabelValue = "unknown"
+
+// Registry holds all of the service's metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	IngestedSessions = newCounterVec(prometheus.CounterOpts{
+		Name: "abacus_ingested_sessions_total",
+		Help: "Number of sessions received by the ingest endpoints, by application, application version and outcome.",
+	}, "application", "version", "outcome")
+
+	ValidationFailures = newCounterVec(prometheus.CounterOpts{
+		Name: "abacus_validation_failures_total",
+		Help: "Number of validation errors reported for sessions, by error type.",
+	}, "type")
+
+	RequestBodySize = newHistogramVec(prometheus.HistogramOpts{
+		Name:    "abacus_request_body_size_bytes",
+		Help:    "Size of request bodies received by the ingest endpoints, as sent by the client (before any decompression).",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 9),
+	}, "endpoint")
+
+	SessionEvents = newHistogram(prometheus.HistogramOpts{
+		Name:    "abacus_session_events",
+		Help:    "Number of events in each valid session received.",
+		Buckets: countBuckets,
+	})
+
+	SessionSpans = newHistogram(prometheus.HistogramOpts{
+		Name:    "abacus_session_spans",
+		Help:    "Number of spans in each valid session received.",
+		Buckets: countBuckets,
+	})
+
+	SessionStoreDuration = newHistogramVec(prometheus.HistogramOpts{
+		Name:    "abacus_session_store_duration_seconds",
+		Help:    "Time taken to store a session, including any retries, by outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, "outcome")
+
+	OptOutStoreDuration = newHistogramVec(prometheus.HistogramOpts{
+		Name:    "abacus_opt_out_store_duration_seconds",
+		Help:    "Time taken to record or check for an opt-out, including any retries, by operation and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, "operation", "outcome")
+)
+
+var countBuckets = []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns a handler that serves the metrics in Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(opts, labels)
+	Registry.MustRegister(c)
+
+	return c
+}
+
+func newHistogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	h := prometheus.NewHistogram(opts)
+	Registry.MustRegister(h)
+
+	return h
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(opts, labels)
+	Registry.MustRegister(h)
+
+	return h
+}
@@ -0,0 +1,346 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/metrics"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var _ = Describe("Metrics", func() {
+	Describe("the metrics endpoint", func() {
+		var resp *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			metrics.RecordIngestedSession("test-app", "1.2.3", metrics.Created, &types.Session{})
+
+			resp = httptest.NewRecorder()
+			metrics.Handler().ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		})
+
+		It("returns a successful response", func() {
+			Expect(resp.Code).To(Equal(http.StatusOK))
+		})
+
+		It("includes the session metrics", func() {
+			Expect(resp.Body.String()).To(ContainSubstring(`abacus_ingested_sessions_total{application="test-app",outcome="created",version="1.2.3"}`))
+		})
+
+		It("includes the Go runtime metrics", func() {
+			Expect(resp.Body.String()).To(ContainSubstring("go_goroutines"))
+		})
+	})
+
+	Describe("recording ingested sessions", func() {
+		var eventsBefore, spansBefore uint64
+
+		BeforeEach(func() {
+			eventsBefore = histogramCount(metrics.Registry, "abacus_session_events")
+			spansBefore = histogramCount(metrics.Registry, "abacus_session_spans")
+		})
+
+		Context("for a valid session", func() {
+			var countBefore float64
+
+			BeforeEach(func() {
+				counter := metrics.IngestedSessions.WithLabelValues("test-app", "2.0.0", string(metrics.Dropped))
+				countBefore = testutil.ToFloat64(counter)
+
+				metrics.RecordIngestedSession("test-app", "2.0.0", metrics.Dropped, &types.Session{
+					Events: []types.Event{{}, {}},
+					Spans:  []types.Span{{}},
+				})
+			})
+
+			It("counts the session by application, version and outcome", func() {
+				Expect(testutil.ToFloat64(metrics.IngestedSessions.WithLabelValues("test-app", "2.0.0", string(metrics.Dropped)))).To(Equal(countBefore + 1))
+			})
+
+			It("records the number of events and spans in the session", func() {
+				Expect(histogramCount(metrics.Registry, "abacus_session_events")).To(Equal(eventsBefore + 1))
+				Expect(histogramCount(metrics.Registry, "abacus_session_spans")).To(Equal(spansBefore + 1))
+			})
+		})
+
+		Context("for an invalid session", func() {
+			BeforeEach(func() {
+				metrics.RecordIngestedSession(metrics.UnknownLabelValue, metrics.UnknownLabelValue, metrics.Invalid, &types.Session{Events: []types.Event{{}}})
+			})
+
+			It("does not record the number of events and spans in the session", func() {
+				Expect(histogramCount(metrics.Registry, "abacus_session_events")).To(Equal(eventsBefore))
+				Expect(histogramCount(metrics.Registry, "abacus_session_spans")).To(Equal(spansBefore))
+			})
+		})
+	})
+
+	Describe("recording validation failures", func() {
+		It("counts each failure by its type", func() {
+			before := testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("uuid4"))
+
+			metrics.RecordValidationFailures([]validation.Error{{Key: "sessionId", Type: "uuid4"}, {Key: "userId", Type: "uuid4"}})
+
+			Expect(testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("uuid4"))).To(Equal(before + 2))
+		})
+	})
+
+	Describe("measuring request body sizes", func() {
+		var before uint64
+		var sumBefore float64
+
+		BeforeEach(func() {
+			before = histogramCount(metrics.Registry, "abacus_request_body_size_bytes")
+			sumBefore = histogramSum(metrics.Registry, "abacus_request_body_size_bytes", "/test")
+		})
+
+		Context("when the handler reads the body", func() {
+			var bodyRead string
+
+			BeforeEach(func() {
+				handler := metrics.MeasureRequestBodySize("/test", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+					body, err := io.ReadAll(req.Body)
+					Expect(err).ToNot(HaveOccurred())
+					bodyRead = string(body)
+				}))
+
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/test", strings.NewReader("some body")))
+			})
+
+			It("passes the body through unchanged", func() {
+				Expect(bodyRead).To(Equal("some body"))
+			})
+
+			It("records the size of the body", func() {
+				Expect(histogramCount(metrics.Registry, "abacus_request_body_size_bytes")).To(Equal(before + 1))
+				Expect(histogramSum(metrics.Registry, "abacus_request_body_size_bytes", "/test")).To(Equal(sumBefore + float64(len("some body"))))
+			})
+		})
+
+		Context("when the handler does not read the body", func() {
+			BeforeEach(func() {
+				handler := metrics.MeasureRequestBodySize("/test", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test", strings.NewReader("some body")))
+			})
+
+			It("does not record anything", func() {
+				Expect(histogramCount(metrics.Registry, "abacus_request_body_size_bytes")).To(Equal(before))
+			})
+		})
+	})
+
+	Describe("measuring session store latency", func() {
+		var inner *fakeStore
+		var store *metrics.InstrumentedSessionStore
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+			inner = &fakeStore{advance: func() { now = now.Add(250 * time.Millisecond) }}
+			store = metrics.NewInstrumentedSessionStoreWithTimeSource(inner, func() time.Time { return now })
+		})
+
+		for _, c := range []struct {
+			description string
+			err         error
+			outcome     string
+		}{
+			{"when storing the session succeeds", nil, "stored"},
+			{"when the session already exists", storage.ErrAlreadyExists, "alreadyExists"},
+			{"when storing the session fails", errors.New("something went wrong"), "failed"},
+		} {
+			testCase := c
+
+			Context(testCase.description, func() {
+				var countBefore uint64
+				var sumBefore float64
+				var err error
+
+				BeforeEach(func() {
+					countBefore = histogramCountWithLabel(metrics.Registry, "abacus_session_store_duration_seconds", testCase.outcome)
+					sumBefore = histogramSum(metrics.Registry, "abacus_session_store_duration_seconds", testCase.outcome)
+					inner.errorToReturn = testCase.err
+
+					err = store.Store(context.Background(), &types.Session{SessionID: "session-1"})
+				})
+
+				It("returns the result from the underlying store", func() {
+					if testCase.err == nil {
+						Expect(err).ToNot(HaveOccurred())
+					} else {
+						Expect(err).To(MatchError(testCase.err))
+					}
+				})
+
+				It("stores the session with the underlying store", func() {
+					Expect(inner.stored).To(ConsistOf("session-1"))
+				})
+
+				It("records the time taken against the outcome", func() {
+					Expect(histogramCountWithLabel(metrics.Registry, "abacus_session_store_duration_seconds", testCase.outcome)).To(Equal(countBefore + 1))
+					Expect(histogramSum(metrics.Registry, "abacus_session_store_duration_seconds", testCase.outcome)).To(BeNumerically("~", sumBefore+0.25))
+				})
+			})
+		}
+	})
+
+	Describe("measuring opt-out store latency", func() {
+		var inner *fakeOptOutStore
+		var store *metrics.InstrumentedOptOutStore
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+			inner = &fakeOptOutStore{advance: func() { now = now.Add(250 * time.Millisecond) }}
+			store = metrics.NewInstrumentedOptOutStoreWithTimeSource(inner, func() time.Time { return now })
+		})
+
+		for _, c := range []struct {
+			description string
+			err         error
+			outcome     string
+		}{
+			{"when recording the opt-out succeeds", nil, "recorded"},
+			{"when recording the opt-out fails", errors.New("something went wrong"), "failed"},
+		} {
+			testCase := c
+
+			Context(testCase.description, func() {
+				var countBefore uint64
+				var sumBefore float64
+				var err error
+
+				BeforeEach(func() {
+					countBefore = histogramCountWithLabel(metrics.Registry, "abacus_opt_out_store_duration_seconds", "record", testCase.outcome)
+					sumBefore = histogramSum(metrics.Registry, "abacus_opt_out_store_duration_seconds", "record", testCase.outcome)
+					inner.errorToReturn = testCase.err
+
+					err = store.RecordOptOut(context.Background(), &types.OptOut{UserID: "user-1"})
+				})
+
+				It("returns the result from the underlying store", func() {
+					if testCase.err == nil {
+						Expect(err).ToNot(HaveOccurred())
+					} else {
+						Expect(err).To(MatchError(testCase.err))
+					}
+				})
+
+				It("records the opt-out with the underlying store", func() {
+					Expect(inner.recorded).To(ConsistOf("user-1"))
+				})
+
+				It("records the time taken against the operation and outcome", func() {
+					Expect(histogramCountWithLabel(metrics.Registry, "abacus_opt_out_store_duration_seconds", "record", testCase.outcome)).To(Equal(countBefore + 1))
+					Expect(histogramSum(metrics.Registry, "abacus_opt_out_store_duration_seconds", "record", testCase.outcome)).To(BeNumerically("~", sumBefore+0.25))
+				})
+			})
+		}
+
+		for _, c := range []struct {
+			description string
+			optedOut    bool
+			err         error
+			outcome     string
+		}{
+			{"when the user has opted out", true, nil, "optedOut"},
+			{"when the user has not opted out", false, nil, "notOptedOut"},
+			{"when checking for an opt-out fails", false, errors.New("something went wrong"), "failed"},
+		} {
+			testCase := c
+
+			Context(testCase.description, func() {
+				var countBefore uint64
+				var sumBefore float64
+				var optedOut bool
+				var err error
+
+				BeforeEach(func() {
+					countBefore = histogramCountWithLabel(metrics.Registry, "abacus_opt_out_store_duration_seconds", "check", testCase.outcome)
+					sumBefore = histogramSum(metrics.Registry, "abacus_opt_out_store_duration_seconds", "check", testCase.outcome)
+					inner.optedOut = testCase.optedOut
+					inner.errorToReturn = testCase.err
+
+					optedOut, err = store.IsOptedOut(context.Background(), "user-1")
+				})
+
+				It("returns the result from the underlying store", func() {
+					Expect(optedOut).To(Equal(testCase.optedOut))
+
+					if testCase.err == nil {
+						Expect(err).ToNot(HaveOccurred())
+					} else {
+						Expect(err).To(MatchError(testCase.err))
+					}
+				})
+
+				It("records the time taken against the operation and outcome", func() {
+					Expect(histogramCountWithLabel(metrics.Registry, "abacus_opt_out_store_duration_seconds", "check", testCase.outcome)).To(Equal(countBefore + 1))
+					Expect(histogramSum(metrics.Registry, "abacus_opt_out_store_duration_seconds", "check", testCase.outcome)).To(BeNumerically("~", sumBefore+0.25))
+				})
+			})
+		}
+	})
+})
+
+type fakeStore struct {
+	stored        []string
+	errorToReturn error
+	advance       func()
+}
+
+func (s *fakeStore) Store(_ context.Context, session *types.Session) error {
+	s.stored = append(s.stored, session.SessionID)
+	s.advance()
+
+	return s.errorToReturn
+}
+
+type fakeOptOutStore struct {
+	recorded      []string
+	optedOut      bool
+	errorToReturn error
+	advance       func()
+}
+
+func (s *fakeOptOutStore) RecordOptOut(_ context.Context, optOut *types.OptOut) error {
+	s.recorded = append(s.recorded, optOut.UserID)
+	s.advance()
+
+	return s.errorToReturn
+}
+
+func (s *fakeOptOutStore) IsOptedOut(_ context.Context, _ string) (bool, error) {
+	s.advance()
+
+	return s.optedOut, s.errorToReturn
+}
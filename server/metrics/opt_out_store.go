@@ -0,0 +1,76 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+)
+
+// InstrumentedOptOutStore wraps another OptOutStore, recording how long each call takes in OptOutStoreDuration.
+type InstrumentedOptOutStore struct {
+	inner      storage.OptOutStore
+	timeSource func() time.Time
+}
+
+func NewInstrumentedOptOutStore(inner storage.OptOutStore) *InstrumentedOptOutStore {
+	return NewInstrumentedOptOutStoreWithTimeSource(inner, time.Now)
+}
+
+func NewInstrumentedOptOutStoreWithTimeSource(inner storage.OptOutStore, timeSource func() time.Time) *InstrumentedOptOutStore {
+	return &InstrumentedOptOutStore{inner: inner, timeSource: timeSource}
+}
+
+func (s *InstrumentedOptOutStore) RecordOptOut(ctx context.Context, optOut *types.OptOut) error {
+	start := s.timeSource()
+	err := s.inner.RecordOptOut(ctx, optOut)
+	duration := s.timeSource().Sub(start)
+
+	outcome := "recorded"
+
+	if err != nil {
+		outcome = "failed"
+	}
+
+	OptOutStoreDuration.WithLabelValues("record", outcome).Observe(duration.Seconds())
+
+	return err
+}
+
+func (s *InstrumentedOptOutStore) IsOptedOut(ctx context.Context, userID string) (bool, error) {
+	start := s.timeSource()
+	optedOut, err := s.inner.IsOptedOut(ctx, userID)
+	duration := s.timeSource().Sub(start)
+
+	outcome := "notOptedOut"
+
+	if err != nil {
+		outcome = "failed"
+	} else if optedOut {
+		outcome = "optedOut"
+	}
+
+	OptOutStoreDuration.WithLabelValues("check", outcome).Observe(duration.Seconds())
+
+	return optedOut, err
+}
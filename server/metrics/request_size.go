@@ -0,0 +1,58 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+)
+
+// MeasureRequestBodySize wraps next, recording the number of bytes it reads from each request body in RequestBodySize.
+// Requests where next doesn't read any of the body, such as those with the wrong method, are not recorded.
+func MeasureRequestBodySize(endpoint string, next http.Handler) http.Handler {
+	observer := RequestBodySize.WithLabelValues(endpoint)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body := &countingReader{inner: req.Body}
+		req.Body = body
+
+		next.ServeHTTP(w, req)
+
+		if body.count > 0 {
+			observer.Observe(float64(body.count))
+		}
+	})
+}
+
+type countingReader struct {
+	inner io.ReadCloser
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.inner.Read(p)
+	r.count += int64(n)
+
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.inner.Close()
+}
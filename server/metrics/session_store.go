@@ -0,0 +1,61 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+)
+
+// InstrumentedSessionStore wraps another SessionStore, recording how long each call to Store takes in SessionStoreDuration.
+type InstrumentedSessionStore struct {
+	inner      storage.SessionStore
+	timeSource func() time.Time
+}
+
+func NewInstrumentedSessionStore(inner storage.SessionStore) *InstrumentedSessionStore {
+	return NewInstrumentedSessionStoreWithTimeSource(inner, time.Now)
+}
+
+func NewInstrumentedSessionStoreWithTimeSource(inner storage.SessionStore, timeSource func() time.Time) *InstrumentedSessionStore {
+	return &InstrumentedSessionStore{inner: inner, timeSource: timeSource}
+}
+
+func (s *InstrumentedSessionStore) Store(ctx context.Context, session *types.Session) error {
+	start := s.timeSource()
+	err := s.inner.Store(ctx, session)
+	duration := s.timeSource().Sub(start)
+
+	outcome := "stored"
+
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		outcome = "alreadyExists"
+	} else if err != nil {
+		outcome = "failed"
+	}
+
+	SessionStoreDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+
+	return err
+}
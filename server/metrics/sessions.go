@@ -0,0 +1,45 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package metrics
+
+import (
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+)
+
+// RecordIngestedSession records that a session for applicationID and version had outcome, along with the number of events and
+// spans in session if it is valid. applicationID and version must have been validated, or be UnknownLabelValue.
+func RecordIngestedSession(applicationID string, version string, outcome Outcome, session *types.Session) {
+	IngestedSessions.WithLabelValues(applicationID, version, string(outcome)).Inc()
+
+	if outcome == Invalid || session == nil {
+		return
+	}
+
+	SessionEvents.Observe(float64(len(session.Events)))
+	SessionSpans.Observe(float64(len(session.Spans)))
+}
+
+// RecordValidationFailures records each of errors by its type.
+func RecordValidationFailures(errors []validation.Error) {
+	for _, e := range errors {
+		ValidationFailures.WithLabelValues(e.Type).Inc()
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package observability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// createExporter creates the exporter selected by config, or returns nil if traces should be discarded.
+func createExporter(ctx context.Context, config Config) (trace.SpanExporter, error) {
+	switch config.Exporter {
+	case ExporterHoneycomb:
+		return nil, errors.New("the Honeycomb exporter is set up by services-common")
+	case ExporterOTLP:
+		exporter, err := otlptrace.New(ctx, otlptracegrpc.NewClient())
+
+		if err != nil {
+			return nil, fmt.Errorf("could not create OTLP tracing exporter: %w", err)
+		}
+
+		return exporter, nil
+	case ExporterStderr:
+		return createWriterExporter(os.Stderr, nil)
+	case ExporterFile:
+		file, err := os.OpenFile(config.TracesFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not open traces file: %w", err)
+		}
+
+		return createWriterExporter(file, file.Close)
+	case ExporterNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter '%v', must be one of %v", config.Exporter, Exporters)
+	}
+}
+
+// createWriterExporter creates an exporter that writes each span to w as JSON, calling closeWriter (if it is not nil) when it is shut down.
+func createWriterExporter(w io.Writer, closeWriter func() error) (trace.SpanExporter, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tracing exporter: %w", err)
+	}
+
+	if closeWriter == nil {
+		return exporter, nil
+	}
+
+	return &closingExporter{SpanExporter: exporter, closeWriter: closeWriter}, nil
+}
+
+type closingExporter struct {
+	trace.SpanExporter
+	closeWriter func() error
+}
+
+func (e *closingExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+
+	if closeErr := e.closeWriter(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
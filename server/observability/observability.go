@@ -0,0 +1,183 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package observability configures logging, tracing and profiling for the service, sending traces to the backend selected in configuration.
+//
+// ExporterHoneycomb is set up by services-common's startup package, in the same way as the other batect services. The other
+// exporters, which services-common does not support, are set up here with the same logging, propagation and HTTP client
+// instrumentation, but with their own tracer provider and without profiling.
+package observability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	gcppropagator "github.com/GoogleCloudPlatform/opentelemetry-operations-go/propagator"
+	"github.com/batect/services-common/startup"
+	"github.com/batect/services-common/tracing"
+	stackdriver "github.com/charleskorn/logrus-stackdriver-formatter"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Exporter selects where traces are sent.
+type Exporter string
+
+const (
+	// ExporterHoneycomb sends traces to both Google Cloud Trace and Honeycomb, and also enables Google Cloud Profiler.
+	ExporterHoneycomb Exporter = "honeycomb"
+
+	// ExporterOTLP sends traces to an OTLP/gRPC endpoint, configured with the standard OTEL_EXPORTER_OTLP_* environment variables.
+	ExporterOTLP Exporter = "otlp"
+
+	// ExporterStderr writes traces to standard error, so that they are not mixed into the output of commands that write to
+	// standard output, such as export.
+	ExporterStderr Exporter = "stderr"
+
+	// ExporterFile writes traces to a file.
+	ExporterFile Exporter = "file"
+
+	// ExporterNone discards traces.
+	ExporterNone Exporter = "none"
+)
+
+// Exporters lists all of the supported exporters.
+var Exporters = []Exporter{ExporterHoneycomb, ExporterOTLP, ExporterStderr, ExporterFile, ExporterNone}
+
+type Config struct {
+	ServiceName    string
+	ServiceVersion string
+	ProjectID      string
+	Exporter       Exporter
+
+	// HoneycombAPIKey is required by ExporterHoneycomb.
+	HoneycombAPIKey string
+
+	// TracesFile is the path traces are appended to, and is required by ExporterFile.
+	TracesFile string
+}
+
+// Validate returns an error if config selects an unknown exporter, or is missing settings required by its exporter.
+func (config Config) Validate() error {
+	switch config.Exporter {
+	case ExporterHoneycomb:
+		if config.HoneycombAPIKey == "" {
+			return errors.New("a Honeycomb API key is required to send traces to Honeycomb")
+		}
+	case ExporterFile:
+		if config.TracesFile == "" {
+			return errors.New("a traces file is required to write traces to a file")
+		}
+	case ExporterOTLP, ExporterStderr, ExporterNone:
+	default:
+		return fmt.Errorf("unknown trace exporter '%v', must be one of %v", config.Exporter, Exporters)
+	}
+
+	return nil
+}
+
+// Initialise configures logging and tracing (and profiling, for ExporterHoneycomb) as described by config.
+// It returns a function that flushes any buffered traces, which should be called before the process exits.
+func Initialise(config Config) (func(), error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Exporter == ExporterHoneycomb {
+		return startup.InitialiseObservability(config.ServiceName, config.ServiceVersion, config.ProjectID, config.HoneycombAPIKey)
+	}
+
+	initLogging(config)
+	otel.SetErrorHandler(&errorHandler{})
+
+	provider, err := NewTracerProvider(context.Background(), config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, gcppropagator.CloudTraceOneWayPropagator{}))
+
+	http.DefaultTransport = otelhttp.NewTransport(
+		http.DefaultTransport,
+		otelhttp.WithMessageEvents(otelhttp.ReadEvents, otelhttp.WriteEvents),
+		otelhttp.WithSpanNameFormatter(tracing.NameHTTPRequestSpan),
+	)
+
+	return func() {
+		logrus.Info("Flushing remaining traces...")
+
+		if err := provider.Shutdown(context.Background()); err != nil {
+			logrus.WithError(err).Warning("Shutting down tracing provider failed with error.")
+		}
+
+		logrus.Info("Flushing complete.")
+	}, nil
+}
+
+// NewTracerProvider creates a tracer provider that sends all traces to the exporter selected by config, with resource
+// attributes identifying the service. ExporterHoneycomb is not supported, as it is set up by services-common.
+func NewTracerProvider(ctx context.Context, config Config) (*trace.TracerProvider, error) {
+	exporter, err := createExporter(ctx, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []trace.TracerProviderOption{
+		trace.WithSampler(trace.AlwaysSample()),
+		trace.WithResource(Resource(config)),
+	}
+
+	if exporter != nil {
+		opts = append(opts, trace.WithBatcher(exporter))
+	}
+
+	return trace.NewTracerProvider(opts...), nil
+}
+
+// Resource returns the resource attributes that identify the service in traces.
+func Resource(config Config) *resource.Resource {
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(config.ServiceName),
+		semconv.ServiceVersionKey.String(config.ServiceVersion),
+	)
+}
+
+func initLogging(config Config) {
+	logrus.SetFormatter(stackdriver.NewFormatter(
+		stackdriver.WithService(config.ServiceName),
+		stackdriver.WithVersion(config.ServiceVersion),
+	))
+}
+
+type errorHandler struct{}
+
+func (e *errorHandler) Handle(err error) {
+	logrus.WithError(err).Warn("OpenTelemetry reported error.")
+}
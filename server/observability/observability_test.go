@@ -0,0 +1,114 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package observability_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/batect/abacus/server/observability"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Observability", func() {
+	Describe("validating configuration", func() {
+		DescribeTable("valid configuration",
+			func(config observability.Config) {
+				Expect(config.Validate()).To(Succeed())
+			},
+			Entry("Honeycomb with an API key", observability.Config{Exporter: observability.ExporterHoneycomb, HoneycombAPIKey: "abc123"}),
+			Entry("OTLP", observability.Config{Exporter: observability.ExporterOTLP}),
+			Entry("standard error", observability.Config{Exporter: observability.ExporterStderr}),
+			Entry("a file with a path", observability.Config{Exporter: observability.ExporterFile, TracesFile: "/tmp/traces.json"}),
+			Entry("no exporter", observability.Config{Exporter: observability.ExporterNone}),
+		)
+
+		DescribeTable("invalid configuration",
+			func(config observability.Config, expectedError string) {
+				Expect(config.Validate()).To(MatchError(expectedError))
+			},
+			Entry("Honeycomb without an API key", observability.Config{Exporter: observability.ExporterHoneycomb}, "a Honeycomb API key is required to send traces to Honeycomb"),
+			Entry("a file without a path", observability.Config{Exporter: observability.ExporterFile}, "a traces file is required to write traces to a file"),
+			Entry("an unknown exporter", observability.Config{Exporter: "zipkin"}, "unknown trace exporter 'zipkin', must be one of [honeycomb otlp stderr file none]"),
+		)
+	})
+
+	Describe("creating a tracer provider", func() {
+		Context("when writing traces to a file", func() {
+			var path string
+
+			BeforeEach(func() {
+				path = filepath.Join(GinkgoT().TempDir(), "traces.json")
+
+				provider, err := observability.NewTracerProvider(context.Background(), observability.Config{
+					ServiceName:    "abacus",
+					ServiceVersion: "1.2.3",
+					Exporter:       observability.ExporterFile,
+					TracesFile:     path,
+				})
+
+				Expect(err).ToNot(HaveOccurred())
+
+				_, span := provider.Tracer("test").Start(context.Background(), "Doing something")
+				span.End()
+
+				Expect(provider.Shutdown(context.Background())).To(Succeed())
+			})
+
+			It("writes spans to the file", func() {
+				Expect(os.ReadFile(path)).To(ContainSubstring(`"Name":"Doing something"`))
+			})
+
+			It("includes the service's resource attributes", func() {
+				content, err := os.ReadFile(path)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(string(content)).To(ContainSubstring(`{"Key":"service.name","Value":{"Type":"STRING","Value":"abacus"}}`))
+				Expect(string(content)).To(ContainSubstring(`{"Key":"service.version","Value":{"Type":"STRING","Value":"1.2.3"}}`))
+			})
+		})
+
+		Context("when the traces file can't be opened", func() {
+			It("returns an error", func() {
+				_, err := observability.NewTracerProvider(context.Background(), observability.Config{
+					Exporter:   observability.ExporterFile,
+					TracesFile: filepath.Join(GinkgoT().TempDir(), "does-not-exist", "traces.json"),
+				})
+
+				Expect(err).To(MatchError(ContainSubstring("could not open traces file")))
+			})
+		})
+
+		Context("when discarding traces", func() {
+			It("creates a tracer provider that records spans without exporting them", func() {
+				provider, err := observability.NewTracerProvider(context.Background(), observability.Config{Exporter: observability.ExporterNone})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, span := provider.Tracer("test").Start(context.Background(), "Doing something")
+				span.End()
+
+				Expect(span.SpanContext().IsValid()).To(BeTrue())
+				Expect(provider.Shutdown(context.Background())).To(Succeed())
+			})
+		})
+	})
+})
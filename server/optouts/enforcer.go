@@ -0,0 +1,142 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package optouts deletes the stored sessions of users who have opted out of telemetry and asked for their existing sessions to be deleted.
+package optouts
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/pseudonymisation"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+)
+
+// Enforcer deletes the stored sessions of every user whose opt-out asks for their existing sessions to be deleted.
+//
+// Sessions received after a user opts out are never stored, so enforcing opt-outs once after each opt-out is recorded is
+// sufficient, but enforcing them repeatedly is harmless.
+//
+// Sessions are matched to users by both their original user ID (for sessions stored before pseudonymisation was enabled)
+// and their pseudonym in each of the application's key epochs.
+type Enforcer struct {
+	registry      *applications.Registry
+	optOuts       storage.OptOutReader
+	store         storage.SessionDeleter
+	pseudonymiser *pseudonymisation.Pseudonymiser
+}
+
+type Report struct {
+	UsersWithDeletionRequested int
+	Applications               []ApplicationReport
+}
+
+type ApplicationReport struct {
+	ApplicationID   string
+	SessionsScanned int
+	SessionsDeleted int
+}
+
+func NewEnforcer(
+	registry *applications.Registry,
+	optOuts storage.OptOutReader,
+	store storage.SessionDeleter,
+	pseudonymiser *pseudonymisation.Pseudonymiser,
+) *Enforcer {
+	return &Enforcer{
+		registry:      registry,
+		optOuts:       optOuts,
+		store:         store,
+		pseudonymiser: pseudonymiser,
+	}
+}
+
+func (e *Enforcer) Enforce(ctx context.Context) (Report, error) {
+	report := Report{}
+	userIDs := map[string]bool{}
+
+	err := e.optOuts.ReadOptOuts(ctx, func(optOut *types.OptOut) error {
+		if optOut.DeleteExistingSessions {
+			userIDs[optOut.UserID] = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return report, fmt.Errorf("could not read opt-outs: %w", err)
+	}
+
+	report.UsersWithDeletionRequested = len(userIDs)
+
+	if len(userIDs) == 0 {
+		return report, nil
+	}
+
+	for _, app := range e.registry.All() {
+		storedUserIDs := e.storedUserIDs(app.ID, userIDs)
+
+		result, err := e.store.DeleteSessions(ctx, app.ID, func(session *types.Session) bool {
+			return storedUserIDs[session.UserID]
+		})
+
+		report.Applications = append(report.Applications, ApplicationReport{
+			ApplicationID:   app.ID,
+			SessionsScanned: result.SessionsScanned,
+			SessionsDeleted: result.SessionsDeleted,
+		})
+
+		if err != nil {
+			return report, fmt.Errorf("could not delete sessions of opted-out users for application %v: %w", app.ID, err)
+		}
+	}
+
+	return report, nil
+}
+
+// storedUserIDs returns every user ID the sessions of the users in userIDs could have been stored with for applicationID.
+func (e *Enforcer) storedUserIDs(applicationID string, userIDs map[string]bool) map[string]bool {
+	if !e.pseudonymiser.Enabled(applicationID) {
+		return userIDs
+	}
+
+	stored := make(map[string]bool, len(userIDs))
+
+	for userID := range userIDs {
+		stored[userID] = true
+
+		for _, pseudonym := range e.pseudonymiser.AllPseudonyms(applicationID, userID) {
+			stored[pseudonym] = true
+		}
+	}
+
+	return stored
+}
+
+func (r Report) TotalSessionsDeleted() int {
+	total := 0
+
+	for _, app := range r.Applications {
+		total += app.SessionsDeleted
+	}
+
+	return total
+}
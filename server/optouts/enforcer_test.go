@@ -0,0 +1,246 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package optouts_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/optouts"
+	"github.com/batect/abacus/server/pseudonymisation"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Enforcing opt-outs", func() {
+	var store *fakeStore
+	var optOutStore *fakeOptOutStore
+	var registry *applications.Registry
+	var enforcer *optouts.Enforcer
+
+	BeforeEach(func() {
+		registry = applications.NewRegistry(
+			applications.Application{ID: "first-app"},
+			applications.Application{ID: "second-app"},
+		)
+
+		store = &fakeStore{sessions: []types.Session{
+			{SessionID: "session-from-user-wanting-deletion", ApplicationID: "first-app", UserID: "user-wanting-deletion"},
+			{SessionID: "session-from-user-not-wanting-deletion", ApplicationID: "first-app", UserID: "user-not-wanting-deletion"},
+			{SessionID: "session-from-other-user", ApplicationID: "first-app", UserID: "other-user"},
+			{SessionID: "other-session-from-user-wanting-deletion", ApplicationID: "second-app", UserID: "user-wanting-deletion"},
+		}}
+
+		optOutStore = &fakeOptOutStore{optOuts: []types.OptOut{
+			{UserID: "user-wanting-deletion", DeleteExistingSessions: true},
+			{UserID: "user-not-wanting-deletion"},
+		}}
+
+		pseudonymiser, err := pseudonymisation.NewPseudonymiser()
+		Expect(err).ToNot(HaveOccurred())
+
+		enforcer = optouts.NewEnforcer(registry, optOutStore, store, pseudonymiser)
+	})
+
+	Context("when deleting sessions succeeds", func() {
+		var report optouts.Report
+
+		BeforeEach(func() {
+			var err error
+			report, err = enforcer.Enforce(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("deletes the sessions of users who asked for their sessions to be deleted from every application", func() {
+			Expect(store.sessionIDs()).To(ConsistOf(
+				"session-from-user-not-wanting-deletion",
+				"session-from-other-user",
+			))
+		})
+
+		It("returns a report summarising the sessions deleted", func() {
+			Expect(report).To(Equal(optouts.Report{
+				UsersWithDeletionRequested: 1,
+				Applications: []optouts.ApplicationReport{
+					{ApplicationID: "first-app", SessionsScanned: 3, SessionsDeleted: 1},
+					{ApplicationID: "second-app", SessionsScanned: 1, SessionsDeleted: 1},
+				},
+			}))
+		})
+
+		It("reports the total number of sessions deleted", func() {
+			Expect(report.TotalSessionsDeleted()).To(Equal(2))
+		})
+	})
+
+	Context("when an application's user IDs are pseudonymised", func() {
+		BeforeEach(func() {
+			firstEpoch := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+			secondEpoch := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+
+			pseudonymiser, err := pseudonymisation.NewPseudonymiser(
+				pseudonymisation.Key{ApplicationID: "first-app", EpochStart: firstEpoch, Secret: bytes.Repeat([]byte{1}, 32)},
+				pseudonymisation.Key{ApplicationID: "first-app", EpochStart: secondEpoch, Secret: bytes.Repeat([]byte{2}, 32)},
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			store.sessions = []types.Session{
+				{SessionID: "session-stored-before-pseudonymisation", ApplicationID: "first-app", UserID: "user-wanting-deletion"},
+				{SessionID: "session-from-first-epoch", ApplicationID: "first-app", UserID: pseudonymiser.Pseudonymise("first-app", "user-wanting-deletion", firstEpoch)},
+				{SessionID: "session-from-second-epoch", ApplicationID: "first-app", UserID: pseudonymiser.Pseudonymise("first-app", "user-wanting-deletion", secondEpoch)},
+				{SessionID: "session-from-other-user", ApplicationID: "first-app", UserID: pseudonymiser.Pseudonymise("first-app", "other-user", secondEpoch)},
+			}
+
+			enforcer = optouts.NewEnforcer(registry, optOutStore, store, pseudonymiser)
+
+			_, err = enforcer.Enforce(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("deletes the user's sessions stored with their original user ID or their pseudonym from any epoch", func() {
+			Expect(store.sessionIDs()).To(ConsistOf("session-from-other-user"))
+		})
+	})
+
+	Context("when no users have asked for their sessions to be deleted", func() {
+		var report optouts.Report
+
+		BeforeEach(func() {
+			optOutStore.optOuts = []types.OptOut{{UserID: "user-not-wanting-deletion"}}
+
+			var err error
+			report, err = enforcer.Enforce(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("does not scan any sessions", func() {
+			Expect(store.scannedApplications).To(BeEmpty())
+		})
+
+		It("returns an empty report", func() {
+			Expect(report).To(Equal(optouts.Report{}))
+		})
+	})
+
+	Context("when reading opt-outs fails", func() {
+		var err error
+
+		BeforeEach(func() {
+			optOutStore.errorToReturn = errors.New("something went wrong")
+			_, err = enforcer.Enforce(context.Background())
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError("could not read opt-outs: something went wrong"))
+		})
+
+		It("does not delete any sessions", func() {
+			Expect(store.sessions).To(HaveLen(4))
+		})
+	})
+
+	Context("when deleting sessions fails", func() {
+		var err error
+		var report optouts.Report
+
+		BeforeEach(func() {
+			store.errorToReturn = errors.New("something went wrong")
+			report, err = enforcer.Enforce(context.Background())
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError("could not delete sessions of opted-out users for application first-app: something went wrong"))
+		})
+
+		It("includes the progress made before the failure in the report", func() {
+			Expect(report.Applications).To(HaveLen(1))
+			Expect(report.Applications[0].SessionsScanned).To(Equal(3))
+		})
+	})
+})
+
+type fakeOptOutStore struct {
+	optOuts       []types.OptOut
+	errorToReturn error
+}
+
+func (f *fakeOptOutStore) ReadOptOuts(_ context.Context, fn func(optOut *types.OptOut) error) error {
+	if f.errorToReturn != nil {
+		return f.errorToReturn
+	}
+
+	for i := range f.optOuts {
+		if err := fn(&f.optOuts[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type fakeStore struct {
+	sessions            []types.Session
+	scannedApplications []string
+	errorToReturn       error
+}
+
+func (f *fakeStore) DeleteSessions(_ context.Context, applicationID string, shouldDelete func(session *types.Session) bool) (storage.DeletionResult, error) {
+	f.scannedApplications = append(f.scannedApplications, applicationID)
+	result := storage.DeletionResult{}
+	remaining := []types.Session{}
+
+	for i, session := range f.sessions {
+		if session.ApplicationID != applicationID {
+			remaining = append(remaining, session)
+			continue
+		}
+
+		result.SessionsScanned++
+
+		if shouldDelete(&f.sessions[i]) {
+			result.SessionsDeleted++
+		} else {
+			remaining = append(remaining, session)
+		}
+	}
+
+	if f.errorToReturn != nil {
+		return result, f.errorToReturn
+	}
+
+	f.sessions = remaining
+
+	return result, nil
+}
+
+func (f *fakeStore) sessionIDs() []string {
+	ids := []string{}
+
+	for _, session := range f.sessions {
+		ids = append(ids, session.SessionID)
+	}
+
+	return ids
+}
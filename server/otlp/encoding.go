@@ -0,0 +1,142 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ProtobufContentType = "application/x-protobuf"
+	JSONContentType     = "application/json"
+)
+
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// UnmarshalTracesRequest decodes an OTLP/HTTP traces export request encoded with contentType.
+func UnmarshalTracesRequest(contentType string, body []byte) (*coltracepb.ExportTraceServiceRequest, error) {
+	req := &coltracepb.ExportTraceServiceRequest{}
+
+	switch contentType {
+	case ProtobufContentType:
+		if err := proto.Unmarshal(body, req); err != nil {
+			return nil, err
+		}
+	case JSONContentType:
+		body, err := hexIDsToBase64(body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, req); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedContentType, contentType)
+	}
+
+	return req, nil
+}
+
+// MarshalTracesResponse encodes resp with contentType, for returning to the client that sent an OTLP/HTTP traces export request.
+func MarshalTracesResponse(contentType string, resp *coltracepb.ExportTraceServiceResponse) ([]byte, error) {
+	switch contentType {
+	case ProtobufContentType:
+		return proto.Marshal(resp)
+	case JSONContentType:
+		return protojson.Marshal(resp)
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedContentType, contentType)
+	}
+}
+
+// The OTLP JSON encoding differs from the standard protobuf JSON mapping in one way: trace and span IDs are
+// hex-encoded rather than base64-encoded. hexIDsToBase64 rewrites those IDs so that the body can be decoded with protojson.
+func hexIDsToBase64(body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var root map[string]interface{}
+
+	if err := decoder.Decode(&root); err != nil {
+		return nil, err
+	}
+
+	for _, rs := range objects(root["resourceSpans"]) {
+		for _, ss := range objects(rs["scopeSpans"]) {
+			for _, span := range objects(ss["spans"]) {
+				if err := convertIDs(span, "traceId", "spanId", "parentSpanId"); err != nil {
+					return nil, err
+				}
+
+				for _, link := range objects(span["links"]) {
+					if err := convertIDs(link, "traceId", "spanId"); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func objects(value interface{}) []map[string]interface{} {
+	list, _ := value.([]interface{})
+	objects := make([]map[string]interface{}, 0, len(list))
+
+	for _, element := range list {
+		if object, ok := element.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+
+	return objects
+}
+
+func convertIDs(object map[string]interface{}, keys ...string) error {
+	for _, key := range keys {
+		value, ok := object[key].(string)
+
+		if !ok {
+			continue
+		}
+
+		id, err := hex.DecodeString(value)
+
+		if err != nil {
+			return fmt.Errorf("%v is not a valid hex-encoded ID: %w", key, err)
+		}
+
+		object[key] = base64.StdEncoding.EncodeToString(id)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp_test
+
+import (
+	"github.com/batect/abacus/server/otlp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Encoding OTLP requests and responses", func() {
+	Describe("unmarshalling traces requests", func() {
+		Context("given a protobuf-encoded request", func() {
+			It("decodes the request", func() {
+				original := &coltracepb.ExportTraceServiceRequest{
+					ResourceSpans: []*tracepb.ResourceSpans{
+						{ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "span", StartTimeUnixNano: 1000}}}}},
+					},
+				}
+
+				body, err := proto.Marshal(original)
+				Expect(err).ToNot(HaveOccurred())
+
+				req, err := otlp.UnmarshalTracesRequest("application/x-protobuf", body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(proto.Equal(req, original)).To(BeTrue())
+			})
+		})
+
+		Context("given a JSON-encoded request", func() {
+			var req *coltracepb.ExportTraceServiceRequest
+			var err error
+
+			BeforeEach(func() {
+				req, err = otlp.UnmarshalTracesRequest("application/json", []byte(`{
+					"resourceSpans": [{
+						"scopeSpans": [{
+							"spans": [{
+								"traceId": "5b8efff798038103d269b633813fc60c",
+								"spanId": "eee19b7ec3c1b174",
+								"parentSpanId": "eee19b7ec3c1b173",
+								"name": "span",
+								"startTimeUnixNano": "1544712660000000000",
+								"attributes": [{ "key": "count", "value": { "intValue": 3 } }],
+								"links": [{ "traceId": "5b8efff798038103d269b633813fc60d", "spanId": "eee19b7ec3c1b175" }],
+								"somethingNew": true
+							}]
+						}]
+					}]
+				}`))
+			})
+
+			It("does not return an error", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("decodes the hex-encoded trace and span IDs", func() {
+				span := req.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()[0]
+
+				Expect(span.GetTraceId()).To(Equal([]byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}))
+				Expect(span.GetSpanId()).To(Equal([]byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74}))
+				Expect(span.GetParentSpanId()).To(Equal([]byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x73}))
+				Expect(span.GetLinks()[0].GetSpanId()).To(Equal([]byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x75}))
+			})
+
+			It("decodes the other span fields", func() {
+				span := req.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()[0]
+
+				Expect(span.GetName()).To(Equal("span"))
+				Expect(span.GetStartTimeUnixNano()).To(Equal(uint64(1544712660000000000)))
+				Expect(span.GetAttributes()).To(HaveLen(1))
+				Expect(span.GetAttributes()[0].GetValue().GetIntValue()).To(Equal(int64(3)))
+			})
+		})
+
+		Context("given a JSON-encoded request with an invalid span ID", func() {
+			It("returns an error", func() {
+				_, err := otlp.UnmarshalTracesRequest("application/json", []byte(`{"resourceSpans":[{"scopeSpans":[{"spans":[{"spanId":"xyz"}]}]}]}`))
+				Expect(err).To(MatchError(ContainSubstring("spanId is not a valid hex-encoded ID")))
+			})
+		})
+
+		Context("given a request with an unsupported content type", func() {
+			It("returns an error", func() {
+				_, err := otlp.UnmarshalTracesRequest("text/plain", []byte("blah"))
+				Expect(err).To(MatchError(otlp.ErrUnsupportedContentType))
+			})
+		})
+	})
+
+	Describe("marshalling traces responses", func() {
+		resp := &coltracepb.ExportTraceServiceResponse{
+			PartialSuccess: &coltracepb.ExportTracePartialSuccess{RejectedSpans: 2, ErrorMessage: "something was wrong"},
+		}
+
+		It("encodes the response as JSON", func() {
+			body, err := otlp.MarshalTracesResponse("application/json", resp)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(MatchJSON(`{"partialSuccess":{"rejectedSpans":"2","errorMessage":"something was wrong"}}`))
+		})
+
+		It("encodes the response as protobuf", func() {
+			body, err := otlp.MarshalTracesResponse("application/x-protobuf", resp)
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded := &coltracepb.ExportTraceServiceResponse{}
+			Expect(proto.Unmarshal(body, decoded)).To(Succeed())
+			Expect(proto.Equal(decoded, resp)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,111 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const maxErrorResponseSize = 1024
+
+var ErrSpansRejected = errors.New("collector rejected some spans")
+
+// Exporter sends traces to an OTLP/HTTP endpoint (eg. an OpenTelemetry Collector, Jaeger or Tempo) using the protobuf encoding.
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewExporter creates an exporter that sends traces to endpoint, which should be the full URL of the traces endpoint
+// (eg. 'http://localhost:4318/v1/traces'). headers are added to every request, and can be used for authentication.
+func NewExporter(endpoint string, headers map[string]string) *Exporter {
+	return &Exporter{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Export sends resourceSpans to the endpoint in a single request.
+//
+// If the endpoint accepts the request but rejects some of the spans, the returned error wraps ErrSpansRejected.
+func (e *Exporter) Export(ctx context.Context, resourceSpans []*tracepb.ResourceSpans) error {
+	body, err := proto.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: resourceSpans})
+
+	if err != nil {
+		return fmt.Errorf("could not encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("Content-Type", ProtobufContentType)
+
+	resp, err := e.client.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("could not send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorResponseSize))
+
+		return fmt.Errorf("endpoint returned HTTP %v: %v", resp.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return fmt.Errorf("could not read response: %w", err)
+	}
+
+	exportResponse := &coltracepb.ExportTraceServiceResponse{}
+
+	if err := proto.Unmarshal(responseBody, exportResponse); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	if partialSuccess := exportResponse.GetPartialSuccess(); partialSuccess.GetRejectedSpans() > 0 {
+		return fmt.Errorf("%w: %v span(s) rejected: %v", ErrSpansRejected, partialSuccess.GetRejectedSpans(), partialSuccess.GetErrorMessage())
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/batect/abacus/server/otlp"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Exporting traces", func() {
+	var server *httptest.Server
+	var receivedRequests []*http.Request
+	var receivedBodies []*coltracepb.ExportTraceServiceRequest
+	var responseStatus int
+	var responseBody proto.Message
+
+	BeforeEach(func() {
+		receivedRequests = nil
+		receivedBodies = nil
+		responseStatus = http.StatusOK
+		responseBody = &coltracepb.ExportTraceServiceResponse{}
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer GinkgoRecover()
+
+			body, err := io.ReadAll(req.Body)
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded := &coltracepb.ExportTraceServiceRequest{}
+			Expect(proto.Unmarshal(body, decoded)).To(Succeed())
+
+			receivedRequests = append(receivedRequests, req)
+			receivedBodies = append(receivedBodies, decoded)
+
+			responseBytes, err := proto.Marshal(responseBody)
+			Expect(err).ToNot(HaveOccurred())
+
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			w.WriteHeader(responseStatus)
+			_, _ = w.Write(responseBytes)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	resourceSpans := []*tracepb.ResourceSpans{
+		{ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "span"}}}}},
+	}
+
+	Context("when the endpoint accepts the request", func() {
+		var err error
+
+		BeforeEach(func() {
+			exporter := otlp.NewExporter(server.URL+"/v1/traces", map[string]string{"X-Api-Key": "secret"})
+			err = exporter.Export(context.Background(), resourceSpans)
+		})
+
+		It("does not return an error", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("sends the spans to the endpoint as protobuf", func() {
+			Expect(receivedRequests).To(HaveLen(1))
+			Expect(receivedRequests[0].Method).To(Equal(http.MethodPost))
+			Expect(receivedRequests[0].URL.Path).To(Equal("/v1/traces"))
+			Expect(receivedRequests[0].Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+			Expect(proto.Equal(receivedBodies[0], &coltracepb.ExportTraceServiceRequest{ResourceSpans: resourceSpans})).To(BeTrue())
+		})
+
+		It("sends the configured headers", func() {
+			Expect(receivedRequests[0].Header.Get("X-Api-Key")).To(Equal("secret"))
+		})
+	})
+
+	Context("when the endpoint rejects some of the spans", func() {
+		It("returns an error", func() {
+			responseBody = &coltracepb.ExportTraceServiceResponse{
+				PartialSuccess: &coltracepb.ExportTracePartialSuccess{RejectedSpans: 1, ErrorMessage: "span is too old"},
+			}
+
+			err := otlp.NewExporter(server.URL, nil).Export(context.Background(), resourceSpans)
+			Expect(err).To(MatchError(otlp.ErrSpansRejected))
+			Expect(err).To(MatchError(ContainSubstring("1 span(s) rejected: span is too old")))
+		})
+	})
+
+	Context("when the endpoint returns an error", func() {
+		It("returns an error", func() {
+			responseStatus = http.StatusServiceUnavailable
+
+			err := otlp.NewExporter(server.URL, nil).Export(context.Background(), resourceSpans)
+			Expect(err).To(MatchError(ContainSubstring("endpoint returned HTTP 503")))
+		})
+	})
+})
@@ -0,0 +1,242 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+const rootSpanName = "session"
+const instrumentationScopeName = "github.com/batect/abacus/server/otlp"
+
+// TracesFromSession converts a session into a single trace.
+//
+// The trace has a root span covering the whole session, with a descendant span for each of the session's spans:
+// spans with a parent span ID are children of that span, and all others are children of the root span.
+// Events associated with a span are recorded on that span, and all other events are recorded on the root span.
+// Session attributes become resource attributes, alongside the session's ID, user ID, application ID, version and
+// parent session ID and consent (using the attribute names listed above).
+//
+// The trace ID is the session's trace ID if it has one, so that all of the sessions in a workflow form a single trace,
+// and otherwise is derived from the session ID. If the session has both a trace ID and a parent session ID, the root span
+// is a child of the parent session's root span. The IDs of root spans and spans that don't have an ID are derived from the
+// session ID, so exporting the same session more than once produces the same trace.
+func TracesFromSession(session *types.Session) (*tracepb.ResourceSpans, error) {
+	sessionID, err := uuidBytes("session ID", session.SessionID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	traceID := sessionID
+
+	if session.TraceID != "" {
+		if traceID, err = uuidBytes("trace ID", session.TraceID); err != nil {
+			return nil, err
+		}
+	}
+
+	resourceAttributes := []*commonpb.KeyValue{
+		stringKeyValue(ServiceNameAttribute, session.ApplicationID),
+		stringKeyValue(ServiceVersionAttribute, session.ApplicationVersion),
+		stringKeyValue(SessionIDAttribute, session.SessionID),
+		stringKeyValue(UserIDAttribute, session.UserID),
+	}
+
+	if session.ParentSessionID != "" {
+		resourceAttributes = append(resourceAttributes, stringKeyValue(ParentSessionIDAttribute, session.ParentSessionID))
+	}
+
+	if session.Consent != nil {
+		resourceAttributes = append(
+			resourceAttributes,
+			stringKeyValue(ConsentPolicyVersionAttribute, session.Consent.PolicyVersion),
+			stringKeyValue(ConsentTimeAttribute, session.Consent.Time.Format(time.RFC3339Nano)),
+		)
+	}
+
+	resourceAttributes = append(resourceAttributes, keyValues(session.Attributes)...)
+	rootSpanID := spanID(sessionID, 0)
+
+	rootSpan := &tracepb.Span{
+		TraceId:           traceID,
+		SpanId:            rootSpanID,
+		Name:              rootSpanName,
+		Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+		StartTimeUnixNano: unixNano(session.SessionStartTime),
+		EndTimeUnixNano:   unixNano(session.SessionEndTime),
+		Events:            []*tracepb.Span_Event{},
+	}
+
+	if session.ParentSessionID != "" && session.TraceID != "" {
+		parentSessionID, err := uuidBytes("parent session ID", session.ParentSessionID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rootSpan.ParentSpanId = spanID(parentSessionID, 0)
+	}
+
+	spans := make([]*tracepb.Span, 0, len(session.Spans)+1)
+	spans = append(spans, rootSpan)
+	spansByID := map[string]*tracepb.Span{}
+
+	for i, s := range session.Spans {
+		span := &tracepb.Span{
+			TraceId:           traceID,
+			SpanId:            spanID(sessionID, i+1),
+			ParentSpanId:      rootSpanID,
+			Name:              s.Type,
+			Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+			StartTimeUnixNano: unixNano(s.StartTime),
+			EndTimeUnixNano:   unixNano(s.EndTime),
+			Attributes:        keyValues(s.Attributes),
+		}
+
+		if s.ID != "" {
+			if span.SpanId, err = hex.DecodeString(s.ID); err != nil {
+				return nil, fmt.Errorf("span ID '%v' is not valid: %w", s.ID, err)
+			}
+
+			spansByID[s.ID] = span
+		}
+
+		if s.ParentID != "" {
+			if span.ParentSpanId, err = hex.DecodeString(s.ParentID); err != nil {
+				return nil, fmt.Errorf("parent span ID '%v' is not valid: %w", s.ParentID, err)
+			}
+		}
+
+		spans = append(spans, span)
+	}
+
+	for _, e := range session.Events {
+		span, ok := spansByID[e.SpanID]
+
+		if !ok {
+			span = rootSpan
+		}
+
+		span.Events = append(span.Events, &tracepb.Span_Event{
+			Name:         e.Type,
+			TimeUnixNano: unixNano(e.Time),
+			Attributes:   keyValues(e.Attributes),
+		})
+	}
+
+	return &tracepb.ResourceSpans{
+		Resource: &resourcepb.Resource{Attributes: resourceAttributes},
+		ScopeSpans: []*tracepb.ScopeSpans{
+			{
+				Scope: &commonpb.InstrumentationScope{Name: instrumentationScopeName},
+				Spans: spans,
+			},
+		},
+	}, nil
+}
+
+// Session IDs are UUIDs, which are conveniently the same size as trace IDs.
+func uuidBytes(description string, value string) ([]byte, error) {
+	id, err := uuid.Parse(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("%v '%v' is not a valid UUID: %w", description, value, err)
+	}
+
+	return id[:], nil
+}
+
+func spanID(sessionID []byte, index int) []byte {
+	indexBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(indexBytes, uint64(index))
+
+	hash := sha256.Sum256(append(append([]byte(nil), sessionID...), indexBytes...))
+
+	return hash[:8]
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return uint64(t.UnixNano())
+}
+
+func stringKeyValue(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// keyValues converts session attributes into OpenTelemetry attributes, sorted by name so that the output is stable.
+func keyValues(attributes map[string]interface{}) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attributes))
+
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+
+	for _, key := range keys {
+		kvs = append(kvs, &commonpb.KeyValue{Key: key, Value: anyValue(attributes[key])})
+	}
+
+	return kvs
+}
+
+func anyValue(value interface{}) *commonpb.AnyValue {
+	switch v := value.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+		}
+
+		if f, err := v.Float64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+		}
+
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.String()}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case nil:
+		return &commonpb.AnyValue{}
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/batect/abacus/server/otlp"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Converting sessions to traces", func() {
+	startTime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	endTime := startTime.Add(time.Minute)
+
+	session := &types.Session{
+		SessionID:          "11112222-3333-4444-a555-666677778888",
+		UserID:             "99990000-3333-4444-a555-666677778888",
+		SessionStartTime:   startTime,
+		SessionEndTime:     endTime,
+		IngestionTime:      endTime.Add(time.Hour),
+		ApplicationID:      "test-app",
+		ApplicationVersion: "1.2.3",
+		Attributes: map[string]interface{}{
+			"osType":    "linux",
+			"cpuCount":  json.Number("8"),
+			"ratio":     json.Number("0.5"),
+			"isCI":      false,
+			"something": nil,
+		},
+		Events: []types.Event{
+			{Type: "warningShown", Time: startTime.Add(time.Second), Attributes: map[string]interface{}{"kind": "deprecated"}},
+		},
+		Spans: []types.Span{
+			{Type: "download", StartTime: startTime.Add(time.Second), EndTime: startTime.Add(2 * time.Second), Attributes: map[string]interface{}{}},
+			{Type: "build", StartTime: startTime.Add(2 * time.Second), EndTime: startTime.Add(50 * time.Second), Attributes: map[string]interface{}{"cacheHit": true}},
+		},
+	}
+
+	var resourceSpans *tracepb.ResourceSpans
+	var spans []*tracepb.Span
+
+	BeforeEach(func() {
+		var err error
+		resourceSpans, err = otlp.TracesFromSession(session)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(resourceSpans.GetScopeSpans()).To(HaveLen(1))
+		spans = resourceSpans.GetScopeSpans()[0].GetSpans()
+	})
+
+	It("uses the session's details and attributes as resource attributes", func() {
+		Expect(resourceSpans.GetResource().GetAttributes()).To(Equal([]*commonpb.KeyValue{
+			{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "test-app"}}},
+			{Key: "service.version", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "1.2.3"}}},
+			{Key: "session.id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "11112222-3333-4444-a555-666677778888"}}},
+			{Key: "enduser.id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "99990000-3333-4444-a555-666677778888"}}},
+			{Key: "cpuCount", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 8}}},
+			{Key: "isCI", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: false}}},
+			{Key: "osType", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "linux"}}},
+			{Key: "ratio", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 0.5}}},
+			{Key: "something", Value: &commonpb.AnyValue{}},
+		}))
+	})
+
+	It("returns a root span covering the whole session and a child span for each of the session's spans", func() {
+		Expect(spans).To(HaveLen(3))
+
+		Expect(spans[0].GetName()).To(Equal("session"))
+		Expect(spans[0].GetStartTimeUnixNano()).To(Equal(uint64(startTime.UnixNano())))
+		Expect(spans[0].GetEndTimeUnixNano()).To(Equal(uint64(endTime.UnixNano())))
+		Expect(spans[0].GetParentSpanId()).To(BeEmpty())
+
+		Expect(spans[1].GetName()).To(Equal("download"))
+		Expect(spans[1].GetStartTimeUnixNano()).To(Equal(uint64(startTime.Add(time.Second).UnixNano())))
+		Expect(spans[1].GetEndTimeUnixNano()).To(Equal(uint64(startTime.Add(2 * time.Second).UnixNano())))
+		Expect(spans[1].GetParentSpanId()).To(Equal(spans[0].GetSpanId()))
+
+		Expect(spans[2].GetName()).To(Equal("build"))
+		Expect(spans[2].GetParentSpanId()).To(Equal(spans[0].GetSpanId()))
+		Expect(spans[2].GetAttributes()).To(Equal([]*commonpb.KeyValue{
+			{Key: "cacheHit", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+		}))
+	})
+
+	It("uses the session ID as the trace ID for all spans", func() {
+		for _, span := range spans {
+			Expect(span.GetTraceId()).To(Equal([]byte{0x11, 0x11, 0x22, 0x22, 0x33, 0x33, 0x44, 0x44, 0xa5, 0x55, 0x66, 0x66, 0x77, 0x77, 0x88, 0x88}))
+		}
+	})
+
+	It("gives each span a different ID", func() {
+		Expect(spans[0].GetSpanId()).To(HaveLen(8))
+		Expect(spans[0].GetSpanId()).ToNot(Equal(spans[1].GetSpanId()))
+		Expect(spans[1].GetSpanId()).ToNot(Equal(spans[2].GetSpanId()))
+	})
+
+	It("records the session's events on the root span", func() {
+		Expect(spans[0].GetEvents()).To(Equal([]*tracepb.Span_Event{
+			{
+				Name:         "warningShown",
+				TimeUnixNano: uint64(startTime.Add(time.Second).UnixNano()),
+				Attributes: []*commonpb.KeyValue{
+					{Key: "kind", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "deprecated"}}},
+				},
+			},
+		}))
+	})
+
+	It("produces the same trace each time the session is converted", func() {
+		again, err := otlp.TracesFromSession(session)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(proto.Equal(again, resourceSpans)).To(BeTrue())
+	})
+
+	Context("given a session with nested spans and events associated with spans", func() {
+		BeforeEach(func() {
+			var err error
+			resourceSpans, err = otlp.TracesFromSession(&types.Session{
+				SessionID:        "11112222-3333-4444-a555-666677778888",
+				SessionStartTime: startTime,
+				SessionEndTime:   endTime,
+				Events: []types.Event{
+					{Type: "retried", Time: startTime.Add(3 * time.Second), SpanID: "0000000000000002"},
+					{Type: "warningShown", Time: startTime.Add(4 * time.Second)},
+				},
+				Spans: []types.Span{
+					{ID: "0000000000000001", Type: "run", StartTime: startTime.Add(time.Second), EndTime: startTime.Add(10 * time.Second)},
+					{ID: "0000000000000002", ParentID: "0000000000000001", Type: "pull", StartTime: startTime.Add(2 * time.Second), EndTime: startTime.Add(5 * time.Second)},
+					{Type: "build", StartTime: startTime.Add(10 * time.Second), EndTime: startTime.Add(20 * time.Second)},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			spans = resourceSpans.GetScopeSpans()[0].GetSpans()
+		})
+
+		It("uses the IDs of spans that have them", func() {
+			Expect(spans).To(HaveLen(4))
+			Expect(spans[1].GetSpanId()).To(Equal([]byte{0, 0, 0, 0, 0, 0, 0, 1}))
+			Expect(spans[2].GetSpanId()).To(Equal([]byte{0, 0, 0, 0, 0, 0, 0, 2}))
+		})
+
+		It("makes spans with a parent span ID children of that span", func() {
+			Expect(spans[2].GetParentSpanId()).To(Equal(spans[1].GetSpanId()))
+		})
+
+		It("makes spans without a parent span ID children of the root span", func() {
+			Expect(spans[1].GetParentSpanId()).To(Equal(spans[0].GetSpanId()))
+			Expect(spans[3].GetParentSpanId()).To(Equal(spans[0].GetSpanId()))
+		})
+
+		It("records events associated with a span on that span, and all other events on the root span", func() {
+			Expect(spans[2].GetEvents()).To(HaveLen(1))
+			Expect(spans[2].GetEvents()[0].GetName()).To(Equal("retried"))
+
+			Expect(spans[0].GetEvents()).To(HaveLen(1))
+			Expect(spans[0].GetEvents()[0].GetName()).To(Equal("warningShown"))
+		})
+	})
+
+	Context("given a session with a trace ID and a parent session", func() {
+		BeforeEach(func() {
+			var err error
+			resourceSpans, err = otlp.TracesFromSession(&types.Session{
+				SessionID:        "11112222-3333-4444-a555-666677778888",
+				ParentSessionID:  "aaaa2222-3333-4444-a555-666677778888",
+				TraceID:          "bbbb2222-3333-4444-a555-666677778888",
+				SessionStartTime: startTime,
+				SessionEndTime:   endTime,
+				Spans: []types.Span{
+					{Type: "build", StartTime: startTime.Add(time.Second), EndTime: startTime.Add(2 * time.Second)},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			spans = resourceSpans.GetScopeSpans()[0].GetSpans()
+		})
+
+		It("uses the trace ID for all spans", func() {
+			for _, span := range spans {
+				Expect(span.GetTraceId()).To(Equal([]byte{0xbb, 0xbb, 0x22, 0x22, 0x33, 0x33, 0x44, 0x44, 0xa5, 0x55, 0x66, 0x66, 0x77, 0x77, 0x88, 0x88}))
+			}
+		})
+
+		It("makes the root span a child of the parent session's root span", func() {
+			parent, err := otlp.TracesFromSession(&types.Session{
+				SessionID: "aaaa2222-3333-4444-a555-666677778888",
+				TraceID:   "bbbb2222-3333-4444-a555-666677778888",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(spans[0].GetParentSpanId()).To(Equal(parent.GetScopeSpans()[0].GetSpans()[0].GetSpanId()))
+		})
+
+		It("includes the parent session ID in the resource attributes", func() {
+			Expect(resourceSpans.GetResource().GetAttributes()).To(ContainElement(
+				&commonpb.KeyValue{Key: "session.parent_id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "aaaa2222-3333-4444-a555-666677778888"}}},
+			))
+		})
+	})
+
+	Context("given a session with consent", func() {
+		It("includes the consent in the resource attributes", func() {
+			resourceSpans, err := otlp.TracesFromSession(&types.Session{
+				SessionID: "11112222-3333-4444-a555-666677778888",
+				Consent:   &types.Consent{PolicyVersion: "2023-06", Time: time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(resourceSpans.GetResource().GetAttributes()).To(ContainElements(
+				&commonpb.KeyValue{Key: "session.consent.policy_version", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "2023-06"}}},
+				&commonpb.KeyValue{Key: "session.consent.time", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "2019-01-02T03:04:05.678Z"}}},
+			))
+		})
+	})
+
+	Context("given a session with an invalid session ID", func() {
+		It("returns an error", func() {
+			_, err := otlp.TracesFromSession(&types.Session{SessionID: "abc123"})
+			Expect(err).To(MatchError(ContainSubstring("session ID 'abc123' is not a valid UUID")))
+		})
+	})
+})
@@ -0,0 +1,259 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// Resource attributes that populate the top-level fields of a session rather than its attributes.
+const (
+	ServiceNameAttribute     = "service.name"
+	ServiceVersionAttribute  = "service.version"
+	SessionIDAttribute       = "session.id"
+	UserIDAttribute          = "enduser.id"
+	ParentSessionIDAttribute = "session.parent_id"
+
+	// ConsentPolicyVersionAttribute and ConsentTimeAttribute populate the session's consent. The time is in RFC 3339 format.
+	ConsentPolicyVersionAttribute = "session.consent.policy_version"
+	ConsentTimeAttribute          = "session.consent.time"
+)
+
+// SessionsFromTraces converts each resource in resourceSpans into a session.
+//
+// Resource attributes become session attributes (apart from those listed above, which populate the session's
+// ID, user ID, application ID, version, parent session ID and consent), spans become session spans and span events become session events.
+// Span IDs are preserved, as are parent span IDs that refer to another span in the same resource, and each event
+// is associated with the span it was recorded on. The trace ID of the resource's spans becomes the session's trace ID,
+// with its UUID version and variant bits set to make it a version 4 UUID, unless it is the same as the session ID, which
+// is the trace ID TracesFromSession uses for sessions without one.
+// The session's start and end times are taken from the earliest span start and latest span end.
+// Attribute names are converted from OpenTelemetry's dotted form (eg. 'os.type') to camel case (eg. 'osType').
+//
+// Resources without any spans are ignored. The returned sessions have not been validated.
+func SessionsFromTraces(resourceSpans []*tracepb.ResourceSpans) []types.Session {
+	sessions := make([]types.Session, 0, len(resourceSpans))
+
+	for _, rs := range resourceSpans {
+		if session, ok := sessionFromResourceSpans(rs); ok {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions
+}
+
+func sessionFromResourceSpans(rs *tracepb.ResourceSpans) (types.Session, bool) {
+	session := types.Session{
+		Attributes: map[string]interface{}{},
+		Events:     []types.Event{},
+		Spans:      []types.Span{},
+	}
+
+	for _, kv := range rs.GetResource().GetAttributes() {
+		switch kv.GetKey() {
+		case ServiceNameAttribute:
+			session.ApplicationID = kv.GetValue().GetStringValue()
+		case ServiceVersionAttribute:
+			session.ApplicationVersion = kv.GetValue().GetStringValue()
+		case SessionIDAttribute:
+			session.SessionID = kv.GetValue().GetStringValue()
+		case UserIDAttribute:
+			session.UserID = kv.GetValue().GetStringValue()
+		case ParentSessionIDAttribute:
+			session.ParentSessionID = kv.GetValue().GetStringValue()
+		case ConsentPolicyVersionAttribute:
+			consent(&session).PolicyVersion = kv.GetValue().GetStringValue()
+		case ConsentTimeAttribute:
+			// Invalid times are left as the zero time, so that validation reports them as missing.
+			consent(&session).Time, _ = time.Parse(time.RFC3339Nano, kv.GetValue().GetStringValue())
+		default:
+			session.Attributes[AttributeName(kv.GetKey())] = attributeValue(kv.GetValue())
+		}
+	}
+
+	spanIDs := map[string]bool{}
+
+	for _, ss := range rs.GetScopeSpans() {
+		for _, s := range ss.GetSpans() {
+			if len(s.GetSpanId()) > 0 {
+				spanIDs[hex.EncodeToString(s.GetSpanId())] = true
+			}
+
+			if session.TraceID == "" {
+				session.TraceID = traceID(s.GetTraceId())
+			}
+		}
+	}
+
+	if strings.EqualFold(session.TraceID, session.SessionID) {
+		session.TraceID = ""
+	}
+
+	for _, ss := range rs.GetScopeSpans() {
+		for _, s := range ss.GetSpans() {
+			span := types.Span{
+				ID:         hex.EncodeToString(s.GetSpanId()),
+				Type:       s.GetName(),
+				StartTime:  timeFromUnixNano(s.GetStartTimeUnixNano()),
+				EndTime:    timeFromUnixNano(s.GetEndTimeUnixNano()),
+				Attributes: attributes(s.GetAttributes()),
+			}
+
+			// Parents in other resources (such as a span from the process that started this one) can't be referred to from a session.
+			if parentID := hex.EncodeToString(s.GetParentSpanId()); spanIDs[parentID] {
+				span.ParentID = parentID
+			}
+
+			if len(session.Spans) == 0 || span.StartTime.Before(session.SessionStartTime) {
+				session.SessionStartTime = span.StartTime
+			}
+
+			if len(session.Spans) == 0 || span.EndTime.After(session.SessionEndTime) {
+				session.SessionEndTime = span.EndTime
+			}
+
+			session.Spans = append(session.Spans, span)
+
+			for _, e := range s.GetEvents() {
+				session.Events = append(session.Events, types.Event{
+					Type:       e.GetName(),
+					Time:       timeFromUnixNano(e.GetTimeUnixNano()),
+					SpanID:     span.ID,
+					Attributes: attributes(e.GetAttributes()),
+				})
+			}
+		}
+	}
+
+	return session, len(session.Spans) > 0
+}
+
+// AttributeName converts an OpenTelemetry attribute name like 'process.runtime.name' or 'http.status_code'
+// into the camel case form used by session attributes ('processRuntimeName' or 'httpStatusCode').
+func AttributeName(key string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return r == '.' || r == '_' || r == '-'
+	})
+
+	var builder strings.Builder
+
+	for i, word := range words {
+		if i == 0 {
+			builder.WriteString(word)
+			continue
+		}
+
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		builder.WriteString(string(runes))
+	}
+
+	return builder.String()
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(kvs))
+
+	for _, kv := range kvs {
+		attrs[AttributeName(kv.GetKey())] = attributeValue(kv.GetValue())
+	}
+
+	return attrs
+}
+
+// attributeValue converts an OpenTelemetry attribute value into the same representation that decoding a JSON session would produce.
+// Values that sessions can't represent (arrays, key/value lists, bytes and non-finite numbers) are converted as-is,
+// and are then rejected by validation.
+func attributeValue(v *commonpb.AnyValue) interface{} {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return json.Number(strconv.FormatInt(value.IntValue, 10))
+	case *commonpb.AnyValue_DoubleValue:
+		if math.IsInf(value.DoubleValue, 0) || math.IsNaN(value.DoubleValue) {
+			return value.DoubleValue
+		}
+
+		return json.Number(strconv.FormatFloat(value.DoubleValue, 'g', -1, 64))
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]interface{}, 0, len(value.ArrayValue.GetValues()))
+
+		for _, element := range value.ArrayValue.GetValues() {
+			values = append(values, attributeValue(element))
+		}
+
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		return attributes(value.KvlistValue.GetValues())
+	case *commonpb.AnyValue_BytesValue:
+		return value.BytesValue
+	default:
+		return nil
+	}
+}
+
+// traceID converts an OpenTelemetry trace ID into a session trace ID, or returns an empty string if id is not a valid
+// trace ID. Session trace IDs are version 4 UUIDs, so the UUID version and variant bits are overwritten: this leaves
+// trace IDs exported by TracesFromSession unchanged, while still giving every session in a trace the same trace ID.
+func traceID(id []byte) string {
+	var u uuid.UUID
+
+	if len(id) != len(u) || bytes.Equal(id, u[:]) {
+		return ""
+	}
+
+	copy(u[:], id)
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	return u.String()
+}
+
+func timeFromUnixNano(nanos uint64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, int64(nanos)).UTC()
+}
+
+func consent(session *types.Session) *types.Consent {
+	if session.Consent == nil {
+		session.Consent = &types.Consent{}
+	}
+
+	return session.Consent
+}
@@ -0,0 +1,359 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package otlp_test
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/batect/abacus/server/otlp"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+var _ = Describe("Converting traces to sessions", func() {
+	stringAttribute := func(key string, value string) *commonpb.KeyValue {
+		return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+	}
+
+	baseTime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	nanos := func(offset time.Duration) uint64 {
+		return uint64(baseTime.Add(offset).UnixNano())
+	}
+
+	Context("given a resource with spans and span events", func() {
+		var sessions []types.Session
+
+		BeforeEach(func() {
+			sessions = otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{
+							stringAttribute("service.name", "test-app"),
+							stringAttribute("service.version", "1.2.3"),
+							stringAttribute("session.id", "11112222-3333-4444-a555-666677778888"),
+							stringAttribute("enduser.id", "99990000-3333-4444-a555-666677778888"),
+							stringAttribute("os.type", "linux"),
+							{Key: "process.pid", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 1234}}},
+						},
+					},
+					ScopeSpans: []*tracepb.ScopeSpans{
+						{
+							Spans: []*tracepb.Span{
+								{
+									Name:              "build",
+									StartTimeUnixNano: nanos(2 * time.Second),
+									EndTimeUnixNano:   nanos(10 * time.Second),
+									Attributes: []*commonpb.KeyValue{
+										{Key: "cache_hit", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}}},
+									},
+									Events: []*tracepb.Span_Event{
+										{
+											Name:         "warning_shown",
+											TimeUnixNano: nanos(3 * time.Second),
+											Attributes:   []*commonpb.KeyValue{stringAttribute("warning.kind", "deprecated")},
+										},
+									},
+								},
+							},
+						},
+						{
+							Spans: []*tracepb.Span{
+								{
+									Name:              "download",
+									StartTimeUnixNano: nanos(time.Second),
+									EndTimeUnixNano:   nanos(4 * time.Second),
+									Attributes: []*commonpb.KeyValue{
+										{Key: "ratio", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 0.5}}},
+									},
+								},
+							},
+						},
+					},
+				},
+			})
+		})
+
+		It("returns a single session with the details from the resource and its spans", func() {
+			Expect(sessions).To(ConsistOf(types.Session{
+				SessionID:          "11112222-3333-4444-a555-666677778888",
+				UserID:             "99990000-3333-4444-a555-666677778888",
+				SessionStartTime:   baseTime.Add(time.Second),
+				SessionEndTime:     baseTime.Add(10 * time.Second),
+				ApplicationID:      "test-app",
+				ApplicationVersion: "1.2.3",
+				Attributes: map[string]interface{}{
+					"osType":     "linux",
+					"processPid": json.Number("1234"),
+				},
+				Events: []types.Event{
+					{Type: "warning_shown", Time: baseTime.Add(3 * time.Second), Attributes: map[string]interface{}{"warningKind": "deprecated"}},
+				},
+				Spans: []types.Span{
+					{
+						Type:       "build",
+						StartTime:  baseTime.Add(2 * time.Second),
+						EndTime:    baseTime.Add(10 * time.Second),
+						Attributes: map[string]interface{}{"cacheHit": true},
+					},
+					{
+						Type:       "download",
+						StartTime:  baseTime.Add(time.Second),
+						EndTime:    baseTime.Add(4 * time.Second),
+						Attributes: map[string]interface{}{"ratio": json.Number("0.5")},
+					},
+				},
+			}))
+		})
+	})
+
+	Context("given a resource with spans that have IDs and parent span IDs", func() {
+		var sessions []types.Session
+
+		BeforeEach(func() {
+			sessions = otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{
+					ScopeSpans: []*tracepb.ScopeSpans{
+						{
+							Spans: []*tracepb.Span{
+								{
+									Name:         "run",
+									SpanId:       []byte{0, 0, 0, 0, 0, 0, 0, 1},
+									ParentSpanId: []byte{0, 0, 0, 0, 0, 0, 0, 9},
+								},
+								{
+									Name:         "pull",
+									SpanId:       []byte{0, 0, 0, 0, 0, 0, 0, 2},
+									ParentSpanId: []byte{0, 0, 0, 0, 0, 0, 0, 1},
+									Events:       []*tracepb.Span_Event{{Name: "retried"}},
+								},
+							},
+						},
+					},
+				},
+			})
+		})
+
+		It("preserves the span IDs", func() {
+			Expect(sessions).To(HaveLen(1))
+			Expect(sessions[0].Spans[0].ID).To(Equal("0000000000000001"))
+			Expect(sessions[0].Spans[1].ID).To(Equal("0000000000000002"))
+		})
+
+		It("preserves parent span IDs that refer to spans in the same resource", func() {
+			Expect(sessions[0].Spans[1].ParentID).To(Equal("0000000000000001"))
+		})
+
+		It("drops parent span IDs that refer to spans outside the resource", func() {
+			Expect(sessions[0].Spans[0].ParentID).To(BeEmpty())
+		})
+
+		It("associates each event with the span it was recorded on", func() {
+			Expect(sessions[0].Events).To(HaveLen(1))
+			Expect(sessions[0].Events[0].SpanID).To(Equal("0000000000000002"))
+		})
+	})
+
+	Context("given a resource with spans that have a trace ID", func() {
+		It("uses it as the session's trace ID, as a version 4 UUID", func() {
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{stringAttribute("session.id", "11112222-3333-4444-a555-666677778888")},
+					},
+					ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{
+						{Name: "build", TraceId: []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}},
+					}}},
+				},
+			})
+
+			Expect(sessions).To(HaveLen(1))
+			Expect(sessions[0].TraceID).To(Equal("5b8efff7-9803-4103-9269-b633813fc60c"))
+		})
+	})
+
+	Context("given a resource with spans that have no trace ID", func() {
+		It("does not set the session's trace ID", func() {
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "build"}}}}},
+			})
+
+			Expect(sessions).To(HaveLen(1))
+			Expect(sessions[0].TraceID).To(BeEmpty())
+		})
+	})
+
+	Context("given a trace converted from a session", func() {
+		roundTrip := func(session *types.Session) types.Session {
+			resourceSpans, err := otlp.TracesFromSession(session)
+			Expect(err).ToNot(HaveOccurred())
+
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{resourceSpans})
+			Expect(sessions).To(HaveLen(1))
+
+			return sessions[0]
+		}
+
+		session := func(traceID string) *types.Session {
+			return &types.Session{
+				SessionID:          "11112222-3333-4444-a555-666677778888",
+				UserID:             "99990000-3333-4444-a555-666677778888",
+				TraceID:            traceID,
+				SessionStartTime:   baseTime,
+				SessionEndTime:     baseTime.Add(time.Minute),
+				ApplicationID:      "test-app",
+				ApplicationVersion: "1.2.3",
+			}
+		}
+
+		It("preserves the session's trace ID", func() {
+			Expect(roundTrip(session("bbbb2222-3333-4444-a555-666677778888")).TraceID).To(Equal("bbbb2222-3333-4444-a555-666677778888"))
+		})
+
+		It("does not add a trace ID to a session that did not have one", func() {
+			Expect(roundTrip(session("")).TraceID).To(BeEmpty())
+		})
+	})
+
+	Context("given a resource with a parent session ID", func() {
+		It("uses it as the session's parent session ID", func() {
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{stringAttribute("session.parent_id", "aaaa2222-3333-4444-a555-666677778888")},
+					},
+					ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "build"}}}},
+				},
+			})
+
+			Expect(sessions).To(HaveLen(1))
+			Expect(sessions[0].ParentSessionID).To(Equal("aaaa2222-3333-4444-a555-666677778888"))
+			Expect(sessions[0].Attributes).To(BeEmpty())
+		})
+	})
+
+	Context("given a resource with consent", func() {
+		It("uses it as the session's consent", func() {
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{
+							stringAttribute("session.consent.policy_version", "2023-06"),
+							stringAttribute("session.consent.time", "2019-01-02T03:04:05.678Z"),
+						},
+					},
+					ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{Name: "build"}}}},
+				},
+			})
+
+			Expect(sessions).To(HaveLen(1))
+			Expect(sessions[0].Consent).To(Equal(&types.Consent{PolicyVersion: "2023-06", Time: time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC)}))
+			Expect(sessions[0].Attributes).To(BeEmpty())
+		})
+	})
+
+	Context("given multiple resources", func() {
+		var sessions []types.Session
+
+		BeforeEach(func() {
+			resourceWithSession := func(sessionID string) *tracepb.ResourceSpans {
+				return &tracepb.ResourceSpans{
+					Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttribute("session.id", sessionID)}},
+					ScopeSpans: []*tracepb.ScopeSpans{
+						{Spans: []*tracepb.Span{{Name: "span", StartTimeUnixNano: nanos(0), EndTimeUnixNano: nanos(time.Second)}}},
+					},
+				}
+			}
+
+			sessions = otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				resourceWithSession("11112222-3333-4444-a555-666677778888"),
+				resourceWithSession("99990000-3333-4444-a555-666677778888"),
+			})
+		})
+
+		It("returns a session for each resource", func() {
+			Expect(sessions).To(HaveLen(2))
+			Expect(sessions[0].SessionID).To(Equal("11112222-3333-4444-a555-666677778888"))
+			Expect(sessions[1].SessionID).To(Equal("99990000-3333-4444-a555-666677778888"))
+		})
+	})
+
+	Context("given a resource with no spans", func() {
+		It("does not return a session for it", func() {
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{stringAttribute("session.id", "11112222-3333-4444-a555-666677778888")}}},
+			})
+
+			Expect(sessions).To(BeEmpty())
+		})
+	})
+
+	Context("given attribute values that sessions can't represent", func() {
+		var attributes map[string]interface{}
+
+		BeforeEach(func() {
+			sessions := otlp.SessionsFromTraces([]*tracepb.ResourceSpans{
+				{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{
+							{Key: "list", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{
+								Values: []*commonpb.AnyValue{{Value: &commonpb.AnyValue_StringValue{StringValue: "a"}}},
+							}}}},
+							{Key: "infinity", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: math.Inf(1)}}},
+							{Key: "empty"},
+						},
+					},
+					ScopeSpans: []*tracepb.ScopeSpans{
+						{Spans: []*tracepb.Span{{Name: "span", StartTimeUnixNano: nanos(0), EndTimeUnixNano: nanos(time.Second)}}},
+					},
+				},
+			})
+
+			Expect(sessions).To(HaveLen(1))
+			attributes = sessions[0].Attributes
+		})
+
+		It("passes them through so that they are rejected by validation", func() {
+			Expect(attributes).To(Equal(map[string]interface{}{
+				"list":     []interface{}{"a"},
+				"infinity": math.Inf(1),
+				"empty":    nil,
+			}))
+		})
+	})
+})
+
+var _ = DescribeTable("converting attribute names",
+	func(key string, expected string) {
+		Expect(otlp.AttributeName(key)).To(Equal(expected))
+	},
+	Entry("a name that is already in camel case", "operatingSystem", "operatingSystem"),
+	Entry("a dotted name", "os.type", "osType"),
+	Entry("a name with multiple dots", "process.runtime.name", "processRuntimeName"),
+	Entry("a name with underscores", "http.status_code", "httpStatusCode"),
+	Entry("a name with hyphens", "user-agent", "userAgent"),
+	Entry("a name with repeated separators", "a..b", "aB"),
+)
@@ -0,0 +1,201 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package pseudonymisation replaces the user IDs in sessions with keyed hashes before they are stored, so that stored
+// sessions can't be linked back to the user IDs clients send without the keys.
+package pseudonymisation
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MinimumSecretSize is the minimum length of a secret, in bytes.
+const MinimumSecretSize = 32
+
+// Key is the secret used to pseudonymise the user IDs of an application's sessions that were ingested during an epoch.
+// Each epoch starts at EpochStart and ends when the application's next epoch starts.
+type Key struct {
+	ApplicationID string
+	EpochStart    time.Time
+	Secret        []byte
+}
+
+// Pseudonymiser replaces user IDs with keyed hashes, using a separate series of keys for each application.
+//
+// Rotating keys on a schedule (by adding keys with later epoch starts) means the same user has a different pseudonym
+// in each epoch, which limits how long their sessions can be linked together.
+type Pseudonymiser struct {
+	keys map[string][]Key
+}
+
+// NewPseudonymiser creates a Pseudonymiser from keys. Applications without any keys keep their user IDs as-is.
+func NewPseudonymiser(keys ...Key) (*Pseudonymiser, error) {
+	p := &Pseudonymiser{keys: map[string][]Key{}}
+
+	for _, key := range keys {
+		if len(key.Secret) < MinimumSecretSize {
+			return nil, fmt.Errorf("secret for application '%v' starting %v must be at least %v bytes long, but is %v bytes long", key.ApplicationID, key.EpochStart.Format(time.RFC3339), MinimumSecretSize, len(key.Secret))
+		}
+
+		for _, existing := range p.keys[key.ApplicationID] {
+			if existing.EpochStart.Equal(key.EpochStart) {
+				return nil, fmt.Errorf("application '%v' has more than one secret starting %v", key.ApplicationID, key.EpochStart.Format(time.RFC3339))
+			}
+		}
+
+		p.keys[key.ApplicationID] = append(p.keys[key.ApplicationID], key)
+	}
+
+	for _, appKeys := range p.keys {
+		sort.Slice(appKeys, func(i, j int) bool { return appKeys[i].EpochStart.Before(appKeys[j].EpochStart) })
+	}
+
+	return p, nil
+}
+
+// LoadKeyFile creates a Pseudonymiser from a file containing one key per line, in the form
+// '<application ID> <epoch start as a RFC 3339 timestamp> <base64-encoded secret>'.
+// Blank lines and lines starting with '#' are ignored.
+func LoadKeyFile(path string) (*Pseudonymiser, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open key file: %w", err)
+	}
+
+	defer file.Close()
+
+	keys := []Key{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := parseKey(line)
+
+		if err != nil {
+			return nil, fmt.Errorf("line %v of key file is not valid: %w", lineNumber, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+
+	return NewPseudonymiser(keys...)
+}
+
+func parseKey(line string) (Key, error) {
+	fields := strings.Fields(line)
+
+	if len(fields) != 3 {
+		return Key{}, fmt.Errorf("expected 3 fields, but got %v", len(fields))
+	}
+
+	epochStart, err := time.Parse(time.RFC3339, fields[1])
+
+	if err != nil {
+		return Key{}, fmt.Errorf("epoch start is not a RFC 3339 timestamp: %w", err)
+	}
+
+	secret, err := base64.StdEncoding.DecodeString(fields[2])
+
+	if err != nil {
+		return Key{}, fmt.Errorf("secret is not valid base64: %w", err)
+	}
+
+	return Key{ApplicationID: fields[0], EpochStart: epochStart, Secret: secret}, nil
+}
+
+// Enabled returns true if the user IDs of applicationID's sessions are pseudonymised.
+func (p *Pseudonymiser) Enabled(applicationID string) bool {
+	return len(p.keys[applicationID]) > 0
+}
+
+// Pseudonymise returns the pseudonym for userID in applicationID's epoch that includes at, or userID itself if the
+// application's user IDs are not pseudonymised. Times before the application's first epoch use the first epoch's key.
+//
+// Pseudonyms are formatted as version 4 UUIDs, so pseudonymised sessions still match the session schema.
+func (p *Pseudonymiser) Pseudonymise(applicationID string, userID string, at time.Time) string {
+	appKeys := p.keys[applicationID]
+
+	if len(appKeys) == 0 {
+		return userID
+	}
+
+	key := appKeys[0]
+
+	for _, candidate := range appKeys[1:] {
+		if candidate.EpochStart.After(at) {
+			break
+		}
+
+		key = candidate
+	}
+
+	return pseudonym(key.Secret, userID)
+}
+
+// AllPseudonyms returns userID's pseudonym in every one of applicationID's epochs, or just userID if the application's
+// user IDs are not pseudonymised.
+func (p *Pseudonymiser) AllPseudonyms(applicationID string, userID string) []string {
+	appKeys := p.keys[applicationID]
+
+	if len(appKeys) == 0 {
+		return []string{userID}
+	}
+
+	pseudonyms := make([]string, 0, len(appKeys))
+
+	for _, key := range appKeys {
+		pseudonyms = append(pseudonyms, pseudonym(key.Secret, userID))
+	}
+
+	return pseudonyms
+}
+
+func pseudonym(secret []byte, userID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userID))
+
+	var id uuid.UUID
+	copy(id[:], mac.Sum(nil))
+	id[6] = (id[6] & 0x0f) | 0x40 // Version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return id.String()
+}
@@ -0,0 +1,219 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package pseudonymisation_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/batect/abacus/server/pseudonymisation"
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pseudonymising user IDs", func() {
+	firstEpoch := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	secondEpoch := time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)
+	firstSecret := bytes.Repeat([]byte{1}, 32)
+	secondSecret := bytes.Repeat([]byte{2}, 32)
+
+	var pseudonymiser *pseudonymisation.Pseudonymiser
+
+	BeforeEach(func() {
+		var err error
+
+		// Keys are deliberately given out of order.
+		pseudonymiser, err = pseudonymisation.NewPseudonymiser(
+			pseudonymisation.Key{ApplicationID: "test-app", EpochStart: secondEpoch, Secret: secondSecret},
+			pseudonymisation.Key{ApplicationID: "test-app", EpochStart: firstEpoch, Secret: firstSecret},
+		)
+
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("for an application with keys", func() {
+		It("reports that pseudonymisation is enabled", func() {
+			Expect(pseudonymiser.Enabled("test-app")).To(BeTrue())
+		})
+
+		It("replaces the user ID with a version 4 UUID", func() {
+			pseudonym := pseudonymiser.Pseudonymise("test-app", "99990000-3333-4444-a555-666677778888", firstEpoch)
+
+			Expect(pseudonym).ToNot(Equal("99990000-3333-4444-a555-666677778888"))
+
+			id, err := uuid.Parse(pseudonym)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id.Version()).To(Equal(uuid.Version(4)))
+			Expect(id.Variant()).To(Equal(uuid.RFC4122))
+		})
+
+		It("gives the same user the same pseudonym throughout an epoch", func() {
+			Expect(pseudonymiser.Pseudonymise("test-app", "user-1", firstEpoch)).To(Equal(pseudonymiser.Pseudonymise("test-app", "user-1", secondEpoch.Add(-time.Nanosecond))))
+		})
+
+		It("gives different users different pseudonyms", func() {
+			Expect(pseudonymiser.Pseudonymise("test-app", "user-1", firstEpoch)).ToNot(Equal(pseudonymiser.Pseudonymise("test-app", "user-2", firstEpoch)))
+		})
+
+		It("gives the same user a different pseudonym in each epoch", func() {
+			Expect(pseudonymiser.Pseudonymise("test-app", "user-1", firstEpoch)).ToNot(Equal(pseudonymiser.Pseudonymise("test-app", "user-1", secondEpoch)))
+		})
+
+		It("uses the first epoch's key for times before the first epoch", func() {
+			Expect(pseudonymiser.Pseudonymise("test-app", "user-1", firstEpoch.Add(-time.Hour))).To(Equal(pseudonymiser.Pseudonymise("test-app", "user-1", firstEpoch)))
+		})
+
+		It("returns the user's pseudonym in every epoch", func() {
+			Expect(pseudonymiser.AllPseudonyms("test-app", "user-1")).To(Equal([]string{
+				pseudonymiser.Pseudonymise("test-app", "user-1", firstEpoch),
+				pseudonymiser.Pseudonymise("test-app", "user-1", secondEpoch),
+			}))
+		})
+	})
+
+	Context("for an application without keys", func() {
+		It("reports that pseudonymisation is not enabled", func() {
+			Expect(pseudonymiser.Enabled("other-app")).To(BeFalse())
+		})
+
+		It("returns the user ID as-is", func() {
+			Expect(pseudonymiser.Pseudonymise("other-app", "user-1", firstEpoch)).To(Equal("user-1"))
+			Expect(pseudonymiser.AllPseudonyms("other-app", "user-1")).To(Equal([]string{"user-1"}))
+		})
+	})
+
+	Context("given a secret that is too short", func() {
+		It("returns an error", func() {
+			_, err := pseudonymisation.NewPseudonymiser(pseudonymisation.Key{ApplicationID: "test-app", EpochStart: firstEpoch, Secret: []byte{1, 2, 3}})
+			Expect(err).To(MatchError("secret for application 'test-app' starting 2019-01-01T00:00:00Z must be at least 32 bytes long, but is 3 bytes long"))
+		})
+	})
+
+	Context("given two secrets for the same epoch", func() {
+		It("returns an error", func() {
+			_, err := pseudonymisation.NewPseudonymiser(
+				pseudonymisation.Key{ApplicationID: "test-app", EpochStart: firstEpoch, Secret: firstSecret},
+				pseudonymisation.Key{ApplicationID: "test-app", EpochStart: firstEpoch, Secret: secondSecret},
+			)
+			Expect(err).To(MatchError("application 'test-app' has more than one secret starting 2019-01-01T00:00:00Z"))
+		})
+	})
+
+	Describe("loading keys from a file", func() {
+		var path string
+
+		writeKeyFile := func(content string) {
+			path = filepath.Join(GinkgoT().TempDir(), "keys")
+			Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		}
+
+		Context("given a valid file", func() {
+			BeforeEach(func() {
+				writeKeyFile("# Rotated monthly\n\n" +
+					"test-app 2019-01-01T00:00:00Z " + base64.StdEncoding.EncodeToString(firstSecret) + "\n" +
+					"test-app 2019-02-01T00:00:00Z " + base64.StdEncoding.EncodeToString(secondSecret) + "\n")
+			})
+
+			It("uses the keys from the file", func() {
+				loaded, err := pseudonymisation.LoadKeyFile(path)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(loaded.AllPseudonyms("test-app", "user-1")).To(Equal(pseudonymiser.AllPseudonyms("test-app", "user-1")))
+			})
+		})
+
+		Context("given a file with a line with the wrong number of fields", func() {
+			BeforeEach(func() {
+				writeKeyFile("test-app 2019-01-01T00:00:00Z\n")
+			})
+
+			It("returns an error", func() {
+				_, err := pseudonymisation.LoadKeyFile(path)
+				Expect(err).To(MatchError("line 1 of key file is not valid: expected 3 fields, but got 2"))
+			})
+		})
+
+		Context("given a file with an invalid epoch start", func() {
+			BeforeEach(func() {
+				writeKeyFile("test-app yesterday " + base64.StdEncoding.EncodeToString(firstSecret) + "\n")
+			})
+
+			It("returns an error", func() {
+				_, err := pseudonymisation.LoadKeyFile(path)
+				Expect(err).To(MatchError(ContainSubstring("line 1 of key file is not valid: epoch start is not a RFC 3339 timestamp")))
+			})
+		})
+
+		Context("given a file with a secret that is not valid base64", func() {
+			BeforeEach(func() {
+				writeKeyFile("test-app 2019-01-01T00:00:00Z !!!\n")
+			})
+
+			It("returns an error", func() {
+				_, err := pseudonymisation.LoadKeyFile(path)
+				Expect(err).To(MatchError(ContainSubstring("line 1 of key file is not valid: secret is not valid base64")))
+			})
+		})
+	})
+
+	Describe("storing sessions", func() {
+		var inner *recordingStore
+		var session *types.Session
+
+		BeforeEach(func() {
+			inner = &recordingStore{}
+			session = &types.Session{
+				SessionID:        "session-1",
+				ApplicationID:    "test-app",
+				UserID:           "user-1",
+				SessionStartTime: secondEpoch.Add(-time.Hour),
+				IngestionTime:    secondEpoch.Add(time.Hour),
+			}
+
+			Expect(pseudonymisation.NewSessionStore(inner, pseudonymiser).Store(context.Background(), session)).To(Succeed())
+		})
+
+		It("stores the session with its user ID pseudonymised using the key for the epoch the session was ingested in", func() {
+			Expect(inner.stored).To(HaveLen(1))
+			Expect(inner.stored[0].SessionID).To(Equal("session-1"))
+			Expect(inner.stored[0].UserID).To(Equal(pseudonymiser.Pseudonymise("test-app", "user-1", secondEpoch)))
+		})
+
+		It("does not modify the original session", func() {
+			Expect(session.UserID).To(Equal("user-1"))
+		})
+	})
+})
+
+type recordingStore struct {
+	stored []types.Session
+}
+
+func (s *recordingStore) Store(_ context.Context, session *types.Session) error {
+	s.stored = append(s.stored, *session)
+
+	return nil
+}
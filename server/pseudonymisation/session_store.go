@@ -0,0 +1,56 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package pseudonymisation
+
+import (
+	"context"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+)
+
+// SessionStore wraps another SessionStore, pseudonymising the user ID of each session before storing it.
+// The key used is chosen based on the session's ingestion time, which is assigned by the server, so that clients can't
+// choose which epoch their sessions are pseudonymised in.
+type SessionStore struct {
+	inner         storage.SessionStore
+	pseudonymiser *Pseudonymiser
+}
+
+func NewSessionStore(inner storage.SessionStore, pseudonymiser *Pseudonymiser) *SessionStore {
+	return &SessionStore{inner: inner, pseudonymiser: pseudonymiser}
+}
+
+// Store stores a copy of session with its user ID pseudonymised. session itself is not modified.
+func (s *SessionStore) Store(ctx context.Context, session *types.Session) error {
+	pseudonymised := *session
+	pseudonymised.UserID = s.pseudonymiser.Pseudonymise(session.ApplicationID, session.UserID, session.IngestionTime)
+
+	return s.inner.Store(ctx, &pseudonymised)
+}
+
+// CheckHealth checks the health of the underlying store, if it supports health checks.
+func (s *SessionStore) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.inner.(storage.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+
+	return nil
+}
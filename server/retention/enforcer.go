@@ -0,0 +1,118 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Enforcer deletes stored sessions that are older than the retention period of the application they belong to.
+//
+// A session's age is measured from the later of its start time and its ingestion time, so that a session is never
+// deleted early because of a client with an incorrect clock.
+type Enforcer struct {
+	registry   *applications.Registry
+	store      storage.SessionDeleter
+	timeSource func() time.Time
+}
+
+type Report struct {
+	Applications []ApplicationReport
+}
+
+type ApplicationReport struct {
+	ApplicationID   string
+	RetentionPeriod time.Duration
+	Cutoff          time.Time
+	SessionsScanned int
+	SessionsDeleted int
+}
+
+func NewEnforcer(registry *applications.Registry, store storage.SessionDeleter) *Enforcer {
+	return NewEnforcerWithTimeSource(registry, store, time.Now)
+}
+
+func NewEnforcerWithTimeSource(registry *applications.Registry, store storage.SessionDeleter, timeSource func() time.Time) *Enforcer {
+	return &Enforcer{
+		registry:   registry,
+		store:      store,
+		timeSource: timeSource,
+	}
+}
+
+func (e *Enforcer) Enforce(ctx context.Context) (Report, error) {
+	report := Report{}
+	now := e.timeSource()
+
+	for _, app := range e.registry.All() {
+		if app.RetentionPeriod == 0 {
+			logrus.WithField("applicationId", app.ID).Info("Application has no retention period, skipping.")
+			continue
+		}
+
+		cutoff := now.Add(-app.RetentionPeriod)
+		result, err := e.store.DeleteSessions(ctx, app.ID, func(session *types.Session) bool {
+			return isExpired(session, cutoff)
+		})
+
+		appReport := ApplicationReport{
+			ApplicationID:   app.ID,
+			RetentionPeriod: app.RetentionPeriod,
+			Cutoff:          cutoff,
+			SessionsScanned: result.SessionsScanned,
+			SessionsDeleted: result.SessionsDeleted,
+		}
+
+		report.Applications = append(report.Applications, appReport)
+
+		if err != nil {
+			return report, fmt.Errorf("could not enforce retention policy for application %v: %w", app.ID, err)
+		}
+	}
+
+	return report, nil
+}
+
+func (r Report) TotalSessionsDeleted() int {
+	total := 0
+
+	for _, app := range r.Applications {
+		total += app.SessionsDeleted
+	}
+
+	return total
+}
+
+func isExpired(session *types.Session, cutoff time.Time) bool {
+	age := session.SessionStartTime
+
+	if session.IngestionTime.After(age) {
+		age = session.IngestionTime
+	}
+
+	return age.Before(cutoff)
+}
@@ -0,0 +1,188 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package retention_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/retention"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Enforcing retention policies", func() {
+	currentTime := time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+	timeSource := func() time.Time { return currentTime }
+
+	var store *fakeStore
+	var enforcer *retention.Enforcer
+
+	BeforeEach(func() {
+		registry := applications.NewRegistry(
+			applications.Application{ID: "short-lived-app", RetentionPeriod: 7 * 24 * time.Hour},
+			applications.Application{ID: "forever-app"},
+		)
+
+		store = &fakeStore{sessions: []types.Session{
+			{
+				SessionID:        "old-session",
+				ApplicationID:    "short-lived-app",
+				SessionStartTime: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				IngestionTime:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+			},
+			{
+				SessionID:        "recent-session",
+				ApplicationID:    "short-lived-app",
+				SessionStartTime: time.Date(2020, 5, 23, 0, 0, 0, 0, time.UTC),
+				IngestionTime:    time.Date(2020, 5, 23, 1, 0, 0, 0, time.UTC),
+			},
+			{
+				SessionID:        "old-session-ingested-recently",
+				ApplicationID:    "short-lived-app",
+				SessionStartTime: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+				IngestionTime:    time.Date(2020, 5, 23, 1, 0, 0, 0, time.UTC),
+			},
+			{
+				SessionID:        "session-from-the-future-ingested-long-ago",
+				ApplicationID:    "short-lived-app",
+				SessionStartTime: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+				IngestionTime:    time.Date(2020, 5, 1, 1, 0, 0, 0, time.UTC),
+			},
+			{
+				SessionID:        "old-session-for-other-app",
+				ApplicationID:    "forever-app",
+				SessionStartTime: time.Date(2010, 5, 1, 0, 0, 0, 0, time.UTC),
+				IngestionTime:    time.Date(2010, 5, 1, 1, 0, 0, 0, time.UTC),
+			},
+		}}
+
+		enforcer = retention.NewEnforcerWithTimeSource(registry, store, timeSource)
+	})
+
+	Context("when deleting sessions succeeds", func() {
+		var report retention.Report
+
+		BeforeEach(func() {
+			var err error
+			report, err = enforcer.Enforce(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("deletes only sessions that started and were ingested before the retention period", func() {
+			Expect(store.sessionIDs()).To(ConsistOf(
+				"recent-session",
+				"old-session-ingested-recently",
+				"session-from-the-future-ingested-long-ago",
+				"old-session-for-other-app",
+			))
+		})
+
+		It("does not scan applications without a retention period", func() {
+			Expect(store.scannedApplications).To(ConsistOf("short-lived-app"))
+		})
+
+		It("returns a report summarising the sessions deleted", func() {
+			Expect(report).To(Equal(retention.Report{
+				Applications: []retention.ApplicationReport{
+					{
+						ApplicationID:   "short-lived-app",
+						RetentionPeriod: 7 * 24 * time.Hour,
+						Cutoff:          time.Date(2020, 5, 17, 10, 12, 14, 0, time.UTC),
+						SessionsScanned: 4,
+						SessionsDeleted: 1,
+					},
+				},
+			}))
+		})
+
+		It("reports the total number of sessions deleted", func() {
+			Expect(report.TotalSessionsDeleted()).To(Equal(1))
+		})
+	})
+
+	Context("when deleting sessions fails", func() {
+		var err error
+		var report retention.Report
+
+		BeforeEach(func() {
+			store.errorToReturn = errors.New("something went wrong")
+			report, err = enforcer.Enforce(context.Background())
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError("could not enforce retention policy for application short-lived-app: something went wrong"))
+		})
+
+		It("includes the progress made before the failure in the report", func() {
+			Expect(report.Applications).To(HaveLen(1))
+			Expect(report.Applications[0].SessionsScanned).To(Equal(4))
+		})
+	})
+})
+
+type fakeStore struct {
+	sessions            []types.Session
+	scannedApplications []string
+	errorToReturn       error
+}
+
+func (f *fakeStore) DeleteSessions(_ context.Context, applicationID string, shouldDelete func(session *types.Session) bool) (storage.DeletionResult, error) {
+	f.scannedApplications = append(f.scannedApplications, applicationID)
+	result := storage.DeletionResult{}
+	remaining := []types.Session{}
+
+	for i, session := range f.sessions {
+		if session.ApplicationID != applicationID {
+			remaining = append(remaining, session)
+			continue
+		}
+
+		result.SessionsScanned++
+
+		if shouldDelete(&f.sessions[i]) {
+			result.SessionsDeleted++
+		} else {
+			remaining = append(remaining, session)
+		}
+	}
+
+	if f.errorToReturn != nil {
+		return result, f.errorToReturn
+	}
+
+	f.sessions = remaining
+
+	return result, nil
+}
+
+func (f *fakeStore) sessionIDs() []string {
+	ids := []string{}
+
+	for _, session := range f.sessions {
+		ids = append(ids, session.SessionID)
+	}
+
+	return ids
+}
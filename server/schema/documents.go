@@ -0,0 +1,348 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/batect/abacus/server/validation"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// GenerateSessionSchema returns a JSON Schema describing sessions accepted by the ingest API.
+func GenerateSessionSchema() ([]byte, error) {
+	g := newGenerator("#/$defs/")
+
+	if _, err := g.ref(reflect.TypeOf(types.Session{})); err != nil {
+		return nil, err
+	}
+
+	root, ok := g.definitions["Session"].(map[string]interface{})
+
+	if !ok {
+		return nil, fmt.Errorf("no schema generated for session")
+	}
+
+	delete(g.definitions, "Session")
+
+	root["$schema"] = jsonSchemaDialect
+	root["title"] = "Session"
+	root["$defs"] = g.definitions
+
+	return marshal(root)
+}
+
+// GenerateOpenAPIDocument returns an OpenAPI document describing the ingest API.
+func GenerateOpenAPIDocument() ([]byte, error) {
+	g := newGenerator("#/components/schemas/")
+
+	if _, err := g.ref(reflect.TypeOf(types.Session{})); err != nil {
+		return nil, err
+	}
+
+	if _, err := g.ref(reflect.TypeOf(types.OptOut{})); err != nil {
+		return nil, err
+	}
+
+	schemas := g.definitions
+	schemas["ErrorResponse"] = object(
+		map[string]interface{}{
+			"type":   map[string]interface{}{"type": "string", "format": "uri"},
+			"title":  map[string]interface{}{"type": "string"},
+			"status": map[string]interface{}{"type": "integer"},
+			"code": map[string]interface{}{
+				"type":        "string",
+				"description": "Stable, machine-readable identifier for the kind of error. See docs/errors.md for details of each code.",
+				"enum": []string{
+					"method-not-allowed",
+					"unsupported-content-type",
+					"malformed-body",
+					"invalid-header",
+					"invalid-parameter",
+					"request-too-large",
+					"validation-failed",
+					"unsupported-version",
+					"unknown-application",
+					"storage-unavailable",
+					"too-many-requests",
+				},
+			},
+			"detail":           map[string]interface{}{"type": "string"},
+			"message":          map[string]interface{}{"type": "string", "deprecated": true, "description": "Same as detail."},
+			"validationErrors": map[string]interface{}{"type": "array", "items": ref("ValidationError")},
+		},
+		"type", "title", "status", "code", "detail", "message",
+	)
+
+	schemas["ValidationError"] = object(
+		map[string]interface{}{
+			"key":          map[string]interface{}{"type": "string", "description": "Path to the invalid field, eg. 'events[0].type'."},
+			"type":         map[string]interface{}{"type": "string", "description": "Validation rule that failed, eg. 'required'."},
+			"invalidValue": map[string]interface{}{"description": "The invalid value, if one was provided."},
+			"message":      map[string]interface{}{"type": "string"},
+		},
+		"key", "type", "message",
+	)
+
+	schemas["ClientConfig"] = object(
+		map[string]interface{}{
+			"enabled":               map[string]interface{}{"type": "boolean", "description": "False if the client should not send any telemetry."},
+			"samplingRate":          map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1, "description": "Proportion of sessions the client should upload."},
+			"uploadIntervalSeconds": map[string]interface{}{"type": "integer", "description": "How often the client should upload the sessions it has recorded."},
+			"disabledEventTypes":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": "Types of events the client should not record."},
+		},
+		"enabled", "samplingRate", "uploadIntervalSeconds", "disabledEventTypes",
+	)
+
+	document := map[string]interface{}{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": jsonSchemaDialect,
+		"info": map[string]interface{}{
+			"title":   "Abacus",
+			"version": "1",
+		},
+		"paths": map[string]interface{}{
+			"/v1/sessions": map[string]interface{}{
+				"put": map[string]interface{}{
+					"summary":     "Upload a session",
+					"operationId": "uploadSession",
+					"parameters":  []interface{}{sentAtParameter()},
+					"requestBody": sessionRequestBody(),
+					"responses": map[string]interface{}{
+						"201": emptyResponse("The session was stored, or was discarded because the application's version policy excludes its version or its user has opted out."),
+						"304": emptyResponse("A session with the same ID has already been stored."),
+						"400": errorResponse("The request was invalid, or the application's version policy rejects its version."),
+						"405": errorResponse("The request used a method other than PUT."),
+						"503": retryableErrorResponse("The session could not be stored and should be retried later."),
+					},
+				},
+			},
+			"/v1/sessions/validate": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Validate a session without storing it",
+					"description": "Decodes, validates, cleans and enriches the session in exactly the same way as PUT /v1/sessions, and returns the result. The user ID is returned as sent: it is only pseudonymised when a session is stored, so the result is not exactly what would be stored.",
+					"operationId": "validateSession",
+					"parameters":  []interface{}{sentAtParameter()},
+					"requestBody": sessionRequestBody(),
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The session is valid. The response contains the session as it would be passed to storage, before the user ID is pseudonymised.",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{"schema": ref("Session")},
+							},
+						},
+						"400": errorResponse("The request was invalid."),
+						"405": errorResponse("The request used a method other than POST."),
+					},
+				},
+			},
+			"/v1/opt-outs": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Opt a user out of telemetry",
+					"description": "Records that the user has opted out. Sessions from the user received afterwards are acknowledged but discarded. If deleteExistingSessions is true, sessions already stored for the user are deleted later, rather than during the request.",
+					"operationId": "optOut",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{"schema": ref("OptOut")},
+						},
+					},
+					"responses": map[string]interface{}{
+						"201": emptyResponse("The opt-out was recorded."),
+						"400": errorResponse("The request was invalid."),
+						"405": errorResponse("The request used a method other than POST."),
+						"413": errorResponse("The request body was too large."),
+						"429": retryableErrorResponse("The client has sent too many opt-outs recently, and should retry later."),
+						"503": retryableErrorResponse("The opt-out could not be recorded and should be retried later."),
+					},
+				},
+			},
+			"/v1/config/{applicationId}": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Get the configuration for a client",
+					"description": "Returns the configuration that clients of the application should use to decide what telemetry to send. Responses include an ETag, and clients should send it in an If-None-Match header when fetching the configuration again.",
+					"operationId": "getClientConfig",
+					"parameters": []interface{}{
+						map[string]interface{}{
+							"name":     "applicationId",
+							"in":       "path",
+							"required": true,
+							"schema":   map[string]interface{}{"type": "string"},
+						},
+						map[string]interface{}{
+							"name":        "version",
+							"in":          "query",
+							"required":    false,
+							"description": "The client's version. Some versions may receive different configuration.",
+							"schema":      map[string]interface{}{"type": "string", "pattern": validation.VersionPattern},
+						},
+						map[string]interface{}{
+							"name":     "If-None-Match",
+							"in":       "header",
+							"required": false,
+							"schema":   map[string]interface{}{"type": "string"},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The client's configuration.",
+							"headers": map[string]interface{}{
+								"ETag": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+							},
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{"schema": ref("ClientConfig")},
+							},
+						},
+						"304": emptyResponse("The configuration has not changed since the version identified by the If-None-Match header."),
+						"400": errorResponse("The request was invalid."),
+						"404": errorResponse("The application is not known."),
+						"405": errorResponse("The request used a method other than GET."),
+					},
+				},
+			},
+			"/v1/schemas/session.json": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Get the JSON Schema for sessions",
+					"operationId": "getSessionSchema",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The JSON Schema for sessions uploaded to /v1/sessions.",
+							"content":     map[string]interface{}{"application/schema+json": map[string]interface{}{}},
+						},
+					},
+				},
+			},
+			"/v1/openapi.json": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":     "Get this document",
+					"operationId": "getOpenAPIDocument",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "This OpenAPI document.",
+							"content":     map[string]interface{}{"application/json": map[string]interface{}{}},
+						},
+					},
+				},
+			},
+			"/v1/traces": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Upload sessions as OTLP traces",
+					"description": "Accepts OTLP/HTTP trace export requests. Each resource becomes a session: the service.name, service.version, session.id, enduser.id and session.parent_id resource attributes provide the session's details, other resource attributes become session attributes, spans become session spans and span events become session events.",
+					"operationId": "uploadTraces",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content": map[string]interface{}{
+							"application/x-protobuf": map[string]interface{}{"schema": map[string]interface{}{"description": "An OTLP ExportTraceServiceRequest message."}},
+							"application/json":       map[string]interface{}{"schema": map[string]interface{}{"description": "An OTLP ExportTraceServiceRequest message, in the OTLP JSON encoding."}},
+						},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{"description": "The request was processed. Any sessions that failed validation are reported as rejected spans."},
+						"400": errorResponse("The request was invalid."),
+						"405": errorResponse("The request used a method other than POST."),
+						"413": errorResponse("The request body was too large."),
+						"415": errorResponse("The request used an unsupported Content-Type."),
+						"503": retryableErrorResponse("The sessions could not be stored and should be retried later."),
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+		},
+	}
+
+	return marshal(document)
+}
+
+func sessionRequestBody() map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": ref("Session")},
+			"application/x-protobuf": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"description": "A batect.abacus.v1.Session message, as defined in session.proto. Validated with the same rules as the JSON form.",
+				},
+			},
+		},
+	}
+}
+
+func sentAtParameter() map[string]interface{} {
+	return map[string]interface{}{
+		"name":        "Abacus-Sent-At",
+		"in":          "header",
+		"required":    false,
+		"description": "The time the request was sent, according to the client's clock. If this differs from the server's clock by more than a minute, all of the session's timestamps are adjusted by the difference.",
+		"schema":      map[string]interface{}{"type": "string", "format": "date-time"},
+	}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func object(properties map[string]interface{}, required ...string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func emptyResponse(description string) map[string]interface{} {
+	return map[string]interface{}{"description": description}
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/problem+json": map[string]interface{}{"schema": ref("ErrorResponse")},
+		},
+	}
+}
+
+func retryableErrorResponse(description string) map[string]interface{} {
+	response := errorResponse(description)
+	response["headers"] = map[string]interface{}{
+		"Retry-After": map[string]interface{}{
+			"description": "Number of seconds to wait before retrying, if known.",
+			"schema":      map[string]interface{}{"type": "integer"},
+		},
+	}
+
+	return response
+}
+
+func marshal(document interface{}) ([]byte, error) {
+	bytes, err := json.MarshalIndent(document, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(bytes, '\n'), nil
+}
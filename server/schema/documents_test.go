@@ -0,0 +1,80 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package schema_test
+
+import (
+	"encoding/json"
+
+	"github.com/batect/abacus/server/schema"
+	"github.com/batect/abacus/server/validation"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generated documents", func() {
+	const regenerateMessage = "the committed document is out of date, run 'go generate ./...' in the server/schema directory to update it"
+
+	Describe("the session JSON Schema", func() {
+		var generated []byte
+
+		BeforeEach(func() {
+			var err error
+			generated, err = schema.GenerateSessionSchema()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("matches the committed copy", func() {
+			Expect(string(schema.SessionSchema)).To(Equal(string(generated)), regenerateMessage)
+		})
+
+		Describe("the generated schema", func() {
+			var properties map[string]map[string]interface{}
+
+			BeforeEach(func() {
+				var document struct {
+					Properties map[string]map[string]interface{} `json:"properties"`
+				}
+
+				Expect(json.Unmarshal(generated, &document)).To(Succeed())
+				properties = document.Properties
+			})
+
+			It("does not list the application IDs, as they depend on the server's configuration", func() {
+				Expect(properties["applicationId"]).ToNot(HaveKey("enum"))
+			})
+
+			It("uses the version validation pattern", func() {
+				Expect(properties["applicationVersion"]["pattern"]).To(Equal(validation.VersionPattern))
+			})
+
+			It("uses the attribute name validation pattern", func() {
+				Expect(properties["attributes"]["propertyNames"]).To(HaveKeyWithValue("pattern", validation.AttributeNamePattern))
+			})
+		})
+	})
+
+	Describe("the OpenAPI document", func() {
+		It("matches the committed copy", func() {
+			generated, err := schema.GenerateOpenAPIDocument()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(schema.OpenAPIDocument)).To(Equal(string(generated)), regenerateMessage)
+		})
+	})
+})
@@ -0,0 +1,47 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Command generate writes the generated JSON Schema and OpenAPI documents to the current directory.
+package main
+
+import (
+	"os"
+
+	"github.com/batect/abacus/server/schema"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	write("session.schema.json", schema.GenerateSessionSchema)
+	write("openapi.json", schema.GenerateOpenAPIDocument)
+}
+
+func write(path string, generate func() ([]byte, error)) {
+	content, err := generate()
+
+	if err != nil {
+		logrus.WithError(err).WithField("path", path).Error("Could not generate document.")
+		os.Exit(1)
+	}
+
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		logrus.WithError(err).WithField("path", path).Error("Could not write document.")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package schema generates a JSON Schema and an OpenAPI document describing the ingest API from the types and
+// validation rules that the server uses, so that client authors don't have to reverse-engineer them.
+//
+// The generated documents are committed alongside this package (and served by the API), and a test ensures that they
+// stay in sync with the Go types. Run 'go generate ./...' to regenerate them after changing types.Session or its validation rules.
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/validation"
+)
+
+//go:generate go run ./generate
+
+// Matches the uuid4 rule from the validator package.
+const uuid4Pattern = "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type generator struct {
+	refPrefix   string
+	definitions map[string]interface{}
+}
+
+func newGenerator(refPrefix string) *generator {
+	return &generator{
+		refPrefix:   refPrefix,
+		definitions: map[string]interface{}{},
+	}
+}
+
+// ref returns a reference to the schema for t, generating it and adding it to the generator's definitions if required.
+func (g *generator) ref(t reflect.Type) (map[string]interface{}, error) {
+	if _, ok := g.definitions[t.Name()]; !ok {
+		// Add a placeholder first so that recursive types don't cause infinite recursion.
+		g.definitions[t.Name()] = nil
+
+		s, err := g.structSchema(t)
+
+		if err != nil {
+			return nil, err
+		}
+
+		g.definitions[t.Name()] = s
+	}
+
+	return map[string]interface{}{"$ref": g.refPrefix + t.Name()}, nil
+}
+
+func (g *generator) structSchema(t reflect.Type) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+
+		if name == "" || name == "-" {
+			continue
+		}
+
+		var rules []string
+
+		if tag := field.Tag.Get("validate"); tag != "" {
+			rules = strings.Split(tag, ",")
+		}
+
+		s, isRequired, err := g.fieldSchema(t, field.Type, rules)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not generate schema for %v.%v: %w", t.Name(), field.Name, err)
+		}
+
+		if field.Tag.Get("schema") == "readOnly" {
+			s["readOnly"] = true
+		}
+
+		properties[name] = s
+
+		if isRequired {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// fieldSchema returns the schema for a value of type t in the struct parent that must satisfy rules,
+// which are in the same format as the 'validate' struct tag.
+func (g *generator) fieldSchema(parent reflect.Type, t reflect.Type, rules []string) (map[string]interface{}, bool, error) {
+	s, err := g.typeSchema(t)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	isRequired := false
+
+	for i, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+
+		switch name {
+		case "required":
+			isRequired = true
+
+			if t.Kind() == reflect.String {
+				s["minLength"] = 1
+			}
+		case "omitempty":
+			// Optional fields are simply not listed as required.
+		case "uuid4":
+			s["format"] = "uuid"
+			s["pattern"] = uuid4Pattern
+		case "applicationId":
+			// The applications accepted by a server depend on its configuration, so they can't be listed in the schema.
+			s["description"] = "The ID of an application registered with the server."
+		case "version":
+			s["pattern"] = validation.VersionPattern
+		case "spanId":
+			s["pattern"] = validation.SpanIDPattern
+		case "attributeName":
+			s["pattern"] = validation.AttributeNamePattern
+		case "attributeValue":
+			s["type"] = []string{"string", "number", "boolean", "null"}
+		case "gtefield":
+			other, ok := parent.FieldByName(param)
+
+			if !ok {
+				return nil, false, fmt.Errorf("'%v' refers to unknown field", rule)
+			}
+
+			s["description"] = fmt.Sprintf("Must not be before %v.", strings.SplitN(other.Tag.Get("json"), ",", 2)[0])
+		case "nefield":
+			other, ok := parent.FieldByName(param)
+
+			if !ok {
+				return nil, false, fmt.Errorf("'%v' refers to unknown field", rule)
+			}
+
+			s["description"] = fmt.Sprintf("Must not be the same as %v.", strings.SplitN(other.Tag.Get("json"), ",", 2)[0])
+		case "dive":
+			return g.diveSchema(parent, t, s, rules[i+1:], isRequired)
+		default:
+			return nil, false, fmt.Errorf("unsupported validation rule '%v'", rule)
+		}
+	}
+
+	return s, isRequired, nil
+}
+
+// diveSchema applies the rules following a 'dive' rule to the elements (and, for maps, the keys) of a slice or map.
+func (g *generator) diveSchema(parent reflect.Type, t reflect.Type, s map[string]interface{}, rules []string, isRequired bool) (map[string]interface{}, bool, error) {
+	switch t.Kind() {
+	case reflect.Slice:
+		items, _, err := g.fieldSchema(parent, t.Elem(), rules)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		s["items"] = items
+	case reflect.Map:
+		var keyRules []string
+
+		if len(rules) > 0 && rules[0] == "keys" {
+			end := indexOf(rules, "endkeys")
+
+			if end == -1 {
+				return nil, false, fmt.Errorf("'keys' rule has no matching 'endkeys' rule")
+			}
+
+			keyRules, rules = rules[1:end], rules[end+1:]
+		}
+
+		keys, _, err := g.fieldSchema(parent, t.Key(), keyRules)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		values, _, err := g.fieldSchema(parent, t.Elem(), rules)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		s["propertyNames"] = keys
+		s["additionalProperties"] = values
+	default:
+		return nil, false, fmt.Errorf("'dive' rule is not supported for %v", t)
+	}
+
+	return s, isRequired, nil
+}
+
+func (g *generator) typeSchema(t reflect.Type) (map[string]interface{}, error) {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	case reflect.Slice:
+		items, err := g.typeSchema(t.Elem())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		return map[string]interface{}{"type": "object"}, nil
+	case reflect.Struct:
+		return g.ref(t)
+	case reflect.Ptr:
+		return g.typeSchema(t.Elem())
+	default:
+		return nil, fmt.Errorf("unsupported type %v", t)
+	}
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,285 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package serviceconfig
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/enrichment"
+	"github.com/batect/abacus/server/versions"
+)
+
+// applicationIDPattern restricts the IDs of applications added in configuration, as IDs are used in storage paths.
+var applicationIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Application configures an application. Each setting replaces the application's setting in the application registry,
+// if it is set. Applications that are not in the registry are added, with the zero value for settings that are not set,
+// apart from the client configuration, which defaults to applications.DefaultClientConfig.
+type Application struct {
+	// RetentionPeriod is how long raw sessions are kept for after they are ingested, such as '720h'. Zero means sessions
+	// are kept forever.
+	RetentionPeriod *time.Duration `yaml:"retentionPeriod"`
+
+	// Enrichers lists the names of the enrichers that add server-derived attributes to the application's sessions, in the
+	// order they run.
+	Enrichers *[]string `yaml:"enrichers"`
+
+	VersionPolicy         *VersionPolicy          `yaml:"versionPolicy"`
+	ConsentPolicy         *ConsentPolicy          `yaml:"consentPolicy"`
+	ClientConfig          *ClientConfig           `yaml:"clientConfig"`
+	ClientConfigOverrides *[]ClientConfigOverride `yaml:"clientConfigOverrides"`
+}
+
+// VersionPolicy mirrors applications.VersionPolicy.
+type VersionPolicy struct {
+	MinimumVersion   string   `yaml:"minimumVersion"`
+	DropPrereleases  bool     `yaml:"dropPrereleases"`
+	ReleasedVersions []string `yaml:"releasedVersions"`
+}
+
+// ConsentPolicy mirrors applications.ConsentPolicy.
+type ConsentPolicy struct {
+	Required               bool     `yaml:"required"`
+	AcceptedPolicyVersions []string `yaml:"acceptedPolicyVersions"`
+}
+
+// ClientConfig mirrors applications.ClientConfig.
+type ClientConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	SamplingRate       float64       `yaml:"samplingRate"`
+	UploadInterval     time.Duration `yaml:"uploadInterval"`
+	DisabledEventTypes []string      `yaml:"disabledEventTypes"`
+}
+
+// ClientConfigOverride mirrors applications.ClientConfigOverride.
+type ClientConfigOverride struct {
+	MinimumVersion string       `yaml:"minimumVersion"`
+	MaximumVersion string       `yaml:"maximumVersion"`
+	Config         ClientConfig `yaml:"config"`
+}
+
+// Registry returns a copy of registry with the applications from config added, and the settings from config applied to
+// each application.
+func (config *Config) Registry(registry *applications.Registry) *applications.Registry {
+	apps := registry.All()
+
+	for _, id := range config.applicationIDs() {
+		if _, ok := registry.Get(id); !ok {
+			apps = append(apps, applications.Application{ID: id, ClientConfig: applications.DefaultClientConfig()})
+		}
+	}
+
+	for i, app := range apps {
+		if configured, ok := config.Applications[app.ID]; ok {
+			apps[i] = configured.apply(app)
+		}
+	}
+
+	return applications.NewRegistry(apps...)
+}
+
+func (a Application) apply(app applications.Application) applications.Application {
+	if a.RetentionPeriod != nil {
+		app.RetentionPeriod = *a.RetentionPeriod
+	}
+
+	if a.Enrichers != nil {
+		app.Enrichers = *a.Enrichers
+	}
+
+	if a.VersionPolicy != nil {
+		app.VersionPolicy = applications.VersionPolicy{
+			MinimumVersion:   a.VersionPolicy.MinimumVersion,
+			DropPrereleases:  a.VersionPolicy.DropPrereleases,
+			ReleasedVersions: a.VersionPolicy.ReleasedVersions,
+		}
+	}
+
+	if a.ConsentPolicy != nil {
+		app.ConsentPolicy = applications.ConsentPolicy{
+			Required:               a.ConsentPolicy.Required,
+			AcceptedPolicyVersions: a.ConsentPolicy.AcceptedPolicyVersions,
+		}
+	}
+
+	if a.ClientConfig != nil {
+		app.ClientConfig = a.ClientConfig.toClientConfig()
+	}
+
+	if a.ClientConfigOverrides != nil {
+		app.ClientConfigOverrides = make([]applications.ClientConfigOverride, 0, len(*a.ClientConfigOverrides))
+
+		for _, override := range *a.ClientConfigOverrides {
+			app.ClientConfigOverrides = append(app.ClientConfigOverrides, applications.ClientConfigOverride{
+				MinimumVersion: override.MinimumVersion,
+				MaximumVersion: override.MaximumVersion,
+				Config:         override.Config.toClientConfig(),
+			})
+		}
+	}
+
+	return app
+}
+
+func (c ClientConfig) toClientConfig() applications.ClientConfig {
+	return applications.ClientConfig{
+		Enabled:            c.Enabled,
+		SamplingRate:       c.SamplingRate,
+		UploadInterval:     c.UploadInterval,
+		DisabledEventTypes: c.DisabledEventTypes,
+	}
+}
+
+func (a Application) validate(id string, addProblem func(format string, args ...interface{})) {
+	key := "applications." + id
+
+	if !applicationIDPattern.MatchString(id) {
+		addProblem("%v must have an ID made up of lowercase letters, digits and single hyphens", key)
+	}
+
+	if a.RetentionPeriod != nil && *a.RetentionPeriod < 0 {
+		addProblem("%v.retentionPeriod must not be negative", key)
+	}
+
+	if a.Enrichers != nil {
+		for _, name := range *a.Enrichers {
+			if !isKnownEnricher(name) {
+				addProblem("%v.enrichers contains unknown enricher '%v', must be one of %v", key, name, enrichment.EnricherNames)
+			}
+		}
+	}
+
+	if a.VersionPolicy != nil {
+		validateVersion(key+".versionPolicy.minimumVersion", a.VersionPolicy.MinimumVersion, addProblem)
+
+		for i, version := range a.VersionPolicy.ReleasedVersions {
+			validateVersion(fmt.Sprintf("%v.versionPolicy.releasedVersions[%v]", key, i), version, addProblem)
+		}
+	}
+
+	if a.ConsentPolicy != nil {
+		for i, version := range a.ConsentPolicy.AcceptedPolicyVersions {
+			if version == "" {
+				addProblem("%v.consentPolicy.acceptedPolicyVersions[%v] must not be empty", key, i)
+			}
+		}
+	}
+
+	if a.ClientConfig != nil {
+		a.ClientConfig.validate(key+".clientConfig", addProblem)
+	}
+
+	if a.ClientConfigOverrides != nil {
+		for i, override := range *a.ClientConfigOverrides {
+			overrideKey := fmt.Sprintf("%v.clientConfigOverrides[%v]", key, i)
+			validateVersion(overrideKey+".minimumVersion", override.MinimumVersion, addProblem)
+			validateVersion(overrideKey+".maximumVersion", override.MaximumVersion, addProblem)
+			override.Config.validate(overrideKey+".config", addProblem)
+		}
+	}
+}
+
+func (c ClientConfig) validate(key string, addProblem func(format string, args ...interface{})) {
+	if c.SamplingRate < 0 || c.SamplingRate > 1 {
+		addProblem("%v.samplingRate must be between 0 and 1", key)
+	}
+
+	if c.UploadInterval <= 0 {
+		addProblem("%v.uploadInterval must be a positive duration, such as '1h'", key)
+	}
+}
+
+// validateVersion reports version if it is set and is not a valid version, such as "1.2.3" or "1.2".
+func validateVersion(key string, version string, addProblem func(format string, args ...interface{})) {
+	if version == "" {
+		return
+	}
+
+	if _, err := versions.Parse(version); err != nil {
+		addProblem("%v '%v' is not a valid version", key, version)
+	}
+}
+
+func isKnownEnricher(name string) bool {
+	for _, known := range enrichment.EnricherNames {
+		if name == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (config *Config) applicationIDs() []string {
+	ids := make([]string, 0, len(config.Applications))
+
+	for id := range config.Applications {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// applicationChangesRequiringRestart lists the applications whose settings differ between config and updated when applied to
+// registry, ignoring client configuration, which can be changed while the service is running.
+func (config *Config) applicationChangesRequiringRestart(updated *Config, registry *applications.Registry) []string {
+	original := config.Registry(registry)
+	changed := updated.Registry(registry)
+	ids := map[string]bool{}
+
+	for _, app := range append(original.All(), changed.All()...) {
+		ids[app.ID] = true
+	}
+
+	sortedIDs := make([]string, 0, len(ids))
+
+	for id := range ids {
+		sortedIDs = append(sortedIDs, id)
+	}
+
+	sort.Strings(sortedIDs)
+
+	var changes []string
+
+	for _, id := range sortedIDs {
+		originalApp, inOriginal := original.Get(id)
+		changedApp, inChanged := changed.Get(id)
+
+		if inOriginal != inChanged || !reflect.DeepEqual(withoutClientConfig(originalApp), withoutClientConfig(changedApp)) {
+			changes = append(changes, "applications."+id)
+		}
+	}
+
+	return changes
+}
+
+func withoutClientConfig(app applications.Application) applications.Application {
+	app.ClientConfig = applications.ClientConfig{}
+	app.ClientConfigOverrides = nil
+
+	return app
+}
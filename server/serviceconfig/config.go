@@ -0,0 +1,273 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package serviceconfig loads the service's configuration from an optional YAML file and environment variables, and
+// validates it.
+//
+// Environment variables take precedence over the file, so a setting can be overridden for a single deployment without
+// changing the file. Some settings can be reloaded while the service is running (see Config.ChangesRequiringRestart).
+package serviceconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/batect/abacus/server/observability"
+	"github.com/batect/abacus/server/storage"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// StorageBackend selects where sessions are stored.
+type StorageBackend string
+
+const (
+	// StorageBackendCloudStorage stores sessions in a Google Cloud Storage bucket.
+	StorageBackendCloudStorage StorageBackend = "cloudStorage"
+)
+
+// StorageBackends lists all of the supported storage backends.
+var StorageBackends = []StorageBackend{StorageBackendCloudStorage}
+
+const (
+	DefaultMaxSessionRequestSize = 10 * 1024 * 1024
+
+	DefaultMaxTracesRequestSize = 10 * 1024 * 1024
+
+	DefaultMaxOptOutRequestsPerMinute = 10
+)
+
+type Config struct {
+	Server        Server                 `yaml:"server"`
+	Storage       Storage                `yaml:"storage"`
+	Applications  map[string]Application `yaml:"applications"`
+	Limits        Limits                 `yaml:"limits"`
+	Observability Observability          `yaml:"observability"`
+	Enrichment    Enrichment             `yaml:"enrichment"`
+}
+
+type Server struct {
+	// Name and Version identify the running revision of the service. They are always taken from the environment.
+	Name    string `yaml:"-"`
+	Version string `yaml:"-"`
+
+	Port      string `yaml:"port"`
+	ProjectID string `yaml:"projectId"`
+
+	// MetricsPort is the port Prometheus metrics are served on. Metrics are only served if it is set, and never on Port,
+	// so that they aren't exposed publicly along with the API.
+	MetricsPort string `yaml:"metricsPort"`
+}
+
+type Storage struct {
+	Backend StorageBackend `yaml:"backend"`
+
+	// Bucket is the bucket sessions and opt-outs are stored in. It defaults to '<project ID>-sessions'.
+	Bucket string `yaml:"bucket"`
+
+	// EncryptionKeyFile is the path to a file containing the keys used to wrap data keys for encrypting stored sessions.
+	// Sessions are stored unencrypted if this is empty.
+	EncryptionKeyFile string `yaml:"encryptionKeyFile"`
+
+	// PseudonymisationKeyFile is the path to a file containing the per-application secrets used to pseudonymise user IDs
+	// before sessions are stored. User IDs are stored as-is if this is empty.
+	PseudonymisationKeyFile string `yaml:"pseudonymisationKeyFile"`
+
+	// Resilience controls how failures to store sessions are retried, and when the circuit breaker stops attempting to
+	// store them. It defaults to storage.DefaultResilienceOptions.
+	Resilience Resilience `yaml:"resilience"`
+}
+
+// Resilience mirrors storage.ResilienceOptions.
+type Resilience struct {
+	AttemptTimeout   time.Duration `yaml:"attemptTimeout"`
+	MaxAttempts      int           `yaml:"maxAttempts"`
+	InitialBackoff   time.Duration `yaml:"initialBackoff"`
+	MaxBackoff       time.Duration `yaml:"maxBackoff"`
+	FailureThreshold int           `yaml:"failureThreshold"`
+	OpenDuration     time.Duration `yaml:"openDuration"`
+}
+
+type Limits struct {
+	// MaxSessionRequestSize is the largest request body, in bytes, accepted by the sessions, session validation and
+	// opt-out endpoints.
+	MaxSessionRequestSize int64 `yaml:"maxSessionRequestSize"`
+
+	// MaxTracesRequestSize is the largest request body, in bytes, accepted by the traces endpoint, both before and after decompression.
+	MaxTracesRequestSize int64 `yaml:"maxTracesRequestSize"`
+
+	// MaxOptOutRequestsPerMinute is the number of requests each client can make to the opt-out endpoint each minute.
+	MaxOptOutRequestsPerMinute int64 `yaml:"maxOptOutRequestsPerMinute"`
+}
+
+type Observability struct {
+	// LogLevel is the minimum level of log messages written, such as 'info' or 'debug'.
+	LogLevel string `yaml:"logLevel"`
+
+	// TracesExporter selects where traces are sent. It defaults to Honeycomb if a Honeycomb API key is set, and otherwise
+	// to discarding traces.
+	TracesExporter observability.Exporter `yaml:"tracesExporter"`
+
+	// HoneycombAPIKey is required if traces are sent to Honeycomb.
+	HoneycombAPIKey string `yaml:"honeycombApiKey"`
+
+	// TracesFile is the path traces are written to, and is required if traces are written to a file.
+	TracesFile string `yaml:"tracesFile"`
+}
+
+type Enrichment struct {
+	// Region is the cloud region the service is running in, if known. It is added to sessions by the region enricher.
+	Region string `yaml:"region"`
+
+	// GeoIPDatabaseFile is the path to a MaxMind-format country database used to add the client's country to sessions.
+	// Countries are not added if this is empty.
+	GeoIPDatabaseFile string `yaml:"geoIPDatabaseFile"`
+}
+
+// ValidationError lists every problem found in a configuration, so that they can all be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "configuration is not valid: " + strings.Join(e.Problems, "; ")
+}
+
+// Load reads the configuration file at path (if path is not empty), applies overrides from the environment variables
+// returned by lookupEnv, fills in defaults and validates the result. Settings only used by the serve command are not
+// validated: use ValidateServer to check them.
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := defaultConfig()
+
+	if path != "" {
+		if err := readFile(path, config); err != nil {
+			return nil, err
+		}
+	}
+
+	applyEnvironment(config, lookupEnv)
+	applyDerivedDefaults(config)
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: Server{
+			Name:    "abacus",
+			Version: "local",
+		},
+		Storage: Storage{
+			Backend:    StorageBackendCloudStorage,
+			Resilience: defaultResilience(),
+		},
+		Limits: Limits{
+			MaxSessionRequestSize:      DefaultMaxSessionRequestSize,
+			MaxTracesRequestSize:       DefaultMaxTracesRequestSize,
+			MaxOptOutRequestsPerMinute: DefaultMaxOptOutRequestsPerMinute,
+		},
+		Observability: Observability{
+			LogLevel: logrus.InfoLevel.String(),
+		},
+	}
+}
+
+func defaultResilience() Resilience {
+	options := storage.DefaultResilienceOptions()
+
+	return Resilience{
+		AttemptTimeout:   options.AttemptTimeout,
+		MaxAttempts:      options.MaxAttempts,
+		InitialBackoff:   options.InitialBackoff,
+		MaxBackoff:       options.MaxBackoff,
+		FailureThreshold: options.FailureThreshold,
+		OpenDuration:     options.OpenDuration,
+	}
+}
+
+func readFile(path string, config *Config) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return fmt.Errorf("could not open configuration file: %w", err)
+	}
+
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not parse configuration file '%v': %w", path, err)
+	}
+
+	return nil
+}
+
+// environmentVariables lists the environment variables that override settings, and the setting each one overrides.
+// Variables that are set to an empty value are ignored.
+var environmentVariables = []struct {
+	name    string
+	setting func(config *Config) *string
+}{
+	{"K_SERVICE", func(c *Config) *string { return &c.Server.Name }},
+	{"K_REVISION", func(c *Config) *string { return &c.Server.Version }},
+	{"PORT", func(c *Config) *string { return &c.Server.Port }},
+	{"METRICS_PORT", func(c *Config) *string { return &c.Server.MetricsPort }},
+	{"GOOGLE_PROJECT", func(c *Config) *string { return &c.Server.ProjectID }},
+	{"SESSIONS_BUCKET", func(c *Config) *string { return &c.Storage.Bucket }},
+	{"ENCRYPTION_KEY_FILE", func(c *Config) *string { return &c.Storage.EncryptionKeyFile }},
+	{"PSEUDONYMISATION_KEY_FILE", func(c *Config) *string { return &c.Storage.PseudonymisationKeyFile }},
+	{"LOG_LEVEL", func(c *Config) *string { return &c.Observability.LogLevel }},
+	{"TRACES_EXPORTER", func(c *Config) *string { return (*string)(&c.Observability.TracesExporter) }},
+	{"HONEYCOMB_API_KEY", func(c *Config) *string { return &c.Observability.HoneycombAPIKey }},
+	{"TRACES_FILE", func(c *Config) *string { return &c.Observability.TracesFile }},
+	{"REGION", func(c *Config) *string { return &c.Enrichment.Region }},
+	{"GEOIP_DATABASE_FILE", func(c *Config) *string { return &c.Enrichment.GeoIPDatabaseFile }},
+}
+
+func applyEnvironment(config *Config, lookupEnv func(string) (string, bool)) {
+	for _, variable := range environmentVariables {
+		if value, ok := lookupEnv(variable.name); ok && value != "" {
+			*variable.setting(config) = value
+		}
+	}
+}
+
+func applyDerivedDefaults(config *Config) {
+	if config.Storage.Bucket == "" && config.Server.ProjectID != "" {
+		config.Storage.Bucket = fmt.Sprintf("%v-sessions", config.Server.ProjectID)
+	}
+
+	if config.Observability.TracesExporter == "" {
+		if config.Observability.HoneycombAPIKey != "" {
+			config.Observability.TracesExporter = observability.ExporterHoneycomb
+		} else {
+			config.Observability.TracesExporter = observability.ExporterNone
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package serviceconfig
+
+import (
+	"reflect"
+
+	"github.com/batect/abacus/server/applications"
+)
+
+// ChangesRequiringRestart lists the settings that differ between config and updated that only take effect when the service
+// restarts, with applications compared after applying each configuration to registry. Applications' client configuration,
+// limits and the log level can be changed while the service is running.
+func (config *Config) ChangesRequiringRestart(updated *Config, registry *applications.Registry) []string {
+	var changes []string
+
+	settings := []struct {
+		name     string
+		original interface{}
+		updated  interface{}
+	}{
+		{"server", config.Server, updated.Server},
+		{"storage", config.Storage, updated.Storage},
+		{"enrichment", config.Enrichment, updated.Enrichment},
+		{"observability.tracesExporter", config.Observability.TracesExporter, updated.Observability.TracesExporter},
+		{"observability.honeycombApiKey", config.Observability.HoneycombAPIKey, updated.Observability.HoneycombAPIKey},
+		{"observability.tracesFile", config.Observability.TracesFile, updated.Observability.TracesFile},
+	}
+
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.original, setting.updated) {
+			changes = append(changes, setting.name)
+		}
+	}
+
+	return append(changes, config.applicationChangesRequiringRestart(updated, registry)...)
+}
@@ -0,0 +1,498 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package serviceconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/observability"
+	"github.com/batect/abacus/server/serviceconfig"
+	"github.com/batect/abacus/server/storage"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Service configuration", func() {
+	registry := applications.NewRegistry(
+		applications.Application{ID: "my-app", ClientConfig: applications.DefaultClientConfig()},
+		applications.Application{ID: "other-app", ClientConfig: applications.DefaultClientConfig()},
+	)
+
+	var env map[string]string
+
+	BeforeEach(func() {
+		env = map[string]string{
+			"PORT":           "8080",
+			"GOOGLE_PROJECT": "my-project",
+		}
+	})
+
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	writeFile := func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+
+		return path
+	}
+
+	Describe("loading configuration", func() {
+		Context("when no configuration file is given", func() {
+			var config *serviceconfig.Config
+
+			BeforeEach(func() {
+				env["K_SERVICE"] = "abacus-service"
+				env["K_REVISION"] = "abacus-service-00001"
+				env["REGION"] = "australia-southeast1"
+				env["ENCRYPTION_KEY_FILE"] = "/keys/encryption"
+
+				var err error
+				config, err = serviceconfig.Load("", lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("takes settings from the environment", func() {
+				Expect(config.Server).To(Equal(serviceconfig.Server{Name: "abacus-service", Version: "abacus-service-00001", Port: "8080", ProjectID: "my-project"}))
+				Expect(config.Enrichment.Region).To(Equal("australia-southeast1"))
+				Expect(config.Storage.EncryptionKeyFile).To(Equal("/keys/encryption"))
+			})
+
+			It("uses defaults for other settings", func() {
+				Expect(config.Storage.Backend).To(Equal(serviceconfig.StorageBackendCloudStorage))
+				Expect(config.Storage.Bucket).To(Equal("my-project-sessions"))
+				Expect(config.ResilienceOptions()).To(Equal(storage.DefaultResilienceOptions()))
+				Expect(config.Limits).To(Equal(serviceconfig.Limits{MaxSessionRequestSize: 10 * 1024 * 1024, MaxTracesRequestSize: 10 * 1024 * 1024, MaxOptOutRequestsPerMinute: 10}))
+				Expect(config.LogLevel()).To(Equal(logrus.InfoLevel))
+				Expect(config.Observability.TracesExporter).To(Equal(observability.ExporterNone))
+				Expect(config.Applications).To(BeEmpty())
+			})
+		})
+
+		Context("when the Honeycomb API key is set and no exporter is selected", func() {
+			BeforeEach(func() {
+				env["HONEYCOMB_API_KEY"] = "abc123"
+			})
+
+			It("sends traces to Honeycomb", func() {
+				config, err := serviceconfig.Load("", lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.Observability.TracesExporter).To(Equal(observability.ExporterHoneycomb))
+			})
+		})
+
+		Context("when a configuration file is given", func() {
+			var path string
+
+			BeforeEach(func() {
+				delete(env, "PORT")
+				delete(env, "GOOGLE_PROJECT")
+
+				path = writeFile(`
+server:
+  port: "9090"
+  metricsPort: "9091"
+  projectId: file-project
+storage:
+  bucket: my-bucket
+  pseudonymisationKeyFile: /keys/pseudonymisation
+  resilience:
+    maxAttempts: 5
+    openDuration: 1m
+applications:
+  my-app:
+    clientConfig:
+      enabled: true
+      samplingRate: 0.5
+      uploadInterval: 15m
+      disabledEventTypes: [ConsoleOutput]
+limits:
+  maxSessionRequestSize: 2048
+  maxOptOutRequestsPerMinute: 5
+observability:
+  logLevel: debug
+  tracesExporter: stderr
+enrichment:
+  geoIPDatabaseFile: /data/countries.mmdb
+`)
+			})
+
+			Context("when no environment variables override settings in the file", func() {
+				var config *serviceconfig.Config
+
+				BeforeEach(func() {
+					var err error
+					config, err = serviceconfig.Load(path, lookupEnv)
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("takes settings from the file", func() {
+					Expect(config.Server.Port).To(Equal("9090"))
+					Expect(config.Server.MetricsPort).To(Equal("9091"))
+					Expect(config.Server.ProjectID).To(Equal("file-project"))
+					Expect(config.Storage.Bucket).To(Equal("my-bucket"))
+					Expect(config.Storage.PseudonymisationKeyFile).To(Equal("/keys/pseudonymisation"))
+					Expect(config.ResilienceOptions().MaxAttempts).To(Equal(5))
+					Expect(config.ResilienceOptions().OpenDuration).To(Equal(time.Minute))
+					Expect(config.Limits.MaxSessionRequestSize).To(BeEquivalentTo(2048))
+					Expect(config.Limits.MaxOptOutRequestsPerMinute).To(BeEquivalentTo(5))
+					Expect(config.LogLevel()).To(Equal(logrus.DebugLevel))
+					Expect(config.Observability.TracesExporter).To(Equal(observability.ExporterStderr))
+					Expect(config.Enrichment.GeoIPDatabaseFile).To(Equal("/data/countries.mmdb"))
+				})
+
+				It("takes application settings from the file", func() {
+					Expect(config.Applications).To(Equal(map[string]serviceconfig.Application{
+						"my-app": {
+							ClientConfig: &serviceconfig.ClientConfig{
+								Enabled:            true,
+								SamplingRate:       0.5,
+								UploadInterval:     15 * time.Minute,
+								DisabledEventTypes: []string{"ConsoleOutput"},
+							},
+						},
+					}))
+				})
+
+				It("uses defaults for settings not in the file", func() {
+					Expect(config.Limits.MaxTracesRequestSize).To(BeEquivalentTo(10 * 1024 * 1024))
+					Expect(config.Storage.Backend).To(Equal(serviceconfig.StorageBackendCloudStorage))
+					Expect(config.ResilienceOptions().FailureThreshold).To(Equal(storage.DefaultResilienceOptions().FailureThreshold))
+				})
+			})
+
+			Context("when environment variables override settings in the file", func() {
+				var config *serviceconfig.Config
+
+				BeforeEach(func() {
+					env["PORT"] = "8080"
+					env["METRICS_PORT"] = "8081"
+					env["LOG_LEVEL"] = "warning"
+					env["SESSIONS_BUCKET"] = ""
+
+					var err error
+					config, err = serviceconfig.Load(path, lookupEnv)
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("uses the values from the environment", func() {
+					Expect(config.Server.Port).To(Equal("8080"))
+					Expect(config.Server.MetricsPort).To(Equal("8081"))
+					Expect(config.LogLevel()).To(Equal(logrus.WarnLevel))
+				})
+
+				It("ignores environment variables set to an empty value", func() {
+					Expect(config.Storage.Bucket).To(Equal("my-bucket"))
+				})
+			})
+		})
+
+		Context("when the configuration file is empty", func() {
+			It("takes settings from the environment", func() {
+				config, err := serviceconfig.Load(writeFile(""), lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.Server.Port).To(Equal("8080"))
+			})
+		})
+
+		Context("when settings only used by the serve command are not set", func() {
+			It("does not return an error", func() {
+				delete(env, "PORT")
+				delete(env, "GOOGLE_PROJECT")
+				env["SESSIONS_BUCKET"] = "my-bucket"
+
+				_, err := serviceconfig.Load(writeFile("limits:\n  maxSessionRequestSize: 0\n"), lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("when the configuration file does not exist", func() {
+			It("returns an error", func() {
+				_, err := serviceconfig.Load("/does/not/exist.yaml", lookupEnv)
+				Expect(err).To(MatchError(ContainSubstring("could not open configuration file")))
+			})
+		})
+
+		Context("when the configuration file contains an unknown setting", func() {
+			It("returns an error that names the setting", func() {
+				path := writeFile("server:\n  prot: 8080\n")
+				_, err := serviceconfig.Load(path, lookupEnv)
+				Expect(err).To(MatchError(ContainSubstring("could not parse configuration file '" + path + "'")))
+				Expect(err).To(MatchError(ContainSubstring("field prot not found")))
+			})
+		})
+
+		Context("when the configuration file is not valid YAML", func() {
+			It("returns an error", func() {
+				path := writeFile("server: [")
+				_, err := serviceconfig.Load(path, lookupEnv)
+				Expect(err).To(MatchError(ContainSubstring("could not parse configuration file '" + path + "'")))
+			})
+		})
+
+		Context("when the configuration is not valid", func() {
+			var err error
+
+			BeforeEach(func() {
+				delete(env, "PORT")
+				delete(env, "GOOGLE_PROJECT")
+
+				path := writeFile(`
+storage:
+  backend: s3
+  resilience:
+    attemptTimeout: -1s
+    maxAttempts: 0
+    maxBackoff: -1s
+    failureThreshold: 0
+    openDuration: 0s
+applications:
+  my-app:
+    clientConfig:
+      samplingRate: 2
+  Invalid_App:
+    retentionPeriod: -1h
+    enrichers: [region, weather]
+    versionPolicy:
+      minimumVersion: abc
+      releasedVersions: ["1.0.0", "1.x"]
+    consentPolicy:
+      acceptedPolicyVersions: ["2023-01", ""]
+    clientConfigOverrides:
+      - maximumVersion: "2.y"
+        config:
+          uploadInterval: 1h
+          samplingRate: -1
+observability:
+  logLevel: loud
+  tracesExporter: file
+`)
+
+				_, err = serviceconfig.Load(path, lookupEnv)
+			})
+
+			It("returns an error listing every problem", func() {
+				var validationError *serviceconfig.ValidationError
+				Expect(err).To(BeAssignableToTypeOf(validationError))
+				Expect(err.(*serviceconfig.ValidationError).Problems).To(Equal([]string{
+					"storage.backend 's3' is not supported, must be one of [cloudStorage]",
+					"storage.bucket (or the SESSIONS_BUCKET environment variable) is required",
+					"storage.resilience.attemptTimeout must not be negative",
+					"storage.resilience.maxAttempts must be at least 1",
+					"storage.resilience.initialBackoff and storage.resilience.maxBackoff must not be negative",
+					"storage.resilience.failureThreshold must be at least 1",
+					"storage.resilience.openDuration must be a positive duration, such as '30s'",
+					"applications.Invalid_App must have an ID made up of lowercase letters, digits and single hyphens",
+					"applications.Invalid_App.retentionPeriod must not be negative",
+					"applications.Invalid_App.enrichers contains unknown enricher 'weather', must be one of [region clientPlatform country]",
+					"applications.Invalid_App.versionPolicy.minimumVersion 'abc' is not a valid version",
+					"applications.Invalid_App.versionPolicy.releasedVersions[1] '1.x' is not a valid version",
+					"applications.Invalid_App.consentPolicy.acceptedPolicyVersions[1] must not be empty",
+					"applications.Invalid_App.clientConfigOverrides[0].maximumVersion '2.y' is not a valid version",
+					"applications.Invalid_App.clientConfigOverrides[0].config.samplingRate must be between 0 and 1",
+					"applications.my-app.clientConfig.samplingRate must be between 0 and 1",
+					"applications.my-app.clientConfig.uploadInterval must be a positive duration, such as '1h'",
+					"observability.logLevel (or the LOG_LEVEL environment variable) is not valid: not a valid logrus Level: \"loud\"",
+					"observability is not valid: a traces file is required to write traces to a file",
+				}))
+			})
+
+			It("returns an error message that includes every problem", func() {
+				Expect(err).To(MatchError(HavePrefix("configuration is not valid: storage.backend 's3' is not supported, must be one of [cloudStorage]; storage.bucket")))
+			})
+		})
+	})
+
+	Describe("validating settings only used by the serve command", func() {
+		Context("when the settings are valid", func() {
+			It("does not return an error", func() {
+				config, err := serviceconfig.Load("", lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.ValidateServer()).To(Succeed())
+			})
+		})
+
+		Context("when metrics are served on the same port as the API", func() {
+			It("returns an error", func() {
+				env["METRICS_PORT"] = env["PORT"]
+
+				config, err := serviceconfig.Load("", lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.ValidateServer()).To(MatchError(ContainSubstring("server.metricsPort (or the METRICS_PORT environment variable) must be different to server.port")))
+			})
+		})
+
+		Context("when the settings are not valid", func() {
+			var err error
+
+			BeforeEach(func() {
+				delete(env, "PORT")
+				delete(env, "GOOGLE_PROJECT")
+				env["SESSIONS_BUCKET"] = "my-bucket"
+
+				path := writeFile(`
+limits:
+  maxSessionRequestSize: 0
+  maxTracesRequestSize: 0
+  maxOptOutRequestsPerMinute: -1
+`)
+
+				config, loadErr := serviceconfig.Load(path, lookupEnv)
+				Expect(loadErr).ToNot(HaveOccurred())
+
+				err = config.ValidateServer()
+			})
+
+			It("returns an error listing every problem", func() {
+				var validationError *serviceconfig.ValidationError
+				Expect(err).To(BeAssignableToTypeOf(validationError))
+				Expect(err.(*serviceconfig.ValidationError).Problems).To(Equal([]string{
+					"server.port (or the PORT environment variable) is required",
+					"server.projectId (or the GOOGLE_PROJECT environment variable) is required",
+					"limits.maxSessionRequestSize must be a positive number of bytes",
+					"limits.maxTracesRequestSize must be a positive number of bytes",
+					"limits.maxOptOutRequestsPerMinute must be a positive number",
+				}))
+			})
+		})
+	})
+
+	Describe("applying configuration to the application registry", func() {
+		var result *applications.Registry
+
+		BeforeEach(func() {
+			path := writeFile(`
+applications:
+  my-app:
+    clientConfig:
+      enabled: false
+      samplingRate: 0.1
+      uploadInterval: 2h
+    retentionPeriod: 720h
+    enrichers: [region]
+    versionPolicy:
+      minimumVersion: "1.2"
+      dropPrereleases: true
+    consentPolicy:
+      required: true
+      acceptedPolicyVersions: ["2023-06"]
+    clientConfigOverrides:
+      - minimumVersion: "1.0"
+        maximumVersion: "2.0"
+        config:
+          enabled: true
+          samplingRate: 0.2
+          uploadInterval: 1h
+  other-app: {}
+  new-app:
+    retentionPeriod: 24h
+`)
+
+			config, err := serviceconfig.Load(path, lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+
+			result = config.Registry(registry)
+		})
+
+		It("replaces the client configuration of applications with client configuration", func() {
+			app, ok := result.Get("my-app")
+			Expect(ok).To(BeTrue())
+			Expect(app.ClientConfig).To(Equal(applications.ClientConfig{SamplingRate: 0.1, UploadInterval: 2 * time.Hour}))
+		})
+
+		It("replaces the other settings of applications", func() {
+			app, _ := result.Get("my-app")
+			Expect(app.RetentionPeriod).To(Equal(720 * time.Hour))
+			Expect(app.Enrichers).To(Equal([]string{"region"}))
+			Expect(app.VersionPolicy).To(Equal(applications.VersionPolicy{MinimumVersion: "1.2", DropPrereleases: true}))
+			Expect(app.ConsentPolicy).To(Equal(applications.ConsentPolicy{Required: true, AcceptedPolicyVersions: []string{"2023-06"}}))
+			Expect(app.ClientConfigOverrides).To(Equal([]applications.ClientConfigOverride{
+				{MinimumVersion: "1.0", MaximumVersion: "2.0", Config: applications.ClientConfig{Enabled: true, SamplingRate: 0.2, UploadInterval: time.Hour}},
+			}))
+		})
+
+		It("keeps the settings of applications that are not configured", func() {
+			app, ok := result.Get("other-app")
+			Expect(ok).To(BeTrue())
+			Expect(app).To(Equal(applications.Application{ID: "other-app", ClientConfig: applications.DefaultClientConfig()}))
+		})
+
+		It("adds applications that are not in the registry, with the default client configuration", func() {
+			app, ok := result.Get("new-app")
+			Expect(ok).To(BeTrue())
+			Expect(app).To(Equal(applications.Application{ID: "new-app", RetentionPeriod: 24 * time.Hour, ClientConfig: applications.DefaultClientConfig()}))
+		})
+
+		It("does not modify the original registry", func() {
+			app, _ := registry.Get("my-app")
+			Expect(app).To(Equal(applications.Application{ID: "my-app", ClientConfig: applications.DefaultClientConfig()}))
+
+			_, ok := registry.Get("new-app")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("comparing configurations", func() {
+		var original *serviceconfig.Config
+
+		BeforeEach(func() {
+			var err error
+			original, err = serviceconfig.Load("", lookupEnv)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when only settings that can be reloaded have changed", func() {
+			It("reports no changes requiring a restart", func() {
+				env["LOG_LEVEL"] = "debug"
+				updated, err := serviceconfig.Load(writeFile("limits:\n  maxSessionRequestSize: 100\napplications:\n  my-app:\n    clientConfig:\n      uploadInterval: 1m\n"), lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(original.ChangesRequiringRestart(updated, registry)).To(BeEmpty())
+			})
+		})
+
+		Context("when settings that require a restart have changed", func() {
+			It("reports the changed settings", func() {
+				env["PORT"] = "9090"
+				env["GEOIP_DATABASE_FILE"] = "/data/countries.mmdb"
+				env["TRACES_EXPORTER"] = "stderr"
+				updated, err := serviceconfig.Load("", lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(original.ChangesRequiringRestart(updated, registry)).To(Equal([]string{"server", "enrichment", "observability.tracesExporter"}))
+			})
+		})
+
+		Context("when applications have been added or their settings other than client configuration have changed", func() {
+			It("reports the changed applications", func() {
+				updated, err := serviceconfig.Load(writeFile("applications:\n  my-app:\n    retentionPeriod: 1h\n  other-app:\n    clientConfig:\n      uploadInterval: 1m\n  new-app: {}\n"), lookupEnv)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(original.ChangesRequiringRestart(updated, registry)).To(Equal([]string{"applications.my-app", "applications.new-app"}))
+			})
+		})
+	})
+})
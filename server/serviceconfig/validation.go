@@ -0,0 +1,173 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package serviceconfig
+
+import (
+	"fmt"
+
+	"github.com/batect/abacus/server/observability"
+	"github.com/batect/abacus/server/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Validate returns a *ValidationError listing every problem with the settings used by every command.
+// Problems name the setting in the configuration file, and the environment variable that overrides it, if there is one.
+func (config *Config) Validate() error {
+	var problems []string
+
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !isKnownStorageBackend(config.Storage.Backend) {
+		addProblem("storage.backend '%v' is not supported, must be one of %v", config.Storage.Backend, StorageBackends)
+	}
+
+	if config.Storage.Bucket == "" {
+		addProblem("storage.bucket (or the SESSIONS_BUCKET environment variable) is required")
+	}
+
+	config.Storage.Resilience.validate(addProblem)
+
+	for _, id := range config.applicationIDs() {
+		config.Applications[id].validate(id, addProblem)
+	}
+
+	if _, err := logrus.ParseLevel(config.Observability.LogLevel); err != nil {
+		addProblem("observability.logLevel (or the LOG_LEVEL environment variable) is not valid: %v", err)
+	}
+
+	if err := config.ObservabilityConfig().Validate(); err != nil {
+		addProblem("observability is not valid: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// ValidateServer returns a *ValidationError listing every problem with the settings only used by the serve command.
+// Other commands don't need these settings, so they are not checked by Validate.
+func (config *Config) ValidateServer() error {
+	var problems []string
+
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if config.Server.Port == "" {
+		addProblem("server.port (or the PORT environment variable) is required")
+	}
+
+	if config.Server.MetricsPort != "" && config.Server.MetricsPort == config.Server.Port {
+		addProblem("server.metricsPort (or the METRICS_PORT environment variable) must be different to server.port")
+	}
+
+	if config.Server.ProjectID == "" {
+		addProblem("server.projectId (or the GOOGLE_PROJECT environment variable) is required")
+	}
+
+	if config.Limits.MaxSessionRequestSize <= 0 {
+		addProblem("limits.maxSessionRequestSize must be a positive number of bytes")
+	}
+
+	if config.Limits.MaxTracesRequestSize <= 0 {
+		addProblem("limits.maxTracesRequestSize must be a positive number of bytes")
+	}
+
+	if config.Limits.MaxOptOutRequestsPerMinute <= 0 {
+		addProblem("limits.maxOptOutRequestsPerMinute must be a positive number")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func (r Resilience) validate(addProblem func(format string, args ...interface{})) {
+	if r.AttemptTimeout < 0 {
+		addProblem("storage.resilience.attemptTimeout must not be negative")
+	}
+
+	if r.MaxAttempts < 1 {
+		addProblem("storage.resilience.maxAttempts must be at least 1")
+	}
+
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		addProblem("storage.resilience.initialBackoff and storage.resilience.maxBackoff must not be negative")
+	}
+
+	if r.FailureThreshold < 1 {
+		addProblem("storage.resilience.failureThreshold must be at least 1")
+	}
+
+	if r.OpenDuration <= 0 {
+		addProblem("storage.resilience.openDuration must be a positive duration, such as '30s'")
+	}
+}
+
+func isKnownStorageBackend(backend StorageBackend) bool {
+	for _, known := range StorageBackends {
+		if backend == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ObservabilityConfig returns the settings used to configure logging and tracing.
+func (config *Config) ObservabilityConfig() observability.Config {
+	return observability.Config{
+		ServiceName:     config.Server.Name,
+		ServiceVersion:  config.Server.Version,
+		ProjectID:       config.Server.ProjectID,
+		Exporter:        config.Observability.TracesExporter,
+		HoneycombAPIKey: config.Observability.HoneycombAPIKey,
+		TracesFile:      config.Observability.TracesFile,
+	}
+}
+
+// ResilienceOptions returns the settings used to retry storing sessions and to configure the circuit breaker.
+func (config *Config) ResilienceOptions() storage.ResilienceOptions {
+	return storage.ResilienceOptions{
+		AttemptTimeout:   config.Storage.Resilience.AttemptTimeout,
+		MaxAttempts:      config.Storage.Resilience.MaxAttempts,
+		InitialBackoff:   config.Storage.Resilience.InitialBackoff,
+		MaxBackoff:       config.Storage.Resilience.MaxBackoff,
+		FailureThreshold: config.Storage.Resilience.FailureThreshold,
+		OpenDuration:     config.Storage.Resilience.OpenDuration,
+	}
+}
+
+// LogLevel returns the configured log level. It must only be called on a valid configuration.
+func (config *Config) LogLevel() logrus.Level {
+	level, err := logrus.ParseLevel(config.Observability.LogLevel)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return level
+}
@@ -0,0 +1,132 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Bundles are gzipped newline-delimited JSON files containing many sessions, one per line.
+
+const bundleContentType = "application/x-ndjson"
+const bundleSessionCountMetadataKey = "sessionCount"
+
+var errBundleMismatch = errors.New("bundle contents do not match the sessions written to it")
+
+type bundleWriter struct {
+	buffer     bytes.Buffer
+	sessionIDs []string
+}
+
+func (b *bundleWriter) Add(sessionID string, sessionJSON []byte) error {
+	if err := json.Compact(&b.buffer, sessionJSON); err != nil {
+		return fmt.Errorf("session %v is not valid JSON: %w", sessionID, err)
+	}
+
+	b.buffer.WriteByte('\n')
+	b.sessionIDs = append(b.sessionIDs, sessionID)
+
+	return nil
+}
+
+func (b *bundleWriter) Bytes() []byte {
+	return b.buffer.Bytes()
+}
+
+// readBundle calls fn with each session in the bundle in r. r must return the decompressed contents of the bundle.
+func readBundle(r io.Reader, fn func(sessionJSON []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading bundle failed: %w", err)
+	}
+
+	return nil
+}
+
+func verifyBundle(r io.Reader, expectedSessionIDs []string) error {
+	remaining := make(map[string]int, len(expectedSessionIDs))
+
+	for _, id := range expectedSessionIDs {
+		remaining[id]++
+	}
+
+	err := readBundle(r, func(sessionJSON []byte) error {
+		id, err := sessionIDFromJSON(sessionJSON)
+
+		if err != nil {
+			return err
+		}
+
+		if remaining[id] == 0 {
+			return fmt.Errorf("%w: unexpected session %v", errBundleMismatch, id)
+		}
+
+		remaining[id]--
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for id, count := range remaining {
+		if count > 0 {
+			return fmt.Errorf("%w: session %v is missing", errBundleMismatch, id)
+		}
+	}
+
+	return nil
+}
+
+func sessionIDFromJSON(sessionJSON []byte) (string, error) {
+	var header struct {
+		SessionID string `json:"sessionId"`
+	}
+
+	if err := json.Unmarshal(sessionJSON, &header); err != nil {
+		return "", fmt.Errorf("could not decode session: %w", err)
+	}
+
+	if header.SessionID == "" {
+		return "", fmt.Errorf("%w: session has no ID", errBundleMismatch)
+	}
+
+	return header.SessionID, nil
+}
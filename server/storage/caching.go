@@ -0,0 +1,124 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+)
+
+// CachingOptOutStore wraps another OptOutStore, remembering whether each user has opted out for a short time, so that
+// ingesting a session doesn't need to query the underlying store every time.
+//
+// Opt-outs recorded through the store are seen straight away. Opt-outs recorded elsewhere, such as by another instance of
+// the service, are seen once the cached result for that user expires.
+type CachingOptOutStore struct {
+	inner      OptOutStore
+	duration   time.Duration
+	timeSource func() time.Time
+
+	lock      sync.Mutex
+	entries   map[string]cachedOptOut
+	nextPrune time.Time
+}
+
+type cachedOptOut struct {
+	optedOut bool
+	expiry   time.Time
+}
+
+// NewCachingOptOutStore creates a store that caches the result of checking whether a user has opted out for duration.
+func NewCachingOptOutStore(inner OptOutStore, duration time.Duration) *CachingOptOutStore {
+	return NewCachingOptOutStoreWithTimeSource(inner, duration, time.Now)
+}
+
+func NewCachingOptOutStoreWithTimeSource(inner OptOutStore, duration time.Duration, timeSource func() time.Time) *CachingOptOutStore {
+	return &CachingOptOutStore{
+		inner:      inner,
+		duration:   duration,
+		timeSource: timeSource,
+		entries:    map[string]cachedOptOut{},
+	}
+}
+
+func (s *CachingOptOutStore) RecordOptOut(ctx context.Context, optOut *types.OptOut) error {
+	if err := s.inner.RecordOptOut(ctx, optOut); err != nil {
+		return err
+	}
+
+	s.remember(optOut.UserID, true)
+
+	return nil
+}
+
+// IsOptedOut returns the cached result for userID if it has not expired, and otherwise checks the underlying store.
+// Failures are not cached.
+func (s *CachingOptOutStore) IsOptedOut(ctx context.Context, userID string) (bool, error) {
+	if optedOut, ok := s.lookup(userID); ok {
+		return optedOut, nil
+	}
+
+	optedOut, err := s.inner.IsOptedOut(ctx, userID)
+
+	if err != nil {
+		return false, err
+	}
+
+	s.remember(userID, optedOut)
+
+	return optedOut, nil
+}
+
+func (s *CachingOptOutStore) lookup(userID string) (bool, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entry, ok := s.entries[userID]
+
+	if !ok || !s.timeSource().Before(entry.expiry) {
+		return false, false
+	}
+
+	return entry.optedOut, true
+}
+
+// remember caches optedOut for userID. Expired entries are removed at most once per cache duration, so that the cache
+// only holds the users seen recently.
+func (s *CachingOptOutStore) remember(userID string, optedOut bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.timeSource()
+
+	if !now.Before(s.nextPrune) {
+		for id, entry := range s.entries {
+			if !now.Before(entry.expiry) {
+				delete(s.entries, id)
+			}
+		}
+
+		s.nextPrune = now.Add(s.duration)
+	}
+
+	s.entries[userID] = cachedOptOut{optedOut: optedOut, expiry: now.Add(s.duration)}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("A caching opt-out store", func() {
+	var inner *scriptedOptOutStore
+	var store *storage.CachingOptOutStore
+	var currentTime time.Time
+
+	const userID = "11112222-3333-4444-a555-666677778888"
+	const otherUserID = "99990000-3333-4444-a555-666677778888"
+
+	BeforeEach(func() {
+		inner = &scriptedOptOutStore{}
+		currentTime = time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+
+		store = storage.NewCachingOptOutStoreWithTimeSource(inner, 30*time.Second, func() time.Time { return currentTime })
+	})
+
+	Context("when the user has been checked recently", func() {
+		BeforeEach(func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+			inner.optedOut = true
+			currentTime = currentTime.Add(29 * time.Second)
+		})
+
+		It("returns the cached result without querying the underlying store", func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+			Expect(inner.attempts).To(Equal(1))
+		})
+
+		It("queries the underlying store for other users", func() {
+			Expect(store.IsOptedOut(context.Background(), otherUserID)).To(BeTrue())
+			Expect(inner.attempts).To(Equal(2))
+		})
+	})
+
+	Context("when the cached result has expired", func() {
+		BeforeEach(func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+			inner.optedOut = true
+			currentTime = currentTime.Add(30 * time.Second)
+		})
+
+		It("returns the result from the underlying store", func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeTrue())
+			Expect(inner.attempts).To(Equal(2))
+		})
+	})
+
+	Context("when checking for an opt-out fails", func() {
+		checkError := errors.New("could not check for opt-out")
+
+		BeforeEach(func() {
+			inner.errors = []error{checkError}
+		})
+
+		It("returns the error", func() {
+			_, err := store.IsOptedOut(context.Background(), userID)
+			Expect(err).To(MatchError(checkError))
+		})
+
+		It("does not cache the failure", func() {
+			_, _ = store.IsOptedOut(context.Background(), userID)
+
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+			Expect(inner.attempts).To(Equal(2))
+		})
+	})
+
+	Context("when an opt-out is recorded for a user whose result is cached", func() {
+		BeforeEach(func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+			Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})).To(Succeed())
+		})
+
+		It("reports that the user has opted out without querying the underlying store", func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeTrue())
+			Expect(inner.attempts).To(Equal(2))
+		})
+	})
+
+	Context("when recording an opt-out fails", func() {
+		recordError := errors.New("could not record opt-out")
+
+		BeforeEach(func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+			inner.errors = []error{recordError}
+		})
+
+		It("returns the error", func() {
+			Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})).To(MatchError(recordError))
+		})
+
+		It("keeps the cached result", func() {
+			_ = store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})
+
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+		})
+	})
+})
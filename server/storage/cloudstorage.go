@@ -20,7 +20,6 @@
 package storage
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -28,6 +27,7 @@ import (
 	"net/http"
 
 	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/encryption"
 	"github.com/batect/abacus/server/types"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
@@ -35,10 +35,16 @@ import (
 
 type cloudStorageSessionStore struct {
 	client *cloudstorage.Client
-	bucket *cloudstorage.BucketHandle
+	sessionBucket
 }
 
 func NewCloudStorageSessionStore(bucketName string, opts ...option.ClientOption) (SessionStore, error) {
+	return NewEncryptedCloudStorageSessionStore(bucketName, nil, opts...)
+}
+
+// NewEncryptedCloudStorageSessionStore creates a session store that encrypts sessions with encryptor before uploading them.
+// Sessions are stored unencrypted if encryptor is nil.
+func NewEncryptedCloudStorageSessionStore(bucketName string, encryptor *encryption.Encryptor, opts ...option.ClientOption) (SessionStore, error) {
 	client, err := cloudstorage.NewClient(context.Background(), opts...)
 
 	if err != nil {
@@ -47,37 +53,27 @@ func NewCloudStorageSessionStore(bucketName string, opts ...option.ClientOption)
 
 	store := cloudStorageSessionStore{
 		client: client,
-		bucket: client.Bucket(bucketName),
+		sessionBucket: sessionBucket{
+			bucket:    client.Bucket(bucketName),
+			encryptor: encryptor,
+		},
 	}
 
 	return &store, nil
 }
 
 func (c *cloudStorageSessionStore) Store(ctx context.Context, session *types.Session) error {
-	w := c.bucket.
-		Object(fmt.Sprintf("v1/%v/%v/%v.json", session.ApplicationID, session.ApplicationVersion, session.SessionID)).
-		If(cloudstorage.Conditions{DoesNotExist: true}).
-		NewWriter(ctx)
-
-	w.ContentType = "application/json"
-	w.ContentEncoding = "gzip"
-	gzipper := gzip.NewWriter(w)
-
 	bytes, err := json.Marshal(session)
 
 	if err != nil {
 		return fmt.Errorf("converting session to JSON failed: %w", err)
 	}
 
-	if _, err := gzipper.Write(bytes); err != nil {
-		return fmt.Errorf("writing to Cloud Storage failed: %w", err)
-	}
+	object := c.bucket.
+		Object(c.sessionObjectName(session)).
+		If(cloudstorage.Conditions{DoesNotExist: true})
 
-	if err := gzipper.Close(); err != nil {
-		return fmt.Errorf("closing gzip stream failed: %w", err)
-	}
-
-	if err := w.Close(); err != nil {
+	if _, err := c.write(ctx, object, session.ApplicationID, "application/json", nil, bytes); err != nil {
 		var gerr *googleapi.Error
 
 		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
@@ -89,3 +85,36 @@ func (c *cloudStorageSessionStore) Store(ctx context.Context, session *types.Ses
 
 	return nil
 }
+
+const sessionObjectRootPrefix = "v1/"
+const bundleObjectRootPrefix = "v1-bundles/"
+const bundleDayFormat = "2006-01-02"
+
+func sessionObjectPrefix(applicationID string) string {
+	return fmt.Sprintf("%v%v/", sessionObjectRootPrefix, applicationID)
+}
+
+// Encrypted sessions are stored with a different extension to plaintext sessions, so that the BigQuery transfer (which only
+// loads objects ending in '.json') skips them rather than failing to parse their ciphertext.
+const sessionObjectSuffix = ".json"
+const encryptedSessionObjectSuffix = ".json.enc"
+
+var sessionObjectSuffixes = []string{sessionObjectSuffix, encryptedSessionObjectSuffix}
+
+func (b *sessionBucket) sessionObjectName(session *types.Session) string {
+	suffix := sessionObjectSuffix
+
+	if b.encryptor != nil {
+		suffix = encryptedSessionObjectSuffix
+	}
+
+	return fmt.Sprintf("%v%v/%v%v", sessionObjectPrefix(session.ApplicationID), session.ApplicationVersion, session.SessionID, suffix)
+}
+
+func bundleObjectPrefix(applicationID string) string {
+	return fmt.Sprintf("%v%v/", bundleObjectRootPrefix, applicationID)
+}
+
+func bundleObjectName(applicationID string, day string, bundleID string) string {
+	return fmt.Sprintf("%v%v/%v.ndjson", bundleObjectPrefix(applicationID), day, bundleID)
+}
@@ -0,0 +1,159 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/types"
+)
+
+func (c *cloudStorageSessionStore) DeleteSessions(
+	ctx context.Context,
+	applicationID string,
+	shouldDelete func(session *types.Session) bool,
+) (DeletionResult, error) {
+	result := DeletionResult{}
+	sessionObjects, err := c.list(ctx, sessionObjectPrefix(applicationID), sessionObjectSuffixes...)
+
+	if err != nil {
+		return result, fmt.Errorf("could not list sessions for application %v: %w", applicationID, err)
+	}
+
+	for _, attrs := range sessionObjects {
+		sessionJSON, err := c.read(ctx, attrs, applicationID)
+
+		if err != nil {
+			return result, err
+		}
+
+		session, err := decodeSession(sessionJSON)
+
+		if err != nil {
+			return result, fmt.Errorf("could not decode session object %v: %w", attrs.Name, err)
+		}
+
+		result.SessionsScanned++
+
+		if !shouldDelete(session) {
+			continue
+		}
+
+		if err := c.delete(ctx, attrs); err != nil {
+			return result, err
+		}
+
+		result.SessionsDeleted++
+	}
+
+	bundles, err := c.list(ctx, bundleObjectPrefix(applicationID), ".ndjson")
+
+	if err != nil {
+		return result, fmt.Errorf("could not list bundles for application %v: %w", applicationID, err)
+	}
+
+	for _, attrs := range bundles {
+		bundleResult, err := c.deleteSessionsFromBundle(ctx, applicationID, attrs, shouldDelete)
+		result.SessionsScanned += bundleResult.SessionsScanned
+		result.SessionsDeleted += bundleResult.SessionsDeleted
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// deleteSessionsFromBundle replaces the bundle with a new bundle containing only the sessions that should be kept.
+func (c *cloudStorageSessionStore) deleteSessionsFromBundle(
+	ctx context.Context,
+	applicationID string,
+	attrs *cloudstorage.ObjectAttrs,
+	shouldDelete func(session *types.Session) bool,
+) (DeletionResult, error) {
+	result := DeletionResult{}
+	content, err := c.read(ctx, attrs, applicationID)
+
+	if err != nil {
+		return result, err
+	}
+
+	type keptSession struct {
+		id   string
+		json []byte
+	}
+
+	sessionsToKeep := []keptSession{}
+
+	err = readBundle(bytes.NewReader(content), func(sessionJSON []byte) error {
+		session, err := decodeSession(sessionJSON)
+
+		if err != nil {
+			return fmt.Errorf("could not decode session in bundle %v: %w", attrs.Name, err)
+		}
+
+		result.SessionsScanned++
+
+		if shouldDelete(session) {
+			result.SessionsDeleted++
+		} else {
+			sessionsToKeep = append(sessionsToKeep, keptSession{session.SessionID, append([]byte(nil), sessionJSON...)})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return DeletionResult{}, err
+	}
+
+	if result.SessionsDeleted == 0 {
+		return result, nil
+	}
+
+	if len(sessionsToKeep) > 0 {
+		day := path.Base(path.Dir(attrs.Name))
+
+		_, _, err := c.storeBundle(ctx, applicationID, day, func(bw *bundleWriter) error {
+			for _, session := range sessionsToKeep {
+				if err := bw.Add(session.id, session.json); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return DeletionResult{}, err
+		}
+	}
+
+	if err := c.delete(ctx, attrs); err != nil {
+		return DeletionResult{}, err
+	}
+
+	return result, nil
+}
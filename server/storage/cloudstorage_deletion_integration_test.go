@@ -0,0 +1,183 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"context"
+	"time"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+)
+
+var _ = Describe("Deleting sessions from Cloud Storage", func() {
+	var bucket *cloudstorage.BucketHandle
+	var bucketName string
+	var store storage.SessionStore
+	var deleter storage.SessionDeleter
+	var opts []option.ClientOption
+
+	createSession := func(applicationID string, sessionID string, startTime time.Time) *types.Session {
+		return &types.Session{
+			SessionID:          sessionID,
+			UserID:             "99990000-3333-4444-5555-666677778888",
+			SessionStartTime:   startTime,
+			SessionEndTime:     startTime.Add(time.Hour),
+			IngestionTime:      startTime.Add(2 * time.Hour),
+			ApplicationID:      applicationID,
+			ApplicationVersion: "1.0.0",
+			Attributes:         map[string]interface{}{},
+			Events:             []types.Event{},
+			Spans:              []types.Span{},
+		}
+	}
+
+	oldSession := createSession("my-app", "11112222-3333-4444-5555-000000000001", time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC))
+	newSession := createSession("my-app", "11112222-3333-4444-5555-000000000002", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	otherAppSession := createSession("other-app", "11112222-3333-4444-5555-000000000003", time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC))
+	cutoff := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	shouldDelete := func(session *types.Session) bool {
+		return session.SessionStartTime.Before(cutoff)
+	}
+
+	BeforeEach(func() {
+		project := "my-project"
+		bucketName = "test-bucket-" + uuid.New().String()
+
+		opts = []option.ClientOption{
+			option.WithEndpoint("http://cloud-storage/storage/v1/"),
+		}
+
+		client, err := cloudstorage.NewClient(context.Background(), opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		bucket = client.Bucket(bucketName)
+		err = bucket.Create(context.Background(), project, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		store, err = storage.NewCloudStorageSessionStore(bucketName, opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		var ok bool
+		deleter, ok = store.(storage.SessionDeleter)
+		Expect(ok).To(BeTrue())
+
+		for _, session := range []*types.Session{oldSession, newSession, otherAppSession} {
+			Expect(store.Store(context.Background(), session)).To(Succeed())
+		}
+	})
+
+	Describe("given the sessions are stored as individual objects", func() {
+		var result storage.DeletionResult
+
+		BeforeEach(func() {
+			var err error
+			result, err = deleter.DeleteSessions(context.Background(), "my-app", shouldDelete)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("reports the number of sessions scanned and deleted", func() {
+			Expect(result).To(Equal(storage.DeletionResult{SessionsScanned: 2, SessionsDeleted: 1}))
+		})
+
+		It("deletes only the matching sessions for the application", func() {
+			Expect(objectNames(bucket, "v1/")).To(ConsistOf(
+				"v1/my-app/1.0.0/11112222-3333-4444-5555-000000000002.json",
+				"v1/other-app/1.0.0/11112222-3333-4444-5555-000000000003.json",
+			))
+		})
+	})
+
+	Describe("given the sessions have been compacted into a bundle", func() {
+		var result storage.DeletionResult
+
+		BeforeEach(func() {
+			timeSource := func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+			compactor, err := storage.NewCloudStorageCompactorWithTimeSource(bucketName, 0, nil, timeSource, opts...)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = compactor.Compact(context.Background(), "my-app")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		Context("when some of the sessions in the bundle should be deleted", func() {
+			BeforeEach(func() {
+				var err error
+				result, err = deleter.DeleteSessions(context.Background(), "my-app", shouldDelete)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("reports the number of sessions scanned and deleted", func() {
+				Expect(result).To(Equal(storage.DeletionResult{SessionsScanned: 2, SessionsDeleted: 1}))
+			})
+
+			It("replaces the bundle with one containing only the remaining sessions", func() {
+				names := objectNames(bucket, "v1-bundles/")
+				Expect(names).To(HaveLen(1))
+
+				Expect(bucket.Object(names[0])).To(HaveContent(WithTransform(nonEmptyLines, ConsistOf(
+					MatchJSON(sessionJSON(newSession)),
+				))))
+			})
+		})
+
+		Context("when all of the sessions in the bundle should be deleted", func() {
+			BeforeEach(func() {
+				var err error
+				result, err = deleter.DeleteSessions(context.Background(), "my-app", func(*types.Session) bool { return true })
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("reports the number of sessions scanned and deleted", func() {
+				Expect(result).To(Equal(storage.DeletionResult{SessionsScanned: 2, SessionsDeleted: 2}))
+			})
+
+			It("deletes the bundle", func() {
+				Expect(objectNames(bucket, "v1-bundles/")).To(BeEmpty())
+			})
+		})
+
+		Context("when none of the sessions in the bundle should be deleted", func() {
+			var originalNames []string
+
+			BeforeEach(func() {
+				originalNames = objectNames(bucket, "v1-bundles/")
+
+				var err error
+				result, err = deleter.DeleteSessions(context.Background(), "my-app", func(*types.Session) bool { return false })
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("reports the number of sessions scanned", func() {
+				Expect(result).To(Equal(storage.DeletionResult{SessionsScanned: 2, SessionsDeleted: 0}))
+			})
+
+			It("leaves the bundle untouched", func() {
+				Expect(objectNames(bucket, "v1-bundles/")).To(Equal(originalNames))
+			})
+		})
+	})
+})
@@ -0,0 +1,49 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// healthCheckObjectPrefix is never used for any objects, so listing it is cheap, but still requires the bucket to exist and
+// the service's credentials to be able to list objects in it.
+const healthCheckObjectPrefix = "health-check/"
+
+func checkBucketHealth(ctx context.Context, bucket *cloudstorage.BucketHandle) error {
+	if _, err := bucket.Objects(ctx, &cloudstorage.Query{Prefix: healthCheckObjectPrefix}).Next(); err != nil && !errors.Is(err, iterator.Done) {
+		return fmt.Errorf("could not list objects in Cloud Storage bucket: %w", err)
+	}
+
+	return nil
+}
+
+func (c *cloudStorageSessionStore) CheckHealth(ctx context.Context) error {
+	return checkBucketHealth(ctx, c.bucket)
+}
+
+func (c *CloudStorageOptOutStore) CheckHealth(ctx context.Context) error {
+	return checkBucketHealth(ctx, c.bucket)
+}
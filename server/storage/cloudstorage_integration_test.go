@@ -48,6 +48,8 @@ var _ = Describe("Saving sessions to Cloud Storage", func() {
 		IngestionTime:      time.Date(2019, 1, 2, 20, 4, 5, 678000000, time.UTC),
 		ApplicationID:      "my-app",
 		ApplicationVersion: "1.0.0",
+		ParentSessionID:    "aaaa2222-3333-4444-5555-666677778888",
+		TraceID:            "bbbb2222-3333-4444-5555-666677778888",
 		Attributes: map[string]interface{}{
 			"operatingSystem": "Mac",
 			"dockerVersion":   "19.3.5",
@@ -93,6 +95,8 @@ var _ = Describe("Saving sessions to Cloud Storage", func() {
 		"ingestionTime": "2019-01-02T20:04:05.678Z",
 		"applicationId": "my-app",
 		"applicationVersion": "1.0.0",
+		"parentSessionId": "aaaa2222-3333-4444-5555-666677778888",
+		"traceId": "bbbb2222-3333-4444-5555-666677778888",
 		"attributes": {
 			"operatingSystem": "Mac",
 			"dockerVersion": "19.3.5",
@@ -206,6 +210,86 @@ var _ = Describe("Saving sessions to Cloud Storage", func() {
 			Expect(bucket.Object("v1/my-app/1.0.0/11112222-3333-4444-5555-666677778888.json")).To(HaveContent(MatchJSON(expectedJSON)))
 		})
 	})
+
+	Describe("given the session has spans with IDs and events that reference them", func() {
+		BeforeEach(func() {
+			sessionWithSpanIDs := &types.Session{
+				SessionID:          "22223333-3333-4444-5555-666677778888",
+				UserID:             "99990000-3333-4444-5555-666677778888",
+				SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+				SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+				IngestionTime:      time.Date(2019, 1, 2, 20, 4, 5, 678000000, time.UTC),
+				ApplicationID:      "my-app",
+				ApplicationVersion: "1.0.0",
+				Attributes:         map[string]interface{}{},
+				Events: []types.Event{
+					{
+						Type:       "ThingHappened",
+						Time:       time.Date(2019, 1, 2, 3, 4, 7, 678000000, time.UTC),
+						SpanID:     "0000000000000002",
+						Attributes: map[string]interface{}{},
+					},
+				},
+				Spans: []types.Span{
+					{
+						ID:         "0000000000000002",
+						ParentID:   "0000000000000001",
+						Type:       "LoadingOneThing",
+						StartTime:  time.Date(2019, 1, 2, 3, 4, 7, 678000000, time.UTC),
+						EndTime:    time.Date(2019, 1, 2, 3, 4, 8, 0, time.UTC),
+						Attributes: map[string]interface{}{},
+					},
+					{
+						ID:         "0000000000000001",
+						Type:       "LoadingThings",
+						StartTime:  time.Date(2019, 1, 2, 3, 4, 7, 678000000, time.UTC),
+						EndTime:    time.Date(2019, 1, 2, 3, 4, 8, 678000000, time.UTC),
+						Attributes: map[string]interface{}{},
+					},
+				},
+			}
+
+			Expect(store.Store(context.Background(), sessionWithSpanIDs)).To(Succeed())
+		})
+
+		It("stores the span IDs, parent span IDs and event span references", func() {
+			Expect(bucket.Object("v1/my-app/1.0.0/22223333-3333-4444-5555-666677778888.json")).To(HaveContent(MatchJSON(`{
+				"sessionId": "22223333-3333-4444-5555-666677778888",
+				"userId": "99990000-3333-4444-5555-666677778888",
+				"sessionStartTime": "2019-01-02T03:04:05.678Z",
+				"sessionEndTime": "2019-01-02T09:04:05.678Z",
+				"ingestionTime": "2019-01-02T20:04:05.678Z",
+				"applicationId": "my-app",
+				"applicationVersion": "1.0.0",
+				"attributes": {},
+				"events": [
+					{
+						"type": "ThingHappened",
+						"time": "2019-01-02T03:04:07.678Z",
+						"spanId": "0000000000000002",
+						"attributes": {}
+					}
+				],
+				"spans": [
+					{
+						"id": "0000000000000002",
+						"parentId": "0000000000000001",
+						"type": "LoadingOneThing",
+						"startTime": "2019-01-02T03:04:07.678Z",
+						"endTime": "2019-01-02T03:04:08Z",
+						"attributes": {}
+					},
+					{
+						"id": "0000000000000001",
+						"type": "LoadingThings",
+						"startTime": "2019-01-02T03:04:07.678Z",
+						"endTime": "2019-01-02T03:04:08.678Z",
+						"attributes": {}
+					}
+				]
+			}`)))
+		})
+	})
 })
 
 type haveContentMatcher struct {
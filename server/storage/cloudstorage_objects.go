@@ -0,0 +1,242 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/encryption"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+)
+
+// sessionBucket provides access to the objects in the sessions bucket, compressing and (if an encryptor is configured) encrypting
+// their contents on the way in, and reversing this on the way out.
+type sessionBucket struct {
+	bucket    *cloudstorage.BucketHandle
+	encryptor *encryption.Encryptor
+}
+
+const encryptedContentType = "application/octet-stream"
+const plaintextContentTypeMetadataKey = "plaintextContentType"
+
+var ErrEncryptionNotConfigured = errors.New("object is encrypted, but no encryption keys are configured")
+
+func (b *sessionBucket) write(
+	ctx context.Context,
+	object *cloudstorage.ObjectHandle,
+	applicationID string,
+	contentType string,
+	metadata map[string]string,
+	payload []byte,
+) (*cloudstorage.ObjectAttrs, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	compressed := &bytes.Buffer{}
+	gzipper := gzip.NewWriter(compressed)
+
+	if _, err := gzipper.Write(payload); err != nil {
+		return nil, fmt.Errorf("compressing %v failed: %w", object.ObjectName(), err)
+	}
+
+	if err := gzipper.Close(); err != nil {
+		return nil, fmt.Errorf("closing gzip stream failed: %w", err)
+	}
+
+	w := object.NewWriter(ctx)
+	w.Metadata = map[string]string{}
+
+	for k, v := range metadata {
+		w.Metadata[k] = v
+	}
+
+	content := compressed.Bytes()
+
+	if b.encryptor == nil {
+		w.ContentType = contentType
+		w.ContentEncoding = "gzip"
+	} else {
+		ciphertext, encryptionMetadata, err := b.encryptor.Encrypt(ctx, applicationID, object.ObjectName(), content)
+
+		if err != nil {
+			return nil, fmt.Errorf("encrypting %v failed: %w", object.ObjectName(), err)
+		}
+
+		for k, v := range encryptionMetadata {
+			w.Metadata[k] = v
+		}
+
+		w.ContentType = encryptedContentType
+		w.Metadata[plaintextContentTypeMetadataKey] = contentType
+		content = ciphertext
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return nil, fmt.Errorf("writing to Cloud Storage failed: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return w.Attrs(), nil
+}
+
+// read returns the decrypted and decompressed contents of the object described by attrs.
+func (b *sessionBucket) read(ctx context.Context, attrs *cloudstorage.ObjectAttrs, applicationID string) ([]byte, error) {
+	r, err := b.bucket.Object(attrs.Name).Generation(attrs.Generation).ReadCompressed(true).NewReader(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open object %v: %w", attrs.Name, err)
+	}
+
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read object %v: %w", attrs.Name, err)
+	}
+
+	if encryption.IsEncrypted(attrs.Metadata) {
+		if b.encryptor == nil {
+			return nil, fmt.Errorf("could not read object %v: %w", attrs.Name, ErrEncryptionNotConfigured)
+		}
+
+		content, err = b.encryptor.Decrypt(ctx, applicationID, attrs.Name, content, attrs.Metadata)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt object %v: %w", attrs.Name, err)
+		}
+	} else if attrs.ContentEncoding != "gzip" {
+		return content, nil
+	}
+
+	return decompress(attrs.Name, content)
+}
+
+// delete deletes the object described by attrs, provided it has not been replaced since attrs was retrieved.
+func (b *sessionBucket) delete(ctx context.Context, attrs *cloudstorage.ObjectAttrs) error {
+	err := b.bucket.Object(attrs.Name).If(cloudstorage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+
+	if err != nil && !errors.Is(err, cloudstorage.ErrObjectNotExist) {
+		return fmt.Errorf("could not delete object %v: %w", attrs.Name, err)
+	}
+
+	return nil
+}
+
+// list returns all objects with names that start with prefix and end with one of suffixes.
+func (b *sessionBucket) list(ctx context.Context, prefix string, suffixes ...string) ([]*cloudstorage.ObjectAttrs, error) {
+	it := b.bucket.Objects(ctx, &cloudstorage.Query{Prefix: prefix})
+	objects := []*cloudstorage.ObjectAttrs{}
+
+	for {
+		attrs, err := it.Next()
+
+		if errors.Is(err, iterator.Done) {
+			return objects, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("listing objects failed: %w", err)
+		}
+
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(attrs.Name, suffix) {
+				objects = append(objects, attrs)
+				break
+			}
+		}
+	}
+}
+
+func (b *sessionBucket) applicationIDs(ctx context.Context) ([]string, error) {
+	it := b.bucket.Objects(ctx, &cloudstorage.Query{Prefix: sessionObjectRootPrefix, Delimiter: "/"})
+	applicationIDs := []string{}
+
+	for {
+		attrs, err := it.Next()
+
+		if errors.Is(err, iterator.Done) {
+			return applicationIDs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("listing applications failed: %w", err)
+		}
+
+		if attrs.Prefix != "" {
+			applicationIDs = append(applicationIDs, strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, sessionObjectRootPrefix), "/"))
+		}
+	}
+}
+
+// storeBundle writes a new bundle containing the sessions added by fill, then reads it back to check that it contains exactly those sessions.
+// It returns the name of the new bundle and the number of sessions in it.
+func (b *sessionBucket) storeBundle(ctx context.Context, applicationID string, day string, fill func(bw *bundleWriter) error) (string, int, error) {
+	bw := &bundleWriter{}
+
+	if err := fill(bw); err != nil {
+		return "", 0, err
+	}
+
+	object := b.bucket.Object(bundleObjectName(applicationID, day, uuid.New().String())).If(cloudstorage.Conditions{DoesNotExist: true})
+	metadata := map[string]string{bundleSessionCountMetadataKey: fmt.Sprint(len(bw.sessionIDs))}
+	attrs, err := b.write(ctx, object, applicationID, bundleContentType, metadata, bw.Bytes())
+
+	if err != nil {
+		return "", 0, fmt.Errorf("storing bundle %v in Cloud Storage failed: %w", object.ObjectName(), err)
+	}
+
+	content, err := b.read(ctx, attrs, applicationID)
+
+	if err != nil {
+		return "", 0, fmt.Errorf("could not read back bundle %v: %w", attrs.Name, err)
+	}
+
+	if err := verifyBundle(bytes.NewReader(content), bw.sessionIDs); err != nil {
+		return "", 0, fmt.Errorf("verifying bundle %v failed: %w", attrs.Name, err)
+	}
+
+	return attrs.Name, len(bw.sessionIDs), nil
+}
+
+func decompress(name string, content []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress object %v: %w", name, err)
+	}
+
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress object %v: %w", name, err)
+	}
+
+	return decompressed, nil
+}
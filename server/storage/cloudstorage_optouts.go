@@ -0,0 +1,195 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/types"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Opt-outs are stored in the sessions bucket, outside of the prefixes used for sessions and bundles.
+// They are not encrypted, as they are not specific to an application, and contain nothing but the user's ID.
+const optOutObjectRootPrefix = "opt-outs/v1/"
+
+// maxOptOutWriteAttempts limits how many times recording an opt-out is tried when the user's opt-out is changed by
+// another request at the same time.
+const maxOptOutWriteAttempts = 5
+
+// CloudStorageOptOutStore is an OptOutStore and OptOutReader backed by Cloud Storage.
+type CloudStorageOptOutStore struct {
+	bucket *cloudstorage.BucketHandle
+}
+
+// NewCloudStorageOptOutStore creates an opt-out store that stores opt-outs in the bucket bucketName, alongside sessions.
+func NewCloudStorageOptOutStore(bucketName string, opts ...option.ClientOption) (*CloudStorageOptOutStore, error) {
+	client, err := cloudstorage.NewClient(context.Background(), opts...)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create Cloud Storage client: %w", err)
+	}
+
+	return &CloudStorageOptOutStore{bucket: client.Bucket(bucketName)}, nil
+}
+
+func optOutObjectName(userID string) string {
+	return fmt.Sprintf("%v%v.json", optOutObjectRootPrefix, userID)
+}
+
+// RecordOptOut records optOut, merging it with any existing opt-out for the same user.
+// If the user's opt-out is changed by another request between reading and writing it, the opt-out is read, merged and
+// written again, so that concurrent opt-outs for the same user don't fail.
+func (c *CloudStorageOptOutStore) RecordOptOut(ctx context.Context, optOut *types.OptOut) error {
+	for attempt := 1; ; attempt++ {
+		err := c.recordOptOut(ctx, optOut)
+
+		var gerr *googleapi.Error
+
+		if attempt == maxOptOutWriteAttempts || !errors.As(err, &gerr) || gerr.Code != http.StatusPreconditionFailed {
+			return err
+		}
+	}
+}
+
+func (c *CloudStorageOptOutStore) recordOptOut(ctx context.Context, optOut *types.OptOut) error {
+	existing, generation, err := c.read(ctx, optOutObjectName(optOut.UserID))
+
+	if err != nil {
+		return err
+	}
+
+	merged := *optOut
+	conditions := cloudstorage.Conditions{DoesNotExist: true}
+
+	if existing != nil {
+		if existing.OptOutTime.Before(merged.OptOutTime) {
+			merged.OptOutTime = existing.OptOutTime
+		}
+
+		merged.DeleteExistingSessions = merged.DeleteExistingSessions || existing.DeleteExistingSessions
+
+		if merged.OptOutTime.Equal(existing.OptOutTime) && merged.DeleteExistingSessions == existing.DeleteExistingSessions {
+			return nil
+		}
+
+		conditions = cloudstorage.Conditions{GenerationMatch: generation}
+	}
+
+	bytes, err := json.Marshal(merged)
+
+	if err != nil {
+		return fmt.Errorf("converting opt-out to JSON failed: %w", err)
+	}
+
+	w := c.bucket.Object(optOutObjectName(optOut.UserID)).If(conditions).NewWriter(ctx)
+	w.ContentType = "application/json"
+
+	if _, err := w.Write(bytes); err != nil {
+		return fmt.Errorf("writing opt-out to Cloud Storage failed: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("storing opt-out in Cloud Storage failed: %w", err)
+	}
+
+	return nil
+}
+
+func (c *CloudStorageOptOutStore) IsOptedOut(ctx context.Context, userID string) (bool, error) {
+	_, err := c.bucket.Object(optOutObjectName(userID)).Attrs(ctx)
+
+	if errors.Is(err, cloudstorage.ErrObjectNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not check for opt-out: %w", err)
+	}
+
+	return true, nil
+}
+
+func (c *CloudStorageOptOutStore) ReadOptOuts(ctx context.Context, fn func(optOut *types.OptOut) error) error {
+	it := c.bucket.Objects(ctx, &cloudstorage.Query{Prefix: optOutObjectRootPrefix})
+
+	for {
+		attrs, err := it.Next()
+
+		if errors.Is(err, iterator.Done) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("listing opt-outs failed: %w", err)
+		}
+
+		if !strings.HasSuffix(attrs.Name, ".json") {
+			continue
+		}
+
+		optOut, _, err := c.read(ctx, attrs.Name)
+
+		if err != nil {
+			return err
+		}
+
+		// The opt-out may have been deleted since it was listed.
+		if optOut == nil {
+			continue
+		}
+
+		if err := fn(optOut); err != nil {
+			return err
+		}
+	}
+}
+
+// read returns the opt-out stored in the object called name and the object's generation, or nil if there is no such object.
+func (c *CloudStorageOptOutStore) read(ctx context.Context, name string) (*types.OptOut, int64, error) {
+	r, err := c.bucket.Object(name).NewReader(ctx)
+
+	if errors.Is(err, cloudstorage.ErrObjectNotExist) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("could not open opt-out %v: %w", name, err)
+	}
+
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not read opt-out %v: %w", name, err)
+	}
+
+	optOut := &types.OptOut{}
+
+	if err := json.Unmarshal(content, optOut); err != nil {
+		return nil, 0, fmt.Errorf("could not decode opt-out %v: %w", name, err)
+	}
+
+	return optOut, r.Attrs.Generation, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+)
+
+var _ = Describe("Storing opt-outs in Cloud Storage", func() {
+	var bucket *cloudstorage.BucketHandle
+	var store *storage.CloudStorageOptOutStore
+
+	userID := "99990000-3333-4444-a555-666677778888"
+	firstOptOutTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	secondOptOutTime := time.Date(2019, 2, 2, 3, 4, 5, 0, time.UTC)
+
+	BeforeEach(func() {
+		project := "my-project"
+		bucketName := "test-bucket-" + uuid.New().String()
+
+		// Note that we also have to set the STORAGE_EMULATOR_HOST environment variable so that object downloads
+		// are done from the correct host and over HTTP (rather than HTTPS).
+		opts := []option.ClientOption{
+			option.WithEndpoint("http://cloud-storage/storage/v1/"),
+		}
+
+		client, err := cloudstorage.NewClient(context.Background(), opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		bucket = client.Bucket(bucketName)
+		err = bucket.Create(context.Background(), project, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		store, err = storage.NewCloudStorageOptOutStore(bucketName, opts...)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	readOptOuts := func() []types.OptOut {
+		optOuts := []types.OptOut{}
+
+		err := store.ReadOptOuts(context.Background(), func(optOut *types.OptOut) error {
+			optOuts = append(optOuts, *optOut)
+			return nil
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+
+		return optOuts
+	}
+
+	Context("given the user has not opted out", func() {
+		It("reports that the user has not opted out", func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeFalse())
+		})
+
+		It("has no opt-outs to read", func() {
+			Expect(readOptOuts()).To(BeEmpty())
+		})
+	})
+
+	Context("given the user has opted out", func() {
+		BeforeEach(func() {
+			Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID, OptOutTime: firstOptOutTime})).To(Succeed())
+		})
+
+		It("stores the opt-out in the bucket at the expected path", func() {
+			Expect(bucket.Object("opt-outs/v1/" + userID + ".json")).To(HaveContent(MatchJSON(`{
+				"userId": "99990000-3333-4444-a555-666677778888",
+				"deleteExistingSessions": false,
+				"optOutTime": "2019-01-02T03:04:05Z"
+			}`)))
+		})
+
+		It("reports that the user has opted out", func() {
+			Expect(store.IsOptedOut(context.Background(), userID)).To(BeTrue())
+		})
+
+		It("reports that other users have not opted out", func() {
+			Expect(store.IsOptedOut(context.Background(), "11110000-3333-4444-a555-666677778888")).To(BeFalse())
+		})
+
+		It("can read the opt-out back", func() {
+			Expect(readOptOuts()).To(ConsistOf(types.OptOut{UserID: userID, OptOutTime: firstOptOutTime}))
+		})
+
+		Context("given the user opts out again and asks for their existing sessions to be deleted", func() {
+			BeforeEach(func() {
+				Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID, OptOutTime: secondOptOutTime, DeleteExistingSessions: true})).To(Succeed())
+			})
+
+			It("keeps the original opt-out time and records the request to delete existing sessions", func() {
+				Expect(readOptOuts()).To(ConsistOf(types.OptOut{UserID: userID, OptOutTime: firstOptOutTime, DeleteExistingSessions: true}))
+			})
+
+			Context("given the user opts out a third time without asking for their existing sessions to be deleted", func() {
+				BeforeEach(func() {
+					Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID, OptOutTime: secondOptOutTime})).To(Succeed())
+				})
+
+				It("still records the request to delete existing sessions", func() {
+					Expect(readOptOuts()).To(ConsistOf(types.OptOut{UserID: userID, OptOutTime: firstOptOutTime, DeleteExistingSessions: true}))
+				})
+			})
+		})
+	})
+
+	Context("given the user opts out from several requests at the same time", func() {
+		var errs []error
+
+		BeforeEach(func() {
+			optOuts := []types.OptOut{
+				{UserID: userID, OptOutTime: secondOptOutTime},
+				{UserID: userID, OptOutTime: firstOptOutTime},
+				{UserID: userID, OptOutTime: secondOptOutTime, DeleteExistingSessions: true},
+			}
+
+			errs = make([]error, len(optOuts))
+
+			var wg sync.WaitGroup
+
+			for i := range optOuts {
+				wg.Add(1)
+
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = store.RecordOptOut(context.Background(), &optOuts[i])
+				}(i)
+			}
+
+			wg.Wait()
+		})
+
+		It("records every opt-out without error", func() {
+			Expect(errs).To(HaveEach(BeNil()))
+		})
+
+		It("merges all of the opt-outs", func() {
+			Expect(readOptOuts()).To(ConsistOf(types.OptOut{UserID: userID, OptOutTime: firstOptOutTime, DeleteExistingSessions: true}))
+		})
+	})
+})
@@ -0,0 +1,95 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/batect/abacus/server/decoding"
+	"github.com/batect/abacus/server/types"
+)
+
+func (c *cloudStorageSessionStore) ReadSessions(ctx context.Context, applicationID string, fn func(session *types.Session) error) error {
+	objects, err := c.list(ctx, sessionObjectPrefix(applicationID), sessionObjectSuffixes...)
+
+	if err != nil {
+		return fmt.Errorf("could not list sessions for application %v: %w", applicationID, err)
+	}
+
+	for _, attrs := range objects {
+		sessionJSON, err := c.read(ctx, attrs, applicationID)
+
+		if err != nil {
+			return err
+		}
+
+		session, err := decodeSession(sessionJSON)
+
+		if err != nil {
+			return fmt.Errorf("could not decode session object %v: %w", attrs.Name, err)
+		}
+
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+
+	bundles, err := c.list(ctx, bundleObjectPrefix(applicationID), ".ndjson")
+
+	if err != nil {
+		return fmt.Errorf("could not list bundles for application %v: %w", applicationID, err)
+	}
+
+	for _, attrs := range bundles {
+		content, err := c.read(ctx, attrs, applicationID)
+
+		if err != nil {
+			return err
+		}
+
+		err = readBundle(bytes.NewReader(content), func(sessionJSON []byte) error {
+			session, err := decodeSession(sessionJSON)
+
+			if err != nil {
+				return fmt.Errorf("could not decode session in bundle %v: %w", attrs.Name, err)
+			}
+
+			return fn(session)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeSession(sessionJSON []byte) (*types.Session, error) {
+	session := &types.Session{}
+
+	if err := decoding.NewJSONDecoder(bytes.NewReader(sessionJSON)).Decode(session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/encryption"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+)
+
+var _ = Describe("Reading sessions from Cloud Storage", func() {
+	var bucket *cloudstorage.BucketHandle
+	var bucketName string
+	var opts []option.ClientOption
+
+	createSession := func(applicationID string, sessionID string) *types.Session {
+		return &types.Session{
+			SessionID:          sessionID,
+			UserID:             "99990000-3333-4444-5555-666677778888",
+			SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+			SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+			IngestionTime:      time.Date(2019, 1, 2, 20, 4, 5, 678000000, time.UTC),
+			ApplicationID:      applicationID,
+			ApplicationVersion: "1.0.0",
+			Attributes:         map[string]interface{}{"operatingSystem": "Mac"},
+			Events:             []types.Event{},
+			Spans:              []types.Span{},
+		}
+	}
+
+	firstSession := createSession("my-app", "11112222-3333-4444-5555-000000000001")
+	secondSession := createSession("my-app", "11112222-3333-4444-5555-000000000002")
+	otherAppSession := createSession("other-app", "11112222-3333-4444-5555-000000000003")
+
+	readSessions := func(store storage.SessionStore, applicationID string) ([]types.Session, error) {
+		reader, ok := store.(storage.SessionReader)
+		Expect(ok).To(BeTrue())
+
+		sessions := []types.Session{}
+
+		err := reader.ReadSessions(context.Background(), applicationID, func(session *types.Session) error {
+			sessions = append(sessions, *session)
+
+			return nil
+		})
+
+		return sessions, err
+	}
+
+	compact := func(encryptor *encryption.Encryptor) {
+		timeSource := func() time.Time { return time.Now().Add(3 * 24 * time.Hour) }
+		compactor, err := storage.NewCloudStorageCompactorWithTimeSource(bucketName, 0, encryptor, timeSource, opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = compactor.Compact(context.Background(), "my-app")
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		project := "my-project"
+		bucketName = "test-bucket-" + uuid.New().String()
+
+		opts = []option.ClientOption{
+			option.WithEndpoint("http://cloud-storage/storage/v1/"),
+		}
+
+		client, err := cloudstorage.NewClient(context.Background(), opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		bucket = client.Bucket(bucketName)
+		err = bucket.Create(context.Background(), project, nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("given the sessions are not encrypted", func() {
+		var store storage.SessionStore
+
+		BeforeEach(func() {
+			var err error
+			store, err = storage.NewCloudStorageSessionStore(bucketName, opts...)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, session := range []*types.Session{firstSession, secondSession, otherAppSession} {
+				Expect(store.Store(context.Background(), session)).To(Succeed())
+			}
+		})
+
+		Context("when the sessions are stored as individual objects", func() {
+			It("returns all sessions for the application", func() {
+				Expect(readSessions(store, "my-app")).To(ConsistOf(*firstSession, *secondSession))
+			})
+		})
+
+		Context("when the sessions have been compacted into a bundle", func() {
+			BeforeEach(func() {
+				compact(nil)
+			})
+
+			It("returns all sessions for the application", func() {
+				Expect(readSessions(store, "my-app")).To(ConsistOf(*firstSession, *secondSession))
+			})
+		})
+	})
+
+	Describe("given the sessions are encrypted", func() {
+		var store storage.SessionStore
+		var encryptor *encryption.Encryptor
+		key := bytes.Repeat([]byte{1}, 32)
+
+		BeforeEach(func() {
+			wrapper, err := encryption.NewLocalKeyWrapper(key)
+			Expect(err).ToNot(HaveOccurred())
+
+			encryptor = encryption.NewEncryptor(wrapper)
+			store, err = storage.NewEncryptedCloudStorageSessionStore(bucketName, encryptor, opts...)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, session := range []*types.Session{firstSession, secondSession, otherAppSession} {
+				Expect(store.Store(context.Background(), session)).To(Succeed())
+			}
+		})
+
+		It("does not store the session in plaintext", func() {
+			Expect(bucket.Object("v1/my-app/1.0.0/11112222-3333-4444-5555-000000000001.json.enc")).ToNot(HaveContent(ContainSubstring(firstSession.SessionID)))
+		})
+
+		It("stores the sessions with an extension that the BigQuery transfer does not load", func() {
+			Expect(objectNames(bucket, "v1/")).To(HaveEach(HaveSuffix(".json.enc")))
+		})
+
+		It("stores the session with a content type that does not suggest it can be read directly", func() {
+			Expect(bucket.Object("v1/my-app/1.0.0/11112222-3333-4444-5555-000000000001.json.enc")).To(HaveContentType("application/octet-stream"))
+		})
+
+		It("stores the ID of the key used in the object's metadata", func() {
+			attrs, err := bucket.Object("v1/my-app/1.0.0/11112222-3333-4444-5555-000000000001.json.enc").Attrs(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(attrs.Metadata).To(HaveKeyWithValue("encryptionKeyId", HavePrefix("local:")))
+			Expect(attrs.Metadata).To(HaveKeyWithValue("encryptionAlgorithm", "AES-256-GCM"))
+			Expect(attrs.Metadata).To(HaveKeyWithValue("plaintextContentType", "application/json"))
+		})
+
+		It("continues to detect duplicate sessions", func() {
+			Expect(store.Store(context.Background(), firstSession)).To(MatchError(storage.ErrAlreadyExists))
+		})
+
+		Context("when the sessions are stored as individual objects", func() {
+			It("decrypts and returns all sessions for the application", func() {
+				Expect(readSessions(store, "my-app")).To(ConsistOf(*firstSession, *secondSession))
+			})
+
+			It("can decrypt the sessions with a new encryptor using the same key", func() {
+				wrapper, err := encryption.NewLocalKeyWrapper(key)
+				Expect(err).ToNot(HaveOccurred())
+
+				otherStore, err := storage.NewEncryptedCloudStorageSessionStore(bucketName, encryption.NewEncryptor(wrapper), opts...)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(readSessions(otherStore, "my-app")).To(ConsistOf(*firstSession, *secondSession))
+			})
+
+			It("returns an error when reading the sessions without encryption keys", func() {
+				unencryptedStore, err := storage.NewCloudStorageSessionStore(bucketName, opts...)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = readSessions(unencryptedStore, "my-app")
+				Expect(err).To(MatchError(storage.ErrEncryptionNotConfigured))
+			})
+		})
+
+		Context("when the sessions have been compacted into a bundle", func() {
+			BeforeEach(func() {
+				compact(encryptor)
+			})
+
+			It("does not store the bundle in plaintext", func() {
+				names := objectNames(bucket, "v1-bundles/")
+				Expect(names).To(HaveLen(1))
+
+				Expect(bucket.Object(names[0])).ToNot(HaveContent(ContainSubstring(firstSession.SessionID)))
+			})
+
+			It("decrypts and returns all sessions for the application", func() {
+				Expect(readSessions(store, "my-app")).To(ConsistOf(*firstSession, *secondSession))
+			})
+		})
+	})
+})
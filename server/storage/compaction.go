@@ -0,0 +1,254 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/encryption"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+)
+
+// CloudStorageCompactor rolls the individual session objects written by the Cloud Storage session store into
+// daily, per-application bundles, to keep the number of objects (and therefore per-operation costs) down.
+//
+// Only objects created on days that ended at least minimumAge ago are compacted: the session store relies on
+// the per-session object to detect duplicate uploads, so sessions must stay as individual objects for as long
+// as clients may retry uploading them.
+//
+// Bundles are written outside of the v1/ prefix so that sessions already imported into BigQuery are not imported again.
+//
+// Compaction is safe to run again after it fails partway through: sessions that are already in one of the day's bundles
+// are not bundled again, and their individual objects are deleted.
+type CloudStorageCompactor struct {
+	sessionBucket
+	minimumAge time.Duration
+	timeSource func() time.Time
+}
+
+type CompactionReport struct {
+	ApplicationID     string
+	BundlesWritten    int
+	SessionsCompacted int
+}
+
+// NewCloudStorageCompactor creates a compactor. encryptor must be provided if sessions are stored encrypted, and is used to encrypt bundles.
+func NewCloudStorageCompactor(bucketName string, minimumAge time.Duration, encryptor *encryption.Encryptor, opts ...option.ClientOption) (*CloudStorageCompactor, error) {
+	return NewCloudStorageCompactorWithTimeSource(bucketName, minimumAge, encryptor, time.Now, opts...)
+}
+
+func NewCloudStorageCompactorWithTimeSource(
+	bucketName string,
+	minimumAge time.Duration,
+	encryptor *encryption.Encryptor,
+	timeSource func() time.Time,
+	opts ...option.ClientOption,
+) (*CloudStorageCompactor, error) {
+	client, err := cloudstorage.NewClient(context.Background(), opts...)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create Cloud Storage client: %w", err)
+	}
+
+	return &CloudStorageCompactor{
+		sessionBucket: sessionBucket{
+			bucket:    client.Bucket(bucketName),
+			encryptor: encryptor,
+		},
+		minimumAge: minimumAge,
+		timeSource: timeSource,
+	}, nil
+}
+
+// CompactAll compacts the sessions for every application that has sessions in the bucket.
+func (c *CloudStorageCompactor) CompactAll(ctx context.Context) ([]CompactionReport, error) {
+	applicationIDs, err := c.applicationIDs(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]CompactionReport, 0, len(applicationIDs))
+
+	for _, applicationID := range applicationIDs {
+		report, err := c.Compact(ctx, applicationID)
+		reports = append(reports, report)
+
+		if err != nil {
+			return reports, err
+		}
+	}
+
+	return reports, nil
+}
+
+func (c *CloudStorageCompactor) Compact(ctx context.Context, applicationID string) (CompactionReport, error) {
+	report := CompactionReport{ApplicationID: applicationID}
+	cutoff := c.timeSource().UTC().Add(-c.minimumAge).Truncate(24 * time.Hour)
+	objects, err := c.list(ctx, sessionObjectPrefix(applicationID), sessionObjectSuffixes...)
+
+	if err != nil {
+		return report, fmt.Errorf("could not list sessions for application %v: %w", applicationID, err)
+	}
+
+	objectsByDay := map[string][]*cloudstorage.ObjectAttrs{}
+
+	for _, attrs := range objects {
+		if attrs.Created.Before(cutoff) {
+			day := attrs.Created.UTC().Format(bundleDayFormat)
+			objectsByDay[day] = append(objectsByDay[day], attrs)
+		}
+	}
+
+	days := make([]string, 0, len(objectsByDay))
+
+	for day := range objectsByDay {
+		days = append(days, day)
+	}
+
+	sort.Strings(days)
+
+	for _, day := range days {
+		dayReport, err := c.compactDay(ctx, applicationID, day, objectsByDay[day])
+
+		if err != nil {
+			return report, fmt.Errorf("could not compact sessions for application %v from %v: %w", applicationID, day, err)
+		}
+
+		report.BundlesWritten += dayReport.BundlesWritten
+		report.SessionsCompacted += dayReport.SessionsCompacted
+	}
+
+	return report, nil
+}
+
+// compactDay writes the sessions in objects to a new bundle, then deletes objects. Sessions already in one of the day's
+// bundles, left behind by a previous run that failed before deleting all of its objects, are not bundled again.
+func (c *CloudStorageCompactor) compactDay(ctx context.Context, applicationID string, day string, objects []*cloudstorage.ObjectAttrs) (CompactionReport, error) {
+	report := CompactionReport{ApplicationID: applicationID}
+	alreadyBundled, err := c.bundledSessionIDs(ctx, applicationID, day)
+
+	if err != nil {
+		return report, err
+	}
+
+	type unbundledSession struct {
+		id   string
+		json []byte
+	}
+
+	sessionsToBundle := []unbundledSession{}
+
+	for _, attrs := range objects {
+		sessionJSON, err := c.read(ctx, attrs, applicationID)
+
+		if err != nil {
+			return report, err
+		}
+
+		sessionID, err := sessionIDFromJSON(sessionJSON)
+
+		if err != nil {
+			return report, fmt.Errorf("could not read session object %v: %w", attrs.Name, err)
+		}
+
+		if !alreadyBundled[sessionID] {
+			sessionsToBundle = append(sessionsToBundle, unbundledSession{sessionID, sessionJSON})
+		}
+	}
+
+	log := logrus.
+		WithField("applicationId", applicationID).
+		WithField("day", day).
+		WithField("alreadyBundledCount", len(objects)-len(sessionsToBundle))
+
+	if len(sessionsToBundle) > 0 {
+		bundleName, count, err := c.storeBundle(ctx, applicationID, day, func(bw *bundleWriter) error {
+			for _, session := range sessionsToBundle {
+				if err := bw.Add(session.id, session.json); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return report, err
+		}
+
+		report.BundlesWritten = 1
+		report.SessionsCompacted = count
+		log = log.WithField("bundle", bundleName)
+	}
+
+	for _, attrs := range objects {
+		if err := c.delete(ctx, attrs); err != nil {
+			return report, err
+		}
+	}
+
+	log.WithField("sessionCount", report.SessionsCompacted).Info("Compacted sessions into bundle.")
+
+	return report, nil
+}
+
+// bundledSessionIDs returns the IDs of the sessions in applicationID's bundles for day.
+func (c *CloudStorageCompactor) bundledSessionIDs(ctx context.Context, applicationID string, day string) (map[string]bool, error) {
+	bundles, err := c.list(ctx, bundleObjectPrefix(applicationID)+day+"/", ".ndjson")
+
+	if err != nil {
+		return nil, fmt.Errorf("could not list bundles for application %v from %v: %w", applicationID, day, err)
+	}
+
+	sessionIDs := map[string]bool{}
+
+	for _, attrs := range bundles {
+		content, err := c.read(ctx, attrs, applicationID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = readBundle(bytes.NewReader(content), func(sessionJSON []byte) error {
+			sessionID, err := sessionIDFromJSON(sessionJSON)
+
+			if err != nil {
+				return fmt.Errorf("could not read session in bundle %v: %w", attrs.Name, err)
+			}
+
+			sessionIDs[sessionID] = true
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sessionIDs, nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	cloudstorage "cloud.google.com/go/storage"
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+var _ = Describe("Compacting sessions in Cloud Storage", func() {
+	var bucket *cloudstorage.BucketHandle
+	var bucketName string
+	var store storage.SessionStore
+	var opts []option.ClientOption
+
+	createSession := func(applicationID string, sessionID string) *types.Session {
+		return &types.Session{
+			SessionID:          sessionID,
+			UserID:             "99990000-3333-4444-5555-666677778888",
+			SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+			SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+			IngestionTime:      time.Date(2019, 1, 2, 20, 4, 5, 678000000, time.UTC),
+			ApplicationID:      applicationID,
+			ApplicationVersion: "1.0.0",
+			Attributes:         map[string]interface{}{"operatingSystem": "Mac"},
+			Events:             []types.Event{},
+			Spans:              []types.Span{},
+		}
+	}
+
+	BeforeEach(func() {
+		project := "my-project"
+		bucketName = "test-bucket-" + uuid.New().String()
+
+		opts = []option.ClientOption{
+			option.WithEndpoint("http://cloud-storage/storage/v1/"),
+		}
+
+		client, err := cloudstorage.NewClient(context.Background(), opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		bucket = client.Bucket(bucketName)
+		err = bucket.Create(context.Background(), project, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		store, err = storage.NewCloudStorageSessionStore(bucketName, opts...)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(store.Store(context.Background(), createSession("my-app", "11112222-3333-4444-5555-000000000001"))).To(Succeed())
+		Expect(store.Store(context.Background(), createSession("my-app", "11112222-3333-4444-5555-000000000002"))).To(Succeed())
+		Expect(store.Store(context.Background(), createSession("other-app", "11112222-3333-4444-5555-000000000003"))).To(Succeed())
+	})
+
+	Describe("given the sessions were ingested more recently than the minimum age", func() {
+		var reports []storage.CompactionReport
+
+		BeforeEach(func() {
+			compactor, err := storage.NewCloudStorageCompactor(bucketName, 7*24*time.Hour, nil, opts...)
+			Expect(err).ToNot(HaveOccurred())
+
+			reports, err = compactor.CompactAll(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("reports that nothing was compacted", func() {
+			Expect(reports).To(ConsistOf(
+				storage.CompactionReport{ApplicationID: "my-app"},
+				storage.CompactionReport{ApplicationID: "other-app"},
+			))
+		})
+
+		It("leaves the original session objects in place", func() {
+			Expect(objectNames(bucket, "v1/")).To(ConsistOf(
+				"v1/my-app/1.0.0/11112222-3333-4444-5555-000000000001.json",
+				"v1/my-app/1.0.0/11112222-3333-4444-5555-000000000002.json",
+				"v1/other-app/1.0.0/11112222-3333-4444-5555-000000000003.json",
+			))
+		})
+
+		It("does not write any bundles", func() {
+			Expect(objectNames(bucket, "v1-bundles/")).To(BeEmpty())
+		})
+
+		It("continues to detect duplicate sessions", func() {
+			err := store.Store(context.Background(), createSession("my-app", "11112222-3333-4444-5555-000000000001"))
+			Expect(err).To(MatchError(storage.ErrAlreadyExists))
+		})
+	})
+
+	Describe("given the sessions were ingested longer ago than the minimum age", func() {
+		var compactor *storage.CloudStorageCompactor
+		var report storage.CompactionReport
+		var today string
+
+		BeforeEach(func() {
+			now := time.Now().UTC()
+			today = now.Format("2006-01-02")
+			timeSource := func() time.Time { return now.Add(8 * 24 * time.Hour) }
+
+			var err error
+			compactor, err = storage.NewCloudStorageCompactorWithTimeSource(bucketName, 7*24*time.Hour, nil, timeSource, opts...)
+			Expect(err).ToNot(HaveOccurred())
+
+			report, err = compactor.Compact(context.Background(), "my-app")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("reports the number of bundles written and sessions compacted", func() {
+			Expect(report).To(Equal(storage.CompactionReport{ApplicationID: "my-app", BundlesWritten: 1, SessionsCompacted: 2}))
+		})
+
+		It("removes the original session objects for the application", func() {
+			Expect(objectNames(bucket, "v1/")).To(ConsistOf("v1/other-app/1.0.0/11112222-3333-4444-5555-000000000003.json"))
+		})
+
+		It("writes a single bundle for the application and day the sessions were ingested", func() {
+			names := objectNames(bucket, "v1-bundles/")
+
+			Expect(names).To(HaveLen(1))
+			Expect(names[0]).To(HavePrefix("v1-bundles/my-app/" + today + "/"))
+			Expect(names[0]).To(HaveSuffix(".ndjson"))
+		})
+
+		It("stores every session in the bundle as newline-delimited JSON", func() {
+			names := objectNames(bucket, "v1-bundles/")
+			Expect(names).To(HaveLen(1))
+
+			Expect(bucket.Object(names[0])).To(HaveContent(WithTransform(nonEmptyLines, ConsistOf(
+				MatchJSON(sessionJSON(createSession("my-app", "11112222-3333-4444-5555-000000000001"))),
+				MatchJSON(sessionJSON(createSession("my-app", "11112222-3333-4444-5555-000000000002"))),
+			))))
+		})
+
+		It("stores the bundle compressed", func() {
+			names := objectNames(bucket, "v1-bundles/")
+			Expect(names).To(HaveLen(1))
+
+			Expect(bucket.Object(names[0])).To(HaveContentEncoding("gzip"))
+		})
+
+		Context("when compaction runs again after a previous run failed to delete some of the original session objects", func() {
+			BeforeEach(func() {
+				Expect(store.Store(context.Background(), createSession("my-app", "11112222-3333-4444-5555-000000000001"))).To(Succeed())
+				Expect(store.Store(context.Background(), createSession("my-app", "11112222-3333-4444-5555-000000000004"))).To(Succeed())
+
+				var err error
+				report, err = compactor.Compact(context.Background(), "my-app")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("only reports the sessions that were not already in a bundle as compacted", func() {
+				Expect(report).To(Equal(storage.CompactionReport{ApplicationID: "my-app", BundlesWritten: 1, SessionsCompacted: 1}))
+			})
+
+			It("removes the original session objects for the application", func() {
+				Expect(objectNames(bucket, "v1/")).To(ConsistOf("v1/other-app/1.0.0/11112222-3333-4444-5555-000000000003.json"))
+			})
+
+			It("stores each session in exactly one bundle", func() {
+				names := objectNames(bucket, "v1-bundles/")
+				Expect(names).To(HaveLen(2))
+
+				lines := []string{}
+
+				for _, name := range names {
+					content := readObject(bucket.Object(name))
+					lines = append(lines, nonEmptyLines(content)...)
+				}
+
+				Expect(lines).To(ConsistOf(
+					MatchJSON(sessionJSON(createSession("my-app", "11112222-3333-4444-5555-000000000001"))),
+					MatchJSON(sessionJSON(createSession("my-app", "11112222-3333-4444-5555-000000000002"))),
+					MatchJSON(sessionJSON(createSession("my-app", "11112222-3333-4444-5555-000000000004"))),
+				))
+			})
+		})
+
+		Context("when compaction runs again and every remaining original session object is already in a bundle", func() {
+			BeforeEach(func() {
+				Expect(store.Store(context.Background(), createSession("my-app", "11112222-3333-4444-5555-000000000002"))).To(Succeed())
+
+				var err error
+				report, err = compactor.Compact(context.Background(), "my-app")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("reports that no bundles were written", func() {
+				Expect(report).To(Equal(storage.CompactionReport{ApplicationID: "my-app"}))
+			})
+
+			It("removes the original session objects for the application", func() {
+				Expect(objectNames(bucket, "v1/")).To(ConsistOf("v1/other-app/1.0.0/11112222-3333-4444-5555-000000000003.json"))
+			})
+
+			It("does not write another bundle", func() {
+				Expect(objectNames(bucket, "v1-bundles/")).To(HaveLen(1))
+			})
+		})
+	})
+})
+
+func objectNames(bucket *cloudstorage.BucketHandle, prefix string) []string {
+	it := bucket.Objects(context.Background(), &cloudstorage.Query{Prefix: prefix})
+	names := []string{}
+
+	for {
+		attrs, err := it.Next()
+
+		if errors.Is(err, iterator.Done) {
+			return names
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+
+		names = append(names, attrs.Name)
+	}
+}
+
+func readObject(object *cloudstorage.ObjectHandle) string {
+	reader, err := object.NewReader(context.Background())
+	Expect(err).ToNot(HaveOccurred())
+
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	return string(content)
+}
+
+func nonEmptyLines(content string) []string {
+	lines := []string{}
+
+	for _, line := range strings.Split(content, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func sessionJSON(session *types.Session) string {
+	bytes, err := json.Marshal(session)
+	Expect(err).ToNot(HaveOccurred())
+
+	return string(bytes)
+}
@@ -30,4 +30,43 @@ type SessionStore interface {
 	Store(ctx context.Context, session *types.Session) error
 }
 
+// SessionReader is implemented by session stores that can read back sessions they have previously stored.
+type SessionReader interface {
+	// ReadSessions calls fn with each stored session for the application, stopping at the first error returned by fn.
+	ReadSessions(ctx context.Context, applicationID string, fn func(session *types.Session) error) error
+}
+
+// SessionDeleter is implemented by session stores that can remove sessions they have previously stored.
+type SessionDeleter interface {
+	// DeleteSessions scans all stored sessions for the application and deletes those for which shouldDelete returns true.
+	DeleteSessions(ctx context.Context, applicationID string, shouldDelete func(session *types.Session) bool) (DeletionResult, error)
+}
+
+// OptOutStore records the users who have opted out of telemetry.
+type OptOutStore interface {
+	// RecordOptOut records optOut, merging it with any opt-out previously recorded for the same user: the earlier opt-out
+	// time is kept, and existing sessions are deleted if either opt-out asked for this.
+	RecordOptOut(ctx context.Context, optOut *types.OptOut) error
+
+	// IsOptedOut returns true if the user has opted out.
+	IsOptedOut(ctx context.Context, userID string) (bool, error)
+}
+
+// OptOutReader is implemented by opt-out stores that can list the opt-outs they have recorded.
+type OptOutReader interface {
+	// ReadOptOuts calls fn with each recorded opt-out, stopping at the first error returned by fn.
+	ReadOptOuts(ctx context.Context, fn func(optOut *types.OptOut) error) error
+}
+
+// HealthChecker is implemented by stores that can check whether their backend is reachable and usable.
+type HealthChecker interface {
+	// CheckHealth returns an error if the store can't currently be used.
+	CheckHealth(ctx context.Context) error
+}
+
+type DeletionResult struct {
+	SessionsScanned int
+	SessionsDeleted int
+}
+
 var ErrAlreadyExists = errors.New("the session already exists")
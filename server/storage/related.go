@@ -0,0 +1,123 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/batect/abacus/server/types"
+)
+
+var ErrSessionNotFound = errors.New("the session does not exist")
+
+// ReadRelatedSessions calls fn with each stored session for the application that is part of the same workflow as the session
+// with ID sessionID: the session itself, sessions linked to it through parent session IDs (directly or via other sessions),
+// and sessions that share a trace ID with any of these. It returns ErrSessionNotFound if there is no session with ID sessionID.
+//
+// Sessions are read twice: once to find the links between them, and again to pass the related sessions to fn.
+func ReadRelatedSessions(ctx context.Context, reader SessionReader, applicationID string, sessionID string, fn func(session *types.Session) error) error {
+	workflows := newWorkflowSet()
+
+	err := reader.ReadSessions(ctx, applicationID, func(session *types.Session) error {
+		workflows.add(session)
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !workflows.contains(sessionID) {
+		return fmt.Errorf("could not find session %v: %w", sessionID, ErrSessionNotFound)
+	}
+
+	workflow := workflows.find(sessionKey(sessionID))
+
+	return reader.ReadSessions(ctx, applicationID, func(session *types.Session) error {
+		if workflows.find(sessionKey(session.SessionID)) != workflow {
+			return nil
+		}
+
+		return fn(session)
+	})
+}
+
+// workflowSet groups sessions into workflows, using a union-find structure over both session IDs and trace IDs.
+type workflowSet struct {
+	parents  map[string]string
+	sessions map[string]bool
+}
+
+func newWorkflowSet() *workflowSet {
+	return &workflowSet{parents: map[string]string{}, sessions: map[string]bool{}}
+}
+
+// Session IDs and trace IDs are both UUIDs, so they are prefixed to keep them apart.
+func sessionKey(id string) string { return "session:" + id }
+func traceKey(id string) string   { return "trace:" + id }
+
+func (w *workflowSet) add(session *types.Session) {
+	key := sessionKey(session.SessionID)
+	w.sessions[session.SessionID] = true
+
+	if session.ParentSessionID != "" {
+		w.union(key, sessionKey(session.ParentSessionID))
+	}
+
+	if session.TraceID != "" {
+		w.union(key, traceKey(session.TraceID))
+	}
+}
+
+// contains returns true if a session with ID sessionID has been added. Sessions that have only been referred to as a parent are not included.
+func (w *workflowSet) contains(sessionID string) bool {
+	return w.sessions[sessionID]
+}
+
+func (w *workflowSet) find(key string) string {
+	parent, ok := w.parents[key]
+
+	if !ok {
+		w.parents[key] = key
+
+		return key
+	}
+
+	if parent == key {
+		return key
+	}
+
+	root := w.find(parent)
+	w.parents[key] = root
+
+	return root
+}
+
+func (w *workflowSet) union(a string, b string) {
+	rootA := w.find(a)
+	rootB := w.find(b)
+
+	if rootA != rootB {
+		w.parents[rootA] = rootB
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type inMemoryReader struct {
+	sessions []types.Session
+}
+
+func (r *inMemoryReader) ReadSessions(_ context.Context, _ string, fn func(session *types.Session) error) error {
+	for i := range r.sessions {
+		if err := fn(&r.sessions[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var _ = Describe("Reading related sessions", func() {
+	reader := &inMemoryReader{
+		sessions: []types.Session{
+			{SessionID: "wrapper"},
+			{SessionID: "app", ParentSessionID: "wrapper"},
+			{SessionID: "nested", ParentSessionID: "app"},
+			{SessionID: "sibling", TraceID: "trace-1"},
+			{SessionID: "traced-child", ParentSessionID: "app", TraceID: "trace-1"},
+			{SessionID: "orphan", ParentSessionID: "never-uploaded"},
+			{SessionID: "unrelated"},
+			{SessionID: "unrelated-trace", TraceID: "trace-2"},
+		},
+	}
+
+	readRelated := func(sessionID string) ([]string, error) {
+		ids := []string{}
+
+		err := storage.ReadRelatedSessions(context.Background(), reader, "my-app", sessionID, func(session *types.Session) error {
+			ids = append(ids, session.SessionID)
+
+			return nil
+		})
+
+		return ids, err
+	}
+
+	Context("given a session with a parent, children and a trace shared with other sessions", func() {
+		It("returns all sessions linked to it through parent session IDs or trace IDs", func() {
+			Expect(readRelated("nested")).To(ConsistOf("wrapper", "app", "nested", "sibling", "traced-child"))
+		})
+	})
+
+	Context("given a session with no links to other sessions", func() {
+		It("returns only that session", func() {
+			Expect(readRelated("unrelated")).To(ConsistOf("unrelated"))
+		})
+	})
+
+	Context("given a session whose parent was never stored", func() {
+		It("returns only that session", func() {
+			Expect(readRelated("orphan")).To(ConsistOf("orphan"))
+		})
+	})
+
+	Context("given a session ID that was only ever referred to as a parent", func() {
+		It("returns an error", func() {
+			_, err := readRelated("never-uploaded")
+			Expect(errors.Is(err, storage.ErrSessionNotFound)).To(BeTrue())
+		})
+	})
+
+	Context("given the callback returns an error", func() {
+		It("stops reading and returns the error", func() {
+			callbackError := errors.New("something went wrong")
+			calls := 0
+
+			err := storage.ReadRelatedSessions(context.Background(), reader, "my-app", "app", func(session *types.Session) error {
+				calls++
+
+				return callbackError
+			})
+
+			Expect(err).To(MatchError(callbackError))
+			Expect(calls).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,409 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/googleapi"
+)
+
+// ResilientSessionStore wraps another SessionStore, retrying transient failures and failing fast with a circuit breaker when
+// the underlying store appears to be unavailable.
+type ResilientSessionStore struct {
+	inner SessionStore
+	resilience
+}
+
+// ResilientOptOutStore wraps another OptOutStore in the same way as ResilientSessionStore, with its own circuit breaker.
+type ResilientOptOutStore struct {
+	inner OptOutStore
+	resilience
+}
+
+// resilience retries operations and tracks their outcomes in a circuit breaker.
+type resilience struct {
+	options ResilienceOptions
+	breaker *circuitBreaker
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+type ResilienceOptions struct {
+	// AttemptTimeout limits how long each individual attempt at an operation can take.
+	AttemptTimeout time.Duration
+
+	// MaxAttempts is the maximum number of attempts made at an operation, including the first.
+	MaxAttempts int
+
+	// InitialBackoff and MaxBackoff control the delay between attempts: the delay is chosen at random between zero and
+	// InitialBackoff * 2^(attempt - 1), capped at MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// FailureThreshold is the number of consecutive failed operations that causes the circuit breaker to open.
+	FailureThreshold int
+
+	// OpenDuration is how long the circuit breaker stays open before allowing a trial operation through.
+	OpenDuration time.Duration
+}
+
+func DefaultResilienceOptions() ResilienceOptions {
+	return ResilienceOptions{
+		AttemptTimeout:   5 * time.Second,
+		MaxAttempts:      3,
+		InitialBackoff:   100 * time.Millisecond,
+		MaxBackoff:       time.Second,
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+// ErrCircuitOpen is returned (wrapped in a CircuitOpenError) when the circuit breaker is open and no attempt was made at the operation.
+var ErrCircuitOpen = errors.New("store is unavailable")
+
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v: circuit breaker is open, retry after %v", ErrCircuitOpen, e.RetryAfter)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen //nolint:errorlint,goerr113
+}
+
+const circuitBreakerState = attribute.Key("storage.circuitBreaker.state")
+const storeAttempts = attribute.Key("storage.attempts")
+
+func NewResilientSessionStore(inner SessionStore, options ResilienceOptions) *ResilientSessionStore {
+	return NewResilientSessionStoreWithClock(inner, options, time.Now, sleepWithContext)
+}
+
+func NewResilientSessionStoreWithClock(
+	inner SessionStore,
+	options ResilienceOptions,
+	timeSource func() time.Time,
+	sleep func(ctx context.Context, d time.Duration) error,
+) *ResilientSessionStore {
+	return &ResilientSessionStore{
+		inner:      inner,
+		resilience: newResilience(options, timeSource, sleep),
+	}
+}
+
+func (s *ResilientSessionStore) Store(ctx context.Context, session *types.Session) error {
+	return s.run(ctx, "storing session", func(ctx context.Context) error {
+		return s.inner.Store(ctx, session)
+	})
+}
+
+// CheckHealth returns an error if the circuit breaker is open, as sessions can't be stored until it closes, and otherwise
+// checks the health of the underlying store if it supports health checks. Health checks don't affect the circuit breaker.
+func (s *ResilientSessionStore) CheckHealth(ctx context.Context) error {
+	return s.checkHealth(ctx, s.inner)
+}
+
+func NewResilientOptOutStore(inner OptOutStore, options ResilienceOptions) *ResilientOptOutStore {
+	return NewResilientOptOutStoreWithClock(inner, options, time.Now, sleepWithContext)
+}
+
+func NewResilientOptOutStoreWithClock(
+	inner OptOutStore,
+	options ResilienceOptions,
+	timeSource func() time.Time,
+	sleep func(ctx context.Context, d time.Duration) error,
+) *ResilientOptOutStore {
+	return &ResilientOptOutStore{
+		inner:      inner,
+		resilience: newResilience(options, timeSource, sleep),
+	}
+}
+
+func (s *ResilientOptOutStore) RecordOptOut(ctx context.Context, optOut *types.OptOut) error {
+	return s.run(ctx, "recording opt-out", func(ctx context.Context) error {
+		return s.inner.RecordOptOut(ctx, optOut)
+	})
+}
+
+func (s *ResilientOptOutStore) IsOptedOut(ctx context.Context, userID string) (bool, error) {
+	optedOut := false
+
+	err := s.run(ctx, "checking for opt-out", func(ctx context.Context) error {
+		var err error
+		optedOut, err = s.inner.IsOptedOut(ctx, userID)
+
+		return err
+	})
+
+	return optedOut, err
+}
+
+// CheckHealth returns an error if the circuit breaker is open, and otherwise checks the health of the underlying store
+// if it supports health checks. Health checks don't affect the circuit breaker.
+func (s *ResilientOptOutStore) CheckHealth(ctx context.Context) error {
+	return s.checkHealth(ctx, s.inner)
+}
+
+func newResilience(options ResilienceOptions, timeSource func() time.Time, sleep func(ctx context.Context, d time.Duration) error) resilience {
+	return resilience{
+		options: options,
+		breaker: newCircuitBreaker(options.FailureThreshold, options.OpenDuration, timeSource),
+		sleep:   sleep,
+	}
+}
+
+// run runs operation, retrying it if it fails with a transient error, unless the circuit breaker is open.
+// description describes the operation in trace events, such as 'storing session'.
+func (r *resilience) run(ctx context.Context, description string, operation func(ctx context.Context) error) error {
+	span := trace.SpanFromContext(ctx)
+
+	if retryAfter, ok := r.breaker.Allow(); !ok {
+		span.SetAttributes(circuitBreakerState.String(string(CircuitOpen)), storeAttempts.Int(0))
+
+		return &CircuitOpenError{RetryAfter: retryAfter}
+	}
+
+	attempts, err := r.runWithRetries(ctx, description, operation)
+
+	switch {
+	case err == nil || errors.Is(err, ErrAlreadyExists):
+		r.breaker.RecordSuccess()
+	case ctx.Err() != nil:
+		// The caller cancelling the request or running out of time says nothing about the health of the underlying store.
+		r.breaker.RecordIndeterminate()
+	default:
+		// Failures that aren't worth retrying, such as the store rejecting our credentials or the bucket having been deleted,
+		// still mean the operation can't succeed, so they count towards opening the circuit breaker.
+		r.breaker.RecordFailure()
+	}
+
+	span.SetAttributes(circuitBreakerState.String(string(r.breaker.State())), storeAttempts.Int(attempts))
+
+	return err
+}
+
+func (r *resilience) runWithRetries(ctx context.Context, description string, operation func(ctx context.Context) error) (int, error) {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = r.attempt(ctx, operation)
+
+		if !isRetryable(ctx, err) || attempt >= r.options.MaxAttempts {
+			return attempt, err
+		}
+
+		delay := r.backoff(attempt)
+
+		trace.SpanFromContext(ctx).AddEvent("Retrying "+description, trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+			attribute.Int64("delayMs", delay.Milliseconds()),
+		))
+
+		if sleepErr := r.sleep(ctx, delay); sleepErr != nil {
+			return attempt, err
+		}
+	}
+}
+
+func (r *resilience) attempt(ctx context.Context, operation func(ctx context.Context) error) error {
+	if r.options.AttemptTimeout <= 0 {
+		return operation(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, r.options.AttemptTimeout)
+	defer cancel()
+
+	return operation(attemptCtx)
+}
+
+func (r *resilience) backoff(attempt int) time.Duration {
+	maximum := r.options.InitialBackoff << (attempt - 1)
+
+	if maximum > r.options.MaxBackoff || maximum <= 0 {
+		maximum = r.options.MaxBackoff
+	}
+
+	if maximum <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(maximum))) //nolint:gosec
+}
+
+// CircuitBreakerState returns the current state of the circuit breaker, for use in health checks.
+func (r *resilience) CircuitBreakerState() CircuitBreakerState {
+	return r.breaker.State()
+}
+
+func (r *resilience) checkHealth(ctx context.Context, inner interface{}) error {
+	if state := r.breaker.State(); state == CircuitOpen {
+		return fmt.Errorf("%w: circuit breaker is %v", ErrCircuitOpen, state)
+	}
+
+	if checker, ok := inner.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+
+	return nil
+}
+
+// isRetryable returns true if err is a failure that might succeed if tried again.
+// Failures caused by the caller's context being cancelled are never retryable.
+func isRetryable(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, ErrAlreadyExists) || ctx.Err() != nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var gerr *googleapi.Error
+
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusTooManyRequests || gerr.Code == http.StatusRequestTimeout || gerr.Code >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type CircuitBreakerState string
+
+const (
+	CircuitClosed   CircuitBreakerState = "closed"
+	CircuitOpen     CircuitBreakerState = "open"
+	CircuitHalfOpen CircuitBreakerState = "half-open"
+)
+
+type circuitBreaker struct {
+	failureThreshold int
+	openDuration     time.Duration
+	timeSource       func() time.Time
+
+	mutex               sync.Mutex
+	state               CircuitBreakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	trialInProgress     bool
+}
+
+func newCircuitBreaker(failureThreshold int, openDuration time.Duration, timeSource func() time.Time) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		timeSource:       timeSource,
+		state:            CircuitClosed,
+	}
+}
+
+// Allow returns true if an operation may proceed. If it may not, it also returns how long until the breaker will next allow an operation.
+func (b *circuitBreaker) Allow() (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitClosed:
+		return 0, true
+	case CircuitOpen:
+		remaining := b.openDuration - b.timeSource().Sub(b.openedAt)
+
+		if remaining > 0 {
+			return remaining, false
+		}
+
+		b.state = CircuitHalfOpen
+		b.trialInProgress = true
+
+		return 0, true
+	case CircuitHalfOpen:
+		if b.trialInProgress {
+			return b.openDuration, false
+		}
+
+		b.trialInProgress = true
+
+		return 0, true
+	default:
+		return 0, true
+	}
+}
+
+func (b *circuitBreaker) RecordSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = CircuitClosed
+	b.consecutiveFailures = 0
+	b.trialInProgress = false
+}
+
+func (b *circuitBreaker) RecordFailure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.consecutiveFailures++
+	b.trialInProgress = false
+
+	if b.state == CircuitHalfOpen || b.consecutiveFailures >= b.failureThreshold {
+		b.state = CircuitOpen
+		b.openedAt = b.timeSource()
+	}
+}
+
+func (b *circuitBreaker) RecordIndeterminate() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.trialInProgress = false
+}
+
+func (b *circuitBreaker) State() CircuitBreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
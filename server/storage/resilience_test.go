@@ -0,0 +1,486 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/batect/abacus/server/storage"
+	"github.com/batect/abacus/server/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/googleapi"
+)
+
+var _ = Describe("A resilient session store", func() {
+	var inner *scriptedStore
+	var store *storage.ResilientSessionStore
+	var currentTime time.Time
+	var sleeps []time.Duration
+
+	session := &types.Session{SessionID: "11112222-3333-4444-a555-666677778888"}
+	transientError := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	permanentError := &googleapi.Error{Code: http.StatusForbidden}
+
+	options := storage.ResilienceOptions{
+		AttemptTimeout:   time.Second,
+		MaxAttempts:      3,
+		InitialBackoff:   100 * time.Millisecond,
+		MaxBackoff:       150 * time.Millisecond,
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+	}
+
+	BeforeEach(func() {
+		inner = &scriptedStore{}
+		currentTime = time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+		sleeps = nil
+
+		timeSource := func() time.Time { return currentTime }
+		sleep := func(_ context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			return nil
+		}
+
+		store = storage.NewResilientSessionStoreWithClock(inner, options, timeSource, sleep)
+	})
+
+	Context("when storing the session succeeds on the first attempt", func() {
+		var err error
+
+		BeforeEach(func() {
+			err = store.Store(context.Background(), session)
+		})
+
+		It("returns no error", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("makes a single attempt", func() {
+			Expect(inner.attempts).To(Equal(1))
+		})
+
+		It("applies the attempt timeout to the attempt", func() {
+			Expect(inner.deadlines).To(ConsistOf(BeTrue()))
+		})
+	})
+
+	Context("when storing the session fails with a transient error and then succeeds", func() {
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{transientError}
+			err = store.Store(context.Background(), session)
+		})
+
+		It("returns no error", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("retries the attempt", func() {
+			Expect(inner.attempts).To(Equal(2))
+		})
+
+		It("waits for a random delay no longer than the initial backoff before retrying", func() {
+			Expect(sleeps).To(ConsistOf(BeNumerically("<", options.InitialBackoff)))
+		})
+
+		It("leaves the circuit breaker closed", func() {
+			Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitClosed))
+		})
+	})
+
+	Context("when storing the session fails with a transient error on every attempt", func() {
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{transientError, transientError, transientError, transientError}
+			err = store.Store(context.Background(), session)
+		})
+
+		It("returns the last error", func() {
+			Expect(err).To(MatchError(transientError))
+		})
+
+		It("gives up after the maximum number of attempts", func() {
+			Expect(inner.attempts).To(Equal(3))
+		})
+
+		It("never waits longer than the maximum backoff", func() {
+			Expect(sleeps).To(HaveLen(2))
+			Expect(sleeps).To(HaveEach(BeNumerically("<", options.MaxBackoff)))
+		})
+	})
+
+	Context("when storing the session times out", func() {
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{context.DeadlineExceeded}
+			err = store.Store(context.Background(), session)
+		})
+
+		It("retries the attempt", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inner.attempts).To(Equal(2))
+		})
+	})
+
+	for _, e := range []error{permanentError, errors.New("could not convert session to JSON")} {
+		nonRetryableError := e
+
+		Context("when storing the session fails with the non-retryable error '"+nonRetryableError.Error()+"'", func() {
+			var err error
+
+			BeforeEach(func() {
+				inner.errors = []error{nonRetryableError, nonRetryableError, nonRetryableError}
+				err = store.Store(context.Background(), session)
+				Expect(store.Store(context.Background(), session)).To(MatchError(nonRetryableError))
+			})
+
+			It("returns the error", func() {
+				Expect(err).To(MatchError(nonRetryableError))
+			})
+
+			It("does not retry the attempt", func() {
+				Expect(inner.attempts).To(Equal(2))
+				Expect(sleeps).To(BeEmpty())
+			})
+
+			It("counts the failures towards opening the circuit breaker", func() {
+				Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitOpen))
+			})
+		})
+	}
+
+	Context("when the session has already been stored", func() {
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{storage.ErrAlreadyExists, storage.ErrAlreadyExists, storage.ErrAlreadyExists}
+			err = store.Store(context.Background(), session)
+			Expect(store.Store(context.Background(), session)).To(MatchError(storage.ErrAlreadyExists))
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError(storage.ErrAlreadyExists))
+		})
+
+		It("does not retry the attempt", func() {
+			Expect(inner.attempts).To(Equal(2))
+			Expect(sleeps).To(BeEmpty())
+		})
+
+		It("does not open the circuit breaker", func() {
+			Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitClosed))
+		})
+	})
+
+	Context("when the caller's context is cancelled", func() {
+		var err error
+
+		BeforeEach(func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			inner.errors = []error{context.Canceled, context.Canceled}
+			err = store.Store(ctx, session)
+			Expect(store.Store(ctx, session)).To(MatchError(context.Canceled))
+		})
+
+		It("returns the error without retrying", func() {
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(inner.attempts).To(Equal(2))
+		})
+
+		It("does not count the failures towards opening the circuit breaker", func() {
+			Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitClosed))
+		})
+	})
+
+	Context("when checking the health of the store while the circuit breaker is closed", func() {
+		Context("when the underlying store is healthy", func() {
+			It("reports that the store is healthy", func() {
+				Expect(store.CheckHealth(context.Background())).To(Succeed())
+			})
+		})
+
+		Context("when the underlying store is unhealthy", func() {
+			BeforeEach(func() {
+				inner.healthError = transientError
+			})
+
+			It("returns the error from the underlying store", func() {
+				Expect(store.CheckHealth(context.Background())).To(MatchError(transientError))
+			})
+
+			It("does not open the circuit breaker", func() {
+				for i := 0; i < options.FailureThreshold+1; i++ {
+					_ = store.CheckHealth(context.Background())
+				}
+
+				Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitClosed))
+			})
+		})
+	})
+
+	Context("when enough consecutive operations fail to reach the failure threshold", func() {
+		BeforeEach(func() {
+			inner.errors = []error{transientError, transientError, transientError, transientError, transientError, transientError}
+			Expect(store.Store(context.Background(), session)).To(MatchError(transientError))
+			Expect(store.Store(context.Background(), session)).To(MatchError(transientError))
+			inner.attempts = 0
+		})
+
+		It("opens the circuit breaker", func() {
+			Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitOpen))
+		})
+
+		It("reports that the store is unhealthy", func() {
+			Expect(store.CheckHealth(context.Background())).To(MatchError(storage.ErrCircuitOpen))
+		})
+
+		Context("when another session is stored before the open duration has elapsed", func() {
+			var err error
+
+			BeforeEach(func() {
+				currentTime = currentTime.Add(20 * time.Second)
+				err = store.Store(context.Background(), session)
+			})
+
+			It("fails fast without attempting to store the session", func() {
+				Expect(err).To(MatchError(storage.ErrCircuitOpen))
+				Expect(inner.attempts).To(BeZero())
+			})
+
+			It("reports how long until the circuit breaker will allow another attempt", func() {
+				var circuitOpenError *storage.CircuitOpenError
+				Expect(errors.As(err, &circuitOpenError)).To(BeTrue())
+				Expect(circuitOpenError.RetryAfter).To(Equal(40 * time.Second))
+			})
+		})
+
+		Context("when another session is stored after the open duration has elapsed", func() {
+			BeforeEach(func() {
+				currentTime = currentTime.Add(time.Minute)
+			})
+
+			Context("when the trial attempt succeeds", func() {
+				BeforeEach(func() {
+					Expect(store.Store(context.Background(), session)).To(Succeed())
+				})
+
+				It("attempts to store the session", func() {
+					Expect(inner.attempts).To(Equal(1))
+				})
+
+				It("closes the circuit breaker", func() {
+					Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitClosed))
+				})
+			})
+
+			Context("when the trial operation fails", func() {
+				BeforeEach(func() {
+					inner.errors = []error{transientError, transientError, transientError}
+					Expect(store.Store(context.Background(), session)).To(MatchError(transientError))
+				})
+
+				It("re-opens the circuit breaker", func() {
+					Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitOpen))
+				})
+			})
+		})
+	})
+})
+
+var _ = Describe("A resilient opt-out store", func() {
+	var inner *scriptedOptOutStore
+	var store *storage.ResilientOptOutStore
+	var currentTime time.Time
+
+	const userID = "11112222-3333-4444-a555-666677778888"
+	transientError := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	permanentError := &googleapi.Error{Code: http.StatusForbidden}
+
+	options := storage.ResilienceOptions{
+		AttemptTimeout:   time.Second,
+		MaxAttempts:      3,
+		InitialBackoff:   100 * time.Millisecond,
+		MaxBackoff:       150 * time.Millisecond,
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+	}
+
+	BeforeEach(func() {
+		inner = &scriptedOptOutStore{optedOut: true}
+		currentTime = time.Date(2020, 5, 24, 10, 12, 14, 0, time.UTC)
+
+		timeSource := func() time.Time { return currentTime }
+		sleep := func(_ context.Context, _ time.Duration) error { return nil }
+
+		store = storage.NewResilientOptOutStoreWithClock(inner, options, timeSource, sleep)
+	})
+
+	Context("when checking for an opt-out fails with a transient error and then succeeds", func() {
+		var optedOut bool
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{transientError}
+			optedOut, err = store.IsOptedOut(context.Background(), userID)
+		})
+
+		It("returns the result from the successful attempt", func() {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(optedOut).To(BeTrue())
+		})
+
+		It("retries the attempt", func() {
+			Expect(inner.attempts).To(Equal(2))
+		})
+
+		It("applies the attempt timeout to each attempt", func() {
+			Expect(inner.deadlines).To(ConsistOf(BeTrue(), BeTrue()))
+		})
+	})
+
+	Context("when recording an opt-out fails with a transient error and then succeeds", func() {
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{transientError}
+			err = store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})
+		})
+
+		It("returns no error", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("retries the attempt", func() {
+			Expect(inner.attempts).To(Equal(2))
+		})
+	})
+
+	Context("when recording an opt-out fails with a non-retryable error", func() {
+		var err error
+
+		BeforeEach(func() {
+			inner.errors = []error{permanentError}
+			err = store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})
+		})
+
+		It("returns the error", func() {
+			Expect(err).To(MatchError(permanentError))
+		})
+
+		It("does not retry the attempt", func() {
+			Expect(inner.attempts).To(Equal(1))
+		})
+	})
+
+	Context("when enough consecutive operations fail to reach the failure threshold", func() {
+		BeforeEach(func() {
+			inner.errors = []error{permanentError, permanentError}
+			Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})).To(MatchError(permanentError))
+			_, err := store.IsOptedOut(context.Background(), userID)
+			Expect(err).To(MatchError(permanentError))
+			inner.attempts = 0
+		})
+
+		It("opens the circuit breaker", func() {
+			Expect(store.CircuitBreakerState()).To(Equal(storage.CircuitOpen))
+		})
+
+		It("reports that the store is unhealthy", func() {
+			Expect(store.CheckHealth(context.Background())).To(MatchError(storage.ErrCircuitOpen))
+		})
+
+		It("fails fast when checking for an opt-out without querying the underlying store", func() {
+			optedOut, err := store.IsOptedOut(context.Background(), userID)
+			Expect(err).To(MatchError(storage.ErrCircuitOpen))
+			Expect(optedOut).To(BeFalse())
+			Expect(inner.attempts).To(BeZero())
+		})
+
+		It("fails fast when recording an opt-out without calling the underlying store", func() {
+			Expect(store.RecordOptOut(context.Background(), &types.OptOut{UserID: userID})).To(MatchError(storage.ErrCircuitOpen))
+			Expect(inner.attempts).To(BeZero())
+		})
+	})
+
+	Context("when the underlying store is unhealthy", func() {
+		BeforeEach(func() {
+			inner.healthError = transientError
+		})
+
+		It("returns the error from the underlying store", func() {
+			Expect(store.CheckHealth(context.Background())).To(MatchError(transientError))
+		})
+	})
+})
+
+type scriptedStore struct {
+	errors    []error
+	attempts  int
+	deadlines []bool
+
+	healthError error
+}
+
+func (s *scriptedStore) CheckHealth(_ context.Context) error {
+	return s.healthError
+}
+
+func (s *scriptedStore) Store(ctx context.Context, _ *types.Session) error {
+	s.attempts++
+
+	_, hasDeadline := ctx.Deadline()
+	s.deadlines = append(s.deadlines, hasDeadline)
+
+	if len(s.errors) == 0 {
+		return nil
+	}
+
+	err := s.errors[0]
+	s.errors = s.errors[1:]
+
+	return err
+}
+
+type scriptedOptOutStore struct {
+	scriptedStore
+
+	optedOut bool
+}
+
+func (s *scriptedOptOutStore) RecordOptOut(ctx context.Context, _ *types.OptOut) error {
+	return s.Store(ctx, nil)
+}
+
+func (s *scriptedOptOutStore) IsOptedOut(ctx context.Context, _ string) (bool, error) {
+	if err := s.Store(ctx, nil); err != nil {
+		return false, err
+	}
+
+	return s.optedOut, nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package types
+
+import "time"
+
+// OptOut records that a user has asked for no further telemetry to be collected about them.
+type OptOut struct {
+	UserID string `json:"userId" validate:"required,uuid4"`
+
+	// DeleteExistingSessions is true if the user has also asked for the sessions already stored about them to be deleted.
+	DeleteExistingSessions bool `json:"deleteExistingSessions"`
+
+	// OptOutTime is when the user first opted out.
+	OptOutTime time.Time `json:"optOutTime" schema:"readOnly"`
+}
@@ -19,28 +19,53 @@
 
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/batect/abacus/server/versions"
+)
 
 type Session struct {
 	SessionID          string                 `json:"sessionId" validate:"required,uuid4"`
 	UserID             string                 `json:"userId" validate:"required,uuid4"`
 	SessionStartTime   time.Time              `json:"sessionStartTime" validate:"required"`
 	SessionEndTime     time.Time              `json:"sessionEndTime" validate:"required,gtefield=SessionStartTime"`
-	IngestionTime      time.Time              `json:"ingestionTime"`
+	IngestionTime      time.Time              `json:"ingestionTime" schema:"readOnly"`
 	ApplicationID      string                 `json:"applicationId" validate:"required,applicationId"`
 	ApplicationVersion string                 `json:"applicationVersion" validate:"required,version"`
 	Attributes         map[string]interface{} `json:"attributes" validate:"dive,keys,required,attributeName,endkeys,attributeValue"`
 	Events             []Event                `json:"events" validate:"dive"`
 	Spans              []Span                 `json:"spans" validate:"dive"`
+
+	// ParentSessionID is the ID of the session that started this one, if any (eg. a wrapper script that invoked the application).
+	ParentSessionID string `json:"parentSessionId,omitempty" validate:"omitempty,uuid4,nefield=SessionID"`
+
+	// TraceID is shared by all of the sessions that make up a single user workflow, and is passed from each process to the processes it starts.
+	TraceID string `json:"traceId,omitempty" validate:"omitempty,uuid4"`
+
+	// Consent records the privacy policy the user agreed to. Applications can require it (see applications.ConsentPolicy).
+	Consent *Consent `json:"consent,omitempty"`
+
+	// ParsedApplicationVersion is derived from ApplicationVersion by the server, so that sessions can be analysed by version component.
+	ParsedApplicationVersion *versions.Version `json:"parsedApplicationVersion,omitempty" schema:"readOnly"`
+}
+
+// Consent records the version of the privacy policy a user agreed to, and when they agreed to it.
+type Consent struct {
+	PolicyVersion string    `json:"policyVersion" validate:"required"`
+	Time          time.Time `json:"time" validate:"required"`
 }
 
 type Event struct {
 	Type       string                 `json:"type" validate:"required"`
 	Time       time.Time              `json:"time" validate:"required"`
+	SpanID     string                 `json:"spanId,omitempty" validate:"omitempty,spanId"`
 	Attributes map[string]interface{} `json:"attributes" validate:"dive,keys,required,attributeName,endkeys,attributeValue"`
 }
 
 type Span struct {
+	ID         string                 `json:"id,omitempty" validate:"omitempty,spanId"`
+	ParentID   string                 `json:"parentId,omitempty" validate:"omitempty,spanId"`
 	Type       string                 `json:"type" validate:"required"`
 	StartTime  time.Time              `json:"startTime" validate:"required"`
 	EndTime    time.Time              `json:"endTime" validate:"required,gtefield=StartTime"`
@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/batect/abacus/server/applications"
 	"github.com/batect/abacus/server/decoding"
 	"github.com/batect/abacus/server/types"
 	"github.com/batect/abacus/server/validation"
@@ -120,6 +121,102 @@ var _ = Describe("A session", func() {
 			})
 		})
 
+		Describe("given a valid session with nested spans and events associated with spans", func() {
+			session := `{
+				"sessionId": "11112222-3333-4444-a555-666677778888", 
+				"userId": "99990000-3333-4444-a555-666677778888", 
+				"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+				"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+				"applicationId": "test-app", 
+				"applicationVersion": "1.0.0",
+				"events": [
+					{ "type": "ThingHappened", "time": "2019-01-02T03:04:06.678Z", "spanId": "0000000000000002" }
+				],
+				"spans": [
+					{ "id": "0000000000000001", "type": "RunningTask", "startTime": "2019-01-02T03:04:06.678Z", "endTime": "2019-01-02T03:04:09.678Z" },
+					{ "id": "0000000000000002", "parentId": "0000000000000001", "type": "PullingImage", "startTime": "2019-01-02T03:04:06.678Z", "endTime": "2019-01-02T03:04:07.678Z" },
+					{ "parentId": "0000000000000001", "type": "StartingContainer", "startTime": "2019-01-02T03:04:07.678Z", "endTime": "2019-01-02T03:04:08.678Z" }
+				]
+			}`
+
+			var errors []validation.Error
+
+			BeforeEach(func() {
+				errors = validate(session)
+			})
+
+			It("returns no errors", func() {
+				Expect(errors).To(BeEmpty())
+			})
+		})
+
+		Describe("given a valid session linked to a parent session and a trace", func() {
+			session := `{
+				"sessionId": "11112222-3333-4444-a555-666677778888", 
+				"userId": "99990000-3333-4444-a555-666677778888", 
+				"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+				"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+				"applicationId": "test-app", 
+				"applicationVersion": "1.0.0",
+				"parentSessionId": "aaaa2222-3333-4444-a555-666677778888",
+				"traceId": "bbbb2222-3333-4444-a555-666677778888"
+			}`
+
+			var errors []validation.Error
+
+			BeforeEach(func() {
+				errors = validate(session)
+			})
+
+			It("returns no errors", func() {
+				Expect(errors).To(BeEmpty())
+			})
+		})
+
+		Describe("given a valid session with consent", func() {
+			session := `{
+				"sessionId": "11112222-3333-4444-a555-666677778888", 
+				"userId": "99990000-3333-4444-a555-666677778888", 
+				"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+				"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+				"applicationId": "test-app", 
+				"applicationVersion": "1.0.0",
+				"consent": { "policyVersion": "2023-06", "time": "2019-01-01T03:04:05.678Z" }
+			}`
+
+			var errors []validation.Error
+
+			BeforeEach(func() {
+				errors = validate(session)
+			})
+
+			It("returns no errors", func() {
+				Expect(errors).To(BeEmpty())
+			})
+		})
+
+		Describe("given a valid session with consent for an application that does not list accepted privacy policy versions", func() {
+			session := `{
+				"sessionId": "11112222-3333-4444-a555-666677778888", 
+				"userId": "99990000-3333-4444-a555-666677778888", 
+				"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+				"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+				"applicationId": "batect", 
+				"applicationVersion": "1.0.0",
+				"consent": { "policyVersion": "any-version", "time": "2019-01-01T03:04:05.678Z" }
+			}`
+
+			var errors []validation.Error
+
+			BeforeEach(func() {
+				errors = validate(session)
+			})
+
+			It("returns no errors", func() {
+				Expect(errors).To(BeEmpty())
+			})
+		})
+
 		sessionWithEvent := func(event string) string {
 			return fmt.Sprintf(`{
 				"sessionId": "11112222-3333-4444-a555-666677778888", 
@@ -233,18 +330,105 @@ var _ = Describe("A session", func() {
 				description: "a span with the end time after the start time",
 				sourceJSON: sessionWithSpan(`{
 					"type": "some-span",
-					"startTime": "2019-01-04T03:04:05.678Z", 
-					"endTime": "2019-01-02T09:04:05.678Z"
+					"startTime": "2019-01-02T08:04:05.678Z", 
+					"endTime": "2019-01-02T07:04:05.678Z"
 				}`),
 				expectedErrors: []validation.Error{
 					{
 						Key:          "spans[0].endTime",
 						Type:         "gtefield",
-						InvalidValue: time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+						InvalidValue: time.Date(2019, 1, 2, 7, 4, 5, 678000000, time.UTC),
 						Message:      "endTime must be greater than or equal to startTime",
 					},
 				},
 			},
+			{
+				description: "an event before the start of the session",
+				sourceJSON: sessionWithEvent(`{
+					"type": "some-event",
+					"time": "2019-01-02T03:04:05.677Z"
+				}`),
+				expectedErrors: []validation.Error{
+					{
+						Key:          "events[0].time",
+						Type:         "withinSession",
+						InvalidValue: time.Date(2019, 1, 2, 3, 4, 5, 677000000, time.UTC),
+						Message:      "events[0].time must be between sessionStartTime and sessionEndTime",
+					},
+				},
+			},
+			{
+				description: "a span that ends after the end of the session",
+				sourceJSON: sessionWithSpan(`{
+					"type": "some-span",
+					"startTime": "2019-01-02T08:04:05.678Z", 
+					"endTime": "2019-01-02T09:04:05.679Z"
+				}`),
+				expectedErrors: []validation.Error{
+					{
+						Key:          "spans[0].endTime",
+						Type:         "withinSession",
+						InvalidValue: time.Date(2019, 1, 2, 9, 4, 5, 679000000, time.UTC),
+						Message:      "spans[0].endTime must be between sessionStartTime and sessionEndTime",
+					},
+				},
+			},
+			{
+				description: "a span that starts before the start of the session",
+				sourceJSON: sessionWithSpan(`{
+					"type": "some-span",
+					"startTime": "2019-01-01T08:04:05.678Z", 
+					"endTime": "2019-01-02T08:04:05.678Z"
+				}`),
+				expectedErrors: []validation.Error{
+					{
+						Key:          "spans[0].startTime",
+						Type:         "withinSession",
+						InvalidValue: time.Date(2019, 1, 1, 8, 4, 5, 678000000, time.UTC),
+						Message:      "spans[0].startTime must be between sessionStartTime and sessionEndTime",
+					},
+				},
+			},
+			{
+				description: "a start time too long before the ingestion time",
+				sourceJSON: `{
+					"sessionId": "11112222-3333-4444-a555-666677778888", 
+					"userId": "99990000-3333-4444-a555-666677778888", 
+					"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+					"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+					"ingestionTime": "2019-04-02T03:04:05.679Z", 
+					"applicationId": "test-app", 
+					"applicationVersion": "1.0.0"
+				}`,
+				expectedErrors: []validation.Error{
+					{
+						Key:          "sessionStartTime",
+						Type:         "notTooOld",
+						InvalidValue: time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+						Message:      "sessionStartTime must be no more than 90 days before the session was uploaded",
+					},
+				},
+			},
+			{
+				description: "an end time too far after the ingestion time",
+				sourceJSON: `{
+					"sessionId": "11112222-3333-4444-a555-666677778888", 
+					"userId": "99990000-3333-4444-a555-666677778888", 
+					"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+					"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+					"ingestionTime": "2019-01-02T08:04:05.677Z", 
+					"applicationId": "test-app", 
+					"applicationVersion": "1.0.0"
+				}`,
+				expectedErrors: []validation.Error{
+					{
+						Key:          "sessionEndTime",
+						Type:         "notInFuture",
+						InvalidValue: time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+						Message:      "sessionEndTime must not be in the future",
+					},
+				},
+			},
 			{
 				description: "an invalid application ID",
 				sourceJSON: `{
@@ -455,6 +639,162 @@ var _ = Describe("A session", func() {
 			},
 		}
 
+		invalidCases = append(invalidCases,
+			invalidCase{
+				description: "invalid span IDs",
+				sourceJSON: sessionWithSpan(`{
+					"id": "span-1",
+					"parentId": "SPAN-2",
+					"type": "the-span",
+					"startTime": "2019-01-02T03:04:05.678Z", 
+					"endTime": "2019-01-02T09:04:05.678Z"
+				}`),
+				expectedErrors: []validation.Error{
+					{Key: "spans[0].id", Type: "spanId", InvalidValue: "span-1", Message: "id must be a valid span ID"},
+					{Key: "spans[0].parentId", Type: "spanId", InvalidValue: "SPAN-2", Message: "parentId must be a valid span ID"},
+				},
+			},
+			invalidCase{
+				description: "an invalid span ID on an event",
+				sourceJSON: sessionWithEvent(`{
+					"type": "the-event",
+					"time": "2019-01-02T03:04:05.678Z",
+					"spanId": "span-1"
+				}`),
+				expectedErrors: []validation.Error{
+					{Key: "events[0].spanId", Type: "spanId", InvalidValue: "span-1", Message: "spanId must be a valid span ID"},
+				},
+			},
+			invalidCase{
+				description: "two spans with the same ID",
+				sourceJSON: sessionWithSpan(`
+					{ "id": "0000000000000001", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" },
+					{ "id": "0000000000000001", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" }
+				`),
+				expectedErrors: []validation.Error{
+					{Key: "spans[1].id", Type: "uniqueSpanId", InvalidValue: "0000000000000001", Message: "spans[1].id must be unique within the session"},
+				},
+			},
+			invalidCase{
+				description: "a span with a parent that does not exist",
+				sourceJSON: sessionWithSpan(`
+					{ "id": "0000000000000001", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" },
+					{ "parentId": "0000000000000002", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" }
+				`),
+				expectedErrors: []validation.Error{
+					{Key: "spans[1].parentId", Type: "spanExists", InvalidValue: "0000000000000002", Message: "spans[1].parentId must be the ID of a span in the session"},
+				},
+			},
+			invalidCase{
+				description: "an event associated with a span that does not exist",
+				sourceJSON: sessionWithEvent(`{
+					"type": "the-event",
+					"time": "2019-01-02T03:04:05.678Z",
+					"spanId": "0000000000000001"
+				}`),
+				expectedErrors: []validation.Error{
+					{Key: "events[0].spanId", Type: "spanExists", InvalidValue: "0000000000000001", Message: "events[0].spanId must be the ID of a span in the session"},
+				},
+			},
+			invalidCase{
+				description: "a span that is its own parent",
+				sourceJSON: sessionWithSpan(`
+					{ "id": "0000000000000001", "parentId": "0000000000000001", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" }
+				`),
+				expectedErrors: []validation.Error{
+					{Key: "spans[0].parentId", Type: "noCycle", InvalidValue: "0000000000000001", Message: "spans[0].parentId must not make the span its own ancestor"},
+				},
+			},
+			invalidCase{
+				description: "spans that are each other's ancestors",
+				sourceJSON: sessionWithSpan(`
+					{ "id": "0000000000000001", "parentId": "0000000000000003", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" },
+					{ "id": "0000000000000002", "parentId": "0000000000000001", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" },
+					{ "id": "0000000000000003", "parentId": "0000000000000002", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" },
+					{ "id": "0000000000000004", "parentId": "0000000000000003", "type": "the-span", "startTime": "2019-01-02T03:04:05.678Z", "endTime": "2019-01-02T09:04:05.678Z" }
+				`),
+				expectedErrors: []validation.Error{
+					{Key: "spans[0].parentId", Type: "noCycle", InvalidValue: "0000000000000003", Message: "spans[0].parentId must not make the span its own ancestor"},
+					{Key: "spans[1].parentId", Type: "noCycle", InvalidValue: "0000000000000001", Message: "spans[1].parentId must not make the span its own ancestor"},
+					{Key: "spans[2].parentId", Type: "noCycle", InvalidValue: "0000000000000002", Message: "spans[2].parentId must not make the span its own ancestor"},
+				},
+			},
+		)
+
+		invalidCases = append(invalidCases,
+			invalidCase{
+				description: "invalid parent session and trace IDs",
+				sourceJSON: `{
+					"sessionId": "11112222-3333-4444-a555-666677778888", 
+					"userId": "99990000-3333-4444-a555-666677778888", 
+					"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+					"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+					"applicationId": "test-app", 
+					"applicationVersion": "1.0.0",
+					"parentSessionId": "abc123",
+					"traceId": "def456"
+				}`,
+				expectedErrors: []validation.Error{
+					{Key: "parentSessionId", Type: "uuid4", InvalidValue: "abc123", Message: "parentSessionId must be a valid version 4 UUID"},
+					{Key: "traceId", Type: "uuid4", InvalidValue: "def456", Message: "traceId must be a valid version 4 UUID"},
+				},
+			},
+			invalidCase{
+				description: "a parent session ID that is the same as the session ID",
+				sourceJSON: `{
+					"sessionId": "11112222-3333-4444-a555-666677778888", 
+					"userId": "99990000-3333-4444-a555-666677778888", 
+					"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+					"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+					"applicationId": "test-app", 
+					"applicationVersion": "1.0.0",
+					"parentSessionId": "11112222-3333-4444-a555-666677778888"
+				}`,
+				expectedErrors: []validation.Error{
+					{Key: "parentSessionId", Type: "nefield", InvalidValue: "11112222-3333-4444-a555-666677778888", Message: "parentSessionId cannot be equal to sessionId"},
+				},
+			},
+		)
+
+		invalidCases = append(invalidCases,
+			invalidCase{
+				description: "attributes in the namespace reserved for the server",
+				sourceJSON: `{
+					"sessionId": "11112222-3333-4444-a555-666677778888", 
+					"userId": "99990000-3333-4444-a555-666677778888", 
+					"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+					"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+					"applicationId": "test-app", 
+					"applicationVersion": "1.0.0",
+					"attributes": {
+						"abacus": "value",
+						"abacusRegion": "value",
+						"abacusy": "not reserved"
+					}
+				}`,
+				expectedErrors: []validation.Error{
+					{Key: "attributes[abacus]", Type: "reservedAttribute", InvalidValue: "value", Message: "attributes[abacus] must not start with 'abacus', as this is reserved for attributes added by the server"},
+					{Key: "attributes[abacusRegion]", Type: "reservedAttribute", InvalidValue: "value", Message: "attributes[abacusRegion] must not start with 'abacus', as this is reserved for attributes added by the server"},
+				},
+			},
+			invalidCase{
+				description: "consent without a policy version or time",
+				sourceJSON: `{
+					"sessionId": "11112222-3333-4444-a555-666677778888", 
+					"userId": "99990000-3333-4444-a555-666677778888", 
+					"sessionStartTime": "2019-01-02T03:04:05.678Z", 
+					"sessionEndTime": "2019-01-02T09:04:05.678Z", 
+					"applicationId": "test-app", 
+					"applicationVersion": "1.0.0",
+					"consent": {}
+				}`,
+				expectedErrors: []validation.Error{
+					{Key: "consent.policyVersion", Type: "required", Message: "policyVersion is a required field"},
+					{Key: "consent.time", Type: "required", Message: "time is a required field"},
+				},
+			},
+		)
+
 		for _, c := range invalidCases {
 			testCase := c
 
@@ -471,4 +811,66 @@ var _ = Describe("A session", func() {
 			})
 		}
 	})
+
+	Describe("validation for an application that requires consent", func() {
+		var v *validator.Validate
+		var trans ut.Translator
+
+		BeforeEach(func() {
+			registry := applications.NewRegistry(applications.Application{
+				ID:            "test-app",
+				ConsentPolicy: applications.ConsentPolicy{Required: true, AcceptedPolicyVersions: []string{"2023-06"}},
+			})
+
+			var err error
+			v, trans, err = validation.CreateValidatorForRegistry(registry)
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		validate := func(consent *types.Consent) []validation.Error {
+			session := types.Session{
+				SessionID:          "11112222-3333-4444-a555-666677778888",
+				UserID:             "99990000-3333-4444-a555-666677778888",
+				SessionStartTime:   time.Date(2019, 1, 2, 3, 4, 5, 678000000, time.UTC),
+				SessionEndTime:     time.Date(2019, 1, 2, 9, 4, 5, 678000000, time.UTC),
+				ApplicationID:      "test-app",
+				ApplicationVersion: "1.0.0",
+				Consent:            consent,
+			}
+
+			err := v.Struct(session)
+
+			if err == nil {
+				return []validation.Error{}
+			}
+
+			Expect(err).To(BeAssignableToTypeOf(validator.ValidationErrors{}))
+
+			//nolint:errorlint,forcetypeassert
+			return validation.ToValidationErrors(err.(validator.ValidationErrors), trans)
+		}
+
+		Describe("given a session with consent to an accepted privacy policy version", func() {
+			It("returns no errors", func() {
+				Expect(validate(&types.Consent{PolicyVersion: "2023-06", Time: time.Date(2019, 1, 1, 3, 4, 5, 0, time.UTC)})).To(BeEmpty())
+			})
+		})
+
+		Describe("given a session without consent", func() {
+			It("returns an error", func() {
+				Expect(validate(nil)).To(ConsistOf(
+					validation.Error{Key: "consent", Type: "consentRequired", Message: "consent is required for this application"},
+				))
+			})
+		})
+
+		Describe("given a session with consent to a privacy policy version that is not accepted", func() {
+			It("returns an error", func() {
+				Expect(validate(&types.Consent{PolicyVersion: "2022-01", Time: time.Date(2019, 1, 1, 3, 4, 5, 0, time.UTC)})).To(ConsistOf(
+					validation.Error{Key: "consent.policyVersion", Type: "acceptedPolicyVersion", InvalidValue: "2022-01", Message: "consent.policyVersion must be a privacy policy version currently accepted for this application"},
+				))
+			})
+		})
+	})
 })
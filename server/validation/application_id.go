@@ -20,21 +20,15 @@
 package validation
 
 import (
+	"github.com/batect/abacus/server/applications"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 )
 
-func RegisterApplicationIDValidation(v *validator.Validate, trans ut.Translator) error {
+func RegisterApplicationIDValidation(v *validator.Validate, trans ut.Translator, registry *applications.Registry) error {
 	return registerValidation(v, trans, "applicationId", "{0} must be a valid application ID", func(fl validator.FieldLevel) bool {
-		switch fl.Field().String() {
-		case "batect":
-			return true
-		case "test-app":
-			return true
-		case "smoke-test-app":
-			return true
-		}
+		_, ok := registry.Get(fl.Field().String())
 
-		return false
+		return ok
 	})
 }
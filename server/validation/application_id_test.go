@@ -22,6 +22,7 @@ package validation_test
 import (
 	"fmt"
 
+	"github.com/batect/abacus/server/applications"
 	"github.com/batect/abacus/server/validation"
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
@@ -40,7 +41,7 @@ var _ = Describe("Validating application IDs", func() {
 		trans, found := uni.GetTranslator("en")
 		Expect(found).To(BeTrue())
 
-		err := validation.RegisterApplicationIDValidation(v, trans)
+		err := validation.RegisterApplicationIDValidation(v, trans, applications.DefaultRegistry())
 		Expect(err).ToNot(HaveOccurred())
 	})
 
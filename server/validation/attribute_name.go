@@ -26,8 +26,11 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// AttributeNamePattern is the regular expression that attribute names must match.
+const AttributeNamePattern = `^[a-zA-Z][a-zA-Z0-9]*$`
+
 func RegisterAttributeNameValidation(v *validator.Validate, trans ut.Translator) error {
-	validationRegex := regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*$`)
+	validationRegex := regexp.MustCompile(AttributeNamePattern)
 
 	return registerValidation(v, trans, "attributeName", "{0} must have a valid attribute name", func(fl validator.FieldLevel) bool {
 		value := fl.Field().String()
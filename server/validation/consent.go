@@ -0,0 +1,65 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation
+
+import (
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/types"
+	"github.com/go-playground/validator/v10"
+)
+
+const consentRequiredTag = "consentRequired"
+const acceptedPolicyVersionTag = "acceptedPolicyVersion"
+
+var consentTranslations = map[string]string{
+	consentRequiredTag:       "{0} is required for this application",
+	acceptedPolicyVersionTag: "{0} must be a privacy policy version currently accepted for this application",
+}
+
+func validateConsent(sl validator.StructLevel, session types.Session, registry *applications.Registry) {
+	app, ok := registry.Get(session.ApplicationID)
+
+	if !ok {
+		// The application ID is reported by its own validator.
+		return
+	}
+
+	if session.Consent == nil {
+		if app.ConsentPolicy.Required {
+			sl.ReportError(nil, "consent", "Consent", consentRequiredTag, "")
+		}
+
+		return
+	}
+
+	if session.Consent.PolicyVersion == "" || len(app.ConsentPolicy.AcceptedPolicyVersions) == 0 {
+		// Missing versions are reported by the required validator on types.Consent, and applications that don't
+		// list accepted versions accept any version.
+		return
+	}
+
+	for _, accepted := range app.ConsentPolicy.AcceptedPolicyVersions {
+		if session.Consent.PolicyVersion == accepted {
+			return
+		}
+	}
+
+	sl.ReportError(session.Consent.PolicyVersion, "consent.policyVersion", "PolicyVersion", acceptedPolicyVersionTag, "")
+}
@@ -0,0 +1,65 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/go-playground/validator/v10"
+)
+
+// ReservedAttributePrefix is the prefix of session attribute names that are reserved for attributes added by the server.
+const ReservedAttributePrefix = "abacus"
+
+const reservedAttributeTag = "reservedAttribute"
+
+var reservedAttributeTranslations = map[string]string{
+	reservedAttributeTag: fmt.Sprintf("{0} must not start with '%v', as this is reserved for attributes added by the server", ReservedAttributePrefix),
+}
+
+// IsReservedAttributeName returns true if name is in the reserved namespace: it is ReservedAttributePrefix on its own,
+// or ReservedAttributePrefix followed by a word starting with an upper case letter (eg. 'abacusRegion', but not 'abacusy').
+func IsReservedAttributeName(name string) bool {
+	if !strings.HasPrefix(name, ReservedAttributePrefix) {
+		return false
+	}
+
+	rest := strings.TrimPrefix(name, ReservedAttributePrefix)
+
+	if rest == "" {
+		return true
+	}
+
+	first, _ := utf8.DecodeRuneInString(rest)
+
+	return unicode.IsUpper(first) || unicode.IsDigit(first)
+}
+
+func validateReservedAttributes(sl validator.StructLevel, session types.Session) {
+	for name, value := range session.Attributes {
+		if IsReservedAttributeName(name) {
+			sl.ReportError(value, fmt.Sprintf("attributes[%v]", name), "Attributes", reservedAttributeTag, "")
+		}
+	}
+}
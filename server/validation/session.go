@@ -0,0 +1,60 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation
+
+import (
+	"fmt"
+
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/types"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+// RegisterSessionValidation registers the checks that involve more than one field of a session: that its events and spans
+// fall within the session and that it is not too old or in the future, that span and event references to other spans are valid,
+// that none of its attributes use the reserved namespace, and that it records consent as required by its application's
+// consent policy in registry.
+func RegisterSessionValidation(v *validator.Validate, trans ut.Translator, registry *applications.Registry) error {
+	for _, translations := range []map[string]string{sessionTimestampTranslations, spanReferenceTranslations, reservedAttributeTranslations, consentTranslations} {
+		for tag, message := range translations {
+			if err := v.RegisterTranslation(tag, trans, registrationFunc(tag, message), translateFunc); err != nil {
+				return fmt.Errorf("could not register %v validator error message translation: %w", tag, err)
+			}
+		}
+	}
+
+	v.RegisterStructValidation(func(sl validator.StructLevel) { validateSession(sl, registry) }, types.Session{})
+
+	return nil
+}
+
+func validateSession(sl validator.StructLevel, registry *applications.Registry) {
+	session, ok := sl.Current().Interface().(types.Session)
+
+	if !ok {
+		return
+	}
+
+	validateSessionTimestamps(sl, session)
+	validateSpanReferences(sl, session)
+	validateReservedAttributes(sl, session)
+	validateConsent(sl, session, registry)
+}
@@ -0,0 +1,86 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/go-playground/validator/v10"
+)
+
+// MaximumSessionAge is how long before its ingestion time a session may start.
+const MaximumSessionAge = 90 * 24 * time.Hour
+
+// MaximumFutureTolerance is how long after its ingestion time a session may end, to allow for clients with clocks that are slightly fast.
+const MaximumFutureTolerance = time.Hour
+
+const (
+	withinSessionTag = "withinSession"
+	notTooOldTag     = "notTooOld"
+	notInFutureTag   = "notInFuture"
+)
+
+var sessionTimestampTranslations = map[string]string{
+	withinSessionTag: "{0} must be between sessionStartTime and sessionEndTime",
+	notTooOldTag:     fmt.Sprintf("{0} must be no more than %v days before the session was uploaded", MaximumSessionAge.Hours()/24),
+	notInFutureTag:   "{0} must not be in the future",
+}
+
+// validateSessionTimestamps checks that each event and span falls within its session's start and end times, and,
+// if the session's ingestion time has been set, that the session is neither too old nor in the future.
+func validateSessionTimestamps(sl validator.StructLevel, session types.Session) {
+	if session.SessionStartTime.IsZero() || session.SessionEndTime.IsZero() {
+		return
+	}
+
+	isWithinSession := func(t time.Time) bool {
+		return t.IsZero() || (!t.Before(session.SessionStartTime) && !t.After(session.SessionEndTime))
+	}
+
+	for i, e := range session.Events {
+		if !isWithinSession(e.Time) {
+			sl.ReportError(e.Time, fmt.Sprintf("events[%v].time", i), "Time", withinSessionTag, "")
+		}
+	}
+
+	for i, s := range session.Spans {
+		if !isWithinSession(s.StartTime) {
+			sl.ReportError(s.StartTime, fmt.Sprintf("spans[%v].startTime", i), "StartTime", withinSessionTag, "")
+		}
+
+		if !isWithinSession(s.EndTime) {
+			sl.ReportError(s.EndTime, fmt.Sprintf("spans[%v].endTime", i), "EndTime", withinSessionTag, "")
+		}
+	}
+
+	if session.IngestionTime.IsZero() {
+		return
+	}
+
+	if session.SessionStartTime.Before(session.IngestionTime.Add(-MaximumSessionAge)) {
+		sl.ReportError(session.SessionStartTime, "sessionStartTime", "SessionStartTime", notTooOldTag, "")
+	}
+
+	if session.SessionEndTime.After(session.IngestionTime.Add(MaximumFutureTolerance)) {
+		sl.ReportError(session.SessionEndTime, "sessionEndTime", "SessionEndTime", notInFutureTag, "")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation
+
+import (
+	"regexp"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+// SpanIDPattern is the regular expression that span IDs must match. This is the same format as OpenTelemetry span IDs.
+const SpanIDPattern = `^[0-9a-f]{16}$`
+
+var spanIDRegex = regexp.MustCompile(SpanIDPattern)
+
+func RegisterSpanIDValidation(v *validator.Validate, trans ut.Translator) error {
+	return registerValidation(v, trans, "spanId", "{0} must be a valid span ID", func(fl validator.FieldLevel) bool {
+		value := fl.Field().String()
+
+		return spanIDRegex.MatchString(value)
+	})
+}
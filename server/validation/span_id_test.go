@@ -0,0 +1,81 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation_test
+
+import (
+	"fmt"
+
+	"github.com/batect/abacus/server/validation"
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	validator "github.com/go-playground/validator/v10"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validating span IDs", func() {
+	var v *validator.Validate
+
+	BeforeEach(func() {
+		v = validator.New()
+		en := en.New()
+		uni := ut.New(en, en)
+		trans, found := uni.GetTranslator("en")
+		Expect(found).To(BeTrue())
+
+		err := validation.RegisterSpanIDValidation(v, trans)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	type testStruct struct {
+		SpanID string `validate:"spanId"`
+	}
+
+	for _, id := range []string{
+		"0123456789abcdef",
+		"0000000000000001",
+		"ffffffffffffffff",
+	} {
+		testObject := testStruct{id}
+
+		Describe(fmt.Sprintf("given the valid span ID '%v'", testObject.SpanID), func() {
+			It("validates as a permitted span ID", func() {
+				Expect(v.Struct(testObject)).ToNot(HaveOccurred())
+			})
+		})
+	}
+
+	for _, id := range []string{
+		"",
+		"0123456789abcde",
+		"0123456789abcdef0",
+		"0123456789ABCDEF",
+		"0123456789abcdeg",
+		"span-1",
+	} {
+		testObject := testStruct{id}
+
+		Describe(fmt.Sprintf("given the invalid span ID '%v'", testObject.SpanID), func() {
+			It("fails validation", func() {
+				Expect(v.Struct(testObject)).To(MatchError("Key: 'testStruct.SpanID' Error:Field validation for 'SpanID' failed on the 'spanId' tag"))
+			})
+		})
+	}
+})
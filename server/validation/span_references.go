@@ -0,0 +1,105 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package validation
+
+import (
+	"fmt"
+
+	"github.com/batect/abacus/server/types"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	uniqueSpanIDTag = "uniqueSpanId"
+	spanExistsTag   = "spanExists"
+	noCycleTag      = "noCycle"
+)
+
+var spanReferenceTranslations = map[string]string{
+	uniqueSpanIDTag: "{0} must be unique within the session",
+	spanExistsTag:   "{0} must be the ID of a span in the session",
+	noCycleTag:      "{0} must not make the span its own ancestor",
+}
+
+// validateSpanReferences checks that span IDs are unique, and that every parent span ID and event span ID refers to a span
+// in the same session, and that following parent span IDs never leads back to the starting span.
+// IDs that are not well-formed are ignored, as they have already been reported by the spanId rule.
+func validateSpanReferences(sl validator.StructLevel, session types.Session) {
+	spans := map[string]types.Span{}
+
+	for i, s := range session.Spans {
+		if !spanIDRegex.MatchString(s.ID) {
+			continue
+		}
+
+		if _, exists := spans[s.ID]; exists {
+			sl.ReportError(s.ID, fmt.Sprintf("spans[%v].id", i), "ID", uniqueSpanIDTag, "")
+			continue
+		}
+
+		spans[s.ID] = s
+	}
+
+	for i, s := range session.Spans {
+		if !spanIDRegex.MatchString(s.ParentID) {
+			continue
+		}
+
+		if _, exists := spans[s.ParentID]; !exists {
+			sl.ReportError(s.ParentID, fmt.Sprintf("spans[%v].parentId", i), "ParentID", spanExistsTag, "")
+			continue
+		}
+
+		if s.ID != "" && isOwnAncestor(s, spans) {
+			sl.ReportError(s.ParentID, fmt.Sprintf("spans[%v].parentId", i), "ParentID", noCycleTag, "")
+		}
+	}
+
+	for i, e := range session.Events {
+		if !spanIDRegex.MatchString(e.SpanID) {
+			continue
+		}
+
+		if _, exists := spans[e.SpanID]; !exists {
+			sl.ReportError(e.SpanID, fmt.Sprintf("events[%v].spanId", i), "SpanID", spanExistsTag, "")
+		}
+	}
+}
+
+func isOwnAncestor(span types.Span, spans map[string]types.Span) bool {
+	current := span
+
+	// A chain of ancestors longer than the number of spans must contain a cycle, so there's no need to keep track of every span visited.
+	for steps := 0; steps < len(spans); steps++ {
+		parent, exists := spans[current.ParentID]
+
+		if !exists {
+			return false
+		}
+
+		if parent.ID == span.ID {
+			return true
+		}
+
+		current = parent
+	}
+
+	return false
+}
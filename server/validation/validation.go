@@ -25,6 +25,7 @@ import (
 	"reflect"
 	"strings"
 
+	"github.com/batect/abacus/server/applications"
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
@@ -38,7 +39,13 @@ type Error struct {
 	Message      string      `json:"message"`
 }
 
+// CreateValidator creates a validator that checks application-specific rules against the default application registry.
 func CreateValidator() (*validator.Validate, ut.Translator, error) {
+	return CreateValidatorForRegistry(applications.DefaultRegistry())
+}
+
+// CreateValidatorForRegistry creates a validator that checks application-specific rules against registry.
+func CreateValidatorForRegistry(registry *applications.Registry) (*validator.Validate, ut.Translator, error) {
 	v := validator.New()
 
 	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
@@ -63,7 +70,7 @@ func CreateValidator() (*validator.Validate, ut.Translator, error) {
 		return nil, nil, fmt.Errorf("could not register default translations: %w", err)
 	}
 
-	if err := RegisterApplicationIDValidation(v, trans); err != nil {
+	if err := RegisterApplicationIDValidation(v, trans, registry); err != nil {
 		return nil, nil, fmt.Errorf("could not register application ID validator: %w", err)
 	}
 
@@ -79,6 +86,14 @@ func CreateValidator() (*validator.Validate, ut.Translator, error) {
 		return nil, nil, fmt.Errorf("could not register version validator: %w", err)
 	}
 
+	if err := RegisterSpanIDValidation(v, trans); err != nil {
+		return nil, nil, fmt.Errorf("could not register span ID validator: %w", err)
+	}
+
+	if err := RegisterSessionValidation(v, trans, registry); err != nil {
+		return nil, nil, fmt.Errorf("could not register session validator: %w", err)
+	}
+
 	return v, trans, nil
 }
 
@@ -20,18 +20,18 @@
 package validation
 
 import (
-	"regexp"
-
+	"github.com/batect/abacus/server/versions"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 )
 
-func RegisterVersionValidation(v *validator.Validate, trans ut.Translator) error {
-	versionValidationRegex := regexp.MustCompile(`^(\d+)(\.(\d+)(\.(\d+)(-([a-zA-Z0-9-.]+))?(\+([a-zA-Z0-9-.]+))?)?)?$`)
+// VersionPattern is the regular expression that application versions must match.
+const VersionPattern = versions.Pattern
 
+func RegisterVersionValidation(v *validator.Validate, trans ut.Translator) error {
 	return registerValidation(v, trans, "version", "{0} must be a valid version", func(fl validator.FieldLevel) bool {
-		value := fl.Field().String()
+		_, err := versions.Parse(fl.Field().String())
 
-		return versionValidationRegex.MatchString(value)
+		return err == nil
 	})
 }
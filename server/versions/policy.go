@@ -0,0 +1,134 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package versions
+
+import (
+	"fmt"
+
+	"github.com/batect/abacus/server/applications"
+)
+
+// Decision is the outcome of applying an application's version policy to a session.
+type Decision int
+
+const (
+	// Accept means the session should be stored.
+	Accept Decision = iota
+
+	// Drop means the session should be acknowledged but not stored, as retrying it will never succeed.
+	Drop
+
+	// Reject means the session should be rejected with an error, so that the client knows its version is no longer supported.
+	Reject
+)
+
+// Policies applies the version policy configured for each application.
+type Policies struct {
+	policies map[string]policy
+}
+
+type policy struct {
+	minimumVersion   *Version
+	dropPrereleases  bool
+	releasedVersions []Version
+}
+
+// NewPolicies returns the version policies for the applications in registry.
+// It returns an error if any application's policy refers to an invalid version.
+func NewPolicies(registry *applications.Registry) (*Policies, error) {
+	policies := map[string]policy{}
+
+	for _, app := range registry.All() {
+		p, err := newPolicy(app.VersionPolicy)
+
+		if err != nil {
+			return nil, fmt.Errorf("application '%v' has an invalid version policy: %w", app.ID, err)
+		}
+
+		policies[app.ID] = p
+	}
+
+	return &Policies{policies: policies}, nil
+}
+
+func newPolicy(config applications.VersionPolicy) (policy, error) {
+	p := policy{dropPrereleases: config.DropPrereleases}
+
+	if config.MinimumVersion != "" {
+		v, err := Parse(config.MinimumVersion)
+
+		if err != nil {
+			return policy{}, fmt.Errorf("invalid minimum version: %w", err)
+		}
+
+		p.minimumVersion = &v
+	}
+
+	for _, released := range config.ReleasedVersions {
+		v, err := Parse(released)
+
+		if err != nil {
+			return policy{}, fmt.Errorf("invalid released version: %w", err)
+		}
+
+		p.releasedVersions = append(p.releasedVersions, v)
+	}
+
+	return p, nil
+}
+
+// Check applies the policy for applicationID to version, returning the decision and, if the session is not accepted, the reason why.
+// Versions of applications that have no policy are always accepted.
+func (p *Policies) Check(applicationID string, version Version) (Decision, string) {
+	pol, ok := p.policies[applicationID]
+
+	if !ok {
+		return Accept, ""
+	}
+
+	if pol.minimumVersion != nil && version.Compare(*pol.minimumVersion) < 0 {
+		return Reject, fmt.Sprintf("version %v is older than the minimum supported version %v", version, pol.minimumVersion)
+	}
+
+	if pol.dropPrereleases && !version.IsRelease() {
+		return Drop, fmt.Sprintf("version %v is a prerelease or development build", version)
+	}
+
+	if len(pol.releasedVersions) > 0 && !isReleased(version, pol.releasedVersions) {
+		return Drop, fmt.Sprintf("version %v has not been released", version)
+	}
+
+	return Accept, ""
+}
+
+// IsReleased returns true if version is one of the released versions listed in the policy for applicationID.
+func (p *Policies) IsReleased(applicationID string, version Version) bool {
+	return isReleased(version, p.policies[applicationID].releasedVersions)
+}
+
+func isReleased(version Version, released []Version) bool {
+	for _, r := range released {
+		if version.Compare(r) == 0 && version.Build == r.Build {
+			return true
+		}
+	}
+
+	return false
+}
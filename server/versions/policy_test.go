@@ -0,0 +1,157 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package versions_test
+
+import (
+	"github.com/batect/abacus/server/applications"
+	"github.com/batect/abacus/server/versions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Version policies", func() {
+	var policies *versions.Policies
+
+	check := func(applicationID string, version string) (versions.Decision, string) {
+		v, err := versions.Parse(version)
+		Expect(err).ToNot(HaveOccurred())
+
+		return policies.Check(applicationID, v)
+	}
+
+	decisionFor := func(applicationID string, version string) versions.Decision {
+		decision, _ := check(applicationID, version)
+
+		return decision
+	}
+
+	BeforeEach(func() {
+		var err error
+		policies, err = versions.NewPolicies(applications.NewRegistry(
+			applications.Application{ID: "unrestricted-app"},
+			applications.Application{ID: "minimum-version-app", VersionPolicy: applications.VersionPolicy{MinimumVersion: "0.40"}},
+			applications.Application{ID: "no-prereleases-app", VersionPolicy: applications.VersionPolicy{DropPrereleases: true}},
+			applications.Application{ID: "released-versions-app", VersionPolicy: applications.VersionPolicy{ReleasedVersions: []string{"1.0.0", "1.1.0-rc.1"}}},
+		))
+
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("given an application with no policy", func() {
+		It("accepts all versions", func() {
+			Expect(decisionFor("unrestricted-app", "0.0.1-dev+abc")).To(Equal(versions.Accept))
+		})
+	})
+
+	Context("given an application that is not registered", func() {
+		It("accepts all versions", func() {
+			Expect(decisionFor("unknown-app", "0.0.1-dev+abc")).To(Equal(versions.Accept))
+		})
+	})
+
+	Context("given an application with a minimum version", func() {
+		It("accepts the minimum version", func() {
+			Expect(decisionFor("minimum-version-app", "0.40.0")).To(Equal(versions.Accept))
+		})
+
+		It("accepts later versions", func() {
+			Expect(decisionFor("minimum-version-app", "0.41.0-rc.1")).To(Equal(versions.Accept))
+		})
+
+		It("rejects earlier versions", func() {
+			decision, reason := check("minimum-version-app", "0.39.2")
+
+			Expect(decision).To(Equal(versions.Reject))
+			Expect(reason).To(Equal("version 0.39.2 is older than the minimum supported version 0.40.0"))
+		})
+
+		It("rejects prereleases of the minimum version", func() {
+			Expect(decisionFor("minimum-version-app", "0.40.0-rc.1")).To(Equal(versions.Reject))
+		})
+	})
+
+	Context("given an application that drops prerelease versions", func() {
+		It("accepts released versions", func() {
+			Expect(decisionFor("no-prereleases-app", "1.2.3")).To(Equal(versions.Accept))
+		})
+
+		It("drops prerelease versions", func() {
+			decision, reason := check("no-prereleases-app", "1.2.3-rc.1")
+
+			Expect(decision).To(Equal(versions.Drop))
+			Expect(reason).To(Equal("version 1.2.3-rc.1 is a prerelease or development build"))
+		})
+
+		It("drops development builds", func() {
+			Expect(decisionFor("no-prereleases-app", "1.2.3+dev")).To(Equal(versions.Drop))
+		})
+	})
+
+	Context("given an application that only accepts released versions", func() {
+		It("accepts released versions", func() {
+			Expect(decisionFor("released-versions-app", "1.0.0")).To(Equal(versions.Accept))
+			Expect(decisionFor("released-versions-app", "1.1.0-rc.1")).To(Equal(versions.Accept))
+		})
+
+		It("accepts released versions written without all components", func() {
+			Expect(decisionFor("released-versions-app", "1.0")).To(Equal(versions.Accept))
+		})
+
+		It("drops versions that have not been released", func() {
+			decision, reason := check("released-versions-app", "1.1.0")
+
+			Expect(decision).To(Equal(versions.Drop))
+			Expect(reason).To(Equal("version 1.1.0 has not been released"))
+		})
+
+		It("drops development builds of released versions", func() {
+			Expect(decisionFor("released-versions-app", "1.0.0+dev")).To(Equal(versions.Drop))
+		})
+
+		It("reports which versions have been released", func() {
+			released, _ := versions.Parse("1.1.0-rc.1")
+			unreleased, _ := versions.Parse("1.1.0")
+
+			Expect(policies.IsReleased("released-versions-app", released)).To(BeTrue())
+			Expect(policies.IsReleased("released-versions-app", unreleased)).To(BeFalse())
+			Expect(policies.IsReleased("unrestricted-app", unreleased)).To(BeFalse())
+		})
+	})
+
+	Context("given an application with an invalid minimum version", func() {
+		It("returns an error", func() {
+			_, err := versions.NewPolicies(applications.NewRegistry(
+				applications.Application{ID: "my-app", VersionPolicy: applications.VersionPolicy{MinimumVersion: "latest"}},
+			))
+
+			Expect(err).To(MatchError("application 'my-app' has an invalid version policy: invalid minimum version: 'latest' is not a valid version"))
+		})
+	})
+
+	Context("given an application with an invalid released version", func() {
+		It("returns an error", func() {
+			_, err := versions.NewPolicies(applications.NewRegistry(
+				applications.Application{ID: "my-app", VersionPolicy: applications.VersionPolicy{ReleasedVersions: []string{"1.0.0", "v2"}}},
+			))
+
+			Expect(err).To(MatchError("application 'my-app' has an invalid version policy: invalid released version: 'v2' is not a valid version"))
+		})
+	})
+})
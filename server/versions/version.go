@@ -0,0 +1,172 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+// Package versions parses application versions and applies each application's version policy to the sessions it uploads.
+//
+// Versions follow Semantic Versioning (https://semver.org), except that the minor and patch components may be omitted
+// (eg. '1' or '1.2'), in which case they are treated as zero.
+package versions
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pattern is the regular expression that application versions must match.
+const Pattern = `^(\d+)(\.(\d+)(\.(\d+)(-([a-zA-Z0-9-.]+))?(\+([a-zA-Z0-9-.]+))?)?)?$`
+
+var versionRegex = regexp.MustCompile(Pattern)
+
+// Version is the parsed form of an application version.
+type Version struct {
+	Major      int    `json:"major"`
+	Minor      int    `json:"minor"`
+	Patch      int    `json:"patch"`
+	Prerelease string `json:"prerelease,omitempty"`
+	Build      string `json:"build,omitempty"`
+}
+
+// Parse parses value, which must match Pattern.
+func Parse(value string) (Version, error) {
+	matches := versionRegex.FindStringSubmatch(value)
+
+	if matches == nil {
+		return Version{}, fmt.Errorf("'%v' is not a valid version", value)
+	}
+
+	components := make([]int, 3)
+
+	for i, match := range []string{matches[1], matches[3], matches[5]} {
+		if match == "" {
+			continue
+		}
+
+		component, err := strconv.Atoi(match)
+
+		if err != nil {
+			return Version{}, fmt.Errorf("'%v' is not a valid version: %w", value, err)
+		}
+
+		components[i] = component
+	}
+
+	return Version{
+		Major:      components[0],
+		Minor:      components[1],
+		Patch:      components[2],
+		Prerelease: matches[7],
+		Build:      matches[9],
+	}, nil
+}
+
+// IsRelease returns true if v has neither a prerelease component nor build metadata. Development builds are expected
+// to include build metadata (eg. '1.2.3+dev' or '1.2.3-4-gabc1234+dirty'), so they are not considered releases.
+func (v Version) IsRelease() bool {
+	return v.Prerelease == "" && v.Build == ""
+}
+
+// Compare returns a negative number if v has lower precedence than other, zero if they have the same precedence, and a
+// positive number if v has higher precedence than other. Precedence is as defined by Semantic Versioning, so build
+// metadata is ignored.
+func (v Version) Compare(other Version) int {
+	if v.Major != other.Major {
+		return compareInts(v.Major, other.Major)
+	}
+
+	if v.Minor != other.Minor {
+		return compareInts(v.Minor, other.Minor)
+	}
+
+	if v.Patch != other.Patch {
+		return compareInts(v.Patch, other.Patch)
+	}
+
+	return comparePrereleases(v.Prerelease, other.Prerelease)
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+
+	return s
+}
+
+// A version without a prerelease component has higher precedence than one with a prerelease component. Otherwise,
+// prerelease components are compared identifier by identifier: numeric identifiers are compared numerically and have
+// lower precedence than alphanumeric identifiers, which are compared lexically.
+func comparePrereleases(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	if a == "" {
+		return 1
+	}
+
+	if b == "" {
+		return -1
+	}
+
+	aIdentifiers := strings.Split(a, ".")
+	bIdentifiers := strings.Split(b, ".")
+
+	for i := 0; i < len(aIdentifiers) && i < len(bIdentifiers); i++ {
+		if result := compareIdentifiers(aIdentifiers[i], bIdentifiers[i]); result != 0 {
+			return result
+		}
+	}
+
+	return compareInts(len(aIdentifiers), len(bIdentifiers))
+}
+
+func compareIdentifiers(a string, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNumber, bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019-2023 Charles Korn.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// and the Commons Clause License Condition v1.0 (the "Condition");
+// you may not use this file except in compliance with both the License and Condition.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// You may obtain a copy of the Condition at
+//
+//     https://commonsclause.com/
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License and the Condition is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See both the License and the Condition for the specific language governing permissions and
+// limitations under the License and the Condition.
+
+package versions_test
+
+import (
+	"fmt"
+
+	"github.com/batect/abacus/server/versions"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parsing versions", func() {
+	for value, expected := range map[string]versions.Version{
+		"1":                           {Major: 1},
+		"1.2":                         {Major: 1, Minor: 2},
+		"1.2.3":                       {Major: 1, Minor: 2, Patch: 3},
+		"01.02.03":                    {Major: 1, Minor: 2, Patch: 3},
+		"1.2.3-abc123":                {Major: 1, Minor: 2, Patch: 3, Prerelease: "abc123"},
+		"1.2.3+xyz456":                {Major: 1, Minor: 2, Patch: 3, Build: "xyz456"},
+		"1.2.3-abc-def.12+ghi-jkl.34": {Major: 1, Minor: 2, Patch: 3, Prerelease: "abc-def.12", Build: "ghi-jkl.34"},
+	} {
+		value := value
+		expected := expected
+
+		Context(fmt.Sprintf("given the version '%v'", value), func() {
+			It("returns its components", func() {
+				Expect(versions.Parse(value)).To(Equal(expected))
+			})
+		})
+	}
+
+	for _, value := range []string{"", "a", "1.", "1.2.3.4", "1.2-thing", "99999999999999999999.0.0"} {
+		value := value
+
+		Context(fmt.Sprintf("given the invalid version '%v'", value), func() {
+			It("returns an error", func() {
+				_, err := versions.Parse(value)
+				Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("'%v' is not a valid version", value))))
+			})
+		})
+	}
+})
+
+var _ = Describe("A version", func() {
+	Describe("formatting it as a string", func() {
+		It("includes all components", func() {
+			Expect(versions.Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1", Build: "abc"}.String()).To(Equal("1.2.3-rc.1+abc"))
+		})
+
+		It("includes omitted minor and patch components", func() {
+			Expect(versions.Version{Major: 1}.String()).To(Equal("1.0.0"))
+		})
+	})
+
+	Describe("determining if it is a release", func() {
+		It("is a release if it has no prerelease component or build metadata", func() {
+			Expect(versions.Version{Major: 1}.IsRelease()).To(BeTrue())
+		})
+
+		It("is not a release if it has a prerelease component", func() {
+			Expect(versions.Version{Major: 1, Prerelease: "rc.1"}.IsRelease()).To(BeFalse())
+		})
+
+		It("is not a release if it has build metadata", func() {
+			Expect(versions.Version{Major: 1, Build: "dev"}.IsRelease()).To(BeFalse())
+		})
+	})
+
+	Describe("comparing it to another version", func() {
+		// Each version has lower precedence than the next.
+		ordered := []string{
+			"0.9.9",
+			"1.0.0-alpha",
+			"1.0.0-alpha.1",
+			"1.0.0-alpha.beta",
+			"1.0.0-beta",
+			"1.0.0-beta.2",
+			"1.0.0-beta.11",
+			"1.0.0-rc.1",
+			"1.0.0",
+			"1.0.1",
+			"1.2",
+			"1.10.0",
+			"2",
+		}
+
+		for i := 0; i < len(ordered)-1; i++ {
+			lower := ordered[i]
+			higher := ordered[i+1]
+
+			It(fmt.Sprintf("orders %v before %v", lower, higher), func() {
+				lowerVersion, err := versions.Parse(lower)
+				Expect(err).ToNot(HaveOccurred())
+				higherVersion, err := versions.Parse(higher)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(lowerVersion.Compare(higherVersion)).To(BeNumerically("<", 0))
+				Expect(higherVersion.Compare(lowerVersion)).To(BeNumerically(">", 0))
+			})
+		}
+
+		It("treats versions that differ only in build metadata as equal", func() {
+			Expect(versions.Version{Major: 1, Build: "abc"}.Compare(versions.Version{Major: 1, Build: "def"})).To(BeZero())
+		})
+
+		It("treats omitted minor and patch components as zero", func() {
+			Expect(versions.Version{Major: 1}.Compare(versions.Version{Major: 1, Minor: 0, Patch: 0})).To(BeZero())
+		})
+	})
+})
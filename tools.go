@@ -24,4 +24,5 @@ package main
 
 import (
 	_ "github.com/onsi/ginkgo/v2/ginkgo"
+	_ "google.golang.org/protobuf/cmd/protoc-gen-go"
 )